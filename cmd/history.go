@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/history"
+)
+
+const historyUsage = `usage: %s history [flags]
+
+Prints the state transitions, clock class changes, threshold crossings and process restarts
+recorded by a running daemon, queried from its history API, or by any daemon, read from the
+file it was started with --history-file. --since and --until take RFC 3339 times or durations
+before now, i.e. 1h for the last hour.
+
+`
+
+// historyTimeout bounds the history API request
+const historyTimeout = 10 * time.Second
+
+// historyCommand implements the history subcommand, it returns the process exit code
+func historyCommand(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), historyUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	address := fs.String("address", "localhost:9091", "address the status API of the daemon is served on")
+	file := fs.String("file", "", "read this history file instead of querying the daemon")
+	since := fs.String("since", "1h", "only events at or after this time, all of them when empty")
+	until := fs.String("until", "", "only events at or before this time")
+	q := history.Query{}
+	fs.StringVar(&q.Type, "type", "", "only events of this type: stateChange, clockClassChange, thresholdCrossing or processRestart")
+	fs.StringVar(&q.Config, "config", "", "only events of this config, i.e. ts2phc.0.config")
+	fs.StringVar(&q.Source, "source", "", "only events of this source, i.e. ptp4l, dpll or GM")
+	asJSON := fs.Bool("json", false, "print the events as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	var err error
+	now := time.Now()
+	if q.Since, err = history.ParseTime(*since, now); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --since: %v\n", err)
+		return 2
+	}
+	if q.Until, err = history.ParseTime(*until, now); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --until: %v\n", err)
+		return 2
+	}
+
+	var events []apiv1.Event
+	if *file != "" {
+		events, err = loadHistory(*file, q)
+	} else {
+		events, err = fetchHistory(*address, q)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(events)
+	} else {
+		err = printHistory(os.Stdout, events)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// loadHistory returns the events of a history file matching q
+func loadHistory(path string, q history.Query) ([]apiv1.Event, error) {
+	all, err := history.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	events := []apiv1.Event{}
+	for _, ev := range all {
+		if q.Match(ev) {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
+
+// fetchHistory queries the history API of the daemon at address
+func fetchHistory(address string, q history.Query) ([]apiv1.Event, error) {
+	client := http.Client{Timeout: historyTimeout}
+	resp, err := client.Get("http://" + address + apiv1.HistoryPath + "?" + q.Values().Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("history API answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var h apiv1.History
+	if err = json.NewDecoder(resp.Body).Decode(&h); err != nil {
+		return nil, fmt.Errorf("invalid history: %w", err)
+	}
+	return h.Events, nil
+}
+
+// printHistory prints events as a table
+func printHistory(out io.Writer, events []apiv1.Event) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tTYPE\tCONFIG\tSOURCE\tINTERFACE\tCHANGE\tMESSAGE")
+	for _, ev := range events {
+		change := ev.To
+		if ev.From != "" {
+			change = ev.From + " -> " + ev.To
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", ev.Time.Local().Format(time.RFC3339), ev.Type, ev.Config, ev.Source,
+			ev.Interface, change, ev.Message)
+	}
+	return w.Flush()
+}
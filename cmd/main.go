@@ -16,9 +16,13 @@ import (
 	"github.com/golang/glog"
 	"k8s.io/client-go/kubernetes"
 
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/capture"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/daemon"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/history"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/logging"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/oran"
 	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
 	ptpclient "github.com/k8snetworkplumbingwg/ptp-operator/pkg/client/clientset/versioned"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -26,9 +30,23 @@ import (
 )
 
 type cliParams struct {
-	updateInterval  int
-	profileDir      string
-	pmcPollInterval int
+	updateInterval     int
+	profileDir         string
+	pmcPollInterval    int
+	standalone         bool
+	profilesPath       string
+	leapFile           string
+	rollbackWindow     int
+	health             daemon.HealthConfig
+	healthStallTimeout int
+	healthAddress      string
+	logFormat          string
+	captureFile        string
+	captureMaxSize     int
+	captureBackups     int
+	notificationAPI    bool
+	historyFile        string
+	historySize        int
 }
 
 // Parse Command line flags
@@ -39,39 +57,103 @@ func flagInit(cp *cliParams) {
 		"profile to start linuxptp processes")
 	flag.IntVar(&cp.pmcPollInterval, "pmc-poll-interval", config.DefaultPmcPollInterval,
 		"Interval for periodical PMC poll")
+	flag.BoolVar(&cp.standalone, "standalone", false,
+		"run without Kubernetes, profiles are read from --profiles and leap data is kept in --leap-file")
+	flag.StringVar(&cp.profilesPath, "profiles", config.DefaultStandaloneProfilesPath,
+		"standalone mode: profile file or directory of profile files")
+	flag.StringVar(&cp.leapFile, "leap-file", config.DefaultStandaloneLeapFile,
+		"standalone mode: leap seconds file maintained by the daemon")
+	flag.IntVar(&cp.rollbackWindow, "rollback-window", config.DefaultRollbackWindow,
+		"Time in seconds newly applied profiles have to lock before rolling back to the last known good profiles, 0 disables rollbacks")
+	flag.StringVar(&cp.healthAddress, "health-bind-address", "0.0.0.0:9091",
+		"Address /healthz and /readyz are served on when the metrics server is disabled by LOGS_TO_SOCKET")
+	flag.IntVar(&cp.healthStallTimeout, "health-stall-timeout", int(daemon.DefaultHealthStallTimeout.Seconds()),
+		"Time in seconds the daemon and event loops may not run before /healthz fails, 0 disables the check")
+	flag.BoolVar(&cp.health.CrashLoopUnhealthy, "health-crash-loop", true,
+		"/healthz fails while a linuxptp process is crash looping")
+	flag.BoolVar(&cp.health.ReadyRequiresLock, "ready-requires-lock", true,
+		"/readyz waits until every process of the profiles locked, otherwise until they are started")
+	flag.StringVar(&cp.logFormat, "log-format", logging.FormatText,
+		"text, glog and linuxptp output as is, or json, one JSON record per line with the fields parsed from the linuxptp output")
+	flag.StringVar(&cp.captureFile, "capture-file", "",
+		"Record the linuxptp output and what the DPLL, GNSS and chronyd monitors read to this file for the replay subcommand, empty disables the capture")
+	flag.IntVar(&cp.captureMaxSize, "capture-max-size", 50,
+		"Size in MiB the capture file is rotated at")
+	flag.IntVar(&cp.captureBackups, "capture-backups", 3,
+		"Number of rotated capture files kept")
+	flag.BoolVar(&cp.notificationAPI, "notification-api", false,
+		"Serve the O-RAN notification API along with the status API: subscriptions to the sync state of the node, notified with CloudEvents")
+	flag.StringVar(&cp.historyFile, "history-file", "",
+		"Persist the event history to this file across restarts, empty keeps it in memory only")
+	flag.IntVar(&cp.historySize, "history-size", history.DefaultCapacity,
+		"Number of state transitions, clock class changes, threshold crossings and process restarts kept in the event history")
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		os.Exit(render(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replay(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		os.Exit(historyCommand(os.Args[2:]))
+	}
 	cp := &cliParams{}
-	flag.Parse()
 	flagInit(cp)
+	flag.Parse()
+	if err := logging.SetFormat(cp.logFormat); err != nil {
+		glog.Fatal(err)
+	}
+	if err := logging.RedirectGlog(); err != nil {
+		glog.Errorf("structured logging disabled: %v", err)
+		_ = logging.SetFormat(logging.FormatText)
+	}
+	defer logging.Flush()
 
 	glog.Infof("resync period set to: %d [s]", cp.updateInterval)
 	glog.Infof("linuxptp profile path set to: %s", cp.profileDir)
 	glog.Infof("pmc poll interval set to: %d [s]", cp.pmcPollInterval)
+	glog.Infof("profile rollback window set to: %d [s]", cp.rollbackWindow)
+	glog.Infof("health stall timeout set to: %d [s]", cp.healthStallTimeout)
 
-	cfg, err := config.GetKubeConfig()
-	if err != nil {
-		glog.Errorf("get kubeconfig failed: %v", err)
-		return
-	}
-	glog.Infof("successfully get kubeconfig")
+	var kubeClient kubernetes.Interface
+	var ptpClient *ptpclient.Clientset
+	var err error
+	if cp.standalone {
+		glog.Infof("running standalone, profiles path set to: %s, leap file set to: %s", cp.profilesPath, cp.leapFile)
+	} else {
+		cfg, err := config.GetKubeConfig()
+		if err != nil {
+			glog.Errorf("get kubeconfig failed: %v", err)
+			return
+		}
+		glog.Infof("successfully get kubeconfig")
 
-	kubeClient, err := kubernetes.NewForConfig(cfg)
-	if err != nil {
-		glog.Errorf("cannot create new config for kubeClient: %v", err)
-		return
-	}
+		kubeClient, err = kubernetes.NewForConfig(cfg)
+		if err != nil {
+			glog.Errorf("cannot create new config for kubeClient: %v", err)
+			return
+		}
 
-	ptpClient, err := ptpclient.NewForConfig(cfg)
-	if err != nil {
-		glog.Errorf("cannot create new config for ptpClient: %v", err)
-		return
+		ptpClient, err = ptpclient.NewForConfig(cfg)
+		if err != nil {
+			glog.Errorf("cannot create new config for ptpClient: %v", err)
+			return
+		}
 	}
 
 	// The name of NodePtpDevice CR for this node is equal to the node name
 	nodeName := os.Getenv("NODE_NAME")
 	podName := os.Getenv("POD_NAME")
+	if nodeName == "" && cp.standalone {
+		if nodeName, err = os.Hostname(); err != nil {
+			glog.Errorf("cannot get hostname: %v", err)
+			return
+		}
+		// the leap manager and the rendered configs read the node name from the environment
+		os.Setenv("NODE_NAME", nodeName)
+	}
 	if nodeName == "" {
 		glog.Error("cannot find NODE_NAME environment variable")
 		return
@@ -100,25 +182,30 @@ func main() {
 		return
 	}
 
-	// label the current linux-ptp-daemon pod with a nodeName label
-	err = labelPod(kubeClient, nodeName, podName)
+	var lm *leap.LeapManager
+	if cp.standalone {
+		lm, err = leap.NewLocal(cp.leapFile)
+	} else {
+		// label the current linux-ptp-daemon pod with a nodeName label
+		err = labelPod(kubeClient, nodeName, podName)
+		if err != nil {
+			glog.Errorf("failed to label linuxptp-daemon with node name, err: %v", err)
+			return
+		}
+		lm, err = leap.New(kubeClient, daemon.PtpNamespace)
+	}
 	if err != nil {
-		glog.Errorf("failed to label linuxptp-daemon with node name, err: %v", err)
+		glog.Error("failed to initialize Leap manager, ", err)
 		return
 	}
+	go lm.Run()
 
 	hwconfigs := []ptpv1.HwConfig{}
 	refreshNodePtpDevice := true
 	closeProcessManager := make(chan bool)
-	lm, err := leap.New(kubeClient, daemon.PtpNamespace)
-	if err != nil {
-		glog.Error("failed to initialize Leap manager, ", err)
-		return
-	}
-	go lm.Run()
 
 	defer close(lm.Close)
-	go daemon.New(
+	dn := daemon.New(
 		nodeName,
 		daemon.PtpNamespace,
 		stdoutToSocket,
@@ -130,7 +217,37 @@ func main() {
 		&refreshNodePtpDevice,
 		closeProcessManager,
 		cp.pmcPollInterval,
-	).Run()
+	)
+	if cp.standalone {
+		dn.SetLeapFile(cp.leapFile)
+	}
+	dn.SetRollbackWindow(time.Second * time.Duration(cp.rollbackWindow))
+	cp.health.StallTimeout = time.Second * time.Duration(cp.healthStallTimeout)
+	dn.SetHealthConfig(cp.health)
+	eventHistory := history.New(cp.historySize)
+	if cp.historyFile != "" {
+		if err = eventHistory.Persist(cp.historyFile); err != nil {
+			glog.Errorf("event history kept in memory only: %v", err)
+		}
+		defer eventHistory.Close()
+	}
+	dn.SetHistory(eventHistory)
+	if cp.notificationAPI {
+		publisher := oran.NewPublisher(nodeName)
+		defer publisher.Close()
+		dn.SetNotificationPublisher(publisher)
+	}
+	if cp.captureFile != "" {
+		recorder, err := capture.NewRecorder(cp.captureFile, int64(cp.captureMaxSize)<<20, cp.captureBackups)
+		if err != nil {
+			glog.Errorf("capture disabled: %v", err)
+		} else {
+			glog.Infof("recording the process output to %s", cp.captureFile)
+			dn.SetCapture(recorder)
+			defer recorder.Close()
+		}
+	}
+	go dn.Run()
 
 	tickerPull := time.NewTicker(time.Second * time.Duration(cp.updateInterval))
 	defer tickerPull.Stop()
@@ -140,7 +257,19 @@ func main() {
 
 	// by default metrics is hosted here,if LOGS_TO_SOCKET variable is set then metrics are disabled
 	if !stdoutToSocket { // if not sending metrics (log) out to a socket then host metrics here
-		daemon.StartMetricsServer("0.0.0.0:9091")
+		daemon.StartMetricsServer("0.0.0.0:9091", dn)
+	} else { // the probes are served anyway
+		daemon.StartHealthServer(cp.healthAddress, dn)
+	}
+
+	profilePath := filepath.Join(cp.profileDir, nodeName)
+	if cp.standalone {
+		profilePath = cp.profilesPath
+	}
+	// the watcher applies profile changes right away, the ticker is kept as a safety net
+	profileCh, err := daemon.WatchProfiles(profilePath, daemon.ProfileWatchDebounce, stopCh)
+	if err != nil {
+		glog.Errorf("failed to watch %s, profile changes are only applied every %d [s]: %v", profilePath, cp.updateInterval, err)
 	}
 
 	for {
@@ -148,31 +277,15 @@ func main() {
 		case <-tickerPull.C:
 			glog.Infof("ticker pull")
 			// Run a loop to update the device status
-			if refreshNodePtpDevice {
+			// there is no NodePtpDevice to update in standalone mode
+			if refreshNodePtpDevice && !cp.standalone {
 				go daemon.RunDeviceStatusUpdate(ptpClient, nodeName, &hwconfigs)
 				refreshNodePtpDevice = false
 			}
-
-			nodeProfile := filepath.Join(cp.profileDir, nodeName)
-			if _, err := os.Stat(nodeProfile); err != nil {
-				if os.IsNotExist(err) {
-					glog.Infof("ptp profile doesn't exist for node: %v", nodeName)
-					continue
-				} else {
-					glog.Errorf("error stating node profile %v: %v", nodeName, err)
-					continue
-				}
-			}
-			nodeProfilesJson, err := os.ReadFile(nodeProfile)
-			if err != nil {
-				glog.Errorf("error reading node profile: %v", nodeProfile)
-				continue
-			}
-
-			err = ptpConfUpdate.UpdateConfig(nodeProfilesJson)
-			if err != nil {
-				glog.Errorf("error updating the node configuration using the profiles loaded: %v", err)
-			}
+			updateConfig(cp, profilePath, ptpConfUpdate)
+		case <-profileCh:
+			glog.Infof("profile change detected in %s", profilePath)
+			updateConfig(cp, profilePath, ptpConfUpdate)
 		case sig := <-sigCh:
 			glog.Info("signal received, shutting down", sig)
 			closeProcessManager <- true
@@ -181,13 +294,45 @@ func main() {
 	}
 }
 
+// updateConfig reads the profiles at profilePath and hands them to ptpConfUpdate, unchanged profiles are ignored
+func updateConfig(cp *cliParams, profilePath string, ptpConfUpdate *daemon.LinuxPTPConfUpdate) {
+	var nodeProfilesJson []byte
+	var err error
+	if cp.standalone {
+		nodeProfilesJson, err = daemon.ReadProfiles(profilePath)
+		if err != nil {
+			glog.Errorf("error reading profiles from %s: %v", profilePath, err)
+			return
+		}
+	} else {
+		if _, err = os.Stat(profilePath); err != nil {
+			if os.IsNotExist(err) {
+				glog.Infof("ptp profile doesn't exist for node: %v", filepath.Base(profilePath))
+			} else {
+				glog.Errorf("error stating node profile %v: %v", filepath.Base(profilePath), err)
+			}
+			return
+		}
+		nodeProfilesJson, err = os.ReadFile(profilePath)
+		if err != nil {
+			glog.Errorf("error reading node profile: %v", profilePath)
+			return
+		}
+	}
+
+	err = ptpConfUpdate.UpdateConfig(nodeProfilesJson)
+	if err != nil {
+		glog.Errorf("error updating the node configuration using the profiles loaded: %v", err)
+	}
+}
+
 type patchStringValue struct {
 	Op    string `json:"op"`
 	Path  string `json:"path"`
 	Value string `json:"value"`
 }
 
-func labelPod(kubeClient *kubernetes.Clientset, nodeName, podName string) (err error) {
+func labelPod(kubeClient kubernetes.Interface, nodeName, podName string) (err error) {
 	pod, err := kubeClient.CoreV1().Pods(daemon.PtpNamespace).Get(context.TODO(), podName, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("error getting linuxptp-daemon pod, err=%s", err)
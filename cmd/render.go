@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/daemon"
+)
+
+const renderUsage = `usage: %s render [flags] <profile.json|->
+
+Prints the configs, command lines and dependent processes the daemon would
+create for the profiles, without touching the node. Hardware plugins are not applied.
+
+`
+
+// render implements the render subcommand, it returns the process exit code
+func render(args []string) int {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), renderUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	nodeName := fs.String("node", os.Getenv("NODE_NAME"), "node name, used in the leapfile path")
+	stdoutToSocket := fs.Bool("logs-to-socket", false, "render as with LOGS_TO_SOCKET set")
+	output := fs.String("o", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || (*output != "text" && *output != "json") {
+		fs.Usage()
+		return 2
+	}
+
+	var data []byte
+	var err error
+	if fs.Arg(0) == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(fs.Arg(0))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read profiles: %v\n", err)
+		return 1
+	}
+	os.Setenv("NODE_NAME", *nodeName)
+
+	profiles, err := daemon.RenderProfiles(data, *stdoutToSocket)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(profiles); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	for i, p := range profiles {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Print(p.String())
+	}
+	return 0
+}
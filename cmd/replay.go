@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/daemon"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
+)
+
+const replayUsage = `usage: %s replay [flags] <capture file>...
+
+Feeds the records of capture files, written with --capture-file, through the parsers,
+DPLL/GNSS state machines, metrics and event logic of the daemon. The events are printed as the daemon
+prints them and the resulting metrics are printed at the end. Give rotated files
+oldest first, i.e. capture.jsonl.2 capture.jsonl.1 capture.jsonl.
+
+`
+
+// replay implements the replay subcommand, it returns the process exit code
+func replay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), replayUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	opts := daemon.ReplayOptions{}
+	fs.StringVar(&opts.NodeName, "node", "replay", "node name of the metrics")
+	fs.Float64Var(&opts.Speed, "speed", 0, "replay at this multiple of the recorded pace, i.e. 1 for real time, as fast as possible when 0")
+	fs.BoolVar(&opts.Echo, "echo", false, "print the replayed lines with the time they were recorded at")
+	leapFile := fs.String("leap-file", filepath.Join(os.TempDir(), "linuxptp-replay-leap-seconds.list"),
+		"leap seconds file, created from the system leap seconds list when missing")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || opts.Speed < 0 {
+		fs.Usage()
+		return 2
+	}
+
+	if _, err := leap.NewLocal(*leapFile); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load the leap seconds: %v\n", err)
+		return 1
+	}
+	var readers []io.Reader
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	if err := daemon.Replay(io.MultiReader(readers...), os.Stdout, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "replay failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,168 @@
+// Package v1 holds the types of version 1 of the daemon HTTP API, served under /api/v1.
+// Fields are only added to a version, a change that breaks clients goes to a new version.
+package v1
+
+import "time"
+
+const (
+	// Version is the version of the API, the apiVersion of every document
+	Version = "v1"
+	// PathPrefix is the path every endpoint of this version is served under
+	PathPrefix = "/api/" + Version
+	// StatusPath serves the Status of the daemon
+	StatusPath = PathPrefix + "/status"
+	// HistoryPath serves the History of the daemon, filtered by the since, until, type, config and source query parameters
+	HistoryPath = PathPrefix + "/history"
+)
+
+// Clock states, as reported by linuxptp and the DPLL, GNSS and GM state logic
+const (
+	StateLocked   = "LOCKED"
+	StateHoldover = "HOLDOVER"
+	StateFreerun  = "FREERUN"
+	StateUnknown  = "UNKNOWN"
+)
+
+// Status is the current state of the daemon
+type Status struct {
+	APIVersion string    `json:"apiVersion"`
+	Node       string    `json:"node"`
+	Time       time.Time `json:"time"`
+	// Profiles are the applied profiles with their processes, by name
+	Profiles []Profile `json:"profiles"`
+	// Configs are the clock states of the event handler, by config name
+	Configs []Config `json:"configs"`
+	// Leap is the leap second data, nil when it is not available
+	Leap *Leap `json:"leap,omitempty"`
+	// Errors are the parts of the status that could not be collected
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Profile is an applied profile
+type Profile struct {
+	Name string `json:"name"`
+	// RunID is the index of the config files of the profile, i.e. 0 for ptp4l.0.config
+	RunID     int       `json:"runID"`
+	Processes []Process `json:"processes"`
+}
+
+// Process is a linuxptp process of a profile, or chronyd
+type Process struct {
+	Name       string `json:"name"`
+	ConfigName string `json:"configName"`
+	Running    bool   `json:"running"`
+	// PID is the pid of the running process, 0 when it is not running
+	PID       int        `json:"pid,omitempty"`
+	StartTime *time.Time `json:"startTime,omitempty"`
+	Uptime    float64    `json:"uptimeSeconds,omitempty"`
+	// Restarts is the number of restarts within the restart policy window
+	Restarts     int   `json:"restarts"`
+	CrashLooping bool  `json:"crashLooping"`
+	LastExit     *Exit `json:"lastExit,omitempty"`
+	// Locked is whether the process reported LOCKED at least once
+	Locked     bool        `json:"locked"`
+	Dependents []Dependent `json:"dependents,omitempty"`
+}
+
+// Exit is how a process last exited
+type Exit struct {
+	Reason string `json:"reason"`
+	Code   int    `json:"code"`
+}
+
+// Dependent is a process started along with a linuxptp process, i.e. gpsd or the DPLL monitor of ts2phc
+type Dependent struct {
+	Name    string `json:"name"`
+	Running bool   `json:"running"`
+}
+
+// Config is the clock state the event handler holds for a config, i.e. ts2phc.0.config
+type Config struct {
+	Name string `json:"name"`
+	// GM is the grandmaster state, nil until one is computed
+	GM *GMState `json:"gm,omitempty"`
+	// ClockClass and ClockAccuracy were last set on the ptp4l of the config, nil until set
+	ClockClass    *uint8 `json:"clockClass,omitempty"`
+	ClockAccuracy *uint8 `json:"clockAccuracy,omitempty"`
+	// Sources are the states of the DPLL, GNSS, ts2phc and other event sources of the config
+	Sources []Source `json:"sources"`
+}
+
+// GMState is the state of a grandmaster, derived from its sources
+type GMState struct {
+	State         string `json:"state"`
+	ClockClass    uint8  `json:"clockClass"`
+	ClockAccuracy uint8  `json:"clockAccuracy"`
+	SourceLost    bool   `json:"sourceLost"`
+	Interface     string `json:"interface,omitempty"`
+}
+
+// Source is the state of an event source of a config, the worst state of its interfaces
+type Source struct {
+	Name       string      `json:"name"`
+	State      string      `json:"state"`
+	Interfaces []Interface `json:"interfaces"`
+}
+
+// Interface is the last state an event source reported for an interface
+type Interface struct {
+	Name         string `json:"name"`
+	State        string `json:"state"`
+	ClockType    string `json:"clockType,omitempty"`
+	SignalSource string `json:"signalSource,omitempty"`
+	SourceLost   bool   `json:"sourceLost"`
+	// Time is when the state was reported
+	Time *time.Time `json:"time,omitempty"`
+	// Values are the last values reported, i.e. offset or gnss_status
+	Values map[string]float64 `json:"values,omitempty"`
+}
+
+// Leap is the leap second data of the node
+type Leap struct {
+	// UTCOffset is the current TAI-UTC offset in seconds
+	UTCOffset int `json:"utcOffset"`
+	// Expiration and Update are the expiration and update times of the leap seconds list
+	Expiration *time.Time  `json:"expiration,omitempty"`
+	Update     *time.Time  `json:"update,omitempty"`
+	Events     []LeapEvent `json:"events"`
+	// Ptp4lConfigs are the grandmaster configs leap announcements are sent to
+	Ptp4lConfigs []string `json:"ptp4lConfigs"`
+}
+
+// LeapEvent is a leap second of the list
+type LeapEvent struct {
+	Time      time.Time `json:"time"`
+	UTCOffset int       `json:"utcOffset"`
+	Comment   string    `json:"comment,omitempty"`
+}
+
+// History event types
+const (
+	EventStateChange       = "stateChange"       // an event source, process or grandmaster changed state
+	EventClockClassChange  = "clockClassChange"  // the clock class of a ptp4l changed
+	EventThresholdCrossing = "thresholdCrossing" // an offset crossed its threshold or holdover specification
+	EventProcessRestart    = "processRestart"    // a process exited and is restarted
+)
+
+// History is the events the daemon recorded over a time range, oldest first
+type History struct {
+	APIVersion string  `json:"apiVersion"`
+	Node       string  `json:"node"`
+	Events     []Event `json:"events"`
+}
+
+// Event is an event of the History
+type Event struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// Config is the config of the source, i.e. ts2phc.0.config
+	Config string `json:"config,omitempty"`
+	// Source is the process or event source, i.e. ptp4l, dpll or GM
+	Source    string `json:"source"`
+	Interface string `json:"interface,omitempty"`
+	// From and To are the previous and new state or clock class, From is empty when unknown
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Message details the event, i.e. the offset or the exit status of a process
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,370 @@
+// Package capture records the output of the linuxptp processes and what the DPLL, GNSS and chronyd monitors read
+// to rotating JSON lines files, which the replay subcommand feeds back through the parsers and state machines.
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/synce"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+)
+
+// Record kinds
+const (
+	KindStart = "start" // a process or monitor started, Start holds what is needed to parse its output
+	KindLine  = "line"  // a line of process output, or of a tool polled by a monitor
+	KindTick  = "tick"  // a monitor polling a tool decided the state from the lines recorded since its previous tick
+	KindDpll  = "dpll"  // an input of a DPLL state decision
+	KindEvent = "event" // an event the replay does not recreate, i.e. the reset sent when a monitor stops
+)
+
+// Record is a line of a capture file
+type Record struct {
+	// Mono is the time since the recording started in seconds, from the monotonic clock
+	Mono    float64     `json:"mono"`
+	Wall    time.Time   `json:"wall"`
+	Kind    string      `json:"kind"`
+	Process string      `json:"process,omitempty"`
+	Config  string      `json:"config,omitempty"`
+	Iface   string      `json:"iface,omitempty"` // tells apart the monitors of a config, i.e. its DPLLs
+	Line    string      `json:"line,omitempty"`
+	Start   *Start      `json:"start,omitempty"`
+	Dpll    *dpll.Input `json:"dpll,omitempty"`
+	Event   *Event      `json:"event,omitempty"`
+}
+
+// Key identifies the process or monitor of the record
+func (r Record) Key() string {
+	return r.Process + "/" + r.Config + "/" + r.Iface
+}
+
+// Start is the state a process was started with
+type Start struct {
+	MessageTag string                   `json:"messageTag"`
+	Ifaces     config.IFaces            `json:"ifaces,omitempty"`
+	ClockType  event.ClockType          `json:"clockType,omitempty"`
+	Threshold  *ptpv1.PtpClockThreshold `json:"threshold,omitempty"`
+	SyncE      *synce.Relations         `json:"synce,omitempty"`
+	// Socket is set when the output was sent to the event socket, the clock ids are then replaced by interface names
+	Socket bool `json:"socket,omitempty"`
+	// Dpll is set for a DPLL
+	Dpll *dpll.Settings `json:"dpll,omitempty"`
+}
+
+// Event is an event.EventChannel with the Go types of its values, which the event logic relies on
+type Event struct {
+	ProcessName        event.EventSource `json:"processName"`
+	State              event.PTPState    `json:"state"`
+	IFace              string            `json:"iface,omitempty"`
+	CfgName            string            `json:"cfgName"`
+	Values             map[string]Value  `json:"values,omitempty"`
+	ClockType          event.ClockType   `json:"clockType,omitempty"`
+	Time               int64             `json:"time"`
+	OutOfSpec          bool              `json:"outOfSpec,omitempty"`
+	WriteToLog         bool              `json:"writeToLog,omitempty"`
+	Reset              bool              `json:"reset,omitempty"`
+	SourceLost         bool              `json:"sourceLost,omitempty"`
+	FrequencyTraceable bool              `json:"frequencyTraceable,omitempty"`
+}
+
+// Value is an event value and its Go type, i.e. {"type":"int64","value":-12}
+type Value struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// NewEvent converts an event for recording
+func NewEvent(e event.EventChannel) *Event {
+	ev := &Event{
+		ProcessName: e.ProcessName, State: e.State, IFace: e.IFace, CfgName: e.CfgName, ClockType: e.ClockType,
+		Time: e.Time, OutOfSpec: e.OutOfSpec, WriteToLog: e.WriteToLog, Reset: e.Reset, SourceLost: e.SourceLost,
+		FrequencyTraceable: e.FrequencyTraceable,
+	}
+	for k, v := range e.Values {
+		b, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		if ev.Values == nil {
+			ev.Values = map[string]Value{}
+		}
+		ev.Values[string(k)] = Value{Type: fmt.Sprintf("%T", v), Value: b}
+	}
+	return ev
+}
+
+// EventChannel converts a recorded event back, values of unknown types are dropped
+func (e *Event) EventChannel() event.EventChannel {
+	ev := event.EventChannel{
+		ProcessName: e.ProcessName, State: e.State, IFace: e.IFace, CfgName: e.CfgName, ClockType: e.ClockType,
+		Time: e.Time, OutOfSpec: e.OutOfSpec, WriteToLog: e.WriteToLog, Reset: e.Reset, SourceLost: e.SourceLost,
+		FrequencyTraceable: e.FrequencyTraceable,
+	}
+	for k, v := range e.Values {
+		if value, err := v.decode(); err == nil {
+			if ev.Values == nil {
+				ev.Values = map[event.ValueType]interface{}{}
+			}
+			ev.Values[event.ValueType(k)] = value
+		}
+	}
+	return ev
+}
+
+func (v Value) decode() (interface{}, error) {
+	var i int64
+	var u uint64
+	var f float64
+	var err error
+	switch v.Type {
+	case "int", "int8", "int16", "int32", "int64":
+		err = json.Unmarshal(v.Value, &i)
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		err = json.Unmarshal(v.Value, &u)
+	case "float32", "float64":
+		err = json.Unmarshal(v.Value, &f)
+	case "string":
+		var s string
+		err = json.Unmarshal(v.Value, &s)
+		return s, err
+	case "bool":
+		var b bool
+		err = json.Unmarshal(v.Value, &b)
+		return b, err
+	default:
+		return nil, fmt.Errorf("unsupported value type %s", v.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch v.Type {
+	case "int":
+		return int(i), nil
+	case "int8":
+		return int8(i), nil
+	case "int16":
+		return int16(i), nil
+	case "int32":
+		return int32(i), nil
+	case "int64":
+		return i, nil
+	case "uint":
+		return uint(u), nil
+	case "uint8":
+		return uint8(u), nil
+	case "uint16":
+		return uint16(u), nil
+	case "uint32":
+		return uint32(u), nil
+	case "uint64":
+		return u, nil
+	case "float32":
+		return float32(f), nil
+	}
+	return f, nil
+}
+
+// Recorder writes records to a file, which is rotated when it grows over maxBytes keeping backups previous files,
+// i.e. capture.jsonl.1 is the previous one. Each file starts with the Start record of every running process.
+type Recorder struct {
+	sync.Mutex
+	path     string
+	maxBytes int64
+	backups  int
+	f        *os.File
+	size     int64
+	start    time.Time
+	// starts are the last Start records by process and config
+	starts map[string]Record
+	keys   []string
+}
+
+// NewRecorder opens path for appending records, the file is rotated when it grows over maxBytes, 0 never rotates it
+func NewRecorder(path string, maxBytes int64, backups int) (*Recorder, error) {
+	r := &Recorder{path: path, maxBytes: maxBytes, backups: backups, start: time.Now(), starts: map[string]Record{}}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Recorder) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open capture file %s: %w", r.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open capture file %s: %w", r.path, err)
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// rotate moves the file to the first backup, shifting the older ones, and opens a new one.
+// When the file cannot be moved, records are appended to it.
+func (r *Recorder) rotate() error {
+	if err := r.f.Close(); err != nil {
+		glog.Errorf("failed to close capture file %s: %v", r.path, err)
+	}
+	r.f = nil
+	var err error
+	if r.backups > 0 {
+		for i := r.backups - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		err = os.Rename(r.path, r.path+".1")
+	} else {
+		err = os.Remove(r.path)
+	}
+	if err != nil {
+		glog.Errorf("failed to rotate capture file %s: %v", r.path, err)
+	}
+	if err = r.open(); err != nil {
+		return err
+	}
+	for _, key := range r.keys {
+		if err = r.write(r.starts[key], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes rec, rotating the file first when it would grow over maxBytes, it is called with the lock held
+func (r *Recorder) write(rec Record, rotate bool) error {
+	if r.f == nil {
+		return fmt.Errorf("capture file %s is closed", r.path)
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if rotate && r.maxBytes > 0 && r.size > 0 && r.size+int64(len(b)) > r.maxBytes {
+		if err = r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.f.Write(b)
+	r.size += int64(n)
+	return err
+}
+
+func (r *Recorder) record(rec Record) {
+	if r == nil {
+		return
+	}
+	now := time.Now()
+	rec.Mono, rec.Wall = now.Sub(r.start).Seconds(), now.UTC()
+	r.Lock()
+	defer r.Unlock()
+	if rec.Kind == KindStart {
+		key := rec.Key()
+		if _, found := r.starts[key]; !found {
+			r.keys = append(r.keys, key)
+		}
+		r.starts[key] = rec
+	}
+	if err := r.write(rec, true); err != nil {
+		glog.Errorf("failed to record %s: %v", rec.Kind, err)
+	}
+}
+
+// Started records that process started with config
+func (r *Recorder) Started(process, config string, start Start) {
+	r.record(Record{Kind: KindStart, Process: process, Config: config, Start: &start})
+}
+
+// Line records a line of output of process
+func (r *Recorder) Line(process, config, line string) {
+	r.record(Record{Kind: KindLine, Process: process, Config: config, Line: line})
+}
+
+// MonitorStarted records that the monitor of process started with config on iface
+func (r *Recorder) MonitorStarted(process, config, iface string, start Start) {
+	r.record(Record{Kind: KindStart, Process: process, Config: config, Iface: iface, Start: &start})
+}
+
+// MonitorLine records a line a monitor read
+func (r *Recorder) MonitorLine(process, config, iface, line string) {
+	r.record(Record{Kind: KindLine, Process: process, Config: config, Iface: iface, Line: line})
+}
+
+// Tick records that a monitor decided the state from the lines it read since its previous tick
+func (r *Recorder) Tick(process, config, iface string) {
+	r.record(Record{Kind: KindTick, Process: process, Config: config, Iface: iface})
+}
+
+// DpllStarted records the settings of a DPLL, it implements dpll.Recorder
+func (r *Recorder) DpllStarted(config, iface string, settings dpll.Settings) {
+	r.MonitorStarted(string(event.DPLL), config, iface, Start{Dpll: &settings})
+}
+
+// DpllInput records an input of a DPLL state decision, it implements dpll.Recorder
+func (r *Recorder) DpllInput(config, iface string, in dpll.Input) {
+	r.record(Record{Kind: KindDpll, Process: string(event.DPLL), Config: config, Iface: iface, Dpll: &in})
+}
+
+// Event records an event
+func (r *Recorder) Event(e event.EventChannel) {
+	r.record(Record{Kind: KindEvent, Process: string(e.ProcessName), Config: e.CfgName, Event: NewEvent(e)})
+}
+
+// Close closes the capture file, nothing is recorded afterwards
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// Reader reads the records of capture files
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewReader returns a Reader of the records of in
+func NewReader(in io.Reader) *Reader {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &Reader{scanner: scanner}
+}
+
+// Next returns the next record, io.EOF after the last one
+func (r *Reader) Next() (Record, error) {
+	var rec Record
+	for r.scanner.Scan() {
+		r.line++
+		if len(r.scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(r.scanner.Bytes(), &rec); err != nil {
+			return rec, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return rec, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return rec, err
+	}
+	return rec, io.EOF
+}
@@ -0,0 +1,109 @@
+package capture
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func readAll(t *testing.T, path string) []Record {
+	f, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer f.Close()
+	var records []Record
+	r := NewReader(f)
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return records
+		}
+		if !assert.NoError(t, err) {
+			return records
+		}
+		records = append(records, rec)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	r, err := NewRecorder(path, 0, 0)
+	assert.NoError(t, err)
+	ifaces := config.IFaces{{Name: "ens1f0", Source: event.GNSS}}
+	r.Started("ts2phc", "ts2phc.0.config", Start{MessageTag: "[ts2phc.0.config:{level}]", Ifaces: ifaces, ClockType: event.GM})
+	r.Line("ts2phc", "ts2phc.0.config", "ts2phc[1.0]: [ts2phc.0.config:6] ens1f0 master offset 0 s2 freq -0")
+	dpll := event.EventChannel{
+		ProcessName: event.DPLL, State: event.PTP_LOCKED, IFace: "ens1f0", CfgName: "ts2phc.0.config", Time: 1700000000000,
+		Values: map[event.ValueType]interface{}{event.OFFSET: int64(-3), event.PHASE_STATUS: 3, event.PPS_STATUS: 1.5,
+			event.CLOCK_QUALITY: "PRC", event.QL: byte(2)},
+	}
+	r.Event(dpll)
+	assert.NoError(t, r.Close())
+	r.Line("ts2phc", "ts2phc.0.config", "not recorded after Close")
+
+	records := readAll(t, path)
+	if !assert.Len(t, records, 3) {
+		return
+	}
+	assert.Equal(t, KindStart, records[0].Kind)
+	assert.Equal(t, ifaces, records[0].Start.Ifaces)
+	assert.Equal(t, KindLine, records[1].Kind)
+	assert.Equal(t, "ts2phc.0.config", records[1].Config)
+	assert.LessOrEqual(t, records[0].Mono, records[1].Mono)
+	assert.Equal(t, KindEvent, records[2].Kind)
+	assert.Equal(t, dpll, records[2].Event.EventChannel(), "the value types are kept")
+}
+
+func TestRecorder_rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	r, err := NewRecorder(path, 400, 2)
+	assert.NoError(t, err)
+	r.Started("phc2sys", "phc2sys.0.config", Start{MessageTag: "[phc2sys.0.config:{level}]"})
+	for i := 0; i < 20; i++ {
+		r.Line("phc2sys", "phc2sys.0.config", "phc2sys[1.0]: [phc2sys.0.config:6] CLOCK_REALTIME phc offset 1 s2 freq -1 delay 500")
+	}
+	assert.NoError(t, r.Close())
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		records := readAll(t, p)
+		if assert.NotEmpty(t, records, p) {
+			assert.Equal(t, KindStart, records[0].Kind, "every file starts with the running processes")
+		}
+		info, err := os.Stat(p)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(400))
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRecorder_monitors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	r, err := NewRecorder(path, 0, 0)
+	assert.NoError(t, err)
+	r.DpllStarted("ts2phc.0.config", "ens1f0", dpll.Settings{ClockID: 1, DependsOn: []event.EventSource{event.GNSS}})
+	r.DpllStarted("ts2phc.0.config", "ens2f0", dpll.Settings{ClockID: 2})
+	r.DpllInput("ts2phc.0.config", "ens2f0", dpll.Input{Source: event.GNSS, State: event.PTP_LOCKED})
+	r.MonitorLine(string(event.GNSS), "ts2phc.0.config", "ens1f0", "UBX-NAV-STATUS:")
+	r.Tick(string(event.GNSS), "ts2phc.0.config", "ens1f0")
+	assert.NoError(t, r.Close())
+
+	records := readAll(t, path)
+	if !assert.Len(t, records, 5) {
+		return
+	}
+	assert.Len(t, r.starts, 2, "the DPLLs of a config are told apart by their interface")
+	assert.Equal(t, uint64(1), records[0].Start.Dpll.ClockID)
+	assert.Equal(t, KindDpll, records[2].Kind)
+	assert.Equal(t, "ens2f0", records[2].Iface)
+	assert.Equal(t, &dpll.Input{Source: event.GNSS, State: event.PTP_LOCKED}, records[2].Dpll)
+	assert.Equal(t, "gnss/ts2phc.0.config/ens1f0", records[3].Key())
+	assert.Equal(t, KindTick, records[4].Kind)
+}
@@ -18,6 +18,13 @@ const (
 	DefaultProfilePath     = "/etc/linuxptp"
 	DefaultLeapConfigPath  = "/etc/leap"
 	DefaultPmcPollInterval = 60
+	// DefaultStandaloneProfilesPath is where profiles are read from in standalone mode
+	DefaultStandaloneProfilesPath = "/etc/linuxptp/profiles"
+	// DefaultStandaloneLeapFile is the leap seconds file maintained in standalone mode
+	DefaultStandaloneLeapFile = "/var/lib/linuxptp-daemon/leap-seconds.list"
+	// DefaultRollbackWindow is how long, in seconds, newly applied profiles have to lock before they are rolled back,
+	// rollbacks are opt-in
+	DefaultRollbackWindow = 0
 )
 
 type IFaces []Iface
@@ -28,14 +35,23 @@ type Iface struct {
 	PhcId    string
 }
 
-// GetGMInterface ... get grandmaster interface
+// GetGMInterface ... get the first grandmaster interface
 func (i *IFaces) GetGMInterface() Iface {
+	if gm := i.GetGMInterfaces(); len(gm) > 0 {
+		return gm[0]
+	}
+	return Iface{}
+}
+
+// GetGMInterfaces ... get every grandmaster interface, the interfaces with a GNSS source
+func (i *IFaces) GetGMInterfaces() IFaces {
+	var gm IFaces
 	for _, iface := range *i {
 		if iface.Source == event.GNSS {
-			return iface
+			gm = append(gm, iface)
 		}
 	}
-	return Iface{}
+	return gm
 }
 
 // Add ...  append interfaces
@@ -104,7 +120,7 @@ func GetKubeConfig() (*rest.Config, error) {
 type ProcessConfig struct {
 	ClockType       event.ClockType
 	ConfigName      string
-	EventChannel    chan<- event.EventChannel
+	EventQueue      *event.Queue
 	GMThreshold     Threshold
 	InitialPTPState event.PTPState
 }
@@ -0,0 +1,50 @@
+package daemon
+
+import (
+	"path/filepath"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/pmc"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ublox"
+)
+
+// chronycName is the chronyd client polled for the tracking status, see chronycTracking
+const chronycName = "chronyc"
+
+// binaries are the executables the daemon starts, by process name
+var binaries = map[string]string{
+	ptp4lProcessName:    "/usr/sbin/ptp4l",
+	phc2sysProcessName:  "/usr/sbin/phc2sys",
+	ts2phcProcessName:   "/usr/sbin/ts2phc",
+	syncEProcessName:    "/usr/sbin/synce4l",
+	chronydProcessName:  "/usr/sbin/chronyd",
+	chronycName:         chronycName,
+	GPSD_PROCESSNAME:    "/usr/local/sbin/gpsd",
+	GPSPIPE_PROCESSNAME: "/usr/local/bin/gpspipe",
+}
+
+// binaryPath returns the executable of a process
+func binaryPath(name string) string {
+	if path, ok := binaries[name]; ok {
+		return path
+	}
+	return name
+}
+
+// SetBinaryDir makes the daemon start the executables found in dir, named after the processes,
+// instead of the system ones; pmc and ubxtool are run from dir as well.
+// It is meant for tests running the daemon against fake linuxptp binaries, and must be called before any profile is applied.
+func SetBinaryDir(dir string) {
+	for name := range binaries {
+		binaries[name] = filepath.Join(dir, name)
+	}
+	pmc.Command = filepath.Join(dir, "pmc")
+	ublox.UBXCommand = filepath.Join(dir, "ubxtool")
+	ublox.UBXInterpreter = ""
+}
+
+// SetGPSDDir makes gpspipe write the NMEA sentences ts2phc reads to a FIFO in dir instead of GPSD_DIR.
+// Like SetBinaryDir it is meant for tests, and must be called before any profile is applied.
+func SetGPSDDir(dir string) {
+	GPSD_DIR = dir
+	GPSPIPE_SERIALPORT = filepath.Join(dir, "data")
+}
@@ -0,0 +1,298 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	ptpnetwork "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/network"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+)
+
+// PtpSettings keys of the chronyd process of a profile, chronyd runs when any of them is set.
+// chronyd disciplines CLOCK_REALTIME from the PTP hardware clock and falls back to NTP servers when PTP is unavailable.
+const (
+	ChronydRefclockSetting = "chronyd.refclock"   // "PHC", "PHC <iface or /dev/ptpN>" or "SOCK <path>"
+	ChronydServersSetting  = "chronyd.ntpServers" // NTP fallback servers, comma separated
+	ChronydConfSetting     = "chronyd.conf"       // chrony.conf directives appended to the rendered config
+)
+
+const (
+	chronydProcessName = "chronyd"
+	// chronydRefID is the reference ID of the PTP refclock, chronyc reports it when it is the selected source
+	chronydRefID = "PTP"
+	// chronyTrackingInterval is the interval between two chronyc tracking polls
+	chronyTrackingInterval = 1 * time.Second
+	chronycTimeout         = 2 * time.Second
+)
+
+// chronyd leap status, as in the NTP leap indicator
+const (
+	chronyLeapNormal int64 = iota
+	chronyLeapInsert
+	chronyLeapDelete
+	chronyLeapUnsynchronised
+)
+
+var chronyLeapStatus = map[string]int64{
+	"Normal":           chronyLeapNormal,
+	"Insert second":    chronyLeapInsert,
+	"Delete second":    chronyLeapDelete,
+	"Not synchronised": chronyLeapUnsynchronised,
+}
+
+// chronydSettings is the chronyd configuration of a profile
+type chronydSettings struct {
+	refclock string // PHC, SOCK or empty for NTP only
+	source   string // interface or PHC device of a PHC refclock, socket path of a SOCK refclock
+	servers  []string
+	extra    string
+}
+
+// getChronydSettings returns the chronyd settings of the profile, nil when chronyd is not enabled
+func getChronydSettings(nodeProfile *ptpv1.PtpProfile) (*chronydSettings, error) {
+	refclock, hasRefclock := nodeProfile.PtpSettings[ChronydRefclockSetting]
+	servers, hasServers := nodeProfile.PtpSettings[ChronydServersSetting]
+	extra, hasExtra := nodeProfile.PtpSettings[ChronydConfSetting]
+	if !hasRefclock && !hasServers && !hasExtra {
+		return nil, nil
+	}
+	s := &chronydSettings{extra: strings.TrimSpace(extra)}
+	if fields := strings.Fields(refclock); len(fields) > 0 {
+		s.refclock = strings.ToUpper(fields[0])
+		switch {
+		case s.refclock != "PHC" && s.refclock != "SOCK":
+			return nil, fmt.Errorf("unknown %s driver %q, expecting PHC or SOCK", ChronydRefclockSetting, fields[0])
+		case len(fields) > 2:
+			return nil, fmt.Errorf("%s %q has too many fields", ChronydRefclockSetting, refclock)
+		case len(fields) == 2:
+			s.source = fields[1]
+		case s.refclock == "SOCK":
+			return nil, fmt.Errorf("%s SOCK requires a socket path", ChronydRefclockSetting)
+		}
+	}
+	for _, server := range strings.Split(servers, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			s.servers = append(s.servers, server)
+		}
+	}
+	return s, nil
+}
+
+// phcDevice returns the PHC of the PHC refclock: the configured device or interface, otherwise the first ptp4l interface
+func (s *chronydSettings) phcDevice(ptp4lIfaces config.IFaces) string {
+	switch {
+	case strings.HasPrefix(s.source, "/dev/"):
+		return s.source
+	case s.source != "":
+		return ptpnetwork.GetPhcId(s.source)
+	case len(ptp4lIfaces) > 0:
+		return ptp4lIfaces[0].PhcId
+	}
+	return ""
+}
+
+// renderChronydConf renders the chrony.conf of a profile, phc is the PHC device of a PHC refclock
+func renderChronydConf(profileName string, runID int, s *chronydSettings, phc string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#profile: %s\n", profileName)
+	fmt.Fprintf(&b, "pidfile %s/chronyd.%d.pid\n", configPrefix, runID)
+	fmt.Fprintf(&b, "bindcmdaddress %s\n", chronydSocketPath(runID))
+	b.WriteString("cmdport 0\n")
+	fmt.Fprintf(&b, "driftfile %s/chronyd.%d.drift\n", configPrefix, runID)
+	b.WriteString("makestep 1 3\n")
+	switch s.refclock {
+	case "PHC":
+		// ptp4l runs the PHC on TAI, chronyd gets the UTC offset from the leap second table
+		b.WriteString("leapsectz right/UTC\n")
+		fmt.Fprintf(&b, "refclock PHC %s poll 0 dpoll -2 refid %s tai prefer\n", phc, chronydRefID)
+	case "SOCK":
+		fmt.Fprintf(&b, "refclock SOCK %s refid %s prefer\n", s.source, chronydRefID)
+	}
+	for _, server := range s.servers {
+		fmt.Fprintf(&b, "server %s iburst\n", server)
+	}
+	if s.extra != "" {
+		b.WriteString(s.extra)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func chronydSocketPath(runID int) string {
+	return fmt.Sprintf("%s/chronyd.%d.sock", configPrefix, runID)
+}
+
+// applyChronyd creates the chronyd process of the profile, if enabled. ptp4lIfaces are the interfaces
+// of the ptp4l process of the profile, the PHC refclock defaults to the first one.
+func (dn *Daemon) applyChronyd(runID int, nodeProfile *ptpv1.PtpProfile, ptp4lIfaces config.IFaces) error {
+	settings, err := getChronydSettings(nodeProfile)
+	if err != nil || settings == nil {
+		return err
+	}
+	phc := ""
+	if settings.refclock == "PHC" {
+		if phc = settings.phcDevice(ptp4lIfaces); phc == "" {
+			glog.Errorf("%s: no PHC found for the chronyd refclock, chronyd uses the NTP servers only", *nodeProfile.Name)
+			settings.refclock = ""
+		}
+	}
+	configFile := fmt.Sprintf("chronyd.%d.config", runID)
+	configPath := fmt.Sprintf("%s/%s", configPrefix, configFile)
+	configOutput := renderChronydConf(*nodeProfile.Name, runID, settings, phc)
+	if dn.dryRun {
+		dn.renderedConfigs[configPath] = configOutput
+	} else if err = os.WriteFile(configPath, []byte(configOutput), 0644); err != nil {
+		return fmt.Errorf("failed to write the configuration file named %s: %v", configPath, err)
+	}
+
+	dn.processManager.process = append(dn.processManager.process, &ptpProcess{
+		name:              chronydProcessName,
+		processConfigPath: configPath,
+		processSocketPath: chronydSocketPath(runID),
+		configName:        configFile,
+		messageTag:        fmt.Sprintf("[%s]", configFile),
+		done:              make(chan struct{}),
+		logFilterRegex:    getLogFilterRegex(nodeProfile),
+		logSampler:        newLogSampler(nodeProfile, chronydProcessName, configFile),
+		cmd:               newCmd(binaryPath(chronydProcessName), "-d", "-f", configPath),
+		nodeProfile:       *nodeProfile,
+		ptpClockThreshold: getPTPThreshold(nodeProfile),
+		restart:           newRestartTracker(getRestartPolicy(nodeProfile)),
+		stopGracePeriod:   getStopGracePeriod(nodeProfile),
+		sched:             getSchedAttr(nodeProfile, chronydProcessName),
+	})
+	return nil
+}
+
+// chronyTracking is the output of chronyc tracking
+type chronyTracking struct {
+	refName    string // refid of a refclock or address of an NTP server
+	stratum    int64
+	lastOffset float64 // seconds
+	leapStatus int64
+}
+
+// parseChronyTracking parses the csv output of chronyc -c tracking:
+// refid,name,stratum,ref time,system time,last offset,rms offset,frequency,residual freq,skew,root delay,root dispersion,update interval,leap status
+func parseChronyTracking(output string) (chronyTracking, error) {
+	fields := strings.Split(strings.TrimSpace(output), ",")
+	if len(fields) < 14 {
+		return chronyTracking{}, fmt.Errorf("unexpected chronyc tracking output %q", output)
+	}
+	t := chronyTracking{refName: fields[1]}
+	var err error
+	if t.stratum, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return chronyTracking{}, fmt.Errorf("invalid stratum %q", fields[2])
+	}
+	if t.lastOffset, err = strconv.ParseFloat(fields[5], 64); err != nil {
+		return chronyTracking{}, fmt.Errorf("invalid last offset %q", fields[5])
+	}
+	leap, ok := chronyLeapStatus[fields[13]]
+	if !ok {
+		return chronyTracking{}, fmt.Errorf("unknown leap status %q", fields[13])
+	}
+	t.leapStatus = leap
+	return t, nil
+}
+
+// offsetNs returns the last offset in nanoseconds
+func (t chronyTracking) offsetNs() int64 {
+	return int64(math.Round(t.lastOffset * 1e9))
+}
+
+// state returns LOCKED when CLOCK_REALTIME follows the PTP refclock within threshold,
+// HOLDOVER when it follows an NTP fallback server and FREERUN when chronyd is not synchronised
+func (t chronyTracking) state(threshold *ptpv1.PtpClockThreshold) event.PTPState {
+	switch {
+	case t.leapStatus == chronyLeapUnsynchronised:
+		return event.PTP_FREERUN
+	case t.refName != chronydRefID:
+		return event.PTP_HOLDOVER
+	case t.offsetNs() > threshold.MaxOffsetThreshold || t.offsetNs() < threshold.MinOffsetThreshold:
+		return event.PTP_FREERUN
+	}
+	return event.PTP_LOCKED
+}
+
+// chronycTracking queries the tracking status of the chronyd listening on socketPath
+func chronycTracking(socketPath string) (chronyTracking, error) {
+	out, err := chronycTrackingOutput(socketPath)
+	if err != nil {
+		return chronyTracking{}, err
+	}
+	return parseChronyTracking(out)
+}
+
+// chronycTrackingOutput returns the csv output of chronyc tracking for the chronyd listening on socketPath
+func chronycTrackingOutput(socketPath string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), chronycTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, binaryPath(chronycName), "-c", "-h", socketPath, "tracking").Output()
+	if err != nil {
+		return "", fmt.Errorf("chronyc tracking: %w", err)
+	}
+	return string(out), nil
+}
+
+// trackChronyd sends the chronyd tracking status to the event pipeline until the process is stopped
+func (p *ptpProcess) trackChronyd() {
+	ticker := time.NewTicker(chronyTrackingInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if p.Stopped() {
+			return
+		}
+		out, err := chronycTrackingOutput(p.processSocketPath)
+		if err != nil {
+			glog.V(2).Infof("%s: %s", p.configName, err)
+			continue
+		}
+		p.capture.Line(chronycName, p.configName, strings.TrimSpace(out))
+		t, err := parseChronyTracking(out)
+		if err != nil {
+			glog.V(2).Infof("%s: %s", p.configName, err)
+			continue
+		}
+		p.processChronyTracking(t)
+	}
+}
+
+// processChronyTracking updates the chronyd metrics and sends the tracking status as a chronyd event,
+// which is only written to the log when the state changed since it is polled every chronyTrackingInterval
+func (p *ptpProcess) processChronyTracking(t chronyTracking) {
+	state := t.state(p.ptpClockThreshold)
+	if state == event.PTP_LOCKED {
+		p.locked.Store(true)
+	}
+	changed := state != p.lastState
+	p.lastState = state
+	UpdateChronyTrackingMetrics(p.configName, t)
+	p.eventQueue.Push(event.EventChannel{
+		ProcessName: event.CHRONY,
+		State:       state,
+		CfgName:     p.configName,
+		IFace:       clockRealTime,
+		Values: map[event.ValueType]interface{}{
+			event.OFFSET:      t.offsetNs(),
+			event.STRATUM:     t.stratum,
+			event.LEAP_STATUS: t.leapStatus,
+		},
+		Time:       time.Now().UnixMilli(),
+		WriteToLog: changed,
+	})
+}
+
+// hasChronydRefclock returns whether the chronyd of the profile has a PTP refclock, otherwise it never locks
+func hasChronydRefclock(nodeProfile *ptpv1.PtpProfile) bool {
+	s, err := getChronydSettings(nodeProfile)
+	return err == nil && s != nil && s.refclock != ""
+}
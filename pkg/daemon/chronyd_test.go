@@ -0,0 +1,110 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+)
+
+func Test_chronyd(t *testing.T) {
+	profiles := `[{"name":"oc","interface":"ens1f0","ptp4lOpts":"-2 -s",
+		"ptpSettings":{"chronyd.refclock":"PHC /dev/ptp3","chronyd.ntpServers":"127.0.0.1, ntp.example.com","chronyd.conf":"maxupdateskew 100","chronyd.cpus":"1"}}]`
+	rendered, err := RenderProfiles([]byte(profiles), false)
+	assert.NoError(t, err)
+	assert.Len(t, rendered[0].Processes, 2)
+	chronyd := rendered[0].Processes[1]
+	assert.Equal(t, chronydProcessName, chronyd.Name)
+	assert.Equal(t, "/usr/sbin/chronyd -d -f "+configPrefix+"/chronyd.0.config", chronyd.CmdLine)
+	assert.Equal(t, "cpus [1]", chronyd.Scheduling)
+	assert.Equal(t, "#profile: oc\n"+
+		"pidfile "+configPrefix+"/chronyd.0.pid\n"+
+		"bindcmdaddress "+configPrefix+"/chronyd.0.sock\n"+
+		"cmdport 0\n"+
+		"driftfile "+configPrefix+"/chronyd.0.drift\n"+
+		"makestep 1 3\n"+
+		"leapsectz right/UTC\n"+
+		"refclock PHC /dev/ptp3 poll 0 dpoll -2 refid PTP tai prefer\n"+
+		"server 127.0.0.1 iburst\n"+
+		"server ntp.example.com iburst\n"+
+		"maxupdateskew 100\n", chronyd.Config)
+
+	for _, invalid := range []map[string]string{
+		{ChronydRefclockSetting: "GPS"},
+		{ChronydRefclockSetting: "SOCK"},
+		{ChronydServersSetting: " "},
+	} {
+		result := ValidateProfiles([]ptpv1.PtpProfile{{Name: pointer.String("p"), PtpSettings: invalid}})
+		assert.True(t, result.HasErrors(), invalid)
+	}
+	two := ValidateProfiles([]ptpv1.PtpProfile{
+		{Name: pointer.String("a"), PtpSettings: map[string]string{ChronydServersSetting: "127.0.0.1"}},
+		{Name: pointer.String("b"), PtpSettings: map[string]string{ChronydServersSetting: "127.0.0.1"}},
+	})
+	assert.Equal(t, ValidationRealtimeConflict, two.Issues[0].Code)
+
+	// phc2sys of any profile must leave CLOCK_REALTIME to chronyd
+	for opts, realtime := range map[string]bool{
+		"-a -r":                       true,
+		"-a -r -r":                    true,
+		"-s ens1f0 -O -37 -m":         true,
+		"-s ens1f0 -c CLOCK_REALTIME": true,
+		"-a":                          false,
+		"-s ens1f0 -c /dev/ptp2":      false,
+		"-s CLOCK_REALTIME -c ens1f1": false,
+		"-w -O -37":                   false,
+	} {
+		assert.Equal(t, realtime, phc2sysDisciplinesRealtime(opts), opts)
+		result := ValidateProfiles([]ptpv1.PtpProfile{
+			{Name: pointer.String("chrony"), PtpSettings: map[string]string{ChronydServersSetting: "127.0.0.1"}},
+			{Name: pointer.String("phc2sys"), Phc2sysOpts: pointer.String(opts)},
+		})
+		assert.Equal(t, realtime, result.HasErrors(), opts)
+	}
+
+	threshold := getPTPThreshold(&ptpv1.PtpProfile{})
+	tests := []struct {
+		output string
+		state  event.PTPState
+		offset int64
+	}{
+		{"50545000,PTP,1,1700000000.000000001,0.000000010,-0.000000012,0.000000020,-1.5,0.0,0.01,0.0,0.000001,1.0,Normal\n", event.PTP_LOCKED, -12},
+		{"50545000,PTP,1,1700000000.000000001,0.000000010,0.000002000,0.000000020,-1.5,0.0,0.01,0.0,0.000001,1.0,Normal\n", event.PTP_FREERUN, 2000},
+		{"7F000001,127.0.0.1,3,1700000000.000000001,0.000000010,0.000050000,0.000000020,-1.5,0.0,0.01,0.0,0.000001,64.0,Normal\n", event.PTP_HOLDOVER, 50000},
+		{"00000000,,0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,Not synchronised\n", event.PTP_FREERUN, 0},
+	}
+	for _, tt := range tests {
+		tracking, err := parseChronyTracking(tt.output)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.state, tracking.state(threshold), tt.output)
+		assert.Equal(t, tt.offset, tracking.offsetNs())
+	}
+	_, err = parseChronyTracking("506 Cannot talk to daemon")
+	assert.Error(t, err)
+
+	eventQueue := event.NewQueue("node", 1, nil, nil, nil)
+	defer eventQueue.Close()
+	p := testProcess(chronydProcessName, "chronyd.0.config", "oc")
+	p.eventQueue = eventQueue
+	tracking, _ := parseChronyTracking(tests[0].output)
+	p.processChronyTracking(tracking)
+	ev := <-eventQueue.Out()
+	assert.Equal(t, event.CHRONY, ev.ProcessName)
+	assert.Equal(t, event.PTP_LOCKED, ev.State)
+	assert.Equal(t, clockRealTime, ev.IFace)
+	assert.Equal(t, map[event.ValueType]interface{}{event.OFFSET: int64(-12), event.STRATUM: int64(1), event.LEAP_STATUS: int64(0)}, ev.Values)
+	assert.True(t, ev.WriteToLog)
+	assert.True(t, p.locked.Load())
+	p.processChronyTracking(tracking)
+	ev = <-eventQueue.Out()
+	assert.False(t, ev.WriteToLog, "unchanged state")
+	tracking, _ = parseChronyTracking(tests[2].output)
+	p.processChronyTracking(tracking)
+	ev = <-eventQueue.Out()
+	assert.Equal(t, event.PTP_HOLDOVER, ev.State)
+	assert.True(t, ev.WriteToLog)
+	assert.Equal(t, float64(3), testutil.ToFloat64(ChronyStratum.WithLabelValues(chronydProcessName, NodeName, "chronyd.0.config")))
+}
@@ -2,13 +2,14 @@ package daemon
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ptpconf"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/synce"
 
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
@@ -23,19 +24,17 @@ import (
 // and contains linuxPTP conf to be updated. It's rendered
 // and passed to linuxptp instance by daemon.
 type LinuxPTPConfUpdate struct {
+	// lock guards NodeProfiles, names and appliedNodeProfileJson, UpdateConfig writes them from main while the daemon reads them
+	lock                   sync.Mutex
 	UpdateCh               chan bool
 	NodeProfiles           []ptpv1.PtpProfile
+	names                  []string // names of NodeProfiles, taken on update for the probes, see profileNames
 	appliedNodeProfileJson []byte
 	defaultPTP4lConfig     []byte
 }
 
-type ptp4lConfSection struct {
-	sectionName string
-	options     map[string]string
-}
-
 type ptp4lConf struct {
-	sections         []ptp4lConfSection
+	file             *ptpconf.File
 	mapping          []string
 	profile_name     string
 	clock_type       event.ClockType
@@ -60,13 +59,17 @@ func NewLinuxPTPConfUpdate() (*LinuxPTPConfUpdate, error) {
 }
 
 func (l *LinuxPTPConfUpdate) UpdateConfig(nodeProfilesJson []byte) error {
+	l.lock.Lock()
 	if string(l.appliedNodeProfileJson) == string(nodeProfilesJson) {
+		l.lock.Unlock()
 		return nil
 	}
 	if nodeProfiles, ok := tryToLoadConfig(nodeProfilesJson); ok {
 		glog.Info("load profiles")
 		l.appliedNodeProfileJson = nodeProfilesJson
 		l.NodeProfiles = nodeProfiles
+		l.names = nodeProfileNames(nodeProfiles)
+		l.lock.Unlock()
 		l.UpdateCh <- true
 
 		return nil
@@ -76,6 +79,7 @@ func (l *LinuxPTPConfUpdate) UpdateConfig(nodeProfilesJson []byte) error {
 		// Support empty old config
 		// '{"name":null,"interface":null}'
 		if nodeProfiles[0].Name == nil || nodeProfiles[0].Interface == nil {
+			l.lock.Unlock()
 			glog.Infof("Skip no profile %+v", nodeProfiles[0])
 			return nil
 		}
@@ -83,14 +87,46 @@ func (l *LinuxPTPConfUpdate) UpdateConfig(nodeProfilesJson []byte) error {
 		glog.Info("load profiles using old method")
 		l.appliedNodeProfileJson = nodeProfilesJson
 		l.NodeProfiles = nodeProfiles
+		l.names = nodeProfileNames(nodeProfiles)
+		l.lock.Unlock()
 		l.UpdateCh <- true
 
 		return nil
 	}
+	l.lock.Unlock()
 
 	return fmt.Errorf("unable to load profile config")
 }
 
+// nodeProfiles returns a copy of the profiles to apply and the JSON they were loaded from
+func (l *LinuxPTPConfUpdate) nodeProfiles() ([]ptpv1.PtpProfile, []byte) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	profiles := make([]ptpv1.PtpProfile, 0, len(l.NodeProfiles))
+	for i := range l.NodeProfiles {
+		profiles = append(profiles, *l.NodeProfiles[i].DeepCopy())
+	}
+	return profiles, l.appliedNodeProfileJson
+}
+
+// profileNames returns the names of the profiles to apply, as they were when last updated
+func (l *LinuxPTPConfUpdate) profileNames() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.names
+}
+
+// nodeProfileNames returns the names of the named profiles
+func nodeProfileNames(nodeProfiles []ptpv1.PtpProfile) []string {
+	var names []string
+	for _, profile := range nodeProfiles {
+		if profile.Name != nil {
+			names = append(names, *profile.Name)
+		}
+	}
+	return names
+}
+
 // Try to load the multiple policy config
 func tryToLoadConfig(nodeProfilesJson []byte) ([]ptpv1.PtpProfile, bool) {
 	ptpConfig := []ptpv1.PtpProfile{}
@@ -115,60 +151,35 @@ func tryToLoadOldConfig(nodeProfilesJson []byte) ([]ptpv1.PtpProfile, bool) {
 
 // Takes as input a PtpProfile.Ptp4lConf and outputs as ptp4lConf struct
 func (output *ptp4lConf) populatePtp4lConf(config *string) error {
-	var currentSectionName string
-	var currentSection ptp4lConfSection
-	output.sections = make([]ptp4lConfSection, 0)
-	globalIsDefined := false
-	hasSlaveConfigDefined := false
-
+	text := ""
 	if config != nil {
-		for _, line := range strings.Split(*config, "\n") {
-			line = strings.TrimSpace(line)
-			if strings.HasPrefix(line, "#") {
-				continue
-			} else if strings.HasPrefix(line, "[") {
-				if currentSectionName != "" {
-					output.sections = append(output.sections, currentSection)
-				}
-				currentLine := strings.Split(line, "]")
-
-				if len(currentLine) < 2 {
-					return errors.New("Section missing closing ']': " + line)
-				}
+		text = *config
+	}
+	file, err := ptpconf.Parse(text)
+	if err != nil {
+		return err
+	}
+	output.file = file
+	if file.Global() == nil {
+		file.AddSection(ptpconf.GlobalSection)
+	}
 
-				currentSectionName = fmt.Sprintf("%s]", currentLine[0])
-				if currentSectionName == "[global]" {
-					globalIsDefined = true
-				}
-				currentSection = ptp4lConfSection{options: map[string]string{}, sectionName: currentSectionName}
-			} else if currentSectionName != "" {
-				split := strings.IndexByte(line, ' ')
-				if split > 0 {
-					currentSection.options[line[:split]] = line[split:]
-					if (line[:split] == "masterOnly" && line[split:] == "0") ||
-						(line[:split] == "serverOnly" && line[split:] == "0") ||
-						(line[:split] == "slaveOnly" && line[split:] == "1") ||
-						(line[:split] == "clientOnly" && line[split:] == "1") {
-						hasSlaveConfigDefined = true
-					}
-				}
-			} else {
-				return errors.New("Config option not in section: " + line)
+	hasSlaveConfigDefined := false
+	for _, section := range file.Sections {
+		for _, option := range section.Options() {
+			if (option.Key == "masterOnly" && option.Value == "0") ||
+				(option.Key == "serverOnly" && option.Value == "0") ||
+				(option.Key == "slaveOnly" && option.Value == "1") ||
+				(option.Key == "clientOnly" && option.Value == "1") {
+				hasSlaveConfigDefined = true
 			}
 		}
-		if currentSectionName != "" {
-			output.sections = append(output.sections, currentSection)
-		}
-	}
-
-	if !globalIsDefined {
-		output.sections = append(output.sections, ptp4lConfSection{options: map[string]string{}, sectionName: "[global]"})
 	}
 
 	if !hasSlaveConfigDefined {
 		// No Slave Interfaces defined
 		output.clock_type = event.GM
-	} else if len(output.sections) > 2 {
+	} else if len(file.SectionsOfType(ptpconf.Port)) > 1 {
 		// Multiple interfaces with at least one slave Interface defined
 		output.clock_type = event.BC
 	} else {
@@ -178,6 +189,26 @@ func (output *ptp4lConf) populatePtp4lConf(config *string) error {
 	return nil
 }
 
+// global returns the global section, populatePtp4lConf makes sure there is one
+func (conf *ptp4lConf) global() *ptpconf.Section {
+	return conf.file.Global()
+}
+
+// readsNmea returns whether ts2phc, started with opts, reads the time of day from NMEA: the GNSS receiver
+// is then read through gpsd and gpspipe, which serve a single receiver per node
+func (conf *ptp4lConf) readsNmea(opts string) bool {
+	if conf.file.Section(ptpconf.NmeaSection) != nil {
+		return true
+	}
+	fields := strings.Fields(opts)
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == "-s" && fields[i+1] == "nmea" {
+			return true
+		}
+	}
+	return false
+}
+
 func getSource(isTs2phcMaster string) event.EventSource {
 	if ts2phcMaster, err := strconv.ParseBool(strings.TrimSpace(isTs2phcMaster)); err == nil {
 		if ts2phcMaster {
@@ -206,8 +237,9 @@ func (conf *ptp4lConf) extractSynceRelations() *synce.Relations {
 	synceRelationInfo := synce.Config{}
 
 	var extendedTlv, networkOption int = synce.ExtendedTLV_DISABLED, synce.SYNCE_NETWORK_OPT_1
-	for _, section := range conf.sections {
-		if strings.HasPrefix(section.sectionName, "[<") {
+	for _, section := range conf.file.Sections {
+		switch section.Type() {
+		case ptpconf.SynceDevice:
 			if synceRelationInfo.Name != "" {
 				if len(ifaces) > 0 {
 					synceRelationInfo.Ifaces = ifaces
@@ -226,23 +258,23 @@ func (conf *ptp4lConf) extractSynceRelations() *synce.Relations {
 			}
 			extendedTlv, networkOption = synce.ExtendedTLV_DISABLED, synce.SYNCE_NETWORK_OPT_1
 
-			synceRelationInfo.Name = re.ReplaceAllString(section.sectionName, "")
-			if networkOptionStr, ok := section.options["network_option"]; ok {
+			synceRelationInfo.Name = re.ReplaceAllString(section.Name, "")
+			if networkOptionStr, ok := section.Get("network_option"); ok {
 				if networkOption, err = strconv.Atoi(strings.TrimSpace(networkOptionStr)); err != nil {
 					glog.Errorf("error parsing `network_option`, setting network_option to default 1 : %s", err)
 				}
 			}
-			if extendedTlvStr, ok := section.options["extended_tlv"]; ok {
+			if extendedTlvStr, ok := section.Get("extended_tlv"); ok {
 				if extendedTlv, err = strconv.Atoi(strings.TrimSpace(extendedTlvStr)); err != nil {
 					glog.Errorf("error parsing `extended_tlv`, setting extended_tlv to default 1 : %s", err)
 				}
 			}
 			synceRelationInfo.NetworkOption = networkOption
 			synceRelationInfo.ExtendedTlv = extendedTlv
-		} else if strings.HasPrefix(section.sectionName, "[{") {
-			synceRelationInfo.ExternalSource = re.ReplaceAllString(section.sectionName, "")
-		} else if strings.HasPrefix(section.sectionName, "[") && section.sectionName != "[global]" {
-			iface := re.ReplaceAllString(section.sectionName, "")
+		case ptpconf.SynceExternalSource:
+			synceRelationInfo.ExternalSource = re.ReplaceAllString(section.Name, "")
+		case ptpconf.Port:
+			iface := re.ReplaceAllString(section.Name, "")
 			ifaces = append(ifaces, iface)
 		}
 	}
@@ -256,53 +288,39 @@ func (conf *ptp4lConf) extractSynceRelations() *synce.Relations {
 }
 
 func (conf *ptp4lConf) renderSyncE4lConf(ptpSettings map[string]string) (configOut string, relations *synce.Relations) {
-	configOut = fmt.Sprintf("#profile: %s\n", conf.profile_name)
 	relations = conf.extractSynceRelations()
 	relations.AddClockIds(ptpSettings)
-	deviceIdx := 0
-	for _, section := range conf.sections {
-		configOut = fmt.Sprintf("%s\n%s", configOut, section.sectionName)
-		if strings.HasPrefix(section.sectionName, "[<") {
-			if _, found := section.options["clock_id"]; !found {
-				section.options["clock_id"] = relations.Devices[deviceIdx].ClockId
-				deviceIdx++
-			}
-		}
-		for k, v := range section.options {
-			configOut = fmt.Sprintf("%s\n%s %s", configOut, k, v)
+	for deviceIdx, section := range conf.file.SectionsOfType(ptpconf.SynceDevice) {
+		if !section.Has("clock_id") {
+			section.Set("clock_id", relations.Devices[deviceIdx].ClockId)
 		}
 	}
-	return
+	return fmt.Sprintf("#profile: %s\n%s", conf.profile_name, conf.file), relations
 }
 
 func (conf *ptp4lConf) renderPtp4lConf() (configOut string, ifaces config.IFaces) {
-	configOut = fmt.Sprintf("#profile: %s\n", conf.profile_name)
 	conf.mapping = nil
 	var nmea_source event.EventSource
 
-	for _, section := range conf.sections {
-		configOut = fmt.Sprintf("%s\n%s", configOut, section.sectionName)
-
-		if section.sectionName == "[nmea]" {
-			if source, ok := section.options["ts2phc.master"]; ok {
+	for _, section := range conf.file.Sections {
+		switch section.Type() {
+		case ptpconf.Nmea:
+			if source, ok := section.Get("ts2phc.master"); ok {
 				nmea_source = getSource(source)
 			}
-		}
-		if section.sectionName != "[global]" && section.sectionName != "[nmea]" {
-			i := section.sectionName
-			i = strings.ReplaceAll(i, "[", "")
-			i = strings.ReplaceAll(i, "]", "")
+		case ptpconf.Port:
+			i := section.Name
 			conf.mapping = append(conf.mapping, i)
 			iface := config.Iface{Name: i}
-			if source, ok := section.options["ts2phc.master"]; ok {
+			if source, ok := section.Get("ts2phc.master"); ok {
 				iface.Source = getSource(source)
 			} else {
 				// if not defined here, use source defined at nmea section
 				iface.Source = nmea_source
 			}
-			if masterOnly, ok := section.options["masterOnly"]; ok {
+			if masterOnly, ok := section.Get("masterOnly"); ok {
 				// TODO add error handling
-				iface.IsMaster, _ = strconv.ParseBool(strings.TrimSpace(masterOnly))
+				iface.IsMaster, _ = strconv.ParseBool(masterOnly)
 			}
 			ifaces = append(ifaces, config.Iface{
 				Name:   iface.Name,
@@ -310,9 +328,6 @@ func (conf *ptp4lConf) renderPtp4lConf() (configOut string, ifaces config.IFaces
 				PhcId:  iface.PhcId,
 			})
 		}
-		for k, v := range section.options {
-			configOut = fmt.Sprintf("%s\n%s %s", configOut, k, v)
-		}
 	}
-	return configOut, ifaces
+	return fmt.Sprintf("#profile: %s\n%s", conf.profile_name, conf.file), ifaces
 }
@@ -0,0 +1,37 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_populatePtp4lConf(t *testing.T) {
+	tests := []struct {
+		name      string
+		conf      string
+		clockType event.ClockType
+		ifaces    []string
+	}{
+		{"grandmaster", "[ens1f0]\nmasterOnly 1\n[global]\ndomainNumber 24\n", event.GM, []string{"ens1f0"}},
+		{"ordinary clock", "[ens1f0]\nmasterOnly 0\n[global]\n", event.OC, []string{"ens1f0"}},
+		{"boundary clock", "[ens1f0]\nmasterOnly  0\n[ens1f1]\nmasterOnly 1\n[global]\n", event.BC, []string{"ens1f0", "ens1f1"}},
+		{"unicast", "[global]\nslaveOnly 1\n[ens1f0]\n[unicast_master_table]\ntable_id 1\nUDPv4 10.0.0.1\nUDPv4 10.0.0.2\n", event.OC, []string{"ens1f0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &ptp4lConf{}
+			assert.NoError(t, conf.populatePtp4lConf(&tt.conf))
+			assert.Equal(t, tt.clockType, conf.clock_type)
+			conf.profile_name = "test"
+			out, ifaces := conf.renderPtp4lConf()
+			assert.Equal(t, "#profile: test\n"+tt.conf, out)
+			var names []string
+			for _, iface := range ifaces {
+				names = append(names, iface.Name)
+			}
+			assert.Equal(t, tt.ifaces, names)
+		})
+	}
+}
@@ -13,17 +13,22 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/synce"
 
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/capture"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
 
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/history"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/logging"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/oran"
 
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/eventsocket"
 	ptpnetwork "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/network"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/pmc"
 
@@ -60,10 +65,10 @@ var (
 var configPrefix = "/var/run"
 
 var ptpProcesses = []string{
-	ts2phcProcessName,  // there can be only one ts2phc process in the system
+	ts2phcProcessName,  // there can be only one ts2phc process per profile, more than one in the system
 	syncEProcessName,   // there can be only one synce Process per profile
 	ptp4lProcessName,   // there could be more than one ptp4l in the system
-	phc2sysProcessName, // there can be only one phc2sys process in the system
+	phc2sysProcessName, // there can be only one phc2sys process per profile, more than one in the system
 }
 
 var ptpTmpFiles = []string{
@@ -71,6 +76,7 @@ var ptpTmpFiles = []string{
 	syncEProcessName,
 	ptp4lProcessName,
 	phc2sysProcessName,
+	chronydProcessName,
 	pmcSocketName,
 }
 
@@ -80,8 +86,9 @@ var ptpTmpFiles = []string{
 // or stopped simultaneously.
 type ProcessManager struct {
 	process         []*ptpProcess
-	eventChannel    chan event.EventChannel
+	eventQueue      *event.Queue
 	ptpEventHandler *event.EventHandler
+	appliedProfiles map[string]appliedProfile // running profiles by name, used to restart only what changed
 }
 
 // NewProcessManager is used by unit tests
@@ -157,21 +164,30 @@ type ptpProcess struct {
 	processConfigPath string
 	configName        string
 	messageTag        string
-	eventCh           chan event.EventChannel
-	exitCh            chan bool
+	eventQueue        *event.Queue
+	done              chan struct{} // closed by cmdRun once the process was waited for the last time
 	execMutex         sync.Mutex
 	stopped           bool
 	logFilterRegex    string
+	logSampler        *logSampler
+	capture           *capture.Recorder // records the output, nil unless enabled, see Daemon.SetCapture
+	history           *history.History  // records the transitions, see Daemon.SetHistory
+	inThreshold       map[string]bool   // whether the last ts2phc offset was within the thresholds, by interface
 	cmd               *exec.Cmd
 	depProcess        []process // these are list of dependent process which needs to be started/stopped if the parent process is starts/stops
 	nodeProfile       ptpv1.PtpProfile
 	parentClockClass  float64
+	lastState         event.PTPState // last clock state of ptp4l, phc2sys or chronyd, see publishState and processChronyTracking
 	pmcCheck          bool
 	clockType         event.ClockType
 	ptpClockThreshold *ptpv1.PtpClockThreshold
 	haProfile         map[string][]string // stores list of interface name for each profile
 	syncERelations    *synce.Relations
 	c                 *net.Conn
+	restart           *restartTracker
+	stopGracePeriod   time.Duration
+	sched             schedAttr
+	locked            atomic.Bool // reported LOCKED at least once, see ProcessManager.health
 }
 
 func (p *ptpProcess) Stopped() bool {
@@ -197,7 +213,7 @@ type Daemon struct {
 	stdoutToSocket bool
 
 	// kubeClient allows interaction with Kubernetes, including the node we are running on.
-	kubeClient *kubernetes.Clientset
+	kubeClient kubernetes.Interface
 
 	ptpUpdate *LinuxPTPConfUpdate
 
@@ -215,6 +231,25 @@ type Daemon struct {
 
 	// Allow vendors to include plugins
 	pluginManager PluginManager
+
+	// dryRun renders profiles without touching the node, see RenderProfiles
+	dryRun bool
+	// renderedConfigs holds the config files rendered in dryRun mode, by path
+	renderedConfigs map[string]string
+
+	// rollback holds the last known good profiles, see SetRollbackWindow
+	rollback rollbackState
+
+	// health is the status of the /healthz and /readyz probes, see SetHealthConfig
+	health healthState
+	// capture records the output of the processes, see SetCapture
+	capture *capture.Recorder
+	// history records the transitions, see SetHistory
+	history *history.History
+	// notifications serves the O-RAN notification API, see SetNotificationPublisher
+	notifications *oran.Publisher
+	// leapFile is the leap seconds file given to ptp4l and ts2phc, see SetLeapFile
+	leapFile string
 }
 
 // New LinuxPTP is called by daemon to generate new linuxptp instance
@@ -222,7 +257,7 @@ func New(
 	nodeName string,
 	namespace string,
 	stdoutToSocket bool,
-	kubeClient *kubernetes.Clientset,
+	kubeClient kubernetes.Interface,
 	ptpUpdate *LinuxPTPConfUpdate,
 	stopCh <-chan struct{},
 	plugins []string,
@@ -236,7 +271,10 @@ func New(
 	}
 	InitializeOffsetMaps()
 	pluginManager := registerPlugins(plugins)
-	eventChannel := make(chan event.EventChannel, 100)
+	eventQueue := event.NewQueue(nodeName, event.DefaultQueueCapacity, EventQueueDepth, EventQueueDropped, EventQueueCoalesced)
+	eventHandler := event.Init(nodeName, stdoutToSocket, eventSocket, eventQueue, closeManager, Offset, ClockState, ClockClassMetrics)
+	event.StateRegisterer.SetMetrics(nodeName, event.SubscriberMetrics{Depth: EventSubscriberQueueDepth, Lag: EventSubscriberLag,
+		Healthy: EventSubscriberHealthy, Dropped: EventSubscriberDropped, Failures: EventSubscriberFailures})
 	return &Daemon{
 		nodeName:             nodeName,
 		namespace:            namespace,
@@ -250,10 +288,11 @@ func New(
 		//TODO:Enable only for GM
 		processManager: &ProcessManager{
 			process:         nil,
-			eventChannel:    eventChannel,
-			ptpEventHandler: event.Init(nodeName, stdoutToSocket, eventSocket, eventChannel, closeManager, Offset, ClockState, ClockClassMetrics),
+			eventQueue:      eventQueue,
+			ptpEventHandler: eventHandler,
 		},
 		stopCh: stopCh,
+		health: healthState{config: DefaultHealthConfig()},
 	}
 }
 
@@ -262,15 +301,26 @@ func (dn *Daemon) Run() {
 	go dn.processManager.ptpEventHandler.ProcessEvents()
 	tickerPmc := time.NewTicker(time.Second * time.Duration(dn.pmcPollInterval))
 	defer tickerPmc.Stop()
+	tickerRollback := time.NewTicker(rollbackCheckInterval)
+	defer tickerRollback.Stop()
+	tickerHealth := time.NewTicker(healthCheckInterval)
+	defer tickerHealth.Stop()
+	dn.updateHealth()
 	for {
 		select {
 		case <-dn.ptpUpdate.UpdateCh:
-			err := dn.applyNodePTPProfiles()
+			appliedJson, err := dn.applyNodePTPProfiles()
 			if err != nil {
 				glog.Errorf("linuxPTP apply node profile failed: %v", err)
 			}
+			dn.watchAppliedProfiles(appliedJson, err)
+			dn.updateHealth()
 		case <-tickerPmc.C:
 			dn.HandlePmcTicker()
+		case <-tickerRollback.C:
+			dn.checkAppliedProfiles()
+		case <-tickerHealth.C:
+			dn.updateHealth()
 		case <-dn.stopCh:
 			for _, p := range dn.processManager.process {
 				if p != nil {
@@ -310,6 +360,19 @@ func (dn *Daemon) SetProcessManager(p *ProcessManager) {
 	dn.processManager = p
 }
 
+// SetLeapFile sets the leap seconds file the processes read, the one the leap manager keeps
+// for the node in config.DefaultLeapConfigPath by default
+func (dn *Daemon) SetLeapFile(path string) {
+	dn.leapFile = path
+}
+
+func (dn *Daemon) leapFilePath() string {
+	if dn.leapFile != "" {
+		return dn.leapFile
+	}
+	return fmt.Sprintf("%s/%s", config.DefaultLeapConfigPath, os.Getenv("NODE_NAME"))
+}
+
 // Delete all socket and config files
 func (dn *Daemon) cleanupTempFiles() error {
 	glog.Infof("Cleaning up temporary files")
@@ -328,51 +391,22 @@ func (dn *Daemon) cleanupTempFiles() error {
 	return nil
 }
 
-func (dn *Daemon) applyNodePTPProfiles() error {
-	glog.Infof("in applyNodePTPProfiles")
-	for _, p := range dn.processManager.process {
-		if p != nil {
-			glog.Infof("stopping process.... %s", p.name)
-			p.cmdStop()
-			if p.depProcess != nil {
-				for _, d := range p.depProcess {
-					if d != nil {
-						d.CmdStop()
-						d = nil
-					}
-				}
-			}
-			p.depProcess = nil
-			//cleanup metrics
-			deleteMetrics(p.ifaces, p.haProfile, p.name, p.configName)
-			if p.name == syncEProcessName && p.syncERelations != nil {
-				deleteSyncEMetrics(p.name, p.configName, p.syncERelations)
+// Delete the socket and config files of a single runID
+func (dn *Daemon) cleanupRunFiles(runID int) {
+	for _, p := range ptpTmpFiles {
+		files, _ := filepath.Glob(fmt.Sprintf("%s/%s.%d.*", configPrefix, p, runID))
+		for _, file := range files {
+			if err := os.Remove(file); err != nil {
+				glog.Infof("Failed deleting %s", file)
 			}
-			p = nil
 		}
 	}
+}
 
-	// All process should have been stopped,
-	// clear process in process manager.
-	// Assigning processManager.process to nil releases
-	// the underlying slice to the garbage
-	// collector (assuming there are no other
-	// references).
-	dn.processManager.process = nil
-
-	// All configs will be rebuild, and sockets recreated, so they can all be deleted
-	dn.cleanupTempFiles()
-
-	// TODO:
-	// compare nodeProfile with previous config,
-	// only apply when nodeProfile changes
-
-	//clear hwconfig before updating
-	*dn.hwconfigs = []ptpv1.HwConfig{}
-
-	glog.Infof("updating NodePTPProfiles to:")
-	runID := 0
-	slices.SortFunc(dn.ptpUpdate.NodeProfiles, func(a, b ptpv1.PtpProfile) int {
+// sortProfiles orders profiles the way they are applied: the profiles running phc2sys last, so the ptp4l
+// instances they follow are started first, and by name. Any number of profiles may run phc2sys.
+func sortProfiles(profiles []ptpv1.PtpProfile) {
+	slices.SortFunc(profiles, func(a, b ptpv1.PtpProfile) int {
 		aHasPhc2sysOpts := a.Phc2sysOpts != nil && *a.Phc2sysOpts != ""
 		bHasPhc2sysOpts := b.Phc2sysOpts != nil && *b.Phc2sysOpts != ""
 		//sorted in ascending order
@@ -384,32 +418,109 @@ func (dn *Daemon) applyNodePTPProfiles() error {
 		}
 		return cmp.Compare(*a.Name, *b.Name)
 	})
-	for _, profile := range dn.ptpUpdate.NodeProfiles {
-		err := dn.applyNodePtpProfile(runID, &profile)
+}
+
+// applyNodePTPProfiles applies the profiles of LinuxPTPConfUpdate, it returns their JSON as read along with them,
+// so an update received while applying is not mistaken for the applied profiles
+func (dn *Daemon) applyNodePTPProfiles() ([]byte, error) {
+	nodeProfiles, nodeProfilesJson := dn.ptpUpdate.nodeProfiles()
+	return nodeProfilesJson, dn.applyProfiles(nodeProfiles)
+}
+
+// applyProfiles applies nodeProfiles in place of the running ones, the profiles of LinuxPTPConfUpdate are left alone
+func (dn *Daemon) applyProfiles(nodeProfiles []ptpv1.PtpProfile) error {
+	glog.Infof("in applyNodePTPProfiles")
+	// Invalid profiles are rejected before anything is stopped, the running processes are left as they are
+	validation := ValidateProfiles(nodeProfiles)
+	validation.Log()
+	if validation.HasErrors() {
+		dn.setValidationStatus(validation)
+		return fmt.Errorf("%w: %w", errProfilesRejected, validation.Err())
+	}
+	sortProfiles(nodeProfiles)
+
+	// Only profiles that were added, removed or changed are (re)started;
+	// processes of unchanged profiles and their dependent processes keep running
+	keep := dn.processManager.unchangedProfiles(nodeProfiles)
+	runIDs := dn.processManager.assignRunIDs(nodeProfiles, keep)
+	dn.rollback.pendingRunIDs = runIDs
+
+	var running []*ptpProcess
+	for _, p := range dn.processManager.process {
+		if p == nil {
+			continue
+		}
+		if p.nodeProfile.Name != nil && keep[*p.nodeProfile.Name] {
+			running = append(running, p)
+			continue
+		}
+		p.stopProcess()
+		dn.beat()
+	}
+	dn.processManager.process = running
+	dn.processManager.removeAppliedProfiles(keep)
+
+	if len(running) == 0 {
+		// All configs will be rebuild, and sockets recreated, so they can all be deleted
+		dn.cleanupTempFiles()
+	} else {
+		for name, runID := range runIDs {
+			glog.Infof("profile %s will be (re)started with runID %d", name, runID)
+			dn.cleanupRunFiles(runID)
+		}
+	}
+
+	glog.Infof("updating NodePTPProfiles to:")
+	for _, profile := range nodeProfiles {
+		if keep[*profile.Name] {
+			glog.Infof("profile %s is unchanged, keeping its processes running", *profile.Name)
+			// the plugins are still given every profile, their hwconfigs are populated from all of them below
+			dn.pluginManager.OnPTPConfigChange(profile.DeepCopy())
+			continue
+		}
+		appliedProfile := profile.DeepCopy()
+		err := dn.applyNodePtpProfile(runIDs[*profile.Name], &profile)
 		if err != nil {
+			// processes created so far were never started, drop them so the next update retries
+			for _, p := range dn.processManager.process[len(running):] {
+				if p.nodeProfile.Name != nil {
+					delete(dn.processManager.appliedProfiles, *p.nodeProfile.Name)
+				}
+			}
+			dn.processManager.process = running
 			return err
 		}
-		runID++
+		dn.processManager.setAppliedProfile(runIDs[*profile.Name], appliedProfile)
 	}
 
-	// Start all the process
-	for _, p := range dn.processManager.process {
+	// Start the processes created above, the ones already running are left as they are
+	readyDeadline := time.Now().Add(applyReadyTimeout)
+	for _, p := range dn.processManager.process[len(running):] {
 		if p != nil {
-			p.eventCh = dn.processManager.eventChannel
+			p.eventQueue = dn.processManager.eventQueue
+			p.capture = dn.capture
+			p.history = dn.history
 			// start ptp4l process early , it doesn't have
 			if p.depProcess == nil {
 				go p.cmdRun(dn.stdoutToSocket)
 			} else {
 				for _, d := range p.depProcess {
 					if d != nil {
-						time.Sleep(3 * time.Second)
+						switch dep := d.(type) {
+						case *GPSD:
+							dep.capture = dn.capture
+						case *dpll.DpllConfig:
+							if dn.capture != nil {
+								dep.SetRecorder(dn.capture)
+							}
+						}
 						go d.CmdRun(false)
-						time.Sleep(3 * time.Second)
+						dn.waitReady(d.Name(), d.Ready, readyDeadline)
 						dn.pluginManager.AfterRunPTPCommand(&p.nodeProfile, d.Name())
 						d.MonitorProcess(config.ProcessConfig{
-							ClockType:    p.clockType,
-							ConfigName:   p.configName,
-							EventChannel: dn.processManager.eventChannel,
+							ClockType:  p.clockType,
+							ConfigName: p.configName,
+							EventQueue: dn.processManager.eventQueue,
 							GMThreshold: config.Threshold{
 								Max:             p.ptpClockThreshold.MaxOffsetThreshold,
 								Min:             p.ptpClockThreshold.MinOffsetThreshold,
@@ -422,14 +533,34 @@ func (dn *Daemon) applyNodePTPProfiles() error {
 				}
 				go p.cmdRun(dn.stdoutToSocket)
 			}
+			dn.waitReady(p.name, p.ready, readyDeadline)
 			dn.pluginManager.AfterRunPTPCommand(&p.nodeProfile, p.name)
 		}
 	}
+	//clear hwconfig before updating
+	*dn.hwconfigs = []ptpv1.HwConfig{}
 	dn.pluginManager.PopulateHwConfig(dn.hwconfigs)
-	*dn.refreshNodePtpDevice = true
+	dn.setValidationStatus(validation)
 	return nil
 }
 
+// setValidationStatus replaces the validation entries of the NodePtpDevice status hwconfigs with the ones of result
+func (dn *Daemon) setValidationStatus(result *ValidationResult) {
+	if dn.hwconfigs == nil {
+		return
+	}
+	hwconfigs := []ptpv1.HwConfig{}
+	for _, hw := range *dn.hwconfigs {
+		if hw.VendorID != ValidationHwConfigVendor {
+			hwconfigs = append(hwconfigs, hw)
+		}
+	}
+	*dn.hwconfigs = append(hwconfigs, result.HwConfigs()...)
+	if dn.refreshNodePtpDevice != nil {
+		*dn.refreshNodePtpDevice = true
+	}
+}
+
 func getLogFilterRegex(nodeProfile *ptpv1.PtpProfile) string {
 	logFilterRegex := "^$"
 	if filter, ok := (*nodeProfile).PtpSettings["stdoutFilter"]; ok {
@@ -474,8 +605,11 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 	testDir, test := nodeProfile.PtpSettings["unitTest"]
 	if test {
 		configPrefix = testDir
+		pmc.ConfigDir = testDir
+	}
+	if !dn.dryRun {
+		dn.pluginManager.OnPTPConfigChange(nodeProfile)
 	}
-	dn.pluginManager.OnPTPConfigChange(nodeProfile)
 
 	var err error
 	var cmdLine string
@@ -488,6 +622,7 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 	var cmd *exec.Cmd
 	var pProcess string
 	var haProfile map[string][]string
+	var ptp4lIfaces config.IFaces
 
 	ptpHAEnabled := len(listHaProfiles(nodeProfile)) > 0
 
@@ -523,7 +658,16 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 			configFile = fmt.Sprintf("ts2phc.%d.config", runID)
 			configPath = fmt.Sprintf("%s/%s", configPrefix, configFile)
 			messageTag = fmt.Sprintf("[ts2phc.%d.config:{level}]", runID)
-			leap.LeapMgr.SetPtp4lConfigPath(fmt.Sprintf("ptp4l.%d.config", runID))
+			if !dn.dryRun {
+				gmStateMachine, gmErr := getGMStateMachine(nodeProfile)
+				if gmErr != nil { // rejected by the profile validation already
+					glog.Errorf("%s, the default GM state machine is used", gmErr)
+				}
+				dn.processManager.ptpEventHandler.SetGMStateMachine(configFile, gmStateMachine)
+				if leap.LeapMgr != nil {
+					leap.LeapMgr.SetPtp4lConfigPath(fmt.Sprintf("ptp4l.%d.config", runID))
+				}
+			}
 			// DPLL is considered to be running along with ts2phc
 			maxInSpecOffset, maxHoldoverOffSet, maxHoldoverTimeout, inSpecTimer, frequencyTraceable := dpll.CalculateTimer(nodeProfile)
 			// update ts2phcOpts with the new config
@@ -572,29 +716,23 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 		output.profile_name = *nodeProfile.Name
 
 		if nodeProfile.Interface != nil && *nodeProfile.Interface != "" {
-			output.sections = append([]ptp4lConfSection{{
-				options:     map[string]string{},
-				sectionName: fmt.Sprintf("[%s]", *nodeProfile.Interface)}}, output.sections...)
+			output.file.InsertSection(0, *nodeProfile.Interface)
 		} else {
 			iface := string("")
 			nodeProfile.Interface = &iface
 		}
 
-		for index, section := range output.sections {
-			if section.sectionName == "[global]" {
-				section.options["message_tag"] = messageTag
-				if socketPath != "" {
-					section.options["uds_address"] = socketPath
-				}
-				if gnssSerialPort, ok := section.options["ts2phc.nmea_serialport"]; ok {
-					output.gnss_serial_port = strings.TrimSpace(gnssSerialPort)
-					section.options["ts2phc.nmea_serialport"] = GPSPIPE_SERIALPORT
-				}
-				if _, ok := section.options["leapfile"]; ok || pProcess == ts2phcProcessName { // not required to check process if leapfile is always included
-					section.options["leapfile"] = fmt.Sprintf("%s/%s", config.DefaultLeapConfigPath, os.Getenv("NODE_NAME"))
-				}
-				output.sections[index] = section
-			}
+		global := output.global()
+		global.Set("message_tag", messageTag)
+		if socketPath != "" {
+			global.Set("uds_address", socketPath)
+		}
+		if gnssSerialPort, ok := global.Get("ts2phc.nmea_serialport"); ok {
+			output.gnss_serial_port = gnssSerialPort
+			global.Set("ts2phc.nmea_serialport", GPSPIPE_SERIALPORT)
+		}
+		if global.Has("leapfile") || pProcess == ts2phcProcessName { // not required to check process if leapfile is always included
+			global.Set("leapfile", dn.leapFilePath())
 		}
 
 		// This adds the flags needed for monitor
@@ -609,19 +747,21 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 			for i := range ifaces {
 				ifaces[i].PhcId = ptpnetwork.GetPhcId(ifaces[i].Name)
 			}
+			if pProcess == ptp4lProcessName {
+				ptp4lIfaces = ifaces
+			}
 		}
 
 		if configInput != nil {
 			*configInput = configOutput
 		}
 
-		cmdLine = fmt.Sprintf("/usr/sbin/%s -f %s  %s ", pProcess, configPath, *configOpts)
-		cmdLine = addScheduling(nodeProfile, cmdLine)
+		cmdLine = fmt.Sprintf("%s -f %s  %s ", binaryPath(pProcess), configPath, *configOpts)
 		if pProcess == phc2sysProcessName {
 			haProfile, cmdLine = dn.ApplyHaProfiles(nodeProfile, cmdLine)
 		}
 		args := strings.Split(cmdLine, " ")
-		cmd = exec.Command(args[0], args[1:]...)
+		cmd = newCmd(args[0], args[1:]...)
 		dprocess := ptpProcess{
 			name:              p,
 			ifaces:            ifaces,
@@ -629,9 +769,10 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 			processSocketPath: socketPath,
 			configName:        configFile,
 			messageTag:        messageTag,
-			exitCh:            make(chan bool),
+			done:              make(chan struct{}),
 			stopped:           false,
 			logFilterRegex:    getLogFilterRegex(nodeProfile),
+			logSampler:        newLogSampler(nodeProfile, p, configFile),
 			cmd:               cmd,
 			depProcess:        []process{},
 			nodeProfile:       *nodeProfile,
@@ -639,52 +780,64 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 			ptpClockThreshold: getPTPThreshold(nodeProfile),
 			haProfile:         haProfile,
 			syncERelations:    relations,
+			restart:           newRestartTracker(getRestartPolicy(nodeProfile)),
+			stopGracePeriod:   getStopGracePeriod(nodeProfile),
+			sched:             getSchedAttr(nodeProfile, p),
 		}
 
 		// TODO HARDWARE PLUGIN for e810
 		if pProcess == ts2phcProcessName { //& if the x plugin is enabled
-			if output.gnss_serial_port == "" {
-				output.gnss_serial_port = GPSPIPE_SERIALPORT
-			}
-			// TODO: move this to plugin or call it from hwplugin or leave it here and remove Hardcoded
-			gmInterface := dprocess.ifaces.GetGMInterface().Name
+			// gpsd and gpspipe serve the GNSS receiver of the ts2phc reading NMEA, validation allows only one per node
+			if output.readsNmea(*configOpts) {
+				if output.gnss_serial_port == "" {
+					output.gnss_serial_port = GPSPIPE_SERIALPORT
+				}
+				// TODO: move this to plugin or call it from hwplugin or leave it here and remove Hardcoded
+				gmInterface := dprocess.ifaces.GetGMInterface().Name
 
-			if e := mkFifo(); e != nil {
-				glog.Errorf("Error creating named pipe, GNSS monitoring will not work as expected %s", e.Error())
-			}
+				if !dn.dryRun {
+					if e := mkFifo(); e != nil {
+						glog.Errorf("Error creating named pipe, GNSS monitoring will not work as expected %s", e.Error())
+					}
+				}
 
-			gpsDaemon := &GPSD{
-				name:        GPSD_PROCESSNAME,
-				execMutex:   sync.Mutex{},
-				cmd:         nil,
-				serialPort:  output.gnss_serial_port,
-				exitCh:      make(chan struct{}),
-				gmInterface: gmInterface,
-				stopped:     false,
-				messageTag:  messageTag,
-				ublxTool:    nil,
-			}
-			gpsDaemon.CmdInit()
-			gpsDaemon.cmdLine = addScheduling(nodeProfile, gpsDaemon.cmdLine)
-			args = strings.Split(gpsDaemon.cmdLine, " ")
-			gpsDaemon.cmd = exec.Command(args[0], args[1:]...)
-			dprocess.depProcess = append(dprocess.depProcess, gpsDaemon)
-
-			// init gpspipe
-			gpsPipeDaemon := &gpspipe{
-				name:       GPSPIPE_PROCESSNAME,
-				execMutex:  sync.Mutex{},
-				cmd:        nil,
-				serialPort: GPSPIPE_SERIALPORT,
-				exitCh:     make(chan struct{}),
-				stopped:    false,
-				messageTag: messageTag,
+				gpsDaemon := &GPSD{
+					name:            GPSD_PROCESSNAME,
+					execMutex:       sync.Mutex{},
+					cmd:             nil,
+					serialPort:      output.gnss_serial_port,
+					exitCh:          make(chan struct{}),
+					gmInterface:     gmInterface,
+					stopped:         false,
+					messageTag:      messageTag,
+					ublxTool:        nil,
+					restart:         newRestartTracker(getRestartPolicy(nodeProfile)),
+					stopGracePeriod: getStopGracePeriod(nodeProfile),
+					sched:           getSchedAttr(nodeProfile, GPSD_PROCESSNAME),
+				}
+				gpsDaemon.CmdInit()
+				args = strings.Split(gpsDaemon.cmdLine, " ")
+				gpsDaemon.cmd = newCmd(args[0], args[1:]...)
+				dprocess.depProcess = append(dprocess.depProcess, gpsDaemon)
+
+				// init gpspipe
+				gpsPipeDaemon := &gpspipe{
+					name:            GPSPIPE_PROCESSNAME,
+					execMutex:       sync.Mutex{},
+					cmd:             nil,
+					serialPort:      GPSPIPE_SERIALPORT,
+					exitCh:          make(chan struct{}),
+					stopped:         false,
+					messageTag:      messageTag,
+					restart:         newRestartTracker(getRestartPolicy(nodeProfile)),
+					stopGracePeriod: getStopGracePeriod(nodeProfile),
+					sched:           getSchedAttr(nodeProfile, GPSPIPE_PROCESSNAME),
+				}
+				gpsPipeDaemon.CmdInit()
+				args = strings.Split(gpsPipeDaemon.cmdLine, " ")
+				gpsPipeDaemon.cmd = newCmd(args[0], args[1:]...)
+				dprocess.depProcess = append(dprocess.depProcess, gpsPipeDaemon)
 			}
-			gpsPipeDaemon.CmdInit()
-			gpsPipeDaemon.cmdLine = addScheduling(nodeProfile, gpsPipeDaemon.cmdLine)
-			args = strings.Split(gpsPipeDaemon.cmdLine, " ")
-			gpsPipeDaemon.cmd = exec.Command(args[0], args[1:]...)
-			dprocess.depProcess = append(dprocess.depProcess, gpsPipeDaemon)
 
 			// init dpll
 			// TODO: Try to inject DPLL depProcess via plugin ?
@@ -741,8 +894,9 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 			}
 
 		}
-		err = os.WriteFile(configPath, []byte(configOutput), 0644)
-		if err != nil {
+		if dn.dryRun {
+			dn.renderedConfigs[configPath] = configOutput
+		} else if err = os.WriteFile(configPath, []byte(configOutput), 0644); err != nil {
 			printNodeProfile(nodeProfile)
 			return fmt.Errorf("failed to write the configuration file named %s: %v", configPath, err)
 		}
@@ -751,7 +905,7 @@ func (dn *Daemon) applyNodePtpProfile(runID int, nodeProfile *ptpv1.PtpProfile)
 		dn.processManager.process = append(dn.processManager.process, &dprocess)
 
 	}
-	return nil
+	return dn.applyChronyd(runID, nodeProfile, ptp4lIfaces)
 }
 
 func (dn *Daemon) GetPhaseOffsetPinFilter(nodeProfile *ptpv1.PtpProfile) map[string]map[string]string {
@@ -780,30 +934,17 @@ func (dn *Daemon) HandlePmcTicker() {
 	}
 }
 
-// Add fifo scheduling if specified in nodeProfile
-func addScheduling(nodeProfile *ptpv1.PtpProfile, cmdLine string) string {
-	if nodeProfile.PtpSchedulingPolicy != nil && *nodeProfile.PtpSchedulingPolicy == "SCHED_FIFO" {
-		if nodeProfile.PtpSchedulingPriority == nil {
-			glog.Errorf("Priority must be set for SCHED_FIFO; using default scheduling.")
-			return cmdLine
-		}
-		priority := *nodeProfile.PtpSchedulingPriority
-		if priority < 1 || priority > 65 {
-			glog.Errorf("Invalid priority %d; using default scheduling.", priority)
-			return cmdLine
-		}
-		cmdLine = fmt.Sprintf("/bin/chrt -f %d %s", priority, cmdLine)
-		glog.Infof(cmdLine)
-		return cmdLine
-	}
-	return cmdLine
-}
-
-func processStatus(c *net.Conn, processName, messageTag string, status int64) {
+// configNameFromMessageTag returns the config name of a message tag, i.e. ptp4l.0.config for [ptp4l.0.config:{level}]
+func configNameFromMessageTag(messageTag string) string {
 	cfgName := strings.Replace(strings.Replace(messageTag, "]", "", 1), "[", "", 1)
 	if cfgName != "" {
 		cfgName = strings.Split(cfgName, MessageTagSuffixSeperator)[0]
 	}
+	return cfgName
+}
+
+func processStatus(c *net.Conn, processName, messageTag string, status int64) {
+	cfgName := configNameFromMessageTag(messageTag)
 	// ptp4l[5196819.100]: [ptp4l.0.config] PTP_PROCESS_STOPPED:0/1
 	deadProcessMsg := fmt.Sprintf("%s[%d]:[%s] PTP_PROCESS_STATUS:%d\n", processName, time.Now().Unix(), cfgName, status)
 	glog.Infof("%s\n", deadProcessMsg)
@@ -832,11 +973,13 @@ func (p *ptpProcess) updateClockClass(c *net.Conn) {
 			var clockClass float64
 			if clockClass, parseError = strconv.ParseFloat(matches[1], 64); parseError == nil {
 				if clockClass != p.parentClockClass {
+					p.recordClockClass(p.parentClockClass, clockClass)
 					p.parentClockClass = clockClass
+					event.StateRegisterer.PublishClockClass(p.configName, uint8(clockClass))
 					glog.Infof("clock change event identified")
 					//ptp4l[5196819.100]: [ptp4l.0.config] CLOCK_CLASS_CHANGE:248
 					clockClassOut := fmt.Sprintf("%s[%d]:[%s] CLOCK_CLASS_CHANGE %f\n", p.name, time.Now().Unix(), p.configName, clockClass)
-					fmt.Printf("%s", clockClassOut)
+					logging.ProcessOutput(clockClassOut)
 					if c == nil {
 						UpdateClockClassMetrics(clockClass) // no socket then update metrics
 					} else {
@@ -866,13 +1009,20 @@ func (p *ptpProcess) cmdRun(stdoutToSocket bool) {
 				glog.Errorf("closing connection returned error %s", err)
 			}
 		}
-		p.exitCh <- true
+		close(p.done)
 	}()
 
 	logFilterRegex, regexErr := regexp.Compile(p.logFilterRegex)
 	if regexErr != nil {
 		glog.Infof("Failed parsing regex %s for %s: %d.  Defaulting to accept all", p.logFilterRegex, p.configName, regexErr)
 	}
+	if p.nodeProfile.Name != nil {
+		logging.SetConfigProfile(p.configName, *p.nodeProfile.Name)
+	}
+	if p.name == chronydProcessName {
+		// chronyd does not log its tracking status, it is polled with chronyc
+		go p.trackChronyd()
+	}
 
 	for {
 		glog.Infof("Starting %s...", p.name)
@@ -889,12 +1039,13 @@ func (p *ptpProcess) cmdRun(stdoutToSocket bool) {
 
 		if !stdoutToSocket {
 			scanner := bufio.NewScanner(cmdReader)
-			processStatus(nil, p.name, p.messageTag, PtpProcessUp)
+			reportProcessStart(nil, p.name, p.messageTag, p.restart)
 			go func() {
 				for scanner.Scan() {
 					output := scanner.Text()
-					if regexErr != nil || !logFilterRegex.MatchString(output) {
-						fmt.Printf("%s\n", output)
+					p.capture.Line(p.name, p.configName, output)
+					if (regexErr != nil || !logFilterRegex.MatchString(output)) && p.logSampler.keep(output) {
+						logging.ProcessOutput(output)
 					}
 					p.processPTPMetrics(output)
 					if p.name == ptp4lProcessName {
@@ -905,25 +1056,27 @@ func (p *ptpProcess) cmdRun(stdoutToSocket bool) {
 						p.announceHAFailOver(nil, output) // do not use go routine since order of execution is important here
 					}
 				}
+				p.logSampler.flush()
 				done <- struct{}{}
 			}()
 		} else {
 			go func() {
 			connect:
 				select {
-				case <-p.exitCh:
+				case <-p.done:
 					done <- struct{}{}
 				default:
-					c, err := net.Dial("unix", eventSocket)
-					p.c = &c
+					conn, err := eventsocket.Dial(eventSocket)
 					if err != nil {
 						glog.Errorf("error trying to connect to event socket")
 						time.Sleep(connectionRetryInterval)
 						goto connect
 					}
+					var c net.Conn = conn
+					p.c = &c
 				}
 				scanner := bufio.NewScanner(cmdReader)
-				processStatus(p.c, p.name, p.messageTag, PtpProcessUp)
+				reportProcessStart(p.c, p.name, p.messageTag, p.restart)
 				for _, d := range p.depProcess {
 					if d != nil {
 						d.ProcessStatus(p.c, PtpProcessUp)
@@ -931,13 +1084,14 @@ func (p *ptpProcess) cmdRun(stdoutToSocket bool) {
 				}
 				for scanner.Scan() {
 					output := scanner.Text()
+					p.capture.Line(p.name, p.configName, output)
 					if p.pmcCheck {
 						p.pmcCheck = false
 						go p.updateClockClass(p.c)
 					}
 
-					if regexErr != nil || !logFilterRegex.MatchString(output) {
-						fmt.Printf("%s\n", output)
+					if (regexErr != nil || !logFilterRegex.MatchString(output)) && p.logSampler.keep(output) {
+						logging.ProcessOutput(output)
 					}
 					// for ts2phc from 4.2 onwards replace /dev/ptpX by actual interface name
 					output = fmt.Sprintf("%s\n", p.replaceClockID(output))
@@ -956,14 +1110,23 @@ func (p *ptpProcess) cmdRun(stdoutToSocket bool) {
 						goto connect
 					}
 				}
+				p.logSampler.flush()
 				done <- struct{}{}
 			}()
 		}
 		// Don't restart after termination
 		if !p.Stopped() {
-			err = p.cmd.Start() // this is asynchronous call,
+			err = p.sched.start(p.name, p.cmd) // this is asynchronous call,
 			if err != nil {
 				glog.Errorf("CmdRun() error starting %s: %v", p.name, err)
+			} else {
+				p.restart.started(p.cmd.Process.Pid)
+				p.recordStart(stdoutToSocket)
+				go func(pid int) {
+					if p.restart.recovered(pid) {
+						reportProcessRecovery(p.c, p.name, p.messageTag, p.restart)
+					}
+				}(p.cmd.Process.Pid)
 			}
 		}
 		<-done // goroutine is done
@@ -971,22 +1134,25 @@ func (p *ptpProcess) cmdRun(stdoutToSocket bool) {
 		if err != nil {
 			glog.Errorf("CmdRun() error waiting for %s: %v", p.name, err)
 		}
-		if stdoutToSocket && p.c != nil {
+		delay := connectionRetryInterval
+		if p.Stopped() {
 			processStatus(p.c, p.name, p.messageTag, PtpProcessDown)
 		} else {
-			processStatus(nil, p.name, p.messageTag, PtpProcessDown)
+			var crashLooping bool
+			delay, crashLooping = p.restart.exited(p.cmd.ProcessState)
+			reportProcessExit(p.c, p.name, p.messageTag, p.restart, crashLooping)
+			p.recordRestart(delay, crashLooping)
 		}
 		p.updateGMStatusOnProcessDown(p.name)
 
-		time.Sleep(connectionRetryInterval) // Delay to prevent flooding restarts if startup fails
+		p.restart.wait(delay) // Delay to prevent flooding restarts, interrupted by cmdStop
 		// Don't restart after termination
 		if p.Stopped() {
 			glog.Infof("Not recreating %s...", p.name)
 			break
 		} else {
 			glog.Infof("Recreating %s...", p.name)
-			newCmd := exec.Command(p.cmd.Args[0], p.cmd.Args[1:]...)
-			p.cmd = newCmd
+			p.cmd = newCmd(p.cmd.Args[0], p.cmd.Args[1:]...)
 		}
 		if stdoutToSocket && p.c != nil {
 			if err2 := (*p.c).Close(); err2 != nil {
@@ -999,7 +1165,9 @@ func (p *ptpProcess) cmdRun(stdoutToSocket bool) {
 // for ts2phc along with processing metrics need to identify event
 func (p *ptpProcess) processPTPMetrics(output string) {
 	state := event.PTP_FREERUN
-	if p.name == syncEProcessName {
+	if p.name == chronydProcessName {
+		return // see trackChronyd
+	} else if p.name == syncEProcessName {
 		configName := strings.Replace(strings.Replace(p.messageTag, "]", "", 1), "[", "", 1)
 		if configName == "" {
 			return
@@ -1009,6 +1177,12 @@ func (p *ptpProcess) processPTPMetrics(output string) {
 		p.ProcessSynceEvents(logEntry)
 	} else {
 		configName, source, ptpOffset, clockState, iface := extractMetrics(p.messageTag, p.name, p.ifaces, output)
+		if clockState == LOCKED {
+			p.locked.Store(true)
+		}
+		if p.name == ptp4lProcessName || p.name == phc2sysProcessName {
+			p.publishState(clockState)
+		}
 		if iface != "" { // for ptp4l/phc2sys this function only update metrics
 			var values map[event.ValueType]interface{}
 			ifaceName := masterOffsetIface.getByAlias(configName, iface).name
@@ -1033,6 +1207,27 @@ func (p *ptpProcess) processPTPMetrics(output string) {
 	}
 }
 
+// publishState records to the history, and notifies the event subscribers of, the clock state changes
+// of ptp4l and phc2sys, the event loop only sees the ts2phc ones
+func (p *ptpProcess) publishState(clockState string) {
+	var state event.PTPState
+	switch clockState {
+	case LOCKED:
+		state = event.PTP_LOCKED
+	case HOLDOVER:
+		state = event.PTP_HOLDOVER
+	case FREERUN:
+		state = event.PTP_FREERUN
+	default:
+		return
+	}
+	if state != p.lastState {
+		p.recordState(clockStateName(p.lastState), clockState)
+		p.lastState = state
+		event.StateRegisterer.PublishInstance(event.EventSource(p.name), event.Instance{CfgName: p.configName}, state)
+	}
+}
+
 // cmdStop stops ptpProcess launched by cmdRun
 func (p *ptpProcess) cmdStop() {
 	glog.Infof("stopping %s...", p.name)
@@ -1040,15 +1235,8 @@ func (p *ptpProcess) cmdStop() {
 		return
 	}
 	p.setStopped(true)
-	if p.cmd.Process != nil {
-		glog.Infof("Sending TERM to (%s) PID: %d", p.name, p.cmd.Process.Pid)
-		err := p.cmd.Process.Signal(syscall.SIGTERM)
-		if err != nil {
-			// If the process is already terminated, we will get an error here
-			glog.Errorf("failed to send SIGTERM to %s (%d): %v", p.name, p.cmd.Process.Pid, err)
-			return
-		}
-	}
+	p.restart.stop()
+	stopProcessGroup(p.name, p.cmd, p.done, p.stopGracePeriod)
 	glog.Infof("removing config path %s for %s ", p.processConfigPath, p.name)
 	if p.processConfigPath != "" {
 		err := os.Remove(p.processConfigPath)
@@ -1056,8 +1244,6 @@ func (p *ptpProcess) cmdStop() {
 			glog.Errorf("failed to remove ptp4l config path %s: %v", p.processConfigPath, err)
 		}
 	}
-	<-p.exitCh
-	glog.Infof("Process %s (%d) terminated", p.name, p.cmd.Process.Pid)
 }
 
 func getPTPThreshold(nodeProfile *ptpv1.PtpProfile) *ptpv1.PtpClockThreshold {
@@ -1090,6 +1276,7 @@ func (p *ptpProcess) ProcessTs2PhcEvents(ptpOffset float64, source string, iface
 		ptpState = event.PTP_LOCKED
 	}
 
+	p.recordThreshold(iface, ptpOffsetInt64)
 	if source == ts2phcProcessName { // for ts2phc send it to event to create metrics and events
 		var values = make(map[event.ValueType]interface{})
 
@@ -1097,8 +1284,7 @@ func (p *ptpProcess) ProcessTs2PhcEvents(ptpOffset float64, source string, iface
 		for k, v := range extraValue {
 			values[k] = v
 		}
-		select {
-		case p.eventCh <- event.EventChannel{
+		p.eventQueue.Push(event.EventChannel{
 			ProcessName: event.TS2PHC,
 			State:       ptpState,
 			CfgName:     p.configName,
@@ -1113,9 +1299,7 @@ func (p *ptpProcess) ProcessTs2PhcEvents(ptpOffset float64, source string, iface
 				return false
 			}(),
 			Reset: false,
-		}:
-		default:
-		}
+		})
 
 	} else {
 		if iface != "" && iface != clockRealTime {
@@ -1215,7 +1399,7 @@ func (p *ptpProcess) announceHAFailOver(c *net.Conn, output string) {
 	}
 	if c == nil {
 		for _, logProfile := range logString {
-			fmt.Printf("%s", logProfile)
+			logging.ProcessOutput(logProfile)
 		}
 		UpdatePTPHAMetrics(currentProfile, inActiveProfiles, activeState)
 	} else {
@@ -1284,9 +1468,14 @@ func (p *ptpProcess) replaceClockID(input string) (output string) {
 func (p *ptpProcess) updateGMStatusOnProcessDown(process string) {
 	// need to update GM status for  following process kill for  ts2phc
 	if process == ts2phcProcessName {
-		// ts2phc process dead should update GM-STATUS
-		iface := p.ifaces.GetGMInterface().Name
-		p.ProcessTs2PhcEvents(faultyOffset, ts2phcProcessName, iface, event.PTP_FREERUN, map[event.ValueType]interface{}{event.PROCESS_STATUS: int64(0)})
+		// ts2phc process dead should update GM-STATUS of every GM interface it drives
+		gmIfaces := p.ifaces.GetGMInterfaces()
+		if len(gmIfaces) == 0 {
+			gmIfaces = config.IFaces{p.ifaces.GetGMInterface()}
+		}
+		for _, iface := range gmIfaces {
+			p.ProcessTs2PhcEvents(faultyOffset, ts2phcProcessName, iface.Name, event.PTP_FREERUN, map[event.ValueType]interface{}{event.PROCESS_STATUS: int64(0)})
+		}
 	}
 }
 
@@ -1380,8 +1569,7 @@ func (p *ptpProcess) ProcessSynceEvents(logEntry synce.LogEntry) {
 	}
 	if len(extraValue) > 0 {
 		glog.Info(extraValue)
-		select {
-		case p.eventCh <- event.EventChannel{
+		p.eventQueue.Push(event.EventChannel{
 			ProcessName: event.SYNCE,
 			State:       state,
 			CfgName:     p.configName,
@@ -1395,9 +1583,7 @@ func (p *ptpProcess) ProcessSynceEvents(logEntry synce.LogEntry) {
 				return false
 			}(),
 			Reset: false,
-		}:
-		default:
-		}
+		})
 	}
 
 }
@@ -0,0 +1,26 @@
+package daemon
+
+import (
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"k8s.io/utils/pointer"
+)
+
+// testProcess returns a process of profile which is never started
+func testProcess(name, configName, profile string) *ptpProcess {
+	return &ptpProcess{name: name, configName: configName, nodeProfile: ptpv1.PtpProfile{Name: pointer.String(profile)},
+		ptpClockThreshold: getPTPThreshold(&ptpv1.PtpProfile{})}
+}
+
+// testDaemon returns a daemon managing processes, without a node, plugins nor an event loop
+func testDaemon(processes ...*ptpProcess) *Daemon {
+	refresh := false
+	return &Daemon{
+		nodeName:             "node",
+		ptpUpdate:            &LinuxPTPConfUpdate{UpdateCh: make(chan bool, 2), NodeProfiles: []ptpv1.PtpProfile{}, appliedNodeProfileJson: []byte("[]")},
+		processManager:       &ProcessManager{process: processes},
+		hwconfigs:            &[]ptpv1.HwConfig{},
+		refreshNodePtpDevice: &refresh,
+		health:               healthState{config: DefaultHealthConfig()},
+		renderedConfigs:      map[string]string{},
+	}
+}
@@ -0,0 +1,19 @@
+package daemon
+
+import (
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+)
+
+// GMStateMachineSetting is the PtpSettings key selecting the GM state machine of the ts2phc of a profile:
+// default, strict-holdover or a YAML document adding rules to them, see event.ParseGMStateMachine
+const GMStateMachineSetting = "gmStateMachine"
+
+// getGMStateMachine returns the GM state machine selected by the profile, nil for the default one
+func getGMStateMachine(nodeProfile *ptpv1.PtpProfile) (*event.GMStateMachine, error) {
+	s, ok := nodeProfile.PtpSettings[GMStateMachineSetting]
+	if !ok {
+		return nil, nil
+	}
+	return event.ParseGMStateMachine(s)
+}
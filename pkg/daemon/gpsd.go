@@ -8,14 +8,15 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/capture"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ublox"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
 	gpsdlib "github.com/stratoberry/go-gpsd"
 )
 
@@ -46,6 +47,10 @@ type GPSD struct {
 	monitorCtx           context.Context
 	monitorCancel        context.CancelFunc
 	c                    *net.Conn
+	restart              *restartTracker
+	stopGracePeriod      time.Duration
+	sched                schedAttr
+	capture              *capture.Recorder // records the ubxtool output, nil unless enabled
 }
 
 // GPSDSubscriber ... event subscriber
@@ -78,6 +83,15 @@ func (s GPSDSubscriber) Notify(source event.EventSource, state event.PTPState) {
 // MonitorProcess ... Monitor GPSD process
 func (g *GPSD) MonitorProcess(p config.ProcessConfig) {
 	g.processConfig = p
+	g.capture.MonitorStarted(string(event.GNSS), p.ConfigName, g.gmInterface, capture.Start{
+		MessageTag: g.messageTag,
+		ClockType:  p.ClockType,
+		Threshold: &ptpv1.PtpClockThreshold{
+			MaxOffsetThreshold: p.GMThreshold.Max,
+			MinOffsetThreshold: p.GMThreshold.Min,
+			HoldOverTimeout:    p.GMThreshold.HoldOverTimeout,
+		},
+	})
 }
 
 func (g *GPSD) registerSubscriber() {
@@ -127,18 +141,11 @@ func (g *GPSD) CmdStop() {
 		return
 	}
 	g.setStopped(true)
+	g.restart.stop()
 	g.ProcessStatus(nil, PtpProcessDown)
-	if g.cmd.Process != nil {
-		glog.Infof("Sending TERM to PID: %d", g.cmd.Process.Pid)
-		err := g.cmd.Process.Signal(syscall.SIGTERM)
-		if err != nil {
-			glog.Infof("Process %s (%d) failed to terminate", g.name, g.cmd.Process.Pid)
-		}
-	}
 	g.unRegisterSubscriber()
-	<-g.exitCh // waiting for all child routines to exit; we could add timeout to avoid waiting
+	stopProcessGroup(g.name, g.cmd, g.exitCh, g.stopGracePeriod) // waiting for all child routines to exit
 	g.monitorCancel()
-	glog.Infof("Process %s terminated", g.name)
 }
 
 // CmdInit ... initialize GPSD
@@ -147,7 +154,7 @@ func (g *GPSD) CmdInit() {
 		g.name = GPSD_PROCESSNAME
 	}
 	g.monitorCtx, g.monitorCancel = context.WithCancel(context.Background())
-	g.cmdLine = fmt.Sprintf("/usr/local/sbin/%s -p -n -S 2947 -G -N %s", g.Name(), g.SerialPort())
+	g.cmdLine = fmt.Sprintf("%s -p -n -S %s -G -N %s", binaryPath(g.Name()), GPSD_PORT, g.SerialPort())
 }
 
 func (g *GPSD) ProcessStatus(c *net.Conn, status int64) {
@@ -164,6 +171,7 @@ func (g *GPSD) CmdRun(stdoutToSocket bool) {
 		if g.subscriber != nil {
 			g.unRegisterSubscriber()
 		}
+		close(g.exitCh) // CmdStop is waiting for confirmation
 	}()
 	// clean up
 	if g.subscriber != nil {
@@ -188,25 +196,31 @@ func (g *GPSD) CmdRun(stdoutToSocket bool) {
 		// Don't restart after termination
 		if !g.Stopped() {
 			time.Sleep(1 * time.Second)
-			err = g.cmd.Start() // this is asynchronous call,
+			err = g.sched.start(g.name, g.cmd) // this is asynchronous call,
 			if err != nil {
 				glog.Errorf("CmdRun() error starting %s: %v", g.Name(), err)
+			} else {
+				g.restart.started(g.cmd.Process.Pid)
 			}
 			err = g.cmd.Wait()
 			if err != nil {
 				glog.Errorf("CmdRun() error waiting for %s: %v", g.Name(), err)
 			}
 		}
-		time.Sleep(connectionRetryInterval) // Delay to prevent flooding restarts if startup fails
+		delay := connectionRetryInterval
+		if !g.Stopped() {
+			var crashLooping bool
+			delay, crashLooping = g.restart.exited(g.cmd.ProcessState)
+			reportProcessExit(g.c, g.name, g.messageTag, g.restart, crashLooping)
+		}
+		g.restart.wait(delay) // Delay to prevent flooding restarts, interrupted by CmdStop
 		// Don't restart after termination
 		if g.Stopped() {
 			glog.Infof("not recreating %s...", g.name)
-			g.exitCh <- struct{}{} // cmdStop is waiting for confirmation
 			break
 		} else {
 			glog.Infof("Recreating %s...", g.name)
-			newCmd := exec.Command(g.cmd.Args[0], g.cmd.Args[1:]...)
-			g.cmd = newCmd
+			g.cmd = newCmd(g.cmd.Args[0], g.cmd.Args[1:]...)
 		}
 	}
 }
@@ -214,19 +228,16 @@ func (g *GPSD) CmdRun(stdoutToSocket bool) {
 // MonitorGNSSEventsWithUblox ... monitor GNSS events with ublox
 func (g *GPSD) MonitorGNSSEventsWithUblox() {
 	//var ublx *ublox.UBlox
-	const timeLsResultLines = 4
 	g.state = event.PTP_FREERUN
 	ticker := time.NewTicker(GNSSMONITOR_INTERVAL)
 	doneFn := func() {
-		select {
-		case g.processConfig.EventChannel <- event.EventChannel{
+		if !g.processConfig.EventQueue.Push(event.EventChannel{
 			ProcessName: event.GNSS,
 			CfgName:     g.processConfig.ConfigName,
 			ClockType:   g.processConfig.ClockType,
 			Time:        time.Now().UnixMilli(),
 			Reset:       true,
-		}:
-		default:
+		}) {
 			glog.Error("failed to send gnss terminated event to eventHandler")
 		}
 		ticker.Stop()
@@ -240,40 +251,23 @@ retry:
 	} else {
 		//TODO: monitor on 1PPS  events trigger
 		g.ublxTool = ublx
-		nStatus := int64(0)
-		nOffset := int64(99999999)
+		poll := newUbxPoll()
 		missedTickers := 0
-		var timeLs *ublox.TimeLs
 		for {
 			select {
 			case <-ticker.C:
 				emptyCount := 0
-				timeLs = nil
+				poll.timeLs = nil
 				for {
 					//UbloxPollInit only initializes if not running
 					ublx.UbloxPollInit()
 					output := ublx.UbloxPollPull()
-					if strings.Contains(output, "UBX-NAV-CLOCK") {
-						nextLine := ublx.UbloxPollPull()
-						//parse
-						nOffset = ublox.ExtractOffset(nextLine)
-						emptyCount = 0
-						missedTickers = 0
-					} else if strings.Contains(output, "UBX-NAV-STATUS") {
-						nextLine := ublx.UbloxPollPull()
-						//parse
-						nStatus = ublox.ExtractNavStatus(nextLine)
-						emptyCount = 0
-						missedTickers = 0
-					} else if strings.Contains(output, "UBX-NAV-TIMELS") {
+					if output != "" || poll.pending != "" {
+						g.capture.MonitorLine(string(event.GNSS), g.processConfig.ConfigName, g.gmInterface, output)
+					}
+					if poll.parse(output) {
 						emptyCount = 0
 						missedTickers = 0
-						var lines []string
-						for i := 0; i < timeLsResultLines; i++ {
-							line := ublx.UbloxPollPull()
-							lines = append(lines, line)
-						}
-						timeLs = ublox.ExtractLeapSec(lines)
 					} else if len(output) == 0 {
 						emptyCount++
 					}
@@ -286,40 +280,11 @@ retry:
 						break
 					}
 				} // loop ends
-				g.offset = nOffset
-				g.sourceLost = false
-				switch nStatus >= 3 {
-				case true:
-					g.state = event.PTP_LOCKED
-					if !g.isOffsetInRange() {
-						g.state = event.PTP_FREERUN
-					}
-				default:
-					g.state = event.PTP_FREERUN
-					g.sourceLost = true
-				}
-				select {
-				case g.processConfig.EventChannel <- event.EventChannel{
-					ProcessName: event.GNSS,
-					State:       g.state,
-					CfgName:     g.processConfig.ConfigName,
-					IFace:       g.gmInterface,
-					Values: map[event.ValueType]interface{}{
-						event.GPS_STATUS: nStatus,
-						event.OFFSET:     g.offset,
-					},
-					ClockType:  g.processConfig.ClockType,
-					Time:       time.Now().UnixMilli(),
-					SourceLost: g.sourceLost,
-					WriteToLog: true,
-					Reset:      false,
-				}:
-				default:
-					glog.Error("failed to send gnss terminated event to eventHandler")
-				}
-				if timeLs != nil {
+				g.capture.Tick(string(event.GNSS), g.processConfig.ConfigName, g.gmInterface)
+				g.decide(poll)
+				if poll.timeLs != nil {
 					select {
-					case leap.LeapMgr.UbloxLsInd <- *timeLs:
+					case leap.LeapMgr.UbloxLsInd <- *poll.timeLs:
 					case <-time.After(100 * time.Millisecond):
 						glog.Infof("failied to send leap event updates")
 					}
@@ -333,6 +298,82 @@ retry:
 	}
 }
 
+// ubxPoll parses the ubxtool output polled by the GNSS monitor
+type ubxPoll struct {
+	status int64
+	offset int64
+	timeLs *ublox.TimeLs // the leap seconds read on the current tick, if any
+	// pending is the message the next lines are the result of, timeLsLines the UBX-NAV-TIMELS result lines read so far
+	pending     string
+	timeLsLines []string
+}
+
+func newUbxPoll() *ubxPoll {
+	return &ubxPoll{offset: 99999999}
+}
+
+// parse parses a line of ubxtool output, it returns whether the line was a message or a line of its result
+func (u *ubxPoll) parse(output string) bool {
+	const timeLsResultLines = 4
+	switch u.pending {
+	case "UBX-NAV-CLOCK":
+		u.offset = ublox.ExtractOffset(output)
+		u.pending = ""
+		return true
+	case "UBX-NAV-STATUS":
+		u.status = ublox.ExtractNavStatus(output)
+		u.pending = ""
+		return true
+	case "UBX-NAV-TIMELS":
+		u.timeLsLines = append(u.timeLsLines, output)
+		if len(u.timeLsLines) == timeLsResultLines {
+			u.timeLs = ublox.ExtractLeapSec(u.timeLsLines)
+			u.pending, u.timeLsLines = "", nil
+		}
+		return true
+	}
+	for _, message := range []string{"UBX-NAV-CLOCK", "UBX-NAV-STATUS", "UBX-NAV-TIMELS"} {
+		if strings.Contains(output, message) {
+			u.pending = message
+			return true
+		}
+	}
+	return false
+}
+
+// decide sets the GNSS state from the ubxtool output polled so far and sends it as a GNSS event
+func (g *GPSD) decide(poll *ubxPoll) {
+	g.offset = poll.offset
+	g.sourceLost = false
+	switch poll.status >= 3 {
+	case true:
+		g.state = event.PTP_LOCKED
+		if !g.isOffsetInRange() {
+			g.state = event.PTP_FREERUN
+		}
+	default:
+		g.state = event.PTP_FREERUN
+		g.sourceLost = true
+	}
+	if !g.processConfig.EventQueue.Push(event.EventChannel{
+		ProcessName: event.GNSS,
+		State:       g.state,
+		CfgName:     g.processConfig.ConfigName,
+		IFace:       g.gmInterface,
+		Values: map[event.ValueType]interface{}{
+			event.GPS_STATUS: poll.status,
+			event.OFFSET:     g.offset,
+		},
+		ClockType:  g.processConfig.ClockType,
+		Time:       time.Now().UnixMilli(),
+		SourceLost: g.sourceLost,
+		WriteToLog: true,
+		Reset:      false,
+	}) {
+		glog.Error("failed to send gnss terminated event to eventHandler")
+	}
+}
+
 // isOffsetInRange ... check if offset is in range
 func (g *GPSD) isOffsetInRange() bool {
 	if g.offset <= g.processConfig.GMThreshold.Max && g.offset >= g.processConfig.GMThreshold.Min {
@@ -17,22 +17,28 @@ import (
 const (
 	// GPSPIPE_PROCESSNAME ... gpspipe process name
 	GPSPIPE_PROCESSNAME = "gpspipe"
-	// GPSPIPE_SERIALPORT ... gpspipe serial port
+)
+
+var (
+	// GPSPIPE_SERIALPORT ... gpspipe serial port, the FIFO ts2phc reads the NMEA sentences from
 	GPSPIPE_SERIALPORT = "/gpsd/data"
-	// GPSD_DIR ... gpsd directory
+	// GPSD_DIR ... gpsd directory, see SetGPSDDir
 	GPSD_DIR = "/gpsd"
 )
 
 type gpspipe struct {
-	name       string
-	execMutex  sync.Mutex
-	cmdLine    string
-	cmd        *exec.Cmd
-	serialPort string
-	exitCh     chan struct{}
-	stopped    bool
-	messageTag string
-	c          *net.Conn
+	name            string
+	execMutex       sync.Mutex
+	cmdLine         string
+	cmd             *exec.Cmd
+	serialPort      string
+	exitCh          chan struct{}
+	stopped         bool
+	messageTag      string
+	c               *net.Conn
+	restart         *restartTracker
+	stopGracePeriod time.Duration
+	sched           schedAttr
 }
 
 // Name ... Process name
@@ -70,21 +76,14 @@ func (gp *gpspipe) CmdStop() {
 		return
 	}
 	gp.setStopped(true)
+	gp.restart.stop()
 	gp.ProcessStatus(nil, PtpProcessDown)
-	if gp.cmd.Process != nil {
-		glog.Infof("Sending TERM to (%s) PID: %d", gp.name, gp.cmd.Process.Pid)
-		err := gp.cmd.Process.Signal(syscall.SIGTERM)
-		if err != nil {
-			glog.Errorf("Failed to send term signal to named pipe: %s", GPSPIPE_SERIALPORT)
-			return
-		}
-	}
+	stopProcessGroup(gp.name, gp.cmd, gp.exitCh, gp.stopGracePeriod)
 	// Clean up (delete) the named pipe
 	err := os.Remove(GPSPIPE_SERIALPORT)
 	if err != nil {
 		glog.Errorf("Failed to delete named pipe: %s", GPSPIPE_SERIALPORT)
 	}
-	glog.Infof("Process %s terminated", gp.name)
 }
 
 // CmdInit ... initialize gpspipe
@@ -92,7 +91,7 @@ func (gp *gpspipe) CmdInit() {
 	if gp.name == "" {
 		gp.name = GPSPIPE_PROCESSNAME
 	}
-	gp.cmdLine = fmt.Sprintf("/usr/local/bin/gpspipe -v -R -l -o %s", gp.SerialPort())
+	gp.cmdLine = fmt.Sprintf("%s -v -R -l -o %s", binaryPath(GPSPIPE_PROCESSNAME), gp.SerialPort())
 }
 
 func (gp *gpspipe) ProcessStatus(c *net.Conn, status int64) {
@@ -105,7 +104,7 @@ func (gp *gpspipe) ProcessStatus(c *net.Conn, status int64) {
 // CmdRun ... run gpspipe
 func (gp *gpspipe) CmdRun(stdoutToSocket bool) {
 	defer func() {
-		gp.exitCh <- struct{}{}
+		close(gp.exitCh) // CmdStop is waiting for confirmation
 	}()
 
 	for {
@@ -125,19 +124,24 @@ func (gp *gpspipe) CmdRun(stdoutToSocket bool) {
 		// Don't restart after termination
 		if !gp.Stopped() {
 			time.Sleep(1 * time.Second)
-			err = gp.cmd.Start() // this is asynchronous call,
+			err = gp.sched.start(gp.name, gp.cmd) // this is asynchronous call,
 			if err != nil {
 				glog.Errorf("CmdRun() error starting %s: %v", gp.Name(), err)
+			} else {
+				gp.restart.started(gp.cmd.Process.Pid)
 			}
 			err = gp.cmd.Wait()
 			if err != nil {
 				glog.Errorf("CmdRun() error waiting for %s: %v, atempting to restart", gp.Name(), err)
 			}
-			newCmd := exec.Command(gp.cmd.Args[0], gp.cmd.Args[1:]...)
-			gp.cmd = newCmd
+			if !gp.Stopped() {
+				delay, crashLooping := gp.restart.exited(gp.cmd.ProcessState)
+				reportProcessExit(gp.c, gp.name, gp.messageTag, gp.restart, crashLooping)
+				gp.restart.wait(delay)
+			}
+			gp.cmd = newCmd(gp.cmd.Args[0], gp.cmd.Args[1:]...)
 		} else {
 			gp.ProcessStatus(nil, PtpProcessDown)
-			gp.exitCh <- struct{}{}
 			break
 		}
 	}
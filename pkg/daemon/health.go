@@ -0,0 +1,185 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
+)
+
+const (
+	// healthCheckInterval is the interval the daemon loop refreshes the probe status at
+	healthCheckInterval = 5 * time.Second
+	// DefaultHealthStallTimeout is how long the daemon and event loops may go without a heartbeat before /healthz fails
+	DefaultHealthStallTimeout = 60 * time.Second
+)
+
+// HealthConfig are the conditions checked by the /healthz and /readyz probes
+type HealthConfig struct {
+	// StallTimeout is how long the daemon and event loops may go without a heartbeat before /healthz fails
+	StallTimeout time.Duration
+	// CrashLoopUnhealthy fails /healthz while a process is crash looping
+	CrashLoopUnhealthy bool
+	// ReadyRequiresLock makes /readyz wait until every process that reports a clock state locked at least once,
+	// otherwise the processes of every profile being started is enough
+	ReadyRequiresLock bool
+}
+
+// DefaultHealthConfig returns the probe conditions used unless SetHealthConfig is called
+func DefaultHealthConfig() HealthConfig {
+	return HealthConfig{StallTimeout: DefaultHealthStallTimeout, CrashLoopUnhealthy: true, ReadyRequiresLock: true}
+}
+
+// healthState is the probe status, refreshed by the daemon loop and read by the probe handlers
+type healthState struct {
+	sync.RWMutex
+	config HealthConfig
+	// beat is when the daemon loop last refreshed the status, or made progress applying profiles, see beat
+	beat time.Time
+	// crashLooping are the processes crash looping, notReady the reasons the profiles are not ready
+	crashLooping []string
+	notReady     []string
+	// processes and runIDs are the processes and the run ID of the applied profiles, by name, for the status API
+	processes []processRef
+	runIDs    map[string]int
+}
+
+// processRef is a process and its dependent processes, as they were when the probe status was refreshed
+type processRef struct {
+	*ptpProcess
+	dependents []process
+}
+
+// SetHealthConfig sets the conditions checked by the /healthz and /readyz probes
+func (dn *Daemon) SetHealthConfig(config HealthConfig) {
+	dn.health.Lock()
+	defer dn.health.Unlock()
+	dn.health.config = config
+}
+
+// updateHealth refreshes the probe status from the running processes, it runs in the daemon loop
+func (dn *Daemon) updateHealth() {
+	var crashLooping, notReady []string
+	dn.health.RLock()
+	requireLock := dn.health.config.ReadyRequiresLock
+	dn.health.RUnlock()
+
+	started := map[string]bool{}
+	var processes []processRef
+	for _, p := range dn.processManager.process {
+		if p == nil {
+			continue
+		}
+		processes = append(processes, processRef{ptpProcess: p, dependents: append([]process(nil), p.depProcess...)})
+		if p.restart.CrashLooping() {
+			crashLooping = append(crashLooping, fmt.Sprintf("%s (%s)", p.name, p.configName))
+		}
+		if p.Stopped() {
+			notReady = append(notReady, fmt.Sprintf("%s (%s) is not running", p.name, p.configName))
+			continue
+		}
+		if p.nodeProfile.Name != nil {
+			started[*p.nodeProfile.Name] = true
+		}
+		if requireLock && p.reportsLock() && !p.locked.Load() {
+			notReady = append(notReady, fmt.Sprintf("%s (%s) did not lock yet", p.name, p.configName))
+		}
+	}
+	if dn.ptpUpdate != nil {
+		for _, name := range dn.ptpUpdate.profileNames() {
+			if !started[name] {
+				notReady = append(notReady, fmt.Sprintf("profile %s is not started", name))
+			}
+		}
+	}
+
+	runIDs := map[string]int{}
+	for name, applied := range dn.processManager.appliedProfiles {
+		runIDs[name] = applied.runID
+	}
+
+	dn.health.Lock()
+	defer dn.health.Unlock()
+	dn.health.beat = time.Now()
+	dn.health.crashLooping = crashLooping
+	dn.health.notReady = notReady
+	dn.health.processes = processes
+	dn.health.runIDs = runIDs
+}
+
+// beat refreshes the heartbeat of the daemon loop while it is busy applying profiles
+func (dn *Daemon) beat() {
+	dn.health.Lock()
+	defer dn.health.Unlock()
+	dn.health.beat = time.Now()
+}
+
+// healthz returns why the daemon is not healthy, nothing when it is
+func (dn *Daemon) healthz() []string {
+	dn.health.RLock()
+	defer dn.health.RUnlock()
+	var failed []string
+	timeout := dn.health.config.StallTimeout
+	if timeout > 0 {
+		if since := time.Since(dn.health.beat); since > timeout {
+			failed = append(failed, fmt.Sprintf("daemon loop is not responsive for %s", since.Round(time.Second)))
+		}
+		if h := dn.processManager.ptpEventHandler; h != nil {
+			if since := time.Since(h.LastHeartbeat()); since > timeout {
+				failed = append(failed, fmt.Sprintf("event loop is not responsive for %s", since.Round(time.Second)))
+			}
+		}
+	}
+	if dn.health.config.CrashLoopUnhealthy {
+		for _, p := range dn.health.crashLooping {
+			failed = append(failed, p+" is crash looping")
+		}
+	}
+	return failed
+}
+
+// readyz returns why the profiles are not ready, nothing when they are
+func (dn *Daemon) readyz() []string {
+	dn.health.RLock()
+	defer dn.health.RUnlock()
+	if dn.health.beat.IsZero() {
+		return []string{"daemon is starting"}
+	}
+	return dn.health.notReady
+}
+
+// probeHandler serves a probe: 200 and "ok" when check returns nothing, 503 and the failed checks otherwise
+func probeHandler(check func() []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed := check(); len(failed) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, strings.Join(failed, "\n"))
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// registerProbes adds the /healthz and /readyz probes, the status and history APIs and, when enabled,
+// the notification API of dn to mux
+func registerProbes(mux *http.ServeMux, dn *Daemon) {
+	mux.Handle("/healthz", probeHandler(dn.healthz))
+	mux.Handle("/readyz", probeHandler(dn.readyz))
+	mux.Handle(apiv1.StatusPath, statusHandler(dn))
+	mux.Handle(apiv1.HistoryPath, historyHandler(dn))
+	if dn.notifications != nil {
+		dn.notifications.RegisterHandlers(mux)
+	}
+}
+
+// StartHealthServer serves the /healthz and /readyz probes and the status API of dn, when the metrics are not served
+func StartHealthServer(bindAddress string, dn *Daemon) {
+	mux := http.NewServeMux()
+	registerProbes(mux, dn)
+	serve(bindAddress, mux)
+}
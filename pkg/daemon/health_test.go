@@ -0,0 +1,98 @@
+package daemon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+)
+
+func Test_healthProbes(t *testing.T) {
+	p := testProcess(phc2sysProcessName, "phc2sys.0.config", "oc")
+	dn := testDaemon(p)
+	assert.NoError(t, dn.ptpUpdate.UpdateConfig([]byte(`[{"name":"oc"}]`)))
+	mux := http.NewServeMux()
+	registerProbes(mux, dn)
+	probe := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	code, body := probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "daemon is starting")
+	code, body = probe("/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "daemon loop is not responsive")
+
+	dn.updateHealth()
+	code, _ = probe("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	code, body = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "phc2sys (phc2sys.0.config) did not lock yet\n", body)
+
+	// started is enough when the lock is not required
+	dn.SetHealthConfig(HealthConfig{StallTimeout: time.Minute})
+	dn.updateHealth()
+	code, body = probe("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok\n", body)
+
+	dn.SetHealthConfig(DefaultHealthConfig())
+	p.locked.Store(true)
+	dn.updateHealth()
+	code, _ = probe("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	// a grandmaster ptp4l serves the time, it is not expected to lock
+	gm := ptpv1.PtpProfile{Name: pointer.String("gm"), Ts2PhcOpts: pointer.String("-s nmea")}
+	bc := ptpv1.PtpProfile{Name: pointer.String("bc")}
+	dn.processManager.process = append(dn.processManager.process,
+		&ptpProcess{name: ptp4lProcessName, configName: "ptp4l.1.config", nodeProfile: gm},
+		&ptpProcess{name: ptp4lProcessName, configName: "ptp4l.2.config", nodeProfile: gm, clockType: event.BC},
+		&ptpProcess{name: ptp4lProcessName, configName: "ptp4l.3.config", nodeProfile: bc, clockType: event.GM},
+		&ptpProcess{name: ptp4lProcessName, configName: "ptp4l.4.config", nodeProfile: bc, clockType: event.BC})
+	dn.updateHealth()
+	code, body = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "ptp4l (ptp4l.4.config) did not lock yet\n", body)
+	dn.processManager.process = dn.processManager.process[:1]
+
+	// every configured profile must be started
+	assert.NoError(t, dn.ptpUpdate.UpdateConfig([]byte(`[{"name":"oc"},{"name":"gm"}]`)))
+	dn.updateHealth()
+	code, body = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "profile gm is not started\n", body)
+
+	p.restart = &restartTracker{crashLooping: true}
+	dn.updateHealth()
+	code, body = probe("/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "phc2sys (phc2sys.0.config) is crash looping\n", body)
+	dn.SetHealthConfig(HealthConfig{StallTimeout: time.Minute})
+	code, _ = probe("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+
+	// a stalled event loop fails the liveness probe
+	dn.processManager.ptpEventHandler = &event.EventHandler{}
+	code, body = probe("/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "event loop is not responsive")
+}
+
+func Test_healthBeatWhileApplying(t *testing.T) {
+	dn := testDaemon()
+	dn.health.beat = time.Now().Add(-2 * DefaultHealthStallTimeout)
+	assert.Len(t, dn.healthz(), 1)
+	// waiting for the processes of an update to become ready is progress of the daemon loop
+	assert.True(t, dn.waitReady("ptp4l", func() bool { return true }, time.Now().Add(applyReadyTimeout)))
+	assert.Empty(t, dn.healthz())
+}
@@ -0,0 +1,115 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/history"
+)
+
+// SetHistory records the state transitions, clock class changes, threshold crossings and process restarts
+// from now on to h, and serves it on the history API. It must be set before Run.
+func (dn *Daemon) SetHistory(h *history.History) {
+	dn.history = h
+	if handler := dn.processManager.ptpEventHandler; handler != nil {
+		handler.SetHistory(h)
+	}
+}
+
+// History returns the events recorded by dn matching q
+func (dn *Daemon) History(q history.Query) apiv1.History {
+	return apiv1.History{APIVersion: apiv1.Version, Node: dn.nodeName, Events: dn.history.Query(q)}
+}
+
+// historyHandler serves the History of dn as JSON
+func historyHandler(dn *Daemon) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q, err := history.ParseQuery(r.URL.Query(), time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(dn.History(q)); err != nil {
+			glog.Errorf("failed to write the history: %v", err)
+		}
+	})
+}
+
+// recordState records a clock state change of ptp4l or phc2sys
+func (p *ptpProcess) recordState(last, state string) {
+	p.history.Add(apiv1.Event{Type: apiv1.EventStateChange, Config: p.configName, Source: p.name, From: last, To: state})
+}
+
+// clockStateName returns the name of a clock state published by publishState, empty when there is none yet
+func clockStateName(state event.PTPState) string {
+	switch state {
+	case event.PTP_LOCKED:
+		return LOCKED
+	case event.PTP_HOLDOVER:
+		return HOLDOVER
+	case event.PTP_FREERUN:
+		return FREERUN
+	}
+	return ""
+}
+
+// recordClockClass records a change of the clock class of the parent of ptp4l
+func (p *ptpProcess) recordClockClass(last, clockClass float64) {
+	ev := apiv1.Event{Type: apiv1.EventClockClassChange, Config: p.configName, Source: p.name,
+		To: strconv.FormatFloat(clockClass, 'f', -1, 64), Message: "parent clock class"}
+	if last != 0 {
+		ev.From = strconv.FormatFloat(last, 'f', -1, 64)
+	}
+	p.history.Add(ev)
+}
+
+// recordThreshold records the offset of iface crossing the thresholds of the profile
+func (p *ptpProcess) recordThreshold(iface string, offset int64) {
+	if p.history == nil {
+		return
+	}
+	inRange := offset >= p.ptpClockThreshold.MinOffsetThreshold && offset <= p.ptpClockThreshold.MaxOffsetThreshold
+	if p.inThreshold == nil {
+		p.inThreshold = map[string]bool{}
+	}
+	last, seen := p.inThreshold[iface]
+	p.inThreshold[iface] = inRange
+	if !seen || last == inRange {
+		return
+	}
+	ev := apiv1.Event{Type: apiv1.EventThresholdCrossing, Config: p.configName, Source: p.name, Interface: iface,
+		From: thresholdName(last), To: thresholdName(inRange),
+		Message: fmt.Sprintf("offset %d, thresholds [%d, %d]", offset, p.ptpClockThreshold.MinOffsetThreshold, p.ptpClockThreshold.MaxOffsetThreshold)}
+	p.history.Add(ev)
+}
+
+func thresholdName(inRange bool) string {
+	if inRange {
+		return "in range"
+	}
+	return "out of range"
+}
+
+// recordRestart records the exit of a process about to be restarted
+func (p *ptpProcess) recordRestart(delay time.Duration, crashLooping bool) {
+	exit, _ := p.restart.LastExit()
+	message := fmt.Sprintf("%s, restarting in %s", exit, delay)
+	if crashLooping {
+		message += ", crash looping"
+	}
+	p.history.Add(apiv1.Event{Type: apiv1.EventProcessRestart, Config: p.configName, Source: p.name, Message: message})
+}
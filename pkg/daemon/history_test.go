@@ -0,0 +1,93 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/history"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_history(t *testing.T) {
+	dn := testDaemon()
+	dn.processManager.ptpEventHandler = &event.EventHandler{}
+	dn.SetHistory(history.New(history.DefaultCapacity))
+	ts2phc := testProcess(ts2phcProcessName, "ts2phc.0.config", "gm")
+	ts2phc.restart = newRestartTracker(getRestartPolicy(&ptpv1.PtpProfile{}))
+	phc2sys := testProcess(phc2sysProcessName, "phc2sys.0.config", "gm")
+	ptp4l := testProcess(ptp4lProcessName, "ptp4l.0.config", "gm")
+	for _, p := range []*ptpProcess{ts2phc, phc2sys, ptp4l} {
+		p.history = dn.history
+	}
+
+	ts2phc.recordThreshold("ens1f0", 0)
+	ts2phc.recordThreshold("ens1f0", 5)
+	ts2phc.recordThreshold("ens1f0", 5000)
+	ts2phc.recordThreshold("ens1f0", -3)
+	phc2sys.publishState(FREERUN)
+	phc2sys.publishState(LOCKED)
+	phc2sys.publishState(LOCKED)
+	// the offsets of ptp4l and phc2sys cross the thresholds too
+	ptp4l.ProcessTs2PhcEvents(5, ptp4lProcessName, "ens2f0", event.PTP_LOCKED, nil)
+	ptp4l.ProcessTs2PhcEvents(-500, ptp4lProcessName, "ens2f0", event.PTP_LOCKED, nil)
+	phc2sys.ProcessTs2PhcEvents(500, phc2sysProcessName, clockRealTime, event.PTP_FREERUN, nil)
+	phc2sys.ProcessTs2PhcEvents(7, phc2sysProcessName, clockRealTime, event.PTP_LOCKED, nil)
+	ts2phc.restart.started(42)
+	delay, crashLooping := ts2phc.restart.exited(nil)
+	ts2phc.recordRestart(delay, crashLooping)
+
+	mux := http.NewServeMux()
+	registerProbes(mux, dn)
+	query := func(query string) []apiv1.Event {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, apiv1.HistoryPath+query, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var h apiv1.History
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &h))
+		assert.Equal(t, "node", h.Node)
+		return h.Events
+	}
+
+	crossings := query("?type=" + apiv1.EventThresholdCrossing + "&source=" + ptp4lProcessName)
+	if assert.Len(t, crossings, 1) {
+		assert.Equal(t, "ens2f0", crossings[0].Interface)
+		assert.Equal(t, "offset -500, thresholds [-100, 100]", crossings[0].Message)
+	}
+	crossings = query("?type=" + apiv1.EventThresholdCrossing + "&source=" + phc2sysProcessName)
+	if assert.Len(t, crossings, 1) {
+		assert.Equal(t, clockRealTime, crossings[0].Interface)
+		assert.Equal(t, []string{"out of range", "in range"}, []string{crossings[0].From, crossings[0].To})
+	}
+	crossings = query("?type=" + apiv1.EventThresholdCrossing + "&source=" + ts2phcProcessName)
+	if assert.Len(t, crossings, 2) {
+		assert.Equal(t, "ens1f0", crossings[0].Interface)
+		assert.Equal(t, []string{"in range", "out of range"}, []string{crossings[0].From, crossings[0].To})
+		assert.Equal(t, "offset 5000, thresholds [-100, 100]", crossings[0].Message)
+		assert.Equal(t, []string{"out of range", "in range"}, []string{crossings[1].From, crossings[1].To})
+	}
+	states := query("?type=" + apiv1.EventStateChange + "&source=" + phc2sysProcessName)
+	if assert.Len(t, states, 2) {
+		assert.Equal(t, apiv1.Event{Type: apiv1.EventStateChange, Config: "phc2sys.0.config", Source: phc2sysProcessName, To: FREERUN},
+			apiv1.Event{Type: states[0].Type, Config: states[0].Config, Source: states[0].Source, From: states[0].From, To: states[0].To})
+		assert.Equal(t, FREERUN, states[1].From)
+		assert.Equal(t, LOCKED, states[1].To)
+	}
+	restarts := query("?since=1m&type=" + apiv1.EventProcessRestart)
+	if assert.Len(t, restarts, 1) {
+		assert.Equal(t, ts2phcProcessName, restarts[0].Source)
+		assert.Contains(t, restarts[0].Message, "failed to start, restarting in ")
+	}
+	assert.Empty(t, query("?until=1m"))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, apiv1.HistoryPath+"?since=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, apiv1.HistoryPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,226 @@
+package daemon
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/logging"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// PtpSettings keys of the log sampling. The rules of a process type are prefixed with the process name,
+// i.e. "phc2sys.logRules". They only decide what is printed, the metrics and events still see every line.
+const (
+	LogRulesSetting           = "logRules"           // YAML list of logRule
+	LogSummaryIntervalSetting = "logSummaryInterval" // interval of the summary of the suppressed lines, i.e. "5m", "0s" disables it
+)
+
+const defaultLogSummaryInterval = time.Minute
+
+// logRuleProcessNames are the process types whose output the log rules apply to
+var logRuleProcessNames = []string{ptp4lProcessName, phc2sysProcessName, ts2phcProcessName, syncEProcessName, chronydProcessName}
+
+// logRule samples the output lines matching Match, the first matching rule of a process applies.
+// A line is printed when both KeepOneIn and MaxPerSecond let it through, unless it is kept anyway
+// because it changes the servo state of its interface or its offset is out of KeepOffsetAbove, or out of the
+// ptpClockThreshold offsets of the profile when KeepOffsetAbove is not set.
+type logRule struct {
+	// Match is a regular expression, empty matches every line
+	Match string `json:"match,omitempty"`
+	// KeepOneIn prints the first of every KeepOneIn lines
+	KeepOneIn int `json:"keepOneIn,omitempty"`
+	// MaxPerSecond prints at most this many lines per second, with bursts of up to one second worth of lines
+	MaxPerSecond float64 `json:"maxPerSecond,omitempty"`
+	// KeepOffsetAbove always prints lines whose absolute offset is above this value, the lines whose offset is out
+	// of the ptpClockThreshold of the profile are printed when it is not set
+	KeepOffsetAbove *float64 `json:"keepOffsetAbove,omitempty"`
+	// KeepStateChanges prints the lines changing the servo state of an interface, true unless set to false
+	KeepStateChanges *bool `json:"keepStateChanges,omitempty"`
+}
+
+// sampledRule is a logRule and its sampling state
+type sampledRule struct {
+	logRule
+	re *regexp.Regexp
+	// count is the number of lines matched for KeepOneIn, tokens and refilled the MaxPerSecond bucket
+	count    int
+	tokens   float64
+	refilled time.Time
+	// states is the last servo state by config and interface
+	states map[string]string
+	// seen and suppressed are counted since the last summary
+	seen       int
+	suppressed int
+}
+
+// logSampler decides which output lines of a process are printed. It is used by the goroutine reading the output only.
+type logSampler struct {
+	name       string
+	configName string
+	rules      []*sampledRule
+	threshold  *ptpv1.PtpClockThreshold
+	interval   time.Duration
+	since      time.Time
+	now        func() time.Time
+}
+
+// parseLogSummaryInterval parses the interval of the summary of the suppressed lines of the profile
+func parseLogSummaryInterval(nodeProfile *ptpv1.PtpProfile) (time.Duration, error) {
+	if nodeProfile == nil {
+		return defaultLogSummaryInterval, nil
+	}
+	s, ok := nodeProfile.PtpSettings[LogSummaryIntervalSetting]
+	if !ok {
+		return defaultLogSummaryInterval, nil
+	}
+	interval, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil || interval < 0 {
+		return defaultLogSummaryInterval, fmt.Errorf("invalid %s %q", LogSummaryIntervalSetting, s)
+	}
+	return interval, nil
+}
+
+// parseLogRules parses the log rules of processName
+func parseLogRules(nodeProfile *ptpv1.PtpProfile, processName string) ([]logRule, error) {
+	if nodeProfile == nil {
+		return nil, nil
+	}
+	s, ok := nodeProfile.PtpSettings[processName+"."+LogRulesSetting]
+	if !ok {
+		return nil, nil
+	}
+	var rules []logRule
+	if err := yaml.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", LogRulesSetting, err)
+	}
+	for i, rule := range rules {
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			return nil, fmt.Errorf("%s rule %d: invalid match %q: %w", LogRulesSetting, i, rule.Match, err)
+		}
+		if rule.KeepOneIn < 0 || rule.MaxPerSecond < 0 || (rule.KeepOffsetAbove != nil && *rule.KeepOffsetAbove < 0) {
+			return nil, fmt.Errorf("%s rule %d: keepOneIn, maxPerSecond and keepOffsetAbove must not be negative", LogRulesSetting, i)
+		}
+	}
+	return rules, nil
+}
+
+// newLogSampler returns the sampler of the output of a process, nil when it has no valid rules
+func newLogSampler(nodeProfile *ptpv1.PtpProfile, processName, configName string) *logSampler {
+	rules, err := parseLogRules(nodeProfile, processName)
+	if err != nil {
+		glog.Errorf("%s (%s): %s, printing every line", processName, configName, err)
+		return nil
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	interval, err := parseLogSummaryInterval(nodeProfile)
+	if err != nil {
+		glog.Errorf("%s (%s): %s, using %s", processName, configName, err, interval)
+	}
+	s := &logSampler{name: processName, configName: configName, threshold: getPTPThreshold(nodeProfile),
+		interval: interval, now: time.Now}
+	for _, rule := range rules {
+		s.rules = append(s.rules, &sampledRule{logRule: rule, re: regexp.MustCompile(rule.Match), states: map[string]string{}})
+	}
+	s.since = s.now()
+	return s
+}
+
+// keep returns whether line is printed, it logs the summary of the suppressed lines when it is due
+func (s *logSampler) keep(line string) bool {
+	if s == nil {
+		return true
+	}
+	now := s.now()
+	if s.interval > 0 && now.Sub(s.since) >= s.interval {
+		s.summarize(now)
+	}
+	for _, r := range s.rules {
+		if r.re.MatchString(line) {
+			return r.keep(line, now, s.threshold)
+		}
+	}
+	return true
+}
+
+func (r *sampledRule) keep(line string, now time.Time, threshold *ptpv1.PtpClockThreshold) bool {
+	r.seen++
+	record := logging.ParseProcessLine(line)
+	if record.State != "" {
+		key := record.Config + "/" + record.Iface
+		last, found := r.states[key]
+		r.states[key] = record.State
+		if (r.KeepStateChanges == nil || *r.KeepStateChanges) && (!found || last != record.State) {
+			return true
+		}
+	}
+	if record.Offset != nil {
+		if r.KeepOffsetAbove != nil {
+			if math.Abs(*record.Offset) > *r.KeepOffsetAbove {
+				return true
+			}
+		} else if *record.Offset > float64(threshold.MaxOffsetThreshold) || *record.Offset < float64(threshold.MinOffsetThreshold) {
+			return true
+		}
+	}
+
+	keep := true
+	if r.KeepOneIn > 1 {
+		r.count++
+		keep = r.count%r.KeepOneIn == 1
+	}
+	if keep && r.MaxPerSecond > 0 {
+		burst := math.Max(1, r.MaxPerSecond)
+		if r.refilled.IsZero() {
+			r.tokens = burst
+		} else {
+			r.tokens = math.Min(burst, r.tokens+now.Sub(r.refilled).Seconds()*r.MaxPerSecond)
+		}
+		r.refilled = now
+		if r.tokens >= 1 {
+			r.tokens--
+		} else {
+			keep = false
+		}
+	}
+	if !keep {
+		r.suppressed++
+	}
+	return keep
+}
+
+// summarize logs the lines suppressed since the last summary, if any
+func (s *logSampler) summarize(now time.Time) {
+	if s == nil {
+		return
+	}
+	var rules []string
+	seen, suppressed := 0, 0
+	for _, r := range s.rules {
+		if r.suppressed > 0 {
+			rules = append(rules, fmt.Sprintf("%d of %d matching %q", r.suppressed, r.seen, r.Match))
+		}
+		seen += r.seen
+		suppressed += r.suppressed
+		r.seen, r.suppressed = 0, 0
+	}
+	if suppressed > 0 {
+		glog.Infof("%s (%s) suppressed %d of %d sampled log lines in the last %s: %s",
+			s.name, s.configName, suppressed, seen, now.Sub(s.since).Round(time.Second), strings.Join(rules, ", "))
+	}
+	s.since = now
+}
+
+// flush logs the summary of the lines suppressed since the last summary, when the process exits
+func (s *logSampler) flush() {
+	if s == nil || s.interval == 0 {
+		return
+	}
+	s.summarize(s.now())
+}
@@ -0,0 +1,84 @@
+package daemon
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+)
+
+func Test_logSampler(t *testing.T) {
+	profile := &ptpv1.PtpProfile{Name: pointer.String("bc"), PtpSettings: map[string]string{
+		"phc2sys." + LogRulesSetting: `
+- match: "CLOCK_REALTIME phc offset"
+  keepOneIn: 10
+  keepOffsetAbove: 100
+- match: "nmea"
+  maxPerSecond: 2
+  keepStateChanges: false
+`,
+		LogSummaryIntervalSetting: "1m",
+	}}
+	assert.Nil(t, newLogSampler(profile, ptp4lProcessName, "ptp4l.0.config"))
+	s := newLogSampler(profile, phc2sysProcessName, "ptp4l.0.config")
+	if !assert.NotNil(t, s) {
+		return
+	}
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	s.since = now
+
+	offset := func(offset int, state string) string {
+		return fmt.Sprintf("phc2sys[1.0]: [ptp4l.0.config:6] CLOCK_REALTIME phc offset %d %s freq -100 delay 500", offset, state)
+	}
+	kept := 0
+	for i := 0; i < 30; i++ {
+		if s.keep(offset(5, "s2")) {
+			kept++
+		}
+	}
+	// the first line changes the state, then one in 10 of the remaining 29
+	assert.Equal(t, 1+3, kept)
+	assert.True(t, s.keep(offset(-500, "s2")), "out of threshold")
+	assert.True(t, s.keep(offset(5, "s1")), "state change")
+	assert.True(t, s.keep("phc2sys[1.0]: [ptp4l.0.config:5] port 1 (ens1f0): SLAVE to UNCALIBRATED"), "no rule matches")
+
+	// the nmea rule leaves keepOffsetAbove unset, the ptpClockThreshold of the profile applies
+	nmeaOffset := func(offset int) string {
+		return fmt.Sprintf("phc2sys[1.0]: [ptp4l.0.config:6] nmea ens1f0 offset %d s2 freq -100", offset)
+	}
+	s.rules[1].refilled, s.rules[1].tokens = now, 0
+	assert.False(t, s.keep(nmeaOffset(50)))
+	assert.True(t, s.keep(nmeaOffset(150)), "above the default max offset")
+	assert.True(t, s.keep(nmeaOffset(-150)), "below the default min offset")
+	profile.PtpClockThreshold = &ptpv1.PtpClockThreshold{MaxOffsetThreshold: 10, MinOffsetThreshold: -10}
+	s.threshold = getPTPThreshold(profile)
+	assert.True(t, s.keep(nmeaOffset(50)), "above the max offset of the profile")
+	profile.PtpClockThreshold = nil
+	s.rules[1].refilled, s.rules[1].tokens, s.rules[1].suppressed = time.Time{}, 0, 0
+
+	// at most 2 per second
+	nmea := "phc2sys[1.0]: [ptp4l.0.config:6] nmea sentence: GNRMC"
+	assert.True(t, s.keep(nmea))
+	assert.True(t, s.keep(nmea))
+	assert.False(t, s.keep(nmea))
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, s.keep(nmea))
+	assert.False(t, s.keep(nmea))
+
+	assert.Equal(t, 26, s.rules[0].suppressed)
+	assert.Equal(t, 2, s.rules[1].suppressed)
+	now = now.Add(time.Minute)
+	s.keep(nmea)
+	assert.Equal(t, 0, s.rules[0].suppressed, "the summary resets the counters")
+	assert.Equal(t, now, s.since)
+
+	profile.PtpSettings[LogSummaryIntervalSetting] = "soon"
+	_, err := parseLogSummaryInterval(profile)
+	assert.Error(t, err)
+	var nilSampler *logSampler
+	assert.True(t, nilSampler.keep(nmea))
+}
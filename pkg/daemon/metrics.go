@@ -53,8 +53,9 @@ const (
 )
 
 const (
-	PtpProcessDown int64 = 0
-	PtpProcessUp   int64 = 1
+	PtpProcessDown      int64 = 0
+	PtpProcessUp        int64 = 1
+	PtpProcessCrashLoop int64 = 2
 )
 
 type ptpPortRole int
@@ -155,7 +156,16 @@ var (
 			Namespace: PTPNamespace,
 			Subsystem: PTPSubsystem,
 			Name:      "process_status",
-			Help:      "0 = DOWN, 1 = UP",
+			Help:      "0 = DOWN, 1 = UP, 2 = CRASH_LOOPING",
+		}, []string{"process", "node", "config"})
+
+	// ProcessExitCode metrics to show the last exit code of a process, 128 + signal number when killed by a signal
+	ProcessExitCode = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "process_exit_code",
+			Help:      "last exit code of the process, 128 + signal number when killed by a signal, -1 when it failed to start",
 		}, []string{"process", "node", "config"})
 
 	ProcessRestartCount = prometheus.NewCounterVec(
@@ -166,6 +176,114 @@ var (
 			Help:      "",
 		}, []string{"process", "node", "config"})
 
+	// ProcessStopCount metrics to count how processes were stopped, by result
+	ProcessStopCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "process_stop_count",
+			Help:      "terminated = exited within the grace period, killed = killed after the grace period, timeout = not reaped after kill",
+		}, []string{"process", "node", "result"})
+
+	// ProfileRollbackCount metrics to count the rollbacks to the last known good profiles, by reason
+	ProfileRollbackCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "profile_rollback_count",
+			Help:      "crashloop = a process crash loops, notlocked = not all processes locked within the rollback window, applyfailed = the profiles failed to apply",
+		}, []string{"node", "reason"})
+
+	// EventQueueDepth metrics to show the number of events waiting for the event loop
+	EventQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "event_queue_depth",
+			Help:      "",
+		}, []string{"node"})
+
+	// EventQueueDropped metrics to count the periodic events dropped because the event queue was full, by source
+	EventQueueDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "event_queue_dropped_count",
+			Help:      "",
+		}, []string{"node", "source"})
+
+	// EventQueueCoalesced metrics to count the periodic events replaced by a newer one of the same source before the event loop got them, by source
+	EventQueueCoalesced = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "event_queue_coalesced_count",
+			Help:      "",
+		}, []string{"node", "source"})
+
+	// EventSubscriberQueueDepth metrics to show the number of state notifications waiting for a subscriber
+	EventSubscriberQueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "event_subscriber_queue_depth",
+			Help:      "",
+		}, []string{"node", "subscriber"})
+
+	// EventSubscriberLag metrics to show the seconds between the publication and the delivery of the last state notification of a subscriber
+	EventSubscriberLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "event_subscriber_lag_seconds",
+			Help:      "",
+		}, []string{"node", "subscriber"})
+
+	// EventSubscriberHealthy metrics to show whether the last state notification of a subscriber succeeded
+	EventSubscriberHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "event_subscriber_healthy",
+			Help:      "0 = the last notification failed, 1 = healthy",
+		}, []string{"node", "subscriber"})
+
+	// EventSubscriberDropped metrics to count the state notifications dropped because the queue of a subscriber was full
+	EventSubscriberDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "event_subscriber_dropped_count",
+			Help:      "",
+		}, []string{"node", "subscriber"})
+
+	// EventSubscriberFailures metrics to count the failed state notifications of a subscriber
+	EventSubscriberFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "event_subscriber_failure_count",
+			Help:      "",
+		}, []string{"node", "subscriber"})
+
+	// ChronyStratum metrics to show the NTP stratum of chronyd
+	ChronyStratum = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "chrony_stratum",
+			Help:      "stratum of CLOCK_REALTIME as disciplined by chronyd",
+		}, []string{"process", "node", "config"})
+
+	// ChronyLeapStatus metrics to show the leap status of chronyd
+	ChronyLeapStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: PTPNamespace,
+			Subsystem: PTPSubsystem,
+			Name:      "chrony_leap_status",
+			Help:      "0 = NORMAL, 1 = INSERT SECOND, 2 = DELETE SECOND, 3 = NOT SYNCHRONISED",
+		}, []string{"process", "node", "config"})
+
 	// PTPHAMetrics metrics to show current ha profiles
 	PTPHAMetrics = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -207,7 +325,20 @@ func RegisterMetrics(nodeName string) {
 		prometheus.MustRegister(ClockState)
 		prometheus.MustRegister(ProcessStatus)
 		prometheus.MustRegister(ProcessRestartCount)
+		prometheus.MustRegister(ProcessExitCode)
+		prometheus.MustRegister(ProcessStopCount)
+		prometheus.MustRegister(ProfileRollbackCount)
 		prometheus.MustRegister(ClockClassMetrics)
+		prometheus.MustRegister(EventQueueDepth)
+		prometheus.MustRegister(EventQueueDropped)
+		prometheus.MustRegister(EventQueueCoalesced)
+		prometheus.MustRegister(EventSubscriberQueueDepth)
+		prometheus.MustRegister(EventSubscriberLag)
+		prometheus.MustRegister(EventSubscriberHealthy)
+		prometheus.MustRegister(EventSubscriberDropped)
+		prometheus.MustRegister(EventSubscriberFailures)
+		prometheus.MustRegister(ChronyStratum)
+		prometheus.MustRegister(ChronyLeapStatus)
 		prometheus.MustRegister(PTPHAMetrics)
 		prometheus.MustRegister(SynceQLInfo)
 		prometheus.MustRegister(SynceClockQL)
@@ -534,11 +665,34 @@ func UpdateProcessStatusMetrics(process, cfgName string, status int64) {
 	ProcessStatus.With(prometheus.Labels{
 		"process": process, "node": NodeName, "config": cfgName}).Set(float64(status))
 	if status == PtpProcessUp {
-		ProcessRestartCount.With(prometheus.Labels{
-			"process": process, "node": NodeName, "config": cfgName}).Inc()
+		UpdateProcessRestartMetrics(process, cfgName)
 	}
 }
 
+// UpdateProcessRestartMetrics ... count a process (re)start
+func UpdateProcessRestartMetrics(process, cfgName string) {
+	ProcessRestartCount.With(prometheus.Labels{
+		"process": process, "node": NodeName, "config": cfgName}).Inc()
+}
+
+// UpdateProcessStopMetrics ... count a process stop by its result
+func UpdateProcessStopMetrics(process string, result stopResult) {
+	ProcessStopCount.With(prometheus.Labels{
+		"process": process, "node": NodeName, "result": string(result)}).Inc()
+}
+
+// UpdateProfileRollbackMetrics ... count a rollback to the last known good profiles
+func UpdateProfileRollbackMetrics(reason rollbackReason) {
+	ProfileRollbackCount.With(prometheus.Labels{
+		"node": NodeName, "reason": string(reason)}).Inc()
+}
+
+// UpdateProcessExitMetrics ... update the last exit code of a process
+func UpdateProcessExitMetrics(process, cfgName string, exitCode int) {
+	ProcessExitCode.With(prometheus.Labels{
+		"process": process, "node": NodeName, "config": cfgName}).Set(float64(exitCode))
+}
+
 // UpdatePTPHAMetrics ... update ptp ha  metrics
 func UpdatePTPHAMetrics(profile string, inActiveProfiles []string, state int64) {
 	PTPHAMetrics.With(prometheus.Labels{
@@ -577,6 +731,27 @@ func deleteSyncEMetrics(process, configName string, relations *synce.Relations)
 	}
 }
 
+// UpdateChronyTrackingMetrics ... update chronyd tracking metrics
+func UpdateChronyTrackingMetrics(cfgName string, t chronyTracking) {
+	Offset.With(prometheus.Labels{
+		"from": sys, "process": chronydProcessName, "node": NodeName, "iface": clockRealTime}).Set(float64(t.offsetNs()))
+	ChronyStratum.With(prometheus.Labels{
+		"process": chronydProcessName, "node": NodeName, "config": cfgName}).Set(float64(t.stratum))
+	ChronyLeapStatus.With(prometheus.Labels{
+		"process": chronydProcessName, "node": NodeName, "config": cfgName}).Set(float64(t.leapStatus))
+}
+
+func deleteChronyMetrics(cfgName string) {
+	Offset.Delete(prometheus.Labels{
+		"from": sys, "process": chronydProcessName, "node": NodeName, "iface": clockRealTime})
+	ClockState.Delete(prometheus.Labels{
+		"process": chronydProcessName, "node": NodeName, "iface": clockRealTime})
+	ChronyStratum.Delete(prometheus.Labels{
+		"process": chronydProcessName, "node": NodeName, "config": cfgName})
+	ChronyLeapStatus.Delete(prometheus.Labels{
+		"process": chronydProcessName, "node": NodeName, "config": cfgName})
+}
+
 // DeleteMetrics ... update ptp ha  metrics
 func deleteMetrics(ifaces config.IFaces, haProfiles map[string][]string, process, config string) {
 	if process == phc2sysProcessName {
@@ -588,7 +763,8 @@ func deleteMetrics(ifaces config.IFaces, haProfiles map[string][]string, process
 		InterfaceRole.Delete(prometheus.Labels{
 			"process": ptp4lProcessName, "node": NodeName, "iface": iface.Name})
 	}
-	for _, iface := range masterOffsetIface.iface {
+	// only delete offsets reported under this config, other profiles may still be running
+	if iface := masterOffsetIface.get(config); iface.alias != "" {
 		ClockState.Delete(prometheus.Labels{
 			"process": process, "node": NodeName, "iface": iface.alias})
 		Delay.Delete(prometheus.Labels{
@@ -624,6 +800,8 @@ func deleteProcessStatusMetrics(config, process string) {
 		"process": process, "node": NodeName, "config": config})
 	ProcessRestartCount.Delete(prometheus.Labels{
 		"process": process, "node": NodeName, "config": config})
+	ProcessExitCode.Delete(prometheus.Labels{
+		"process": process, "node": NodeName, "config": config})
 
 }
 func extractPTP4lEventState(output string) (portId int, role ptpPortRole) {
@@ -683,15 +861,9 @@ func addFlagsForMonitor(process string, configOpts *string, conf *ptp4lConf, std
 			}
 
 			if !strings.Contains(*configOpts, "--summary_interval") {
-				for index, section := range conf.sections {
-					if section.sectionName == "[global]" {
-						_, exist := section.options["summary_interval"]
-						if !exist {
-							glog.Info("adding summary_interval 1 to print summary messages to stdout for ptp4l to use prometheus exporter")
-							section.options["summary_interval"] = "1"
-						}
-						conf.sections[index] = section
-					}
+				if global := conf.global(); !global.Has("summary_interval") {
+					glog.Info("adding summary_interval 1 to print summary messages to stdout for ptp4l to use prometheus exporter")
+					global.Set("summary_interval", "1")
 				}
 			}
 		}
@@ -716,11 +888,18 @@ func addFlagsForMonitor(process string, configOpts *string, conf *ptp4lConf, std
 
 }
 
-// StartMetricsServer runs the prometheus listner so that metrics can be collected
-func StartMetricsServer(bindAddress string) {
+// StartMetricsServer runs the prometheus listner so that metrics can be collected,
+// it also serves the /healthz and /readyz probes and the status API of dn when dn is not nil
+func StartMetricsServer(bindAddress string, dn *Daemon) {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
+	if dn != nil {
+		registerProbes(mux, dn)
+	}
+	serve(bindAddress, mux)
+}
 
+func serve(bindAddress string, mux *http.ServeMux) {
 	go utilwait.Until(func() {
 		err := http.ListenAndServe(bindAddress, mux)
 		if err != nil {
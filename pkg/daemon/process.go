@@ -12,4 +12,5 @@ type process interface {
 	CmdRun(stdToSocket bool)
 	MonitorProcess(p config.ProcessConfig)
 	ExitCh() chan struct{}
+	Ready() bool
 }
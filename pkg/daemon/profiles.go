@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+)
+
+// appliedProfile is a profile whose processes are currently running.
+// profile is a copy taken before rendering, since applyNodePtpProfile
+// rewrites the config strings and options in place.
+type appliedProfile struct {
+	runID   int
+	profile *ptpv1.PtpProfile
+}
+
+// unchangedProfiles returns the names of the profiles in nodeProfiles that are
+// identical to the ones already running and can be left untouched.
+// A profile listing haProfiles is only kept if all the profiles it follows are kept,
+// since its phc2sys command line is built from their sockets and interfaces.
+func (p *ProcessManager) unchangedProfiles(nodeProfiles []ptpv1.PtpProfile) map[string]bool {
+	keep := map[string]bool{}
+	for i := range nodeProfiles {
+		if nodeProfiles[i].Name == nil {
+			continue
+		}
+		name := *nodeProfiles[i].Name
+		if applied, ok := p.appliedProfiles[name]; ok && reflect.DeepEqual(applied.profile, &nodeProfiles[i]) {
+			keep[name] = true
+		}
+	}
+	for i := range nodeProfiles {
+		if nodeProfiles[i].Name == nil || !keep[*nodeProfiles[i].Name] {
+			continue
+		}
+		for _, haProfile := range listHaProfiles(&nodeProfiles[i]) {
+			if !keep[haProfile] {
+				glog.Infof("profile %s follows changed profile %s, it will be restarted", *nodeProfiles[i].Name, haProfile)
+				delete(keep, *nodeProfiles[i].Name)
+				break
+			}
+		}
+	}
+	return keep
+}
+
+// setAppliedProfile records profile as running under runID
+func (p *ProcessManager) setAppliedProfile(runID int, profile *ptpv1.PtpProfile) {
+	if p.appliedProfiles == nil {
+		p.appliedProfiles = map[string]appliedProfile{}
+	}
+	p.appliedProfiles[*profile.Name] = appliedProfile{runID: runID, profile: profile}
+}
+
+// removeAppliedProfiles forgets every applied profile not listed in keep
+func (p *ProcessManager) removeAppliedProfiles(keep map[string]bool) {
+	for name := range p.appliedProfiles {
+		if !keep[name] {
+			delete(p.appliedProfiles, name)
+		}
+	}
+}
+
+// assignRunIDs returns the runID for every profile in nodeProfiles that has to be started.
+// Kept profiles hold on to their runID, restarted profiles get their previous runID back
+// so config and socket paths stay stable, and new profiles take the lowest free runID.
+func (p *ProcessManager) assignRunIDs(nodeProfiles []ptpv1.PtpProfile, keep map[string]bool) map[string]int {
+	runIDs := map[string]int{}
+	used := map[int]bool{}
+	for name, applied := range p.appliedProfiles {
+		if keep[name] {
+			used[applied.runID] = true
+		}
+	}
+	for _, profile := range nodeProfiles {
+		name := *profile.Name
+		if applied, ok := p.appliedProfiles[name]; ok && !keep[name] && !used[applied.runID] {
+			runIDs[name] = applied.runID
+			used[applied.runID] = true
+		}
+	}
+	runID := 0
+	for _, profile := range nodeProfiles {
+		name := *profile.Name
+		if _, ok := runIDs[name]; ok || keep[name] {
+			continue
+		}
+		for used[runID] {
+			runID++
+		}
+		runIDs[name] = runID
+		used[runID] = true
+	}
+	return runIDs
+}
+
+// stopProcess stops p and its dependent processes and deletes their metrics
+func (p *ptpProcess) stopProcess() {
+	glog.Infof("stopping process.... %s", p.name)
+	p.cmdStop()
+	for _, d := range p.depProcess {
+		if d != nil {
+			d.CmdStop()
+		}
+	}
+	p.depProcess = nil
+	//cleanup metrics
+	deleteMetrics(p.ifaces, p.haProfile, p.name, p.configName)
+	if p.name == syncEProcessName && p.syncERelations != nil {
+		deleteSyncEMetrics(p.name, p.configName, p.syncERelations)
+	}
+	if p.name == chronydProcessName {
+		deleteChronyMetrics(p.configName)
+	}
+	if p.name == ts2phcProcessName && leap.LeapMgr != nil {
+		leap.LeapMgr.RemovePtp4lConfigPath(strings.Replace(p.configName, ts2phcProcessName, ptp4lProcessName, 1))
+	}
+}
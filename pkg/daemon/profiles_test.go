@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/plugin"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+)
+
+func Test_unchangedProfiles(t *testing.T) {
+	bc := ptpv1.PtpProfile{Name: pointer.String("bc"), Ptp4lOpts: pointer.String("-2")}
+	gm := ptpv1.PtpProfile{Name: pointer.String("gm"), Ptp4lOpts: pointer.String("-2"), Ts2PhcOpts: pointer.String(" ")}
+	ha := ptpv1.PtpProfile{Name: pointer.String("ha"), Phc2sysOpts: pointer.String("-a"),
+		PtpSettings: map[string]string{PTP_HA_IDENTIFIER: "bc"}}
+
+	pm := &ProcessManager{}
+	for i, p := range []ptpv1.PtpProfile{bc, gm, ha} {
+		pm.setAppliedProfile(i, p.DeepCopy())
+	}
+
+	// nothing changed
+	keep := pm.unchangedProfiles([]ptpv1.PtpProfile{*bc.DeepCopy(), *gm.DeepCopy(), *ha.DeepCopy()})
+	assert.Equal(t, map[string]bool{"bc": true, "gm": true, "ha": true}, keep)
+
+	// bc changed: gm keeps running, ha follows bc and is restarted
+	bcChanged := bc.DeepCopy()
+	bcChanged.Ptp4lConf = pointer.String("[global]\ndomainNumber 24")
+	profiles := []ptpv1.PtpProfile{*bcChanged, *gm.DeepCopy(), *ha.DeepCopy()}
+	keep = pm.unchangedProfiles(profiles)
+	assert.Equal(t, map[string]bool{"gm": true}, keep)
+
+	// restarted profiles get their previous runID back
+	runIDs := pm.assignRunIDs(profiles, keep)
+	assert.Equal(t, map[string]int{"bc": 0, "ha": 2}, runIDs)
+
+	// gm removed and a new profile added: the new profile takes the lowest free runID
+	oc := ptpv1.PtpProfile{Name: pointer.String("oc"), Ptp4lOpts: pointer.String("-s")}
+	profiles = []ptpv1.PtpProfile{*bc.DeepCopy(), *oc.DeepCopy(), *ha.DeepCopy()}
+	keep = pm.unchangedProfiles(profiles)
+	assert.Equal(t, map[string]bool{"bc": true, "ha": true}, keep)
+	runIDs = pm.assignRunIDs(profiles, keep)
+	assert.Equal(t, map[string]int{"oc": 1}, runIDs)
+
+	pm.removeAppliedProfiles(keep)
+	assert.Len(t, pm.appliedProfiles, 2)
+}
+
+func Test_applyProfiles_hwconfigs(t *testing.T) {
+	// a plugin reporting the profiles it was given since its hwconfigs were last populated
+	var configured []string
+	testPlugin := &plugin.Plugin{
+		Name: "test",
+		OnPTPConfigChange: func(_ *interface{}, profile *ptpv1.PtpProfile) error {
+			configured = append(configured, *profile.Name)
+			return nil
+		},
+		AfterRunPTPCommand: func(*interface{}, *ptpv1.PtpProfile, string) error { return nil },
+		PopulateHwConfig: func(_ *interface{}, hwconfigs *[]ptpv1.HwConfig) error {
+			for _, name := range configured {
+				*hwconfigs = append(*hwconfigs, ptpv1.HwConfig{DeviceID: name})
+			}
+			configured = nil
+			return nil
+		},
+	}
+	assert.NoError(t, leap.MockLeapFile())
+	defer close(leap.LeapMgr.Close)
+	dn := testDaemon()
+	dn.pluginManager = PluginManager{plugins: map[string]*plugin.Plugin{"test": testPlugin}, data: map[string]*interface{}{"test": nil}}
+	deviceIDs := func() []string {
+		var ids []string
+		for _, hw := range *dn.hwconfigs {
+			ids = append(ids, hw.DeviceID)
+		}
+		return ids
+	}
+
+	// profiles without options run no process
+	a := ptpv1.PtpProfile{Name: pointer.String("a"), Ptp4lOpts: pointer.String("")}
+	b := ptpv1.PtpProfile{Name: pointer.String("b"), Ptp4lOpts: pointer.String("")}
+	assert.NoError(t, dn.applyProfiles([]ptpv1.PtpProfile{*a.DeepCopy(), *b.DeepCopy()}))
+	assert.Equal(t, []string{"a", "b"}, deviceIDs())
+
+	// only b changed, the hwconfig of a is kept
+	b.PtpSettings = map[string]string{"logReduce": "true"}
+	assert.NoError(t, dn.applyProfiles([]ptpv1.PtpProfile{*a.DeepCopy(), *b.DeepCopy()}))
+	assert.Equal(t, []string{"a", "b"}, deviceIDs())
+}
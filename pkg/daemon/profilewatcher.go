@@ -0,0 +1,76 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+)
+
+const (
+	// ProfileWatchDebounce coalesces the burst of events of a single profile update
+	ProfileWatchDebounce = 500 * time.Millisecond
+	// configMapDataDir is the symlink kubelet swaps atomically when a mounted ConfigMap is updated
+	configMapDataDir = "..data"
+)
+
+// WatchProfiles watches the profile file or directory at path and notifies the returned channel
+// once a burst of changes settles for debounce.
+// A file is watched through its directory, so that the ConfigMap ..data symlink swap,
+// and files being replaced or created after the watch started, are seen.
+// The watch stops when stopCh is closed.
+func WatchProfiles(path string, debounce time.Duration, stopCh <-chan struct{}) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	dir, name := filepath.Dir(path), filepath.Base(path)
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		dir, name = path, ""
+	}
+	if err = watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	glog.Infof("watching %s for profile changes", path)
+
+	notifyCh := make(chan struct{}, 1)
+	go func() {
+		defer watcher.Close()
+		timer := time.NewTimer(debounce)
+		timer.Stop()
+		defer timer.Stop()
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				base := filepath.Base(ev.Name)
+				if name != "" && base != name && base != configMapDataDir {
+					continue
+				}
+				if ev.Op == fsnotify.Chmod {
+					continue
+				}
+				glog.V(2).Infof("profile watch event %s", ev)
+				timer.Reset(debounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				glog.Errorf("profile watch error: %s", err)
+			case <-timer.C:
+				select {
+				case notifyCh <- struct{}{}:
+				default: // an update is already pending
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return notifyCh, nil
+}
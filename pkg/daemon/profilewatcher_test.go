@@ -0,0 +1,50 @@
+package daemon
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WatchProfiles(t *testing.T) {
+	// lay out the directory the way kubelet mounts a ConfigMap
+	dir := t.TempDir()
+	assert.NoError(t, os.Mkdir(dir+"/..2024_01_01", 0755))
+	assert.NoError(t, os.WriteFile(dir+"/..2024_01_01/node1", []byte("[]"), 0644))
+	assert.NoError(t, os.Symlink("..2024_01_01", dir+"/..data"))
+	assert.NoError(t, os.Symlink("..data/node1", dir+"/node1"))
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	profileCh, err := WatchProfiles(dir+"/node1", 100*time.Millisecond, stopCh)
+	assert.NoError(t, err)
+
+	// a ConfigMap update swaps ..data and removes the old data directory
+	assert.NoError(t, os.Mkdir(dir+"/..2024_01_02", 0755))
+	assert.NoError(t, os.WriteFile(dir+"/..2024_01_02/node1", []byte(`[{"name":"bc"}]`), 0644))
+	assert.NoError(t, os.Symlink("..2024_01_02", dir+"/..data_tmp"))
+	assert.NoError(t, os.Rename(dir+"/..data_tmp", dir+"/..data"))
+	assert.NoError(t, os.RemoveAll(dir+"/..2024_01_01"))
+
+	select {
+	case <-profileCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("profile change not notified")
+	}
+	// the burst was coalesced into a single notification
+	select {
+	case <-profileCh:
+		t.Fatal("unexpected second notification")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// other nodes' profiles are ignored
+	assert.NoError(t, os.WriteFile(dir+"/node2", []byte("[]"), 0644))
+	select {
+	case <-profileCh:
+		t.Fatal("unexpected notification for another node")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
@@ -0,0 +1,93 @@
+package daemon
+
+import (
+	"net"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/pmc"
+)
+
+const (
+	// processReadyTimeout is how long startup waits for a process to become ready before moving on
+	processReadyTimeout = 30 * time.Second
+	// applyReadyTimeout bounds the readiness waits of all the processes started by a profile update
+	applyReadyTimeout = 60 * time.Second
+	// readinessPollInterval is the interval between two readiness checks
+	readinessPollInterval = 500 * time.Millisecond
+	// readinessProbeTimeout bounds a single readiness check
+	readinessProbeTimeout = 1 * time.Second
+	// GPSD_PORT ... port gpsd listens on, see CmdInit
+	GPSD_PORT = "2947"
+)
+
+// gpsdAddress is the address gpsd is probed at
+var gpsdAddress = net.JoinHostPort("localhost", GPSD_PORT)
+
+// waitReady polls ready until it returns true or timeout expires, it returns whether the process became ready
+func waitReady(name string, ready func() bool, timeout time.Duration) bool {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for {
+		if ready() {
+			glog.Infof("%s is ready after %s", name, time.Since(start).Round(time.Millisecond))
+			return true
+		}
+		if time.Now().After(deadline) {
+			glog.Errorf("%s is not ready after %s, continuing startup", name, timeout)
+			return false
+		}
+		time.Sleep(readinessPollInterval)
+	}
+}
+
+// waitReady waits for a process started by a profile update to become ready, for at most processReadyTimeout
+// and until deadline, which bounds the waits of the whole update. The daemon loop keeps beating meanwhile.
+func (dn *Daemon) waitReady(name string, ready func() bool, deadline time.Time) bool {
+	return waitReady(name, func() bool {
+		dn.beat()
+		return ready()
+	}, max(0, min(processReadyTimeout, time.Until(deadline))))
+}
+
+// gpsdListening returns whether gpsd accepts connections on its port
+func gpsdListening() bool {
+	conn, err := net.DialTimeout("tcp", gpsdAddress, readinessProbeTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Ready ... gpsd is ready once it accepts connections on its port
+func (g *GPSD) Ready() bool {
+	return gpsdListening()
+}
+
+// Ready ... gpspipe is ready once it is running and the gpsd it reads from accepts connections.
+// Its FIFO is left alone, every NMEA sentence written to it is for ts2phc.
+func (gp *gpspipe) Ready() bool {
+	if pid, _ := gp.restart.Running(); pid == 0 || gp.Stopped() {
+		return false
+	}
+	return gpsdListening()
+}
+
+// ready returns true once ptp4l answers pmc on its UDS socket and chronyd answers chronyc on its command socket,
+// the other linuxptp processes have no management interface and are ready once started
+func (p *ptpProcess) ready() bool {
+	if p.name == chronydProcessName {
+		_, err := chronycTracking(p.processSocketPath)
+		return err == nil
+	}
+	if p.name != ptp4lProcessName {
+		return true
+	}
+	if _, err := os.Stat(p.processSocketPath); err != nil {
+		return false
+	}
+	_, _, err := pmc.RunPMCExp(p.configName, pmc.CmdGetParentDataSet, pmc.ParentDataSetRegEx)
+	return err == nil
+}
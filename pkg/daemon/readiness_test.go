@@ -0,0 +1,45 @@
+package daemon
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_gpspipeReady(t *testing.T) {
+	gpsd, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	defer gpsd.Close()
+	defer func(address string) { gpsdAddress = address }(gpsdAddress)
+	gpsdAddress = gpsd.Addr().String()
+
+	gp := &gpspipe{name: GPSPIPE_PROCESSNAME, restart: newRestartTracker(restartPolicy{})}
+	assert.False(t, gp.Ready(), "not started")
+	gp.restart.started(42)
+	assert.True(t, gp.Ready())
+	gp.setStopped(true)
+	assert.False(t, gp.Ready(), "stopped")
+	gp.setStopped(false)
+	gpsd.Close()
+	assert.False(t, gp.Ready(), "gpsd is not listening")
+}
+
+func Test_waitReady(t *testing.T) {
+	dn := testDaemon()
+	checks := 0
+	never := func() bool {
+		checks++
+		return false
+	}
+	// the deadline of the update bounds the wait of each process
+	start := time.Now()
+	assert.False(t, dn.waitReady("first", never, start.Add(2*readinessPollInterval)))
+	assert.Less(t, time.Since(start), processReadyTimeout)
+	// once it passed, the processes are checked once
+	checks = 0
+	assert.False(t, dn.waitReady("second", never, start))
+	assert.Equal(t, 1, checks)
+	assert.True(t, dn.waitReady("third", func() bool { return true }, start))
+}
@@ -0,0 +1,120 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll"
+)
+
+// RenderedProcess is a process as applyNodePtpProfile creates it
+type RenderedProcess struct {
+	Name       string   `json:"name"`
+	ConfigPath string   `json:"configPath"`
+	Config     string   `json:"config"`
+	CmdLine    string   `json:"cmdLine"`
+	Scheduling string   `json:"scheduling,omitempty"` // the process is started with, see schedAttr
+	Dependents []string `json:"dependents,omitempty"` // dependent processes, started before this one
+}
+
+// RenderedProfile holds the processes of a profile
+type RenderedProfile struct {
+	Name      string            `json:"name"`
+	RunID     int               `json:"runID"`
+	Processes []RenderedProcess `json:"processes"`
+	Warnings  []ValidationIssue `json:"warnings,omitempty"` // validation warnings, profiles with errors are not rendered
+}
+
+// RenderProfiles renders the profiles in nodeProfilesJson, in any format LinuxPTPConfUpdate.UpdateConfig accepts,
+// exactly as applyNodePTPProfiles would on a fresh start, without writing anything to the node.
+// Hardware plugins are not called, since they configure the NICs.
+func RenderProfiles(nodeProfilesJson []byte, stdoutToSocket bool) ([]RenderedProfile, error) {
+	nodeProfiles, ok := tryToLoadConfig(nodeProfilesJson)
+	if !ok {
+		if nodeProfiles, ok = tryToLoadOldConfig(nodeProfilesJson); !ok {
+			return nil, fmt.Errorf("unable to load profile config")
+		}
+	}
+	validation := ValidateProfiles(nodeProfiles)
+	if validation.HasErrors() {
+		return nil, validation.Err()
+	}
+	sortProfiles(nodeProfiles)
+
+	dn := &Daemon{
+		stdoutToSocket:  stdoutToSocket,
+		processManager:  &ProcessManager{},
+		dryRun:          true,
+		renderedConfigs: map[string]string{},
+	}
+	rendered := make([]RenderedProfile, 0, len(nodeProfiles))
+	for runID := range nodeProfiles {
+		profile := &nodeProfiles[runID]
+		start := len(dn.processManager.process)
+		if err := dn.applyNodePtpProfile(runID, profile); err != nil {
+			return nil, fmt.Errorf("failed to render profile %s: %w", *profile.Name, err)
+		}
+		r := RenderedProfile{Name: *profile.Name, RunID: runID, Processes: []RenderedProcess{}, Warnings: validation.ForProfile(*profile.Name)}
+		for _, p := range dn.processManager.process[start:] {
+			rp := RenderedProcess{
+				Name:       p.name,
+				ConfigPath: p.processConfigPath,
+				Config:     dn.renderedConfigs[p.processConfigPath],
+				CmdLine:    strings.Join(p.cmd.Args, " "),
+				Scheduling: p.sched.String(),
+			}
+			for _, d := range p.depProcess {
+				rp.Dependents = append(rp.Dependents, describeDependent(d))
+			}
+			r.Processes = append(r.Processes, rp)
+		}
+		rendered = append(rendered, r)
+	}
+	return rendered, nil
+}
+
+// describeDependent returns a one line description of a dependent process
+func describeDependent(d process) string {
+	switch dp := d.(type) {
+	case *GPSD:
+		return describeCmd(dp.Name(), dp.cmdLine, dp.sched)
+	case *gpspipe:
+		return describeCmd(dp.Name(), dp.cmdLine, dp.sched)
+	case *dpll.DpllConfig:
+		return fmt.Sprintf("%s: iface %s depends on %v, maxInSpecOffset %d, localMaxHoldoverOffSet %d, localHoldoverTimeout %d",
+			dp.Name(), dp.Iface(), dp.DependsOn(), dp.MaxInSpecOffset, dp.LocalMaxHoldoverOffSet, dp.LocalHoldoverTimeout)
+	}
+	return d.Name()
+}
+
+func describeCmd(name, cmdLine string, sched schedAttr) string {
+	if sched.isSet() {
+		return fmt.Sprintf("%s: %s (scheduling %s)", name, cmdLine, sched)
+	}
+	return fmt.Sprintf("%s: %s", name, cmdLine)
+}
+
+// String formats the rendered profile for review
+func (r RenderedProfile) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# profile %s (runID %d)\n", r.Name, r.RunID)
+	for _, w := range r.Warnings {
+		fmt.Fprintf(&b, "warning: %s\n", w.Error())
+	}
+	for _, p := range r.Processes {
+		fmt.Fprintf(&b, "\n## %s\n", p.Name)
+		for _, d := range p.Dependents {
+			fmt.Fprintf(&b, "dependent: %s\n", d)
+		}
+		fmt.Fprintf(&b, "command: %s\n", p.CmdLine)
+		if p.Scheduling != "" {
+			fmt.Fprintf(&b, "scheduling: %s\n", p.Scheduling)
+		}
+		fmt.Fprintf(&b, "config: %s\n", p.ConfigPath)
+		b.WriteString(p.Config)
+		if !strings.HasSuffix(p.Config, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
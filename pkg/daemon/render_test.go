@@ -0,0 +1,110 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+)
+
+func Test_RenderProfiles(t *testing.T) {
+	profiles := `[{"name":"gm","interface":"ens1f0","ptp4lOpts":"-2","ptp4lConf":"[global]\ndomainNumber 24\n",
+		"ts2phcOpts":" ","ts2phcConf":"[nmea]\nts2phc.master 1\n[global]\nts2phc.nmea_serialport /dev/gnss0\n[ens1f0]\nts2phc.extts_polarity rising\n",
+		"ptpSchedulingPolicy":"SCHED_FIFO","ptpSchedulingPriority":10,
+		"ptpSettings":{"ptp4l.schedPolicy":"SCHED_RR","ptp4l.schedPriority":"20","ptp4l.cpus":"2-3","gpspipe.nice":"5"}}]`
+	rendered, err := RenderProfiles([]byte(profiles), false)
+	assert.NoError(t, err)
+	assert.Len(t, rendered, 1)
+	assert.Len(t, rendered[0].Processes, 2)
+
+	ts2phc := rendered[0].Processes[0]
+	assert.Equal(t, ts2phcProcessName, ts2phc.Name)
+	assert.True(t, strings.HasPrefix(ts2phc.CmdLine, "/usr/sbin/ts2phc -f "+configPrefix+"/ts2phc.0.config"))
+	assert.Equal(t, "SCHED_FIFO 10", ts2phc.Scheduling)
+	assert.Contains(t, ts2phc.CmdLine, "--ts2phc.holdover")
+	assert.Contains(t, ts2phc.Config, "ts2phc.nmea_serialport "+GPSPIPE_SERIALPORT)
+	assert.Len(t, ts2phc.Dependents, 3)
+	assert.Equal(t, "gpsd: /usr/local/sbin/gpsd -p -n -S 2947 -G -N /dev/gnss0 (scheduling SCHED_FIFO 10)", ts2phc.Dependents[0])
+	assert.Contains(t, ts2phc.Dependents[1], "(scheduling SCHED_FIFO 10 nice 5)")
+
+	ptp4l := rendered[0].Processes[1]
+	assert.Equal(t, configPrefix+"/ptp4l.0.config", ptp4l.ConfigPath)
+	assert.Contains(t, ptp4l.Config, "message_tag [ptp4l.0.config:{level}]")
+	assert.Contains(t, ptp4l.Config, "uds_address "+configPrefix+"/ptp4l.0.socket")
+	assert.Equal(t, "SCHED_RR 20 cpus [2 3]", ptp4l.Scheduling)
+
+	// rendering is stable
+	again, err := RenderProfiles([]byte(profiles), false)
+	assert.NoError(t, err)
+	assert.Equal(t, rendered, again)
+
+	_, err = RenderProfiles([]byte("not a profile"), false)
+	assert.Error(t, err)
+}
+
+func Test_RenderProfiles_multipleTs2phc(t *testing.T) {
+	profiles := `[{"name":"gm1","ptp4lOpts":"-2","ptp4lConf":"[ens1f0]\nmasterOnly 1\n[global]\n",
+		"ts2phcOpts":" ","ts2phcConf":"[nmea]\nts2phc.master 1\n[global]\n[ens1f0]\nts2phc.extts_polarity rising\n",
+		"phc2sysOpts":"-a -r"},
+		{"name":"gm2","ptp4lOpts":"-2","ptp4lConf":"[ens2f0]\nmasterOnly 1\n[global]\n",
+		"ts2phcOpts":"-s generic","ts2phcConf":"[global]\n[ens2f0]\nts2phc.master 0\nts2phc.extts_polarity rising\n",
+		"phc2sysOpts":"-s ens1f0 -c ens2f0"}]`
+	rendered, err := RenderProfiles([]byte(profiles), false)
+	assert.NoError(t, err)
+	assert.Len(t, rendered, 2)
+	for runID, r := range rendered {
+		assert.Equal(t, runID, r.RunID)
+		var names []string
+		for _, p := range r.Processes {
+			names = append(names, p.Name)
+			assert.Contains(t, p.ConfigPath, fmt.Sprintf(".%d.config", runID))
+		}
+		assert.Equal(t, []string{ts2phcProcessName, ptp4lProcessName, phc2sysProcessName}, names)
+	}
+	// only the ts2phc reading NMEA runs gpsd and gpspipe, both run a DPLL
+	assert.Len(t, rendered[0].Processes[0].Dependents, 3)
+	assert.Len(t, rendered[1].Processes[0].Dependents, 1)
+
+	// a single GNSS receiver is served per node
+	conflict := strings.Replace(profiles, `"-s generic"`, `"-s nmea"`, 1)
+	_, err = RenderProfiles([]byte(conflict), false)
+	assert.ErrorContains(t, err, string(ValidationGnssConflict))
+
+	ifaces := config.IFaces{{Name: "ens1f0", Source: event.GNSS}, {Name: "ens2f0", Source: event.PPS}, {Name: "ens3f0", Source: event.GNSS}}
+	assert.Equal(t, config.IFaces{ifaces[0], ifaces[2]}, ifaces.GetGMInterfaces())
+	assert.Equal(t, ifaces[0], ifaces.GetGMInterface())
+}
+
+func Test_renderGMStateMachine(t *testing.T) {
+	dn := testDaemon()
+	dn.dryRun = true
+	dn.processManager.ptpEventHandler = &event.EventHandler{}
+	profile := ptpv1.PtpProfile{
+		Name:        pointer.String("gm"),
+		Ts2PhcOpts:  pointer.String(" "),
+		Ts2PhcConf:  pointer.String("[nmea]\nts2phc.master 1\n[global]\n[ens1f0]\nts2phc.extts_polarity rising\n"),
+		PtpSettings: map[string]string{GMStateMachineSetting: event.StrictHoldoverGMStateMachine},
+	}
+	assert.NoError(t, dn.applyNodePtpProfile(0, &profile))
+	assert.Equal(t, &event.EventHandler{}, dn.processManager.ptpEventHandler, "rendering registers no GM state machine")
+}
+
+func Test_renderLeapManager(t *testing.T) {
+	assert.NoError(t, leap.MockLeapFile())
+	defer close(leap.LeapMgr.Close)
+	dn := testDaemon()
+	dn.dryRun = true
+	profile := ptpv1.PtpProfile{
+		Name:       pointer.String("gm"),
+		Ts2PhcOpts: pointer.String(" "),
+		Ts2PhcConf: pointer.String("[nmea]\nts2phc.master 1\n[global]\n[ens1f0]\nts2phc.extts_polarity rising\n"),
+	}
+	assert.NoError(t, dn.applyNodePtpProfile(0, &profile))
+	assert.Empty(t, leap.LeapMgr.Ptp4lConfigPaths(), "rendering sends no leap announcement")
+}
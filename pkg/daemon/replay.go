@@ -0,0 +1,300 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/capture"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/protocol"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// replayTimeout bounds how long the event loop has to process the events of a replayed record
+const replayTimeout = 10 * time.Second
+
+// SetCapture records the output of the processes started from now on, what the DPLL, GNSS and chronyd
+// monitors read, and the events the replay does not recreate, to recorder
+func (dn *Daemon) SetCapture(recorder *capture.Recorder) {
+	dn.capture = recorder
+	if h := dn.processManager.ptpEventHandler; h != nil {
+		h.SetTap(func(e event.EventChannel) {
+			if !replayed(e) {
+				recorder.Event(e)
+			}
+		})
+	}
+}
+
+// replayed returns whether Replay recreates e from the process output and what the monitors read, those events are not recorded
+func replayed(e event.EventChannel) bool {
+	if e.Reset {
+		return false
+	}
+	switch e.ProcessName {
+	case event.TS2PHC, event.SYNCE, event.GNSS, event.DPLL, event.CHRONY:
+		return true
+	}
+	return false
+}
+
+// recordStart records the state the output of p is parsed with
+func (p *ptpProcess) recordStart(stdoutToSocket bool) {
+	if p.capture == nil {
+		return
+	}
+	p.capture.Started(p.name, p.configName, capture.Start{
+		MessageTag: p.messageTag,
+		Ifaces:     p.ifaces,
+		ClockType:  p.clockType,
+		Threshold:  p.ptpClockThreshold,
+		SyncE:      p.syncERelations,
+		Socket:     stdoutToSocket,
+	})
+}
+
+// ReplayOptions tunes Replay
+type ReplayOptions struct {
+	NodeName string
+	// Speed replays the records at Speed times the recorded pace, as fast as possible when 0
+	Speed float64
+	// Echo writes the replayed lines with the time they were recorded at
+	Echo bool
+}
+
+// replayProcess is a process of a capture, socket is set when its output was sent to the event socket
+type replayProcess struct {
+	*ptpProcess
+	socket bool
+}
+
+// Replay feeds the records of a capture to the parsers and state machines of the processes and monitors that recorded
+// them: the process output to processPTPMetrics, the ubxtool output to the GNSS state, the DPLL inputs to the DPLL state
+// decision and chronyc tracking to the chronyd state. The events they send go through the event handler, which prints them
+// as the daemon does, and the resulting metrics are written to out. The clock class is not read from or written to ptp4l,
+// it is kept in memory.
+func Replay(in io.Reader, out io.Writer, opts ReplayOptions) error {
+	RegisterMetrics(opts.NodeName)
+	InitializeOffsetMaps()
+	event.PMCGMGetter, event.PMCGMSetter = replayPMC()
+	eventQueue := event.NewQueue(opts.NodeName, event.DefaultQueueCapacity, EventQueueDepth, EventQueueDropped, EventQueueCoalesced)
+	closeCh := make(chan bool)
+	handler := event.Init(opts.NodeName, false, "", eventQueue, closeCh, Offset, ClockState, ClockClassMetrics)
+	go handler.ProcessEvents()
+	defer close(closeCh)
+
+	processes := map[string]*replayProcess{}
+	process := func(rec capture.Record) *replayProcess {
+		p, found := processes[rec.Key()]
+		if !found {
+			p = newReplayProcess(rec, eventQueue)
+			processes[rec.Key()] = p
+		}
+		return p
+	}
+	monitors := map[string]*replayGNSS{}
+	gnss := func(rec capture.Record) *replayGNSS {
+		g, found := monitors[rec.Key()]
+		if !found {
+			g = newReplayGNSS(rec, eventQueue)
+			monitors[rec.Key()] = g
+		}
+		return g
+	}
+	dplls := map[string]*dpll.DpllConfig{}
+	reader := capture.NewReader(in)
+	last := -1.0
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if opts.Speed > 0 && last >= 0 && rec.Mono > last {
+			time.Sleep(time.Duration((rec.Mono - last) / opts.Speed * float64(time.Second)))
+		}
+		last = rec.Mono
+
+		switch rec.Kind {
+		case capture.KindStart:
+			switch rec.Process {
+			case string(event.GNSS):
+				monitors[rec.Key()] = newReplayGNSS(rec, eventQueue)
+			case string(event.DPLL):
+				dplls[rec.Key()] = newReplayDpll(rec, eventQueue)
+			default:
+				processes[rec.Key()] = newReplayProcess(rec, eventQueue)
+			}
+		case capture.KindLine:
+			if opts.Echo {
+				fmt.Fprintf(out, "%s %s\n", rec.Wall.Format(time.RFC3339Nano), rec.Line)
+			}
+			switch rec.Process {
+			case string(event.GNSS):
+				gnss(rec).poll.parse(rec.Line)
+			case chronycName:
+				chronyd := rec
+				chronyd.Process = chronydProcessName
+				process(chronyd).replayChronyc(rec.Line)
+			default:
+				process(rec).replay(rec.Line)
+			}
+		case capture.KindTick:
+			g := gnss(rec)
+			g.decide(g.poll)
+			g.poll.timeLs = nil
+		case capture.KindDpll:
+			d, found := dplls[rec.Key()]
+			if !found {
+				d = newReplayDpll(rec, eventQueue)
+				dplls[rec.Key()] = d
+			}
+			if rec.Dpll != nil {
+				d.Replay(*rec.Dpll)
+			}
+		case capture.KindEvent:
+			if rec.Event != nil {
+				eventQueue.Push(rec.Event.EventChannel())
+			}
+		}
+		if err = handler.Drain(replayTimeout); err != nil {
+			return err
+		}
+	}
+	return writeMetrics(out)
+}
+
+// newReplayProcess creates the process of a record, from its Start when it has one
+func newReplayProcess(rec capture.Record, eventQueue *event.Queue) *replayProcess {
+	p := &replayProcess{ptpProcess: &ptpProcess{
+		name:              rec.Process,
+		configName:        rec.Config,
+		messageTag:        fmt.Sprintf("[%s:{level}]", rec.Config),
+		eventQueue:        eventQueue,
+		ptpClockThreshold: getPTPThreshold(&ptpv1.PtpProfile{}),
+	}}
+	if s := rec.Start; s != nil {
+		p.messageTag, p.ifaces, p.clockType, p.syncERelations, p.socket = s.MessageTag, s.Ifaces, s.ClockType, s.SyncE, s.Socket
+		if s.Threshold != nil {
+			p.ptpClockThreshold = s.Threshold
+		}
+	}
+	return p
+}
+
+// replay parses a line of output as cmdRun does, the clock class updates and HA announces that need pmc are left out
+func (p *replayProcess) replay(output string) {
+	if p.socket {
+		output = fmt.Sprintf("%s\n", p.replaceClockID(output))
+	}
+	p.processPTPMetrics(output)
+}
+
+// replayChronyc parses chronyc tracking output as trackChronyd does
+func (p *replayProcess) replayChronyc(output string) {
+	t, err := parseChronyTracking(output)
+	if err != nil {
+		glog.V(2).Infof("%s: %s", p.configName, err)
+		return
+	}
+	p.processChronyTracking(t)
+}
+
+// replayGNSS is a GNSS monitor of a capture, poll holds the ubxtool output replayed since the last tick
+type replayGNSS struct {
+	*GPSD
+	poll *ubxPoll
+}
+
+// newReplayGNSS creates the GNSS monitor of a record, from its Start when it has one
+func newReplayGNSS(rec capture.Record, eventQueue *event.Queue) *replayGNSS {
+	threshold := getPTPThreshold(&ptpv1.PtpProfile{})
+	g := &GPSD{
+		name:        GPSD_PROCESSNAME,
+		gmInterface: rec.Iface,
+		messageTag:  fmt.Sprintf("[%s:{level}]", rec.Config),
+		processConfig: config.ProcessConfig{
+			ConfigName:      rec.Config,
+			EventQueue:      eventQueue,
+			InitialPTPState: event.PTP_FREERUN,
+		},
+	}
+	if s := rec.Start; s != nil {
+		g.messageTag, g.processConfig.ClockType = s.MessageTag, s.ClockType
+		if s.Threshold != nil {
+			threshold = s.Threshold
+		}
+	}
+	g.processConfig.GMThreshold = config.Threshold{
+		Max:             threshold.MaxOffsetThreshold,
+		Min:             threshold.MinOffsetThreshold,
+		HoldOverTimeout: threshold.HoldOverTimeout,
+	}
+	return &replayGNSS{GPSD: g, poll: newUbxPoll()}
+}
+
+// newReplayDpll creates the DPLL of a record, from its Start when it has one, with the default settings otherwise
+func newReplayDpll(rec capture.Record, eventQueue *event.Queue) *dpll.DpllConfig {
+	threshold := getPTPThreshold(&ptpv1.PtpProfile{})
+	settings := dpll.Settings{
+		LocalMaxHoldoverOffSet: dpll.LocalMaxHoldoverOffSet,
+		LocalHoldoverTimeout:   dpll.LocalHoldoverTimeout,
+		MaxInSpecOffset:        dpll.MaxInSpecOffset,
+		DependsOn:              []event.EventSource{event.GNSS},
+		APIType:                string(dpll.NONE),
+		GMThreshold: config.Threshold{
+			Max:             threshold.MaxOffsetThreshold,
+			Min:             threshold.MinOffsetThreshold,
+			HoldOverTimeout: threshold.HoldOverTimeout,
+		},
+	}
+	if rec.Start != nil && rec.Start.Dpll != nil {
+		settings = *rec.Start.Dpll
+	}
+	return dpll.NewReplayDpll(rec.Config, rec.Iface, settings, eventQueue)
+}
+
+// replayPMC returns the PMC functions of the event handler for Replay, the settings are kept in memory
+func replayPMC() (func(string) (protocol.GrandmasterSettings, error), func(string, protocol.GrandmasterSettings) error) {
+	var lock sync.Mutex
+	settings := map[string]protocol.GrandmasterSettings{}
+	get := func(cfgName string) (protocol.GrandmasterSettings, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		return settings[cfgName], nil
+	}
+	set := func(cfgName string, g protocol.GrandmasterSettings) error {
+		lock.Lock()
+		defer lock.Unlock()
+		settings[cfgName] = g
+		return nil
+	}
+	return get, set
+}
+
+// writeMetrics writes the daemon metrics in the Prometheus text format
+func writeMetrics(out io.Writer) error {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return err
+	}
+	for _, f := range families {
+		if !strings.HasPrefix(f.GetName(), PTPNamespace+"_"+PTPSubsystem+"_") {
+			continue
+		}
+		if _, err = expfmt.MetricFamilyToText(out, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
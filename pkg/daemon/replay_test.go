@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/capture"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Replay(t *testing.T) {
+	assert.NoError(t, leap.MockLeapFile())
+	defer close(leap.LeapMgr.Close)
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	recorder, err := capture.NewRecorder(path, 0, 0)
+	assert.NoError(t, err)
+	dn := testDaemon()
+	dn.processManager.ptpEventHandler = &event.EventHandler{}
+	dn.SetCapture(recorder)
+	p := &ptpProcess{name: ts2phcProcessName, configName: "ts2phc.0.config", messageTag: "[ts2phc.0.config:{level}]",
+		ifaces: config.IFaces{{Name: "ens1f0", Source: event.GNSS, PhcId: "/dev/ptp0"}}, clockType: event.GM,
+		ptpClockThreshold: getPTPThreshold(&ptpv1.PtpProfile{}), capture: recorder}
+	p.recordStart(false)
+	// the GNSS and DPLL monitors record what they read, the replay decides their state from it
+	gnss := &GPSD{gmInterface: "ens1f0", messageTag: p.messageTag, capture: recorder}
+	gnss.MonitorProcess(config.ProcessConfig{ClockType: event.GM, ConfigName: p.configName,
+		GMThreshold: config.Threshold{Max: 100, Min: -100}})
+	for _, line := range []string{"UBX-NAV-STATUS:", "  iTOW 223968000 gpsFix 3 flags 0xdd fixStat 0x0 flags2 0x8",
+		"UBX-NAV-CLOCK:", "  iTOW 223968000 clkB 61524 clkD 2 tAcc 4 fAcc 120"} {
+		recorder.MonitorLine(string(event.GNSS), p.configName, gnss.gmInterface, line)
+	}
+	recorder.Tick(string(event.GNSS), p.configName, gnss.gmInterface)
+	recorder.DpllStarted(p.configName, "ens1f0", dpll.Settings{LocalMaxHoldoverOffSet: dpll.LocalMaxHoldoverOffSet,
+		LocalHoldoverTimeout: dpll.LocalHoldoverTimeout, MaxInSpecOffset: dpll.MaxInSpecOffset,
+		DependsOn: []event.EventSource{event.GNSS}, ClockType: event.GM, GMThreshold: config.Threshold{Max: 100, Min: -100}})
+	recorder.DpllInput(p.configName, "ens1f0", dpll.Input{Status: &dpll.Status{PhaseStatus: dpll.DPLL_LOCKED_HO_ACQ,
+		FrequencyStatus: dpll.DPLL_LOCKED_HO_ACQ, PhaseOffset: 0}})
+	recorder.DpllInput(p.configName, "ens1f0", dpll.Input{Source: event.GNSS, State: event.PTP_LOCKED})
+	assert.True(t, replayed(event.EventChannel{ProcessName: event.DPLL, State: event.PTP_LOCKED}))
+	assert.False(t, replayed(event.EventChannel{ProcessName: event.DPLL, Reset: true}), "the resets are recorded")
+	// events derived from the output are recreated by the replay
+	recorder.Event(event.EventChannel{ProcessName: event.TS2PHC, CfgName: "ts2phc.0.config"})
+	for i := 0; i < 3; i++ {
+		p.capture.Line(p.name, p.configName, "ts2phc[1.0]: [ts2phc.0.config:6] ens1f0 master offset          1 s2 freq      -0")
+	}
+	assert.NoError(t, recorder.Close())
+
+	in, err := os.Open(path)
+	assert.NoError(t, err)
+	defer in.Close()
+	var out strings.Builder
+	assert.NoError(t, Replay(in, &out, ReplayOptions{NodeName: "replay", Echo: true}))
+	assert.Contains(t, out.String(), "ens1f0 master offset          1 s2")
+	assert.Contains(t, out.String(), `openshift_ptp_offset_ns{from="master",iface="ens1fx",node="replay",process="ts2phc"} 1`)
+	assert.Contains(t, out.String(), `openshift_ptp_clock_class{node="replay",process="ptp4l"} 6`)
+	assert.Contains(t, out.String(), `openshift_ptp_offset_ns{from="gnss",iface="ens1fx",node="replay",process="gnss"} 4`)
+	assert.Contains(t, out.String(), `openshift_ptp_clock_state{iface="ens1fx",node="replay",process="dpll"} 1`)
+}
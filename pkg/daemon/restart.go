@@ -0,0 +1,282 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PtpSettings keys to tune how the processes of a profile are restarted when they exit
+const (
+	RestartBackoffInitialSetting = "restartBackoffInitial" // delay before the first restart, i.e. "1s"
+	RestartBackoffMaxSetting     = "restartBackoffMax"     // the delay doubles on every restart up to this value, i.e. "2m"
+	RestartMaxRestartsSetting    = "restartMaxRestarts"    // restarts allowed within restartWindow before the process is crash looping
+	RestartWindowSetting         = "restartWindow"         // i.e. "5m"
+)
+
+const (
+	defaultRestartBackoffInitial = 1 * time.Second
+	defaultRestartBackoffMax     = 2 * time.Minute
+	defaultRestartMaxRestarts    = 5
+	defaultRestartWindow         = 5 * time.Minute
+)
+
+// restartPolicy defines the exponential backoff applied between restarts of a process
+type restartPolicy struct {
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	maxRestarts    int
+	window         time.Duration
+}
+
+// getRestartPolicy reads the restart policy from the profile PtpSettings, using the defaults for anything not set or invalid
+func getRestartPolicy(nodeProfile *ptpv1.PtpProfile) restartPolicy {
+	policy := restartPolicy{
+		initialBackoff: defaultRestartBackoffInitial,
+		maxBackoff:     defaultRestartBackoffMax,
+		maxRestarts:    defaultRestartMaxRestarts,
+		window:         defaultRestartWindow,
+	}
+	if nodeProfile == nil {
+		return policy
+	}
+	durations := map[string]*time.Duration{
+		RestartBackoffInitialSetting: &policy.initialBackoff,
+		RestartBackoffMaxSetting:     &policy.maxBackoff,
+		RestartWindowSetting:         &policy.window,
+	}
+	for key, value := range durations {
+		if s, ok := nodeProfile.PtpSettings[key]; ok {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				glog.Errorf("invalid %s %q, using default %s", key, s, *value)
+				continue
+			}
+			*value = d
+		}
+	}
+	if s, ok := nodeProfile.PtpSettings[RestartMaxRestartsSetting]; ok {
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			policy.maxRestarts = n
+		} else {
+			glog.Errorf("invalid %s %q, using default %d", RestartMaxRestartsSetting, s, policy.maxRestarts)
+		}
+	}
+	if policy.maxBackoff < policy.initialBackoff {
+		policy.maxBackoff = policy.initialBackoff
+	}
+	return policy
+}
+
+// restartTracker keeps the restart history of a process and decides how long to wait before the next restart.
+// A process that keeps running for longer than the max backoff is considered healthy again: its backoff is reset
+// and it is no longer crash looping.
+type restartTracker struct {
+	sync.Mutex
+	policy       restartPolicy
+	backoff      time.Duration
+	restarts     []time.Time
+	startedAt    time.Time
+	pid          int // pid of the running process, 0 when it is not running
+	crashLooping bool
+	lastExit     string
+	lastExitCode int
+	stopCh       chan struct{}
+	stopOnce     sync.Once
+}
+
+func newRestartTracker(policy restartPolicy) *restartTracker {
+	return &restartTracker{
+		policy: policy,
+		stopCh: make(chan struct{}),
+	}
+}
+
+// started records the time the process was (re)started and its pid
+func (r *restartTracker) started(pid int) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	r.startedAt = time.Now()
+	r.pid = pid
+	r.Unlock()
+}
+
+// exited records the exit status of the process and returns the delay before it is restarted
+// and whether it is now crash looping
+func (r *restartTracker) exited(state *os.ProcessState) (time.Duration, bool) {
+	if r == nil {
+		return connectionRetryInterval, false
+	}
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	r.pid = 0
+	r.lastExit, r.lastExitCode = exitReason(state)
+
+	if !r.startedAt.IsZero() && now.Sub(r.startedAt) >= r.policy.maxBackoff {
+		r.backoff = 0
+	}
+	r.restarts = append(r.restarts, now)
+	for len(r.restarts) > 0 && now.Sub(r.restarts[0]) > r.policy.window {
+		r.restarts = r.restarts[1:]
+	}
+
+	if r.backoff == 0 {
+		r.backoff = r.policy.initialBackoff
+	} else {
+		r.backoff = min(2*r.backoff, r.policy.maxBackoff)
+	}
+	r.crashLooping = len(r.restarts) > r.policy.maxRestarts
+	return r.backoff, r.crashLooping
+}
+
+// wait sleeps for d, it returns false when interrupted by stop
+func (r *restartTracker) wait(d time.Duration) bool {
+	if r == nil {
+		time.Sleep(d)
+		return true
+	}
+	select {
+	case <-time.After(d):
+		return true
+	case <-r.stopCh:
+		return false
+	}
+}
+
+// stop interrupts any pending wait, no more restarts are expected after it
+func (r *restartTracker) stop() {
+	if r == nil {
+		return
+	}
+	r.stopOnce.Do(func() { close(r.stopCh) })
+}
+
+// runningStatus returns the status of the (re)started process, PtpProcessCrashLoop until it recovers
+func (r *restartTracker) runningStatus() int64 {
+	if r.CrashLooping() {
+		return PtpProcessCrashLoop
+	}
+	return PtpProcessUp
+}
+
+// recovered waits for the max backoff and returns true when the crash looping process started with pid
+// kept running meanwhile, it is then no longer crash looping
+func (r *restartTracker) recovered(pid int) bool {
+	if r == nil || !r.CrashLooping() {
+		return false
+	}
+	if !r.wait(r.policy.maxBackoff) {
+		return false
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.pid != pid || !r.crashLooping {
+		return false
+	}
+	r.crashLooping = false
+	return true
+}
+
+// CrashLooping returns true when the process restarted more than the policy allows within its window
+func (r *restartTracker) CrashLooping() bool {
+	if r == nil {
+		return false
+	}
+	r.Lock()
+	defer r.Unlock()
+	return r.crashLooping
+}
+
+// LastExit returns the last exit reason and exit code of the process
+func (r *restartTracker) LastExit() (string, int) {
+	if r == nil {
+		return "", 0
+	}
+	r.Lock()
+	defer r.Unlock()
+	return r.lastExit, r.lastExitCode
+}
+
+// Running returns the pid of the running process and when it started, 0 when it is not running
+func (r *restartTracker) Running() (int, time.Time) {
+	if r == nil {
+		return 0, time.Time{}
+	}
+	r.Lock()
+	defer r.Unlock()
+	return r.pid, r.startedAt
+}
+
+// Restarts returns the number of restarts within the policy window
+func (r *restartTracker) Restarts() int {
+	if r == nil {
+		return 0
+	}
+	r.Lock()
+	defer r.Unlock()
+	return len(r.restarts)
+}
+
+// exitReason returns a printable exit reason and an exit code, following the shell convention
+// of 128 + signal number for processes killed by a signal
+func exitReason(state *os.ProcessState) (string, int) {
+	if state == nil {
+		return "failed to start", -1
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return fmt.Sprintf("signal: %s", ws.Signal()), 128 + int(ws.Signal())
+	}
+	return fmt.Sprintf("exit status %d", state.ExitCode()), state.ExitCode()
+}
+
+// reportProcessExit reports a process exit through the process status, crash looping processes
+// are reported as PtpProcessCrashLoop instead of PtpProcessDown
+func reportProcessExit(c *net.Conn, processName, messageTag string, r *restartTracker, crashLooping bool) {
+	status := PtpProcessDown
+	if crashLooping {
+		status = PtpProcessCrashLoop
+	}
+	exit, code := r.LastExit()
+	if crashLooping {
+		glog.Errorf("%s is crash looping: %d restarts within %s, last exit: %s", processName, r.Restarts(), r.policy.window, exit)
+	} else {
+		glog.Infof("%s exited: %s", processName, exit)
+	}
+	processStatus(c, processName, messageTag, status)
+	if c == nil {
+		UpdateProcessExitMetrics(processName, configNameFromMessageTag(messageTag), code)
+	}
+}
+
+// reportProcessStart reports a (re)started process through the process status, a crash looping process
+// is reported as PtpProcessCrashLoop until it recovers, see restartTracker.recovered
+func reportProcessStart(c *net.Conn, processName, messageTag string, r *restartTracker) {
+	status := r.runningStatus()
+	processStatus(c, processName, messageTag, status)
+	if c == nil && status == PtpProcessCrashLoop {
+		UpdateProcessRestartMetrics(processName, configNameFromMessageTag(messageTag))
+	}
+}
+
+// reportProcessRecovery reports a process that is no longer crash looping as PtpProcessUp, see restartTracker.recovered
+func reportProcessRecovery(c *net.Conn, processName, messageTag string, r *restartTracker) {
+	glog.Infof("%s kept running for %s, it is no longer crash looping", processName, r.policy.maxBackoff)
+	if c == nil {
+		// the process did not restart, only its status changes
+		ProcessStatus.With(prometheus.Labels{
+			"process": processName, "node": NodeName, "config": configNameFromMessageTag(messageTag)}).Set(float64(PtpProcessUp))
+		return
+	}
+	processStatus(c, processName, messageTag, PtpProcessUp)
+}
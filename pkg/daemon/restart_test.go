@@ -0,0 +1,95 @@
+package daemon
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_restartTracker(t *testing.T) {
+	policy := getRestartPolicy(&ptpv1.PtpProfile{PtpSettings: map[string]string{
+		RestartBackoffInitialSetting: "10ms",
+		RestartBackoffMaxSetting:     "40ms",
+		RestartMaxRestartsSetting:    "3",
+		RestartWindowSetting:         "bad",
+	}})
+	assert.Equal(t, restartPolicy{initialBackoff: 10 * time.Millisecond, maxBackoff: 40 * time.Millisecond,
+		maxRestarts: 3, window: defaultRestartWindow}, policy)
+
+	r := newRestartTracker(policy)
+	expected := []time.Duration{10, 20, 40, 40}
+	for i, d := range expected {
+		r.started(1)
+		delay, crashLooping := r.exited(nil)
+		assert.Equal(t, d*time.Millisecond, delay)
+		assert.Equal(t, i == len(expected)-1, crashLooping)
+	}
+	exit, code := r.LastExit()
+	assert.Equal(t, "failed to start", exit)
+	assert.Equal(t, -1, code)
+
+	// a process running for longer than the max backoff starts over from the initial backoff
+	r.started(1)
+	pid, _ := r.Running()
+	assert.Equal(t, 1, pid)
+	time.Sleep(policy.maxBackoff)
+	delay, _ := r.exited(nil)
+	assert.Equal(t, policy.initialBackoff, delay)
+	pid, _ = r.Running()
+	assert.Zero(t, pid)
+
+	cmd := exec.Command("sh", "-c", "exit 3")
+	_ = cmd.Run()
+	exit, code = exitReason(cmd.ProcessState)
+	assert.Equal(t, "exit status 3", exit)
+	assert.Equal(t, 3, code)
+
+	// stop interrupts a pending wait
+	r.stop()
+	assert.False(t, r.wait(time.Minute))
+}
+
+func Test_crashLoopStatus(t *testing.T) {
+	r := newRestartTracker(restartPolicy{initialBackoff: time.Millisecond, maxBackoff: 50 * time.Millisecond, maxRestarts: 1, window: time.Minute})
+	const messageTag = "[ptp4l.9.config:{level}]"
+	labels := prometheus.Labels{"process": ptp4lProcessName, "node": NodeName, "config": "ptp4l.9.config"}
+	status := func() int64 { return int64(testutil.ToFloat64(ProcessStatus.With(labels))) }
+	restarts := func() float64 { return testutil.ToFloat64(ProcessRestartCount.With(labels)) }
+
+	// restarting rapidly: the process stays crash looping when it is started again
+	for pid, want := range []int64{PtpProcessUp, PtpProcessUp, PtpProcessCrashLoop, PtpProcessCrashLoop} {
+		if pid > 0 {
+			_, crashLooping := r.exited(nil)
+			reportProcessExit(nil, ptp4lProcessName, messageTag, r, crashLooping)
+		}
+		r.started(pid + 1)
+		reportProcessStart(nil, ptp4lProcessName, messageTag, r)
+		assert.Equal(t, want, status(), "start %d", pid+1)
+	}
+	assert.Equal(t, float64(4), restarts())
+
+	// a process exiting before the max backoff did not recover
+	recovered := make(chan bool)
+	go func() { recovered <- r.recovered(4) }()
+	time.Sleep(10 * time.Millisecond)
+	_, crashLooping := r.exited(nil)
+	assert.True(t, crashLooping)
+	assert.False(t, <-recovered)
+
+	// it recovers once it kept running for the max backoff
+	r.started(5)
+	reportProcessStart(nil, ptp4lProcessName, messageTag, r)
+	assert.Equal(t, PtpProcessCrashLoop, status())
+	if assert.True(t, r.recovered(5)) {
+		reportProcessRecovery(nil, ptp4lProcessName, messageTag, r)
+	}
+	assert.Equal(t, PtpProcessUp, status())
+	assert.False(t, r.CrashLooping())
+	assert.Equal(t, float64(5), restarts(), "a recovery is not a restart")
+	assert.False(t, r.recovered(5), "only crash looping processes recover")
+}
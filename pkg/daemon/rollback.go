@@ -0,0 +1,181 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// rollbackCheckInterval is the interval between two health checks of newly applied profiles
+const rollbackCheckInterval = 5 * time.Second
+
+// ProfileRollbackEventReason is the reason of the Kubernetes event emitted on a rollback
+const ProfileRollbackEventReason = "ProfileRollback"
+
+// rollbackReason is why newly applied profiles were rolled back, reported in the profile_rollback_count metric
+type rollbackReason string
+
+const (
+	rollbackCrashLoop   rollbackReason = "crashloop"   // a process of the profiles crash loops
+	rollbackNotLocked   rollbackReason = "notlocked"   // a process did not lock within the rollback window
+	rollbackApplyFailed rollbackReason = "applyfailed" // the profiles failed to apply
+)
+
+// errProfilesRejected is returned when the profiles fail validation, nothing was stopped or started
+var errProfilesRejected = errors.New("profiles rejected, running processes are unchanged")
+
+// rollbackState tracks the profiles to fall back to while newly applied ones prove healthy
+type rollbackState struct {
+	// window is how long new profiles have to lock before they are rolled back, 0 disables rollbacks
+	window time.Duration
+	// goodJson are the last profiles whose processes all locked
+	goodJson []byte
+	// pendingJson are the profiles being watched until deadline, deadline is zero when nothing is watched
+	pendingJson []byte
+	deadline    time.Time
+	// pendingRunIDs are the runIDs of the profiles the last apply (re)started, by profile name,
+	// the processes of the profiles it left running are not watched
+	pendingRunIDs map[string]int
+}
+
+// SetRollbackWindow sets how long newly applied profiles have to lock before the daemon
+// rolls back to the last known good profiles, 0 disables rollbacks
+func (dn *Daemon) SetRollbackWindow(window time.Duration) {
+	dn.rollback.window = window
+}
+
+// watchAppliedProfiles starts watching the profiles just applied, appliedJson and applyErr are what
+// applyNodePTPProfiles returned
+func (dn *Daemon) watchAppliedProfiles(appliedJson []byte, applyErr error) {
+	if dn.rollback.window <= 0 || errors.Is(applyErr, errProfilesRejected) {
+		return
+	}
+	dn.rollback.pendingJson = appliedJson
+	if applyErr != nil {
+		dn.rollbackProfiles(rollbackApplyFailed)
+		return
+	}
+	dn.rollback.deadline = time.Now().Add(dn.rollback.window)
+	glog.Infof("watching the applied profiles for %s before keeping them as last known good", dn.rollback.window)
+}
+
+// checkAppliedProfiles keeps the watched profiles once all their processes locked,
+// and rolls them back when a process crash loops or the window expires
+func (dn *Daemon) checkAppliedProfiles() {
+	if dn.rollback.deadline.IsZero() {
+		return
+	}
+	reason, healthy := dn.processManager.health(dn.rollback.pendingRunIDs)
+	switch {
+	case healthy:
+		glog.Infof("all processes of the applied profiles locked, keeping them as last known good")
+		dn.rollback.goodJson = dn.rollback.pendingJson
+		dn.rollback.deadline = time.Time{}
+	case reason == rollbackCrashLoop || time.Now().After(dn.rollback.deadline):
+		dn.rollbackProfiles(reason)
+	}
+}
+
+// health returns whether no process of the profiles of runIDs crash loops and every one of them that reports
+// a clock state locked at least once, otherwise it returns why not
+func (p *ProcessManager) health(runIDs map[string]int) (rollbackReason, bool) {
+	reason := rollbackReason("")
+	for _, proc := range p.process {
+		if proc == nil || proc.nodeProfile.Name == nil {
+			continue
+		}
+		if _, ok := runIDs[*proc.nodeProfile.Name]; !ok {
+			continue
+		}
+		if proc.restart.CrashLooping() {
+			glog.Errorf("%s (%s) is crash looping", proc.name, proc.configName)
+			return rollbackCrashLoop, false
+		}
+		if proc.reportsLock() && !proc.locked.Load() {
+			reason = rollbackNotLocked
+		}
+	}
+	return reason, reason == ""
+}
+
+// reportsLock returns whether the process reports a clock state that is expected to reach LOCKED, from the role
+// it has in its profile. The ptp4l of a grandmaster, which has no client port or serves the time ts2phc disciplines,
+// synce4l and a chronyd without PTP refclock report none.
+func (p *ptpProcess) reportsLock() bool {
+	switch p.name {
+	case phc2sysProcessName, ts2phcProcessName:
+		return true
+	case ptp4lProcessName:
+		return p.clockType != event.GM && !isGrandmasterProfile(&p.nodeProfile)
+	case chronydProcessName:
+		return hasChronydRefclock(&p.nodeProfile)
+	}
+	return false
+}
+
+// isGrandmasterProfile returns whether the profile runs ts2phc, as applyNodePtpProfile does when it has options
+func isGrandmasterProfile(nodeProfile *ptpv1.PtpProfile) bool {
+	return nodeProfile.Ts2PhcOpts != nil && *nodeProfile.Ts2PhcOpts != ""
+}
+
+// rollbackProfiles restores the last known good profiles in place of the watched ones.
+// The watched profiles stay in LinuxPTPConfUpdate, so they are not applied again until they change,
+// and an update received meanwhile is still applied next.
+func (dn *Daemon) rollbackProfiles(reason rollbackReason) {
+	dn.rollback.deadline = time.Time{}
+	good := dn.rollback.goodJson
+	if good == nil || bytes.Equal(good, dn.rollback.pendingJson) {
+		glog.Errorf("applied profiles are unhealthy (%s), there are no last known good profiles to roll back to", reason)
+		return
+	}
+	nodeProfiles, ok := tryToLoadConfig(good)
+	if !ok {
+		nodeProfiles, _ = tryToLoadOldConfig(good)
+	}
+	glog.Errorf("applied profiles are unhealthy (%s), rolling back to the last known good profiles", reason)
+	err := dn.applyProfiles(nodeProfiles)
+	if err != nil {
+		glog.Errorf("rollback to the last known good profiles failed: %v", err)
+	}
+	UpdateProfileRollbackMetrics(reason)
+	dn.emitRollbackEvent(reason, err)
+}
+
+// emitRollbackEvent records the rollback as a Kubernetes event of the node NodePtpDevice
+func (dn *Daemon) emitRollbackEvent(reason rollbackReason, rollbackErr error) {
+	if dn.kubeClient == nil {
+		return
+	}
+	message := fmt.Sprintf("applied profiles were rolled back to the last known good profiles: %s", reason)
+	if rollbackErr != nil {
+		message = fmt.Sprintf("%s, rollback failed: %v", message, rollbackErr)
+	}
+	now := metav1.Now()
+	ev := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: dn.nodeName + "-", Namespace: dn.namespace},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: ptpv1.GroupVersion.String(),
+			Kind:       "NodePtpDevice",
+			Name:       dn.nodeName,
+			Namespace:  dn.namespace,
+		},
+		Reason:         ProfileRollbackEventReason,
+		Message:        message,
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: "linuxptp-daemon", Host: dn.nodeName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := dn.kubeClient.CoreV1().Events(dn.namespace).Create(context.TODO(), ev, metav1.CreateOptions{}); err != nil {
+		glog.Errorf("failed to create %s event: %v", ProfileRollbackEventReason, err)
+	}
+}
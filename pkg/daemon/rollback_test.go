@@ -0,0 +1,115 @@
+package daemon
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_rollbackProfiles(t *testing.T) {
+	assert.NoError(t, leap.MockLeapFile())
+	defer close(leap.LeapMgr.Close)
+	dn := testDaemon()
+	dn.SetRollbackWindow(time.Minute)
+	// watch watches the profiles of LinuxPTPConfUpdate as the daemon loop does once they are applied
+	watch := func(applyErr error) {
+		_, appliedJson := dn.ptpUpdate.nodeProfiles()
+		dn.watchAppliedProfiles(appliedJson, applyErr)
+	}
+
+	// profiles without processes are healthy right away
+	watch(nil)
+	dn.checkAppliedProfiles()
+	assert.Equal(t, []byte("[]"), dn.rollback.goodJson)
+	assert.True(t, dn.rollback.deadline.IsZero())
+
+	// new profiles are kept once all their processes locked
+	apply := func(profilesJson string) *ptpProcess {
+		p := testProcess(phc2sysProcessName, "phc2sys.0.config", "new")
+		dn.processManager.process = []*ptpProcess{p}
+		dn.ptpUpdate.appliedNodeProfileJson = []byte(profilesJson)
+		dn.rollback.pendingRunIDs = map[string]int{"new": 0}
+		return p
+	}
+	p := apply(`[{"name":"good"}]`)
+	watch(nil)
+	dn.checkAppliedProfiles()
+	assert.False(t, dn.rollback.deadline.IsZero(), "phc2sys did not lock yet")
+	p.locked.Store(true)
+	dn.checkAppliedProfiles()
+	assert.Equal(t, []byte(`[{"name":"good"}]`), dn.rollback.goodJson)
+	assert.True(t, dn.rollback.deadline.IsZero())
+
+	// only the processes of the profiles the apply (re)started are watched
+	p = apply(`[{"name":"good"},{"name":"other"}]`)
+	unchanged := testProcess(ptp4lProcessName, "ptp4l.1.config", "unchanged")
+	unchanged.restart = &restartTracker{crashLooping: true}
+	dn.processManager.process = append(dn.processManager.process, unchanged)
+	watch(nil)
+	p.locked.Store(true)
+	dn.checkAppliedProfiles()
+	assert.Equal(t, []byte(`[{"name":"good"},{"name":"other"}]`), dn.rollback.goodJson)
+	assert.True(t, dn.rollback.deadline.IsZero())
+
+	// the profiles applied are watched, not an update received meanwhile, a profile without options runs no process
+	assert.NoError(t, dn.ptpUpdate.UpdateConfig([]byte(`[{"name":"applied","ptp4lOpts":""}]`)))
+	<-dn.ptpUpdate.UpdateCh
+	appliedJson, err := dn.applyNodePTPProfiles()
+	assert.NoError(t, err)
+	assert.NoError(t, dn.ptpUpdate.UpdateConfig([]byte(`[{"name":"next"}]`)))
+	<-dn.ptpUpdate.UpdateCh
+	dn.watchAppliedProfiles(appliedJson, nil)
+	assert.Equal(t, []byte(`[{"name":"applied","ptp4lOpts":""}]`), dn.rollback.pendingJson)
+	dn.checkAppliedProfiles()
+
+	// a crash loop rolls back before the window expires
+	dn.rollback.goodJson = []byte("[]")
+	p = apply(`[{"name":"bad"}]`)
+	watch(nil)
+	p.restart = &restartTracker{crashLooping: true}
+	rollbacks := testutil.ToFloat64(ProfileRollbackCount.With(prometheus.Labels{"node": NodeName, "reason": string(rollbackCrashLoop)}))
+	dn.checkAppliedProfiles()
+	assert.Empty(t, dn.processManager.process)
+	assert.True(t, dn.rollback.deadline.IsZero())
+	assert.Equal(t, []byte(`[{"name":"bad"}]`), dn.ptpUpdate.appliedNodeProfileJson, "the bad profiles are not applied again")
+	assert.Equal(t, rollbacks+1, testutil.ToFloat64(ProfileRollbackCount.With(prometheus.Labels{"node": NodeName, "reason": string(rollbackCrashLoop)})))
+
+	// processes that never lock are rolled back once the window expires
+	apply(`[{"name":"slow"}]`)
+	dn.SetRollbackWindow(time.Nanosecond)
+	watch(nil)
+	time.Sleep(time.Millisecond)
+	dn.checkAppliedProfiles()
+	assert.Empty(t, dn.processManager.process)
+
+	// a failed apply rolls back right away, rejected profiles never stopped anything
+	apply(`[{"name":"broken"}]`)
+	watch(fmt.Errorf("%w: invalid", errProfilesRejected))
+	assert.Len(t, dn.processManager.process, 1)
+	watch(fmt.Errorf("failed"))
+	assert.Empty(t, dn.processManager.process)
+
+	// there is nothing to roll back to when the good profiles are the ones failing
+	p = apply("[]")
+	watch(fmt.Errorf("failed"))
+	assert.Equal(t, []*ptpProcess{p}, dn.processManager.process)
+
+	// an update received while rolling back is still applied next
+	dn.ptpUpdate.UpdateCh = make(chan bool, 1)
+	apply(`[{"name":"bad"}]`)
+	watch(nil)
+	done := make(chan error)
+	go func() { done <- dn.ptpUpdate.UpdateConfig([]byte(`[{"name":"next"}]`)) }()
+	dn.rollbackProfiles(rollbackCrashLoop)
+	assert.NoError(t, <-done)
+	assert.True(t, <-dn.ptpUpdate.UpdateCh)
+	profiles, _ := dn.ptpUpdate.nodeProfiles()
+	if assert.Len(t, profiles, 1) {
+		assert.Equal(t, "next", *profiles[0].Name)
+	}
+}
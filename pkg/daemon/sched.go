@@ -0,0 +1,228 @@
+package daemon
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"golang.org/x/sys/unix"
+)
+
+// PtpSettings keys of the scheduling of a process type, prefixed with the process name,
+// i.e. "phc2sys.cpus": "0-1" or "ptp4l.schedPolicy": "SCHED_RR".
+// They override ptpSchedulingPolicy and ptpSchedulingPriority, which apply to every process of the profile.
+const (
+	SchedPolicySetting   = "schedPolicy"   // SCHED_OTHER, SCHED_FIFO or SCHED_RR
+	SchedPrioritySetting = "schedPriority" // real time priority, 1-99
+	CPUAffinitySetting   = "cpus"          // cpu list the process is pinned to, i.e. "2,4-5"
+	NiceSetting          = "nice"          // nice value, -20 to 19
+	IOClassSetting       = "ioClass"       // I/O scheduling class: realtime, best-effort or idle
+	IOPrioritySetting    = "ioPriority"    // I/O priority within the realtime and best-effort classes, 0-7
+)
+
+const (
+	ioprioClassShift  = 13
+	ioprioWhoProcess  = 1
+	ioprioClassRT     = 1
+	ioprioClassBE     = 2
+	ioprioClassIdle   = 3
+	legacyMaxPriority = 65 // upper bound of ptpSchedulingPriority
+)
+
+var schedPolicies = map[string]int{"SCHED_OTHER": unix.SCHED_NORMAL, "SCHED_FIFO": unix.SCHED_FIFO, "SCHED_RR": unix.SCHED_RR}
+var ioClasses = map[string]int{"realtime": ioprioClassRT, "best-effort": ioprioClassBE, "idle": ioprioClassIdle}
+
+// schedProcessNames are the process types the scheduling settings apply to
+var schedProcessNames = []string{ptp4lProcessName, phc2sysProcessName, ts2phcProcessName, syncEProcessName, chronydProcessName, GPSD_PROCESSNAME, GPSPIPE_PROCESSNAME}
+
+// schedAttr is the scheduling a process is started with, the zero value leaves everything inherited
+type schedAttr struct {
+	policy   string
+	priority int
+	nice     *int
+	cpus     []int
+	ioClass  string
+	ioPrio   int
+}
+
+// getSchedAttr reads the scheduling of processName from the profile, invalid settings are logged and ignored
+func getSchedAttr(nodeProfile *ptpv1.PtpProfile, processName string) schedAttr {
+	attr, errs := parseSchedAttr(nodeProfile, processName)
+	for _, err := range errs {
+		glog.Errorf("%s scheduling: %s, ignoring it", processName, err)
+	}
+	return attr
+}
+
+// parseSchedAttr parses the scheduling of processName and returns the invalid settings as errors
+func parseSchedAttr(nodeProfile *ptpv1.PtpProfile, processName string) (attr schedAttr, errs []error) {
+	if nodeProfile == nil {
+		return
+	}
+	if nodeProfile.PtpSchedulingPolicy != nil && *nodeProfile.PtpSchedulingPolicy == "SCHED_FIFO" {
+		if p := nodeProfile.PtpSchedulingPriority; p != nil && *p >= 1 && *p <= legacyMaxPriority {
+			attr.policy, attr.priority = "SCHED_FIFO", int(*p)
+		}
+	}
+	setting := func(key string) (string, bool) {
+		v, ok := nodeProfile.PtpSettings[processName+"."+key]
+		return strings.TrimSpace(v), ok
+	}
+	if v, ok := setting(SchedPolicySetting); ok {
+		if _, known := schedPolicies[v]; known {
+			attr.policy = v
+		} else {
+			errs = append(errs, fmt.Errorf("unknown %s %q", SchedPolicySetting, v))
+		}
+	}
+	if v, ok := setting(SchedPrioritySetting); ok {
+		if p, err := strconv.Atoi(v); err == nil && p >= 1 && p <= 99 {
+			attr.priority = p
+		} else {
+			errs = append(errs, fmt.Errorf("%s %q is not within 1-99", SchedPrioritySetting, v))
+		}
+	}
+	switch {
+	case attr.policy == "SCHED_OTHER":
+		attr.priority = 0
+	case attr.policy != "" && attr.priority == 0:
+		errs = append(errs, fmt.Errorf("%s %s requires %s", SchedPolicySetting, attr.policy, SchedPrioritySetting))
+		attr.policy = ""
+	}
+	if v, ok := setting(NiceSetting); ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= -20 && n <= 19 {
+			attr.nice = &n
+		} else {
+			errs = append(errs, fmt.Errorf("%s %q is not within -20-19", NiceSetting, v))
+		}
+	}
+	if v, ok := setting(CPUAffinitySetting); ok {
+		cpus, err := parseCPUList(v)
+		if err == nil {
+			attr.cpus = cpus
+		} else {
+			errs = append(errs, fmt.Errorf("%s %q: %w", CPUAffinitySetting, v, err))
+		}
+	}
+	if v, ok := setting(IOClassSetting); ok {
+		if _, known := ioClasses[v]; known {
+			attr.ioClass = v
+		} else {
+			errs = append(errs, fmt.Errorf("unknown %s %q", IOClassSetting, v))
+		}
+	}
+	if v, ok := setting(IOPrioritySetting); ok {
+		if p, err := strconv.Atoi(v); err == nil && p >= 0 && p <= 7 {
+			attr.ioPrio = p
+			if attr.ioClass == "" {
+				attr.ioClass = "best-effort"
+			}
+		} else {
+			errs = append(errs, fmt.Errorf("%s %q is not within 0-7", IOPrioritySetting, v))
+		}
+	}
+	return
+}
+
+// parseCPUList parses a cpu list as in /sys/devices/system/cpu/isolated, i.e. "0,2-3"
+func parseCPUList(list string) ([]int, error) {
+	var cpus []int
+	for _, r := range strings.Split(list, ",") {
+		r = strings.TrimSpace(r)
+		first, last, isRange := strings.Cut(r, "-")
+		from, err := strconv.Atoi(first)
+		if err != nil || from < 0 {
+			return nil, fmt.Errorf("invalid cpu %q", r)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(last); err != nil || to < from {
+				return nil, fmt.Errorf("invalid cpu range %q", r)
+			}
+		}
+		for cpu := from; cpu <= to; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// isSet returns whether the process is started with anything but the inherited scheduling
+func (a schedAttr) isSet() bool {
+	return a.policy != "" || a.nice != nil || len(a.cpus) > 0 || a.ioClass != ""
+}
+
+// String describes the scheduling, i.e. "SCHED_RR 10 nice -5 cpus [2 3] io realtime 0"
+func (a schedAttr) String() string {
+	var s []string
+	if a.policy != "" {
+		s = append(s, fmt.Sprintf("%s %d", a.policy, a.priority))
+	}
+	if a.nice != nil {
+		s = append(s, fmt.Sprintf("nice %d", *a.nice))
+	}
+	if len(a.cpus) > 0 {
+		s = append(s, fmt.Sprintf("cpus %v", a.cpus))
+	}
+	if a.ioClass != "" {
+		s = append(s, fmt.Sprintf("io %s %d", a.ioClass, a.ioPrio))
+	}
+	return strings.Join(s, " ")
+}
+
+// start starts cmd with the scheduling. It is set on a thread of its own that forks the process,
+// so the process inherits it before it executes anything, as do the threads it creates.
+// When the scheduling cannot be set, the process is started with the inherited one.
+func (a schedAttr) start(name string, cmd *exec.Cmd) error {
+	if !a.isSet() {
+		return cmd.Start()
+	}
+	started := make(chan error)
+	go func() {
+		// the goroutine exits with its thread locked, so the thread exits too instead of running others with the scheduling
+		runtime.LockOSThread()
+		if err := a.applyThread(unix.Gettid()); err != nil {
+			glog.Errorf("failed to set %s scheduling to %s: %v", name, a, err)
+			go func() { started <- cmd.Start() }()
+			return
+		}
+		err := cmd.Start()
+		if err == nil {
+			glog.Infof("%s (%d) started with scheduling %s", name, cmd.Process.Pid, a)
+		}
+		started <- err
+	}()
+	return <-started
+}
+
+func (a schedAttr) applyThread(tid int) error {
+	if a.policy != "" || a.nice != nil {
+		attr := &unix.SchedAttr{Policy: uint32(schedPolicies[a.policy]), Priority: uint32(a.priority)}
+		if a.nice != nil {
+			attr.Nice = int32(*a.nice)
+		}
+		if err := unix.SchedSetAttr(tid, attr, 0); err != nil {
+			return fmt.Errorf("sched_setattr: %w", err)
+		}
+	}
+	if len(a.cpus) > 0 {
+		set := unix.CPUSet{}
+		for _, cpu := range a.cpus {
+			set.Set(cpu)
+		}
+		if err := unix.SchedSetaffinity(tid, &set); err != nil {
+			return fmt.Errorf("sched_setaffinity: %w", err)
+		}
+	}
+	if a.ioClass != "" {
+		ioprio := ioClasses[a.ioClass]<<ioprioClassShift | a.ioPrio
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(ioprio)); errno != 0 {
+			return fmt.Errorf("ioprio_set: %w", errno)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"syscall"
+	"testing"
+
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+	"k8s.io/utils/pointer"
+)
+
+func Test_schedAttr(t *testing.T) {
+	profile := &ptpv1.PtpProfile{
+		Name:                  pointer.String("sched"),
+		PtpSchedulingPolicy:   pointer.String("SCHED_FIFO"),
+		PtpSchedulingPriority: pointer.Int64(10),
+		PtpSettings: map[string]string{
+			"phc2sys.schedPolicy": "SCHED_OTHER",
+			"phc2sys.nice":        "5",
+			"phc2sys.cpus":        "0",
+			"phc2sys.ioPriority":  "4",
+			"ts2phc.schedPolicy":  "SCHED_RR",
+			"ts2phc.ioClass":      "idle",
+			"gpsd.schedPriority":  "100",
+			"gpspipe.cpus":        "3-1",
+		},
+	}
+	attr, errs := parseSchedAttr(profile, ptp4lProcessName)
+	assert.Empty(t, errs)
+	assert.Equal(t, "SCHED_FIFO 10", attr.String())
+
+	attr, errs = parseSchedAttr(profile, phc2sysProcessName)
+	assert.Empty(t, errs)
+	assert.Equal(t, "SCHED_OTHER 0 nice 5 cpus [0] io best-effort 4", attr.String())
+
+	attr, errs = parseSchedAttr(profile, ts2phcProcessName)
+	assert.Empty(t, errs)
+	assert.Equal(t, "SCHED_RR 10 io idle 0", attr.String())
+
+	_, errs = parseSchedAttr(profile, GPSD_PROCESSNAME)
+	assert.Len(t, errs, 1)
+	_, errs = parseSchedAttr(profile, GPSPIPE_PROCESSNAME)
+	assert.Len(t, errs, 1)
+	assert.True(t, ValidateProfiles([]ptpv1.PtpProfile{*profile}).HasErrors())
+
+	cpus, err := parseCPUList("0, 2-4,7")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 2, 3, 4, 7}, cpus)
+	_, err = parseCPUList("a-1")
+	assert.Error(t, err)
+
+	// the process is started with nice and affinity, neither needs privileges, the daemon keeps its own
+	nice := 5
+	own, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	assert.NoError(t, err)
+	cmd := newCmd("sleep", "10")
+	assert.NoError(t, schedAttr{nice: &nice, cpus: []int{0}}.start("sleep", cmd))
+	defer cmd.Process.Kill()
+	prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, own, prio)
+	prio, err = syscall.Getpriority(syscall.PRIO_PROCESS, cmd.Process.Pid)
+	assert.NoError(t, err)
+	assert.Equal(t, 20-nice, prio) // the raw syscall returns 20 - nice
+	set := unix.CPUSet{}
+	assert.NoError(t, unix.SchedGetaffinity(cmd.Process.Pid, &set))
+	assert.Equal(t, 1, set.Count())
+	assert.True(t, set.IsSet(0))
+}
@@ -0,0 +1,88 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// ReadProfiles reads the profiles used in standalone mode, where no ptp-operator renders them for the node.
+// path is either a file or a directory whose .yaml, .yml and .json files are read in lexical order.
+// Every file holds a single profile or a list of profiles, in YAML or JSON.
+// The profiles are returned as the JSON list LinuxPTPConfUpdate.UpdateConfig expects.
+func ReadProfiles(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, e := range entries {
+			switch strings.ToLower(filepath.Ext(e.Name())) {
+			case ".yaml", ".yml", ".json":
+				if !e.IsDir() {
+					files = append(files, filepath.Join(path, e.Name()))
+				}
+			}
+		}
+		sort.Strings(files)
+	}
+
+	profiles := []ptpv1.PtpProfile{}
+	for _, f := range files {
+		fileProfiles, err := readProfileFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read profiles from %s: %w", f, err)
+		}
+		profiles = append(profiles, fileProfiles...)
+	}
+	names := map[string]bool{}
+	for i, p := range profiles {
+		if p.Name == nil || *p.Name == "" {
+			return nil, fmt.Errorf("profile %d has no name", i)
+		}
+		if names[*p.Name] {
+			return nil, fmt.Errorf("profile %s is defined more than once", *p.Name)
+		}
+		names[*p.Name] = true
+	}
+	return json.Marshal(profiles)
+}
+
+// readProfileFile reads a single profile or a list of profiles from a YAML or JSON file
+func readProfileFile(path string) ([]ptpv1.PtpProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	if data[0] == '[' {
+		profiles := []ptpv1.PtpProfile{}
+		err = json.Unmarshal(data, &profiles)
+		return profiles, err
+	}
+	profile := ptpv1.PtpProfile{}
+	if err = json.Unmarshal(data, &profile); err != nil {
+		return nil, err
+	}
+	return []ptpv1.PtpProfile{profile}, nil
+}
@@ -0,0 +1,50 @@
+package daemon
+
+import (
+	"os"
+	"testing"
+
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+)
+
+func Test_ReadProfiles(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(dir+"/10-bc.yaml", []byte("name: bc\ninterface: ens1f0\nptp4lOpts: \"-2\"\n"), 0644))
+	assert.NoError(t, os.WriteFile(dir+"/20-more.json", []byte(`[{"name":"oc","interface":"ens2f0"},{"name":"gm"}]`), 0644))
+	assert.NoError(t, os.WriteFile(dir+"/README", []byte("not a profile"), 0644))
+
+	data, err := ReadProfiles(dir)
+	assert.NoError(t, err)
+	profiles, ok := tryToLoadConfig(data)
+	assert.True(t, ok)
+	assert.Len(t, profiles, 3)
+	assert.Equal(t, "bc", *profiles[0].Name)
+	assert.Equal(t, "-2", *profiles[0].Ptp4lOpts)
+	assert.Equal(t, "gm", *profiles[2].Name)
+
+	data, err = ReadProfiles(dir + "/10-bc.yaml")
+	assert.NoError(t, err)
+	profiles, _ = tryToLoadConfig(data)
+	assert.Len(t, profiles, 1)
+
+	assert.NoError(t, os.WriteFile(dir+"/30-dup.yaml", []byte("- name: bc\n"), 0644))
+	_, err = ReadProfiles(dir)
+	assert.Error(t, err)
+}
+
+func Test_leapFile(t *testing.T) {
+	dn := testDaemon()
+	dn.dryRun = true
+	dn.SetLeapFile("/var/lib/linuxptp/leap-seconds.list")
+	profile := ptpv1.PtpProfile{
+		Name:       pointer.String("gm"),
+		Ts2PhcOpts: pointer.String(" "),
+		Ts2PhcConf: pointer.String("[nmea]\nts2phc.master 1\n[global]\n[ens1f0]\nts2phc.extts_polarity rising\n"),
+	}
+	assert.NoError(t, dn.applyNodePtpProfile(0, &profile))
+	if assert.NotEmpty(t, dn.processManager.process) && assert.Equal(t, ts2phcProcessName, dn.processManager.process[0].name) {
+		assert.Contains(t, dn.renderedConfigs[dn.processManager.process[0].processConfigPath], "leapfile /var/lib/linuxptp/leap-seconds.list\n")
+	}
+}
@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/oran"
+)
+
+// statusTimeout bounds how long the event loop and the leap manager have to answer a status request
+const statusTimeout = 2 * time.Second
+
+// Status returns the current state of the daemon: the applied profiles and their processes,
+// the clock states of the event handler and the leap second data
+func (dn *Daemon) Status() apiv1.Status {
+	s := apiv1.Status{
+		APIVersion: apiv1.Version,
+		Node:       dn.nodeName,
+		Time:       time.Now().UTC(),
+		Profiles:   dn.profilesStatus(),
+		Configs:    []apiv1.Config{},
+	}
+	if h := dn.processManager.ptpEventHandler; h != nil {
+		if configs, err := h.Status(statusTimeout); err != nil {
+			s.Errors = append(s.Errors, err.Error())
+		} else {
+			s.Configs = configs
+		}
+	}
+	if lm := leap.LeapMgr; lm != nil {
+		if l, err := lm.Status(statusTimeout); err != nil {
+			s.Errors = append(s.Errors, err.Error())
+		} else {
+			s.Leap = l
+		}
+	}
+	return s
+}
+
+// SetNotificationPublisher feeds the state changes of the event handler and the processes to publisher,
+// and serves its notification API along with the status API. It must be set before the API is served.
+func (dn *Daemon) SetNotificationPublisher(publisher *oran.Publisher) {
+	dn.notifications = publisher
+	publisher.Register(event.StateRegisterer)
+}
+
+// profilesStatus returns the applied profiles, as of the last probe status refresh, and the current state of their processes
+func (dn *Daemon) profilesStatus() []apiv1.Profile {
+	dn.health.RLock()
+	processes, runIDs := dn.health.processes, dn.health.runIDs
+	dn.health.RUnlock()
+
+	now := time.Now()
+	byName := map[string]*apiv1.Profile{}
+	profiles := []apiv1.Profile{}
+	var names []string
+	for _, p := range processes {
+		name := ""
+		if p.nodeProfile.Name != nil {
+			name = *p.nodeProfile.Name
+		}
+		if _, ok := byName[name]; !ok {
+			runID, found := runIDs[name]
+			if !found {
+				runID = -1
+			}
+			byName[name] = &apiv1.Profile{Name: name, RunID: runID, Processes: []apiv1.Process{}}
+			names = append(names, name)
+		}
+		proc := apiv1.Process{
+			Name:         p.name,
+			ConfigName:   p.configName,
+			Restarts:     p.restart.Restarts(),
+			CrashLooping: p.restart.CrashLooping(),
+			Locked:       p.locked.Load(),
+		}
+		if pid, startedAt := p.restart.Running(); pid != 0 && !p.Stopped() {
+			proc.Running, proc.PID = true, pid
+			start := startedAt.UTC()
+			proc.StartTime = &start
+			proc.Uptime = now.Sub(startedAt).Seconds()
+		}
+		if reason, code := p.restart.LastExit(); reason != "" {
+			proc.LastExit = &apiv1.Exit{Reason: reason, Code: code}
+		}
+		for _, d := range p.dependents {
+			if d != nil {
+				proc.Dependents = append(proc.Dependents, apiv1.Dependent{Name: d.Name(), Running: !d.Stopped()})
+			}
+		}
+		byName[name].Processes = append(byName[name].Processes, proc)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		profiles = append(profiles, *byName[name])
+	}
+	return profiles
+}
+
+// statusHandler serves the Status of dn as JSON
+func statusHandler(dn *Daemon) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(dn.Status()); err != nil {
+			glog.Errorf("failed to write the status: %v", err)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_statusHandler(t *testing.T) {
+	gpsd := &GPSD{name: GPSD_PROCESSNAME}
+	ts2phc := testProcess(ts2phcProcessName, "ts2phc.0.config", "gm")
+	ts2phc.restart, ts2phc.depProcess = &restartTracker{}, []process{gpsd}
+	phc2sys := testProcess(phc2sysProcessName, "phc2sys.0.config", "gm")
+	ptp4l := testProcess(ptp4lProcessName, "ptp4l.1.config", "bc")
+	ts2phc.restart.started(42)
+	ts2phc.locked.Store(true)
+	assert.NoError(t, leap.MockLeapFile())
+	defer close(leap.LeapMgr.Close)
+	ptp4l.setStopped(true)
+	dn := testDaemon(ts2phc, phc2sys, ptp4l)
+	dn.processManager.appliedProfiles = map[string]appliedProfile{"gm": {runID: 0}, "bc": {runID: 1}}
+	dn.updateHealth()
+	mux := http.NewServeMux()
+	registerProbes(mux, dn)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, apiv1.StatusPath, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var status apiv1.Status
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, apiv1.Version, status.APIVersion)
+	assert.Equal(t, "node", status.Node)
+	assert.Empty(t, status.Errors)
+	assert.Equal(t, []apiv1.Config{}, status.Configs)
+	if assert.NotNil(t, status.Leap) {
+		assert.Equal(t, 37, status.Leap.UTCOffset)
+	}
+	if assert.Len(t, status.Profiles, 2) {
+		assert.Equal(t, "bc", status.Profiles[0].Name)
+		assert.Equal(t, 1, status.Profiles[0].RunID)
+		assert.Equal(t, []apiv1.Process{{Name: ptp4lProcessName, ConfigName: "ptp4l.1.config"}}, status.Profiles[0].Processes)
+		assert.Equal(t, "gm", status.Profiles[1].Name)
+		if assert.Len(t, status.Profiles[1].Processes, 2) {
+			p := status.Profiles[1].Processes[0]
+			assert.Equal(t, ts2phcProcessName, p.Name)
+			assert.True(t, p.Running)
+			assert.True(t, p.Locked)
+			assert.Equal(t, 42, p.PID)
+			assert.NotNil(t, p.StartTime)
+			assert.Equal(t, []apiv1.Dependent{{Name: GPSD_PROCESSNAME, Running: true}}, p.Dependents)
+			// a process without a restart tracker has no pid to report
+			assert.False(t, status.Profiles[1].Processes[1].Running)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, apiv1.StatusPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,122 @@
+package daemon
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+)
+
+// StopGracePeriodSetting is the PtpSettings key for the time a process is given to exit after SIGTERM
+// before it is killed, i.e. "10s"
+const StopGracePeriodSetting = "stopGracePeriod"
+
+const (
+	defaultStopGracePeriod = 10 * time.Second
+	// stopKillTimeout is how long to wait for the process to be reaped once SIGKILL is sent
+	stopKillTimeout = 5 * time.Second
+)
+
+// stopResult is the outcome of stopping a process, reported in the process_stop_count metric
+type stopResult string
+
+const (
+	stopResultTerminated stopResult = "terminated" // exited within the grace period
+	stopResultKilled     stopResult = "killed"     // killed after the grace period expired
+	stopResultTimeout    stopResult = "timeout"    // still not reaped after SIGKILL, the run loop is abandoned
+)
+
+// getStopGracePeriod reads the stop grace period from the profile PtpSettings
+func getStopGracePeriod(nodeProfile *ptpv1.PtpProfile) time.Duration {
+	if nodeProfile == nil {
+		return defaultStopGracePeriod
+	}
+	if s, ok := nodeProfile.PtpSettings[StopGracePeriodSetting]; ok {
+		d, err := time.ParseDuration(s)
+		if err == nil && d > 0 {
+			return d
+		}
+		glog.Errorf("invalid %s %q, using default %s", StopGracePeriodSetting, s, defaultStopGracePeriod)
+	}
+	return defaultStopGracePeriod
+}
+
+// newCmd returns a command started in its own process group, so it can be stopped
+// together with anything it forks
+func newCmd(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+// signalProcessGroup sends sig to the process group of cmd, or to the process alone
+// when it was not started in its own group
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid {
+		err := syscall.Kill(-cmd.Process.Pid, sig)
+		if !errors.Is(err, syscall.ESRCH) {
+			return err
+		}
+	}
+	return cmd.Process.Signal(sig)
+}
+
+// processGroupExists returns whether the process group pgid still has a member. Its id is not reused while it has one.
+func processGroupExists(pgid int) bool {
+	err := syscall.Kill(-pgid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// stopProcessGroup sends SIGTERM to cmd and waits up to gracePeriod for done to be closed by the run loop once
+// it waited for the process. A process that does not exit in time is sent SIGKILL; whatever is left in its
+// process group is killed in any case.
+func stopProcessGroup(name string, cmd *exec.Cmd, done <-chan struct{}, gracePeriod time.Duration) stopResult {
+	if gracePeriod <= 0 {
+		gracePeriod = defaultStopGracePeriod
+	}
+	start := time.Now()
+	result := stopResultTerminated
+	running := cmd != nil && cmd.Process != nil
+
+	if running {
+		glog.Infof("Sending TERM to (%s) PID: %d", name, cmd.Process.Pid)
+		if err := signalProcessGroup(cmd, syscall.SIGTERM); err != nil {
+			// If the process is already terminated, we will get an error here
+			glog.Infof("failed to send SIGTERM to %s (%d): %v", name, cmd.Process.Pid, err)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(gracePeriod):
+		if !running {
+			glog.Errorf("%s did not exit within %s", name, gracePeriod)
+			result = stopResultTimeout
+			break
+		}
+		glog.Errorf("%s (%d) did not exit within %s, sending KILL", name, cmd.Process.Pid, gracePeriod)
+		result = stopResultKilled
+		if err := signalProcessGroup(cmd, syscall.SIGKILL); err != nil {
+			glog.Errorf("failed to send SIGKILL to %s (%d): %v", name, cmd.Process.Pid, err)
+		}
+		select {
+		case <-done:
+		case <-time.After(stopKillTimeout):
+			glog.Errorf("%s (%d) was not reaped %s after KILL", name, cmd.Process.Pid, stopKillTimeout)
+			result = stopResultTimeout
+		}
+	}
+
+	if running && cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid && processGroupExists(cmd.Process.Pid) {
+		// reap anything left behind in the process group
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err == nil {
+			glog.Infof("killed remaining processes in %s process group %d", name, cmd.Process.Pid)
+		}
+	}
+	glog.Infof("%s stopped (%s) in %s", name, result, time.Since(start).Round(time.Millisecond))
+	UpdateProcessStopMetrics(name, result)
+	return result
+}
@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_stopProcessGroup(t *testing.T) {
+	run := func(script string) (*exec.Cmd, <-chan struct{}) {
+		cmd := newCmd("sh", "-c", script)
+		assert.NoError(t, cmd.Start())
+		done := make(chan struct{})
+		go func() {
+			_ = cmd.Wait()
+			close(done)
+		}()
+		return cmd, done
+	}
+
+	cmd, done := run("exec sleep 30")
+	assert.True(t, processGroupExists(cmd.Process.Pid))
+	assert.Equal(t, stopResultTerminated, stopProcessGroup("sleep", cmd, done, time.Second))
+	assert.False(t, processGroupExists(cmd.Process.Pid), "the group is gone once its only process was reaped")
+
+	// the run loop already waited for the process
+	cmd, done = run("exit 0")
+	<-done
+	start := time.Now()
+	assert.Equal(t, stopResultTerminated, stopProcessGroup("exit", cmd, done, time.Second))
+	assert.Less(t, time.Since(start), time.Second)
+
+	// ignores SIGTERM, and so does the child it forks
+	pidFile := t.TempDir() + "/child.pid"
+	cmd, done = run(fmt.Sprintf("trap '' TERM; sleep 30 & echo $! > %s; wait", pidFile))
+	assert.Eventually(t, func() bool {
+		pid, _ := os.ReadFile(pidFile)
+		return strings.HasSuffix(string(pid), "\n")
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, stopResultKilled, stopProcessGroup("trap", cmd, done, 200*time.Millisecond))
+	pid, _ := os.ReadFile(pidFile)
+	assert.Eventually(t, func() bool { // the child is gone or a zombie waiting to be reaped by init
+		stat, err := os.ReadFile("/proc/" + strings.TrimSpace(string(pid)) + "/stat")
+		return err != nil || strings.Contains(string(stat), ") Z ")
+	}, time.Second, 10*time.Millisecond)
+
+	// never started
+	assert.Equal(t, stopResultTimeout, stopProcessGroup("none", nil, make(chan struct{}), 10*time.Millisecond))
+}
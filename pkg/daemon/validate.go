@@ -0,0 +1,531 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ptpconf"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	apiextensions "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+// ValidationSeverity tells whether an issue rejects the profiles or is only reported
+type ValidationSeverity string
+
+const (
+	// ValidationError rejects the whole profile update
+	ValidationError ValidationSeverity = "error"
+	// ValidationWarning is logged and reported, the profiles are applied anyway
+	ValidationWarning ValidationSeverity = "warning"
+)
+
+// ValidationCode identifies the kind of a validation issue
+type ValidationCode string
+
+const (
+	ValidationMissingName       ValidationCode = "MissingName"
+	ValidationDuplicateName     ValidationCode = "DuplicateName"
+	ValidationInvalidConfig     ValidationCode = "InvalidConfig"
+	ValidationUnknownOption     ValidationCode = "UnknownOption"
+	ValidationMisplacedOption   ValidationCode = "MisplacedOption"
+	ValidationInterfaceConflict ValidationCode = "InterfaceConflict"
+	ValidationUnknownHaProfile  ValidationCode = "UnknownHaProfile"
+	ValidationScheduling        ValidationCode = "InvalidScheduling"
+	ValidationTs2phcNoSource    ValidationCode = "Ts2phcNoSource"
+	ValidationSynceNoPorts      ValidationCode = "Synce4lDeviceWithoutPorts"
+	ValidationChronyd           ValidationCode = "InvalidChronyd"
+	ValidationRealtimeConflict  ValidationCode = "ClockRealtimeConflict"
+	ValidationGnssConflict      ValidationCode = "GnssConflict"
+	ValidationLogRules          ValidationCode = "InvalidLogRules"
+	ValidationGMStateMachine    ValidationCode = "InvalidGMStateMachine"
+)
+
+// ValidationHwConfigVendor is the VendorID of the NodePtpDevice status hwconfig entries
+// holding the validation issues of a profile, the DeviceID is the profile name
+const ValidationHwConfigVendor = "ptpconfig-validation"
+
+// ValidationIssue is a single problem found in a profile
+type ValidationIssue struct {
+	Profile  string             `json:"profile"`
+	Severity ValidationSeverity `json:"severity"`
+	Code     ValidationCode     `json:"code"`
+	Process  string             `json:"process,omitempty"`
+	Message  string             `json:"message"`
+}
+
+func (i ValidationIssue) Error() string {
+	if i.Process != "" {
+		return fmt.Sprintf("profile %s: %s: %s: %s", i.Profile, i.Process, i.Code, i.Message)
+	}
+	return fmt.Sprintf("profile %s: %s: %s", i.Profile, i.Code, i.Message)
+}
+
+// ValidationResult holds the issues of all the profiles of an update, in profile order
+type ValidationResult struct {
+	Issues []ValidationIssue `json:"issues"`
+}
+
+func (r *ValidationResult) add(profile string, severity ValidationSeverity, code ValidationCode, process, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, ValidationIssue{
+		Profile:  profile,
+		Severity: severity,
+		Code:     code,
+		Process:  process,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// HasErrors returns true when the profiles must be rejected
+func (r *ValidationResult) HasErrors() bool {
+	for _, i := range r.Issues {
+		if i.Severity == ValidationError {
+			return true
+		}
+	}
+	return false
+}
+
+// ForProfile returns the issues of a single profile
+func (r *ValidationResult) ForProfile(name string) []ValidationIssue {
+	var issues []ValidationIssue
+	for _, i := range r.Issues {
+		if i.Profile == name {
+			issues = append(issues, i)
+		}
+	}
+	return issues
+}
+
+// Err joins the errors of the result, it is nil when there are none
+func (r *ValidationResult) Err() error {
+	var errs []error
+	for _, i := range r.Issues {
+		if i.Severity == ValidationError {
+			errs = append(errs, i)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Log logs every issue of the result
+func (r *ValidationResult) Log() {
+	for _, i := range r.Issues {
+		if i.Severity == ValidationError {
+			glog.Errorf("profile validation: %s", i.Error())
+		} else {
+			glog.Warningf("profile validation: %s", i.Error())
+		}
+	}
+}
+
+// HwConfigs returns one NodePtpDevice status entry per profile with issues, see ValidationHwConfigVendor
+func (r *ValidationResult) HwConfigs() []ptpv1.HwConfig {
+	var hwconfigs []ptpv1.HwConfig
+	seen := map[string]bool{}
+	for _, i := range r.Issues {
+		if seen[i.Profile] {
+			continue
+		}
+		seen[i.Profile] = true
+		issues := r.ForProfile(i.Profile)
+		errCount := 0
+		for _, pi := range issues {
+			if pi.Severity == ValidationError {
+				errCount++
+			}
+		}
+		hw := ptpv1.HwConfig{
+			DeviceID: i.Profile,
+			VendorID: ValidationHwConfigVendor,
+			Failed:   errCount > 0,
+			Status: fmt.Sprintf("%d errors, %d warnings, first: %s",
+				errCount, len(issues)-errCount, issues[0].Error()),
+		}
+		if raw, err := json.Marshal(issues); err == nil {
+			hw.Config = &apiextensions.JSON{Raw: raw}
+		}
+		hwconfigs = append(hwconfigs, hw)
+	}
+	return hwconfigs
+}
+
+// ValidateProfiles checks the profiles of an update before anything is stopped or started.
+// Errors are problems that would make a process fail or crash loop, warnings are reported only.
+func ValidateProfiles(profiles []ptpv1.PtpProfile) *ValidationResult {
+	r := &ValidationResult{}
+	names := map[string]bool{}
+	for i := range profiles {
+		if profiles[i].Name == nil || *profiles[i].Name == "" {
+			r.add(fmt.Sprintf("#%d", i), ValidationError, ValidationMissingName, "", "profile has no name")
+			continue
+		}
+		if names[*profiles[i].Name] {
+			r.add(*profiles[i].Name, ValidationError, ValidationDuplicateName, "", "profile is defined more than once")
+		}
+		names[*profiles[i].Name] = true
+	}
+
+	// interface -> profile whose ptp4l claims it
+	ifaceOwners := map[string]string{}
+	// profile whose chronyd disciplines CLOCK_REALTIME
+	chronydOwner := ""
+	// profile whose ts2phc reads NMEA from the GNSS receiver through gpsd
+	gnssOwner := ""
+	// profiles whose phc2sys disciplines CLOCK_REALTIME
+	var realtimePhc2sys []string
+	for i := range profiles {
+		profile := &profiles[i]
+		if profile.Name == nil || *profile.Name == "" {
+			continue
+		}
+		name := *profile.Name
+
+		for _, ha := range listHaProfiles(profile) {
+			if ha == "" {
+				continue
+			}
+			if ha == name {
+				r.add(name, ValidationError, ValidationUnknownHaProfile, phc2sysProcessName, "haProfiles lists the profile itself")
+			} else if !names[ha] {
+				r.add(name, ValidationError, ValidationUnknownHaProfile, phc2sysProcessName, "haProfiles lists %s, which is not a profile of this node", ha)
+			}
+		}
+
+		validateScheduling(r, profile)
+		validateLogRules(r, profile)
+		validateChronyd(r, profile, &chronydOwner)
+		validateGMStateMachine(r, profile)
+
+		for _, pProcess := range ptpProcesses {
+			configInput, configOpts := profileProcessConfig(profile, pProcess)
+			// ptp4l is started with default options when there are none, see applyNodePtpProfile
+			if pProcess != ptp4lProcessName && (configOpts == nil || *configOpts == "") {
+				continue
+			}
+			conf := &ptp4lConf{}
+			if err := conf.populatePtp4lConf(configInput); err != nil {
+				r.add(name, ValidationError, ValidationInvalidConfig, pProcess, "%s", err)
+				continue
+			}
+			validateOptions(r, name, pProcess, conf)
+
+			switch pProcess {
+			case ptp4lProcessName:
+				ifaces := conf.portNames()
+				if profile.Interface != nil && *profile.Interface != "" {
+					ifaces = append([]string{*profile.Interface}, ifaces...)
+				}
+				for _, iface := range ifaces {
+					if owner, ok := ifaceOwners[iface]; ok && owner != name {
+						r.add(name, ValidationError, ValidationInterfaceConflict, pProcess, "interface %s is already used by profile %s", iface, owner)
+						continue
+					}
+					ifaceOwners[iface] = name
+				}
+			case ts2phcProcessName:
+				if conf.file.Section(ptpconf.NmeaSection) == nil && !hasOpt(*configOpts, "-s") {
+					r.add(name, ValidationError, ValidationTs2phcNoSource, pProcess, "ts2phc has neither an [nmea] section nor a -s source")
+				}
+				if conf.readsNmea(*configOpts) {
+					if gnssOwner != "" {
+						r.add(name, ValidationError, ValidationGnssConflict, pProcess,
+							"profile %s already runs ts2phc with an NMEA source, gpsd serves a single GNSS receiver per node", gnssOwner)
+					} else {
+						gnssOwner = name
+					}
+				}
+			case syncEProcessName:
+				for _, device := range conf.synceDevicesWithoutPorts() {
+					r.add(name, ValidationError, ValidationSynceNoPorts, pProcess, "device %s has no port or external source sections", device)
+				}
+			case phc2sysProcessName:
+				if phc2sysDisciplinesRealtime(*configOpts) {
+					realtimePhc2sys = append(realtimePhc2sys, name)
+				}
+			}
+		}
+	}
+	if chronydOwner != "" {
+		for _, name := range realtimePhc2sys {
+			r.add(name, ValidationError, ValidationRealtimeConflict, phc2sysProcessName,
+				"phc2sys disciplines CLOCK_REALTIME, which the chronyd of profile %s already does", chronydOwner)
+		}
+	}
+	return r
+}
+
+// validateChronyd checks the chronyd settings, only one chronyd may discipline CLOCK_REALTIME
+func validateChronyd(r *ValidationResult, profile *ptpv1.PtpProfile, owner *string) {
+	name := *profile.Name
+	settings, err := getChronydSettings(profile)
+	if err != nil {
+		r.add(name, ValidationError, ValidationChronyd, chronydProcessName, "%s", err)
+		return
+	}
+	if settings == nil {
+		return
+	}
+	if *owner != "" {
+		r.add(name, ValidationError, ValidationRealtimeConflict, chronydProcessName, "profile %s already runs chronyd", *owner)
+	} else {
+		*owner = name
+	}
+	if settings.refclock == "" && len(settings.servers) == 0 && settings.extra == "" {
+		r.add(name, ValidationError, ValidationChronyd, chronydProcessName, "chronyd has neither a refclock nor NTP servers")
+	}
+}
+
+// validateGMStateMachine checks the GM state machine selected by the profile
+func validateGMStateMachine(r *ValidationResult, profile *ptpv1.PtpProfile) {
+	if _, ok := profile.PtpSettings[GMStateMachineSetting]; !ok {
+		return
+	}
+	if _, err := getGMStateMachine(profile); err != nil {
+		r.add(*profile.Name, ValidationError, ValidationGMStateMachine, ts2phcProcessName, "%s", err)
+	} else if profile.Ts2PhcOpts == nil || *profile.Ts2PhcOpts == "" {
+		r.add(*profile.Name, ValidationWarning, ValidationGMStateMachine, ts2phcProcessName,
+			"%s is set but the profile does not run ts2phc, it has no effect", GMStateMachineSetting)
+	}
+}
+
+// profileProcessConfig returns the config and options of a process in the profile
+func profileProcessConfig(profile *ptpv1.PtpProfile, pProcess string) (configInput, configOpts *string) {
+	switch pProcess {
+	case ptp4lProcessName:
+		return profile.Ptp4lConf, profile.Ptp4lOpts
+	case phc2sysProcessName:
+		return profile.Phc2sysConf, profile.Phc2sysOpts
+	case ts2phcProcessName:
+		return profile.Ts2PhcConf, profile.Ts2PhcOpts
+	case syncEProcessName:
+		return profile.Synce4lConf, profile.Synce4lOpts
+	}
+	return nil, nil
+}
+
+// validateScheduling checks the scheduling settings getSchedAttr would ignore
+func validateScheduling(r *ValidationResult, profile *ptpv1.PtpProfile) {
+	for _, pProcess := range schedProcessNames {
+		_, errs := parseSchedAttr(profile, pProcess)
+		for _, err := range errs {
+			r.add(*profile.Name, ValidationError, ValidationScheduling, pProcess, "%s", err)
+		}
+	}
+	if profile.PtpSchedulingPolicy == nil {
+		return
+	}
+	switch *profile.PtpSchedulingPolicy {
+	case "", "SCHED_OTHER":
+	case "SCHED_FIFO":
+		if profile.PtpSchedulingPriority == nil {
+			r.add(*profile.Name, ValidationError, ValidationScheduling, "", "ptpSchedulingPriority must be set for SCHED_FIFO")
+		} else if p := *profile.PtpSchedulingPriority; p < 1 || p > 65 {
+			r.add(*profile.Name, ValidationError, ValidationScheduling, "", "ptpSchedulingPriority %d is out of range 1-65", p)
+		}
+	default:
+		r.add(*profile.Name, ValidationWarning, ValidationScheduling, "",
+			"unsupported ptpSchedulingPolicy %s, default scheduling is used", *profile.PtpSchedulingPolicy)
+	}
+}
+
+// validateLogRules checks the log rules newLogSampler would ignore
+func validateLogRules(r *ValidationResult, profile *ptpv1.PtpProfile) {
+	if _, err := parseLogSummaryInterval(profile); err != nil {
+		r.add(*profile.Name, ValidationError, ValidationLogRules, "", "%s", err)
+	}
+	for _, pProcess := range logRuleProcessNames {
+		if _, err := parseLogRules(profile, pProcess); err != nil {
+			r.add(*profile.Name, ValidationError, ValidationLogRules, pProcess, "%s", err)
+		}
+	}
+}
+
+// validateOptions rejects options placed in a section they do not belong to, the linuxptp programs refuse to start
+// on those. Options none of the supported releases know are only warned about, the installed release may know them.
+func validateOptions(r *ValidationResult, profile, pProcess string, conf *ptp4lConf) {
+	known := linuxptpOptions
+	var tableKnown map[string]bool
+	if pProcess == syncEProcessName {
+		known = synce4lOptions
+	} else {
+		tableKnown = unicastMasterTableOptions
+	}
+	for _, section := range conf.file.Sections {
+		sectionKnown, otherKnown := known, tableKnown
+		if section.Type() == ptpconf.UnicastMasterTable {
+			sectionKnown, otherKnown = tableKnown, known
+		}
+		for _, option := range section.Options() {
+			switch {
+			case sectionKnown[option.Key]:
+			case otherKnown[option.Key]:
+				r.add(profile, ValidationError, ValidationMisplacedOption, pProcess, "option %s does not belong to section [%s]", option.Key, section.Name)
+			default:
+				r.add(profile, ValidationWarning, ValidationUnknownOption, pProcess, "unknown option %s in section [%s]", option.Key, section.Name)
+			}
+		}
+	}
+}
+
+// phc2sysDisciplinesRealtime returns whether phc2sys run with opts disciplines CLOCK_REALTIME. With -a it does only
+// when -r is given, otherwise unless -c names another clock or CLOCK_REALTIME is the -s source.
+// Options limited to -O and -w select no clock.
+func phc2sysDisciplinesRealtime(opts string) bool {
+	fields := strings.Fields(opts)
+	auto, realtime, selects := false, false, false
+	sink, source := clockRealTime, ""
+	value := func(i *int) string {
+		if *i+1 < len(fields) {
+			*i++
+			return fields[*i]
+		}
+		return ""
+	}
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "-O":
+			value(&i)
+			continue
+		case "-w":
+			continue
+		case "-a":
+			auto = true
+		case "-r":
+			realtime = true
+		case "-c":
+			sink = value(&i)
+		case "-s":
+			source = value(&i)
+		}
+		selects = true
+	}
+	if auto {
+		return realtime
+	}
+	return selects && sink == clockRealTime && source != clockRealTime
+}
+
+// hasOpt returns true when the command line options contain opt
+func hasOpt(opts, opt string) bool {
+	for _, f := range strings.Fields(opts) {
+		if f == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// portNames returns the interfaces of the port sections of a ptp4l config
+func (conf *ptp4lConf) portNames() []string {
+	var ports []string
+	for _, section := range conf.file.SectionsOfType(ptpconf.Port) {
+		ports = append(ports, section.Name)
+	}
+	return ports
+}
+
+// synceDevicesWithoutPorts returns the synce4l devices no port or external source section follows,
+// see extractSynceRelations for the section layout
+func (conf *ptp4lConf) synceDevicesWithoutPorts() []string {
+	var devices []string
+	device, sources := "", 0
+	closeDevice := func() {
+		if device != "" && sources == 0 {
+			devices = append(devices, device)
+		}
+	}
+	for _, section := range conf.file.Sections {
+		switch section.Type() {
+		case ptpconf.SynceDevice:
+			closeDevice()
+			device = strings.Trim(section.Name, "<>")
+			sources = 0
+		case ptpconf.Port, ptpconf.SynceExternalSource:
+			sources++
+		}
+	}
+	closeDevice()
+	return devices
+}
+
+func optionSet(options ...string) map[string]bool {
+	set := make(map[string]bool, len(options))
+	for _, o := range options {
+		set[o] = true
+	}
+	return set
+}
+
+// linuxptpReleaseOptions are the config file options of ptp4l, phc2sys and ts2phc by the linuxptp release that
+// added them. The three programs parse their config files with the same option table, config.c config_tab,
+// so they accept the same options. The release installed in the image depends on its base image, see the Dockerfile.
+var linuxptpReleaseOptions = []struct {
+	release string
+	options []string
+}{
+	{"3.1", []string{
+		"announceReceiptTimeout", "asCapable", "assume_two_step", "boundary_clock_jbod", "check_fup_sync",
+		"clock_class_threshold", "clock_servo", "clock_type", "clockAccuracy", "clockClass", "dataset_comparison",
+		"delay_filter", "delay_filter_length", "delay_mechanism", "delayAsymmetry", "domainNumber", "dscp_event",
+		"dscp_general", "egressLatency", "fault_badpeer_interval", "fault_reset_interval", "first_step_threshold",
+		"follow_up_info", "free_running", "freq_est_interval", "G.8275.defaultDS.localPriority",
+		"G.8275.portDS.localPriority", "gmCapable", "hwts_filter", "hybrid_e2e", "ignore_source_id",
+		"ignore_transport_specific", "ingressLatency", "inhibit_announce", "inhibit_delay_req",
+		"inhibit_multicast_service", "initial_delay", "interface_rate_tlv", "kernel_leap", "logAnnounceInterval",
+		"logging_level", "logMinDelayReqInterval", "logMinPdelayReqInterval", "logSyncInterval",
+		"manufacturerIdentity", "masterOnly", "max_frequency", "message_tag", "min_neighbor_prop_delay",
+		"msg_interval_request", "neighborPropDelayThresh", "net_sync_monitor", "network_transport", "ntpshm_segment",
+		"offsetScaledLogVariance", "operLogPdelayReqInterval", "operLogSyncInterval", "p2p_dst_mac",
+		"path_trace_enabled", "pi_integral_const", "pi_integral_exponent", "pi_integral_norm_max",
+		"pi_integral_scale", "pi_proportional_const", "pi_proportional_exponent", "pi_proportional_norm_max",
+		"pi_proportional_scale", "priority1", "priority2", "productDescription", "ptp_dst_mac", "revisionData",
+		"sanity_freq_limit", "servo_num_offset_values", "servo_offset_threshold", "slave_event_monitor", "slaveOnly",
+		"socket_priority", "step_threshold", "summary_interval", "syncReceiptTimeout", "tc_spanning_tree",
+		"time_stamping", "timeSource", "transportSpecific", "ts2phc.channel", "ts2phc.extts_correction",
+		"ts2phc.extts_polarity", "ts2phc.master", "ts2phc.nmea_baudrate", "ts2phc.nmea_remote_host",
+		"ts2phc.nmea_remote_port", "ts2phc.nmea_serialport", "ts2phc.pin_index", "ts2phc.pulsewidth", "tsproc_mode",
+		"twoStepFlag", "tx_timestamp_timeout", "udp6_scope", "udp_ttl", "uds_address", "unicast_listen",
+		"unicast_master_table", "unicast_req_duration", "use_syslog", "userDescription", "utc_offset", "verbose",
+		"write_phase_mode",
+	}},
+	{"4.0", []string{
+		"active_key_id", "allowedLostResponses", "BMCA", "clientOnly", "clockIdentity", "delay_response_timeout",
+		"leapfile", "phc_index", "power_profile.2011.grandmasterTimeInaccuracy",
+		"power_profile.2011.networkTimeInaccuracy", "power_profile.2017.totalTimeInaccuracy",
+		"power_profile.grandmasterID", "power_profile.version", "ptp_minor_version", "sa_file", "serverOnly", "spp",
+		"step_window", "ts2phc.holdover", "ts2phc.nmea_delay", "ts2phc.perout_phase", "ts2phc.tod_source",
+		"uds_file_mode", "uds_ro_address", "uds_ro_file_mode",
+	}},
+	{"4.2", []string{
+		"maxStepsRemoved", "refclock_sock_address", "ts2phc.max_phc_update_skip_cnt",
+	}},
+	// the phc2sys high availability of the haProfiles setting
+	{"4.4", []string{
+		"ha_enabled", "ha_max_offset", "ha_min_offset", "ha_stability_timer",
+	}},
+}
+
+// linuxptpOptions are the options known by any of the supported linuxptp releases
+var linuxptpOptions = func() map[string]bool {
+	var options []string
+	for _, r := range linuxptpReleaseOptions {
+		options = append(options, r.options...)
+	}
+	return optionSet(options...)
+}()
+
+// unicastMasterTableOptions are the options of a [unicast_master_table] section
+var unicastMasterTableOptions = optionSet("table_id", "logQueryInterval", "peer_address", "UDPv4", "UDPv6", "L2")
+
+// synce4lOptions are the synce4l config file options of the global, device, port and external source sections
+var synce4lOptions = optionSet(
+	"logging_level", "use_syslog", "verbose", "message_tag", "poll_interval_msec", "smc_socket_path",
+	"network_option", "extended_tlv", "recover_time", "clock_id", "module_name", "dnu_prio", "eec_get_state_cmd",
+	"eec_holdover_value", "eec_locked_ho_value", "eec_locked_value", "eec_freerun_value", "eec_invalid_value",
+	"tx_heartbeat_msec", "rx_heartbeat_msec", "recover_clock_enable_cmd", "recover_clock_disable_cmd",
+	"allowed_qls", "allowed_ext_qls", "input_QL", "input_ext_QL", "internal_prio", "external_enable_cmd",
+	"external_disable_cmd", "board_label", "panel_label", "package_label",
+)
@@ -0,0 +1,96 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/pointer"
+)
+
+func Test_ValidateProfiles(t *testing.T) {
+	profiles := []ptpv1.PtpProfile{
+		{
+			Name:      pointer.String("bc"),
+			Interface: pointer.String("ens1f0"),
+			Ptp4lOpts: pointer.String("-2"),
+			Ptp4lConf: pointer.String("[ens1f1]\nmasterOnly 1\ntable_id 1\n[global]\ndomainNumber 24\nbogus_option 1\n"),
+		},
+		{
+			Name:                pointer.String("oc"),
+			Ptp4lOpts:           pointer.String("-2"),
+			Ptp4lConf:           pointer.String("[ens1f1]\nmasterOnly 0\n"),
+			Ts2PhcOpts:          pointer.String(" "),
+			Ts2PhcConf:          pointer.String("[global]\n[ens1f1]\n"),
+			Synce4lOpts:         pointer.String("-m"),
+			Synce4lConf:         pointer.String("[global]\n[<synce1>]\nnetwork_option 1\n"),
+			PtpSchedulingPolicy: pointer.String("SCHED_FIFO"),
+			PtpSettings:         map[string]string{GMStateMachineSetting: "rules: [{ts2phc: [LOCKING], state: FREERUN}]"},
+		},
+		{
+			Name:        pointer.String("ha"),
+			Phc2sysOpts: pointer.String("-a -r"),
+			Phc2sysConf: pointer.String("[global]\nha_enabled 1\nha_stability_timer 10\n"),
+			PtpSettings: map[string]string{PTP_HA_IDENTIFIER: "bc, missing", "phc2sys." + LogRulesSetting: "- match: \"(\"",
+				GMStateMachineSetting: event.StrictHoldoverGMStateMachine},
+		},
+		{Name: pointer.String("bc")},
+		{},
+	}
+	result := ValidateProfiles(profiles)
+	assert.True(t, result.HasErrors())
+
+	codes := func(profile string) map[ValidationCode]ValidationSeverity {
+		m := map[ValidationCode]ValidationSeverity{}
+		for _, i := range result.ForProfile(profile) {
+			m[i.Code] = i.Severity
+		}
+		return m
+	}
+	assert.Equal(t, map[ValidationCode]ValidationSeverity{
+		ValidationUnknownOption:   ValidationWarning,
+		ValidationMisplacedOption: ValidationError,
+		ValidationDuplicateName:   ValidationError,
+	}, codes("bc"))
+	assert.Equal(t, map[ValidationCode]ValidationSeverity{
+		ValidationScheduling:        ValidationError,
+		ValidationInterfaceConflict: ValidationError,
+		ValidationTs2phcNoSource:    ValidationError,
+		ValidationSynceNoPorts:      ValidationError,
+		ValidationGMStateMachine:    ValidationError,
+	}, codes("oc"))
+	assert.Equal(t, map[ValidationCode]ValidationSeverity{
+		ValidationUnknownHaProfile: ValidationError,
+		ValidationLogRules:         ValidationError,
+		ValidationGMStateMachine:   ValidationWarning,
+	}, codes("ha"))
+	assert.Equal(t, map[ValidationCode]ValidationSeverity{ValidationMissingName: ValidationError}, codes("#4"))
+	assert.ErrorContains(t, result.Err(), "interface ens1f1 is already used by profile bc")
+
+	hwconfigs := result.HwConfigs()
+	assert.Len(t, hwconfigs, 4)
+	assert.Equal(t, "bc", hwconfigs[0].DeviceID)
+	assert.Equal(t, ValidationHwConfigVendor, hwconfigs[0].VendorID)
+	assert.True(t, hwconfigs[0].Failed)
+	assert.Contains(t, string(hwconfigs[0].Config.Raw), `"code":"UnknownOption"`)
+
+	// warnings alone do not reject the profiles
+	warned := []ptpv1.PtpProfile{{Name: pointer.String("warned"), Ptp4lConf: pointer.String("[global]\nleapfile /x\n"),
+		PtpSettings: map[string]string{GMStateMachineSetting: event.StrictHoldoverGMStateMachine}}}
+	assert.False(t, ValidateProfiles(warned).HasErrors())
+	assert.Len(t, ValidateProfiles(warned).ForProfile("warned"), 1)
+
+	// a rejected update leaves the running processes and their profiles alone
+	running := testProcess(ptp4lProcessName, "ptp4l.0.config", "running")
+	dn := testDaemon(running)
+	dn.ptpUpdate.NodeProfiles = profiles
+	*dn.hwconfigs = []ptpv1.HwConfig{{DeviceID: "nic", VendorID: "8086"}}
+	_, err := dn.applyNodePTPProfiles()
+	assert.Error(t, err)
+	assert.Equal(t, []*ptpProcess{running}, dn.processManager.process)
+	assert.False(t, running.Stopped())
+	assert.Len(t, *dn.hwconfigs, 5)
+	assert.Equal(t, "nic", (*dn.hwconfigs)[0].DeviceID)
+	assert.True(t, *dn.refreshNodePtpDevice)
+}
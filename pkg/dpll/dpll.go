@@ -7,6 +7,7 @@ import (
 	"math"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -44,6 +45,10 @@ type dpllApiType string
 
 var MockDpllReplies chan *nl.DoDeviceGetReply
 
+// SysfsNetDir holds the network interfaces whose device directory has the sysfs DPLL files, see MonitorDpllSysfs.
+// Tests running the daemon without the hardware point it at fake files.
+var SysfsNetDir = "/sys/class/net"
+
 const (
 	SYSFS   dpllApiType = "sysfs"
 	NETLINK dpllApiType = "netlink"
@@ -120,6 +125,8 @@ type DpllConfig struct {
 	isMonitoring         bool
 	subscriber           []*DpllSubscriber
 	phaseOffsetPinFilter map[string]map[string]string
+	recorder             Recorder // records the inputs of the state decisions, see SetRecorder
+	replaying            bool     // the inputs are given with Replay, the holdover is driven by the recorded ticks
 }
 
 func (d *DpllConfig) InSpec() bool {
@@ -131,6 +138,11 @@ func (d *DpllConfig) DependsOn() []event.EventSource {
 	return d.dependsOn
 }
 
+// Iface ... interface the dpll is associated with
+func (d *DpllConfig) Iface() string {
+	return d.iface
+}
+
 // SetDependsOn ... set depends on ..
 func (d *DpllConfig) SetDependsOn(dependsOn []event.EventSource) {
 	d.dependsOn = dependsOn
@@ -196,21 +208,27 @@ func (s DpllSubscriber) Notify(source event.EventSource, state event.PTPState) {
 		glog.Errorf("dpll subscriber %s is not initialized (monitoring state %t)", s.source, s.dpll.isMonitoring)
 		return
 	}
+	s.dpll.notify(source, state)
+}
+
+// notify decides the state when the state of a source the DPLL depends on changed
+func (d *DpllConfig) notify(source event.EventSource, state event.PTPState) {
 	dependingProcessStateMap.Lock()
 	defer dependingProcessStateMap.Unlock()
 	currentState := dependingProcessStateMap.states[source]
 	if currentState != state {
+		d.record(Input{Source: source, State: state})
 		glog.Infof("%s notified on state change: from state %v to state %v", source, currentState, state)
 		dependingProcessStateMap.states[source] = state
 		if source == event.GNSS {
 			if state == event.PTP_LOCKED {
-				s.dpll.sourceLost = false
+				d.sourceLost = false
 			} else {
-				s.dpll.sourceLost = true
+				d.sourceLost = true
 			}
-			glog.Infof("sourceLost %v", s.dpll.sourceLost)
+			glog.Infof("sourceLost %v", d.sourceLost)
 		}
-		s.dpll.stateDecision()
+		d.stateDecision()
 		glog.Infof("%s notified on state change: state %v", source, state)
 		dependingProcessStateMap.UpdateState(source)
 	}
@@ -221,10 +239,14 @@ func (d *DpllConfig) Name() string {
 	return string(event.DPLL)
 }
 
-// Stopped ... stopped
+// Stopped ... the DPLL is stopped once CmdStop closed its exit channel
 func (d *DpllConfig) Stopped() bool {
-	//TODO implement me
-	panic("implement me")
+	select {
+	case <-d.exitCh:
+		return true
+	default:
+		return false
+	}
 }
 
 // ExitCh ... exit channel
@@ -275,6 +297,17 @@ func (d *DpllConfig) CmdRun(stdToSocket bool) {
 	// noting to run, monitor() function takes care of dpll run
 }
 
+// Ready ... dpll is ready once the api it is monitored through is available
+func (d *DpllConfig) Ready() bool {
+	switch d.apiType {
+	case NETLINK:
+		return d.isNetLinkPresent()
+	case SYSFS:
+		return d.isSysFsPresent()
+	}
+	return true
+}
+
 func (d *DpllConfig) unRegisterAll() {
 	// register to event notification from other processes
 	for _, s := range d.subscriber {
@@ -412,14 +445,14 @@ func (d *DpllConfig) monitorNtf(c *genetlink.Conn) {
 			}
 		}
 		if d.nlUpdateState(devices, pins) {
-			d.stateDecision()
+			d.statusRead()
 		}
 	}
 }
 
 // checks whether sysfs file structure exists for dpll associated with the interface
 func (d *DpllConfig) isSysFsPresent() bool {
-	path := fmt.Sprintf("/sys/class/net/%s/device/dpll_0_state", d.iface)
+	path := filepath.Join(SysfsNetDir, d.iface, "device", "dpll_0_state")
 	if _, err := os.Stat(path); err == nil {
 		return true
 	}
@@ -452,7 +485,7 @@ func (d *DpllConfig) MonitorDpllMock() {
 	glog.Info("starting dpll mock monitoring")
 
 	if d.nlUpdateState([]*nl.DoDeviceGetReply{<-MockDpllReplies}, []*nl.DoPinGetReply{}) {
-		d.stateDecision()
+		d.statusRead()
 	}
 
 	glog.Infof("closing dpll mock ")
@@ -489,7 +522,7 @@ func (d *DpllConfig) MonitorDpllNetlink() {
 			}
 
 			if d.nlUpdateState(replies, []*nl.DoPinGetReply{}) {
-				d.stateDecision()
+				d.statusRead()
 			}
 
 			err = c.JoinGroup(mcastId)
@@ -518,16 +551,14 @@ func (d *DpllConfig) MonitorDpllNetlink() {
 		select {
 		case <-d.exitCh:
 			glog.Infof("terminating netlink dpll monitoring")
-			select {
-			case d.processConfig.EventChannel <- event.EventChannel{
+			if !d.processConfig.EventQueue.Push(event.EventChannel{
 				ProcessName: event.DPLL,
 				IFace:       d.iface,
 				CfgName:     d.processConfig.ConfigName,
 				ClockType:   d.processConfig.ClockType,
 				Time:        time.Now().UnixMilli(),
 				Reset:       true,
-			}:
-			default:
+			}) {
 				glog.Error("failed to send dpll event terminated event")
 			}
 			// unregister from event notification from other processes
@@ -577,6 +608,9 @@ func (d *DpllConfig) stopDpll() {
 // MonitorProcess is initiating monitoring of DPLL associated with a process
 func (d *DpllConfig) MonitorProcess(processCfg config.ProcessConfig) {
 	d.processConfig = processCfg
+	if d.recorder != nil {
+		d.recorder.DpllStarted(processCfg.ConfigName, d.iface, d.settings())
+	}
 	// register to event notification from other processes
 	for _, dep := range d.dependsOn {
 		if dep == event.GNSS { //TODO: fow now no subscription for pps
@@ -632,7 +666,7 @@ func (d *DpllConfig) stateDecision() {
 		d.inSpec = false
 		d.sourceLost = true
 		if d.hasGNSSAsSource() && d.onHoldover {
-			d.holdoverCloseCh <- true
+			d.closeHoldover()
 		}
 		d.state = event.PTP_FREERUN
 		d.phaseOffset = FaultyPhaseOffset
@@ -641,7 +675,7 @@ func (d *DpllConfig) stateDecision() {
 	case DPLL_LOCKED:
 		if !d.sourceLost && d.isOffsetInRange() {
 			if d.hasGNSSAsSource() && d.onHoldover {
-				d.holdoverCloseCh <- true
+				d.closeHoldover()
 			}
 			glog.Infof("dpll is locked, offset is in range, state is LOCKED(%s)", d.iface)
 			d.state = event.PTP_LOCKED
@@ -668,12 +702,8 @@ func (d *DpllConfig) stateDecision() {
 			}
 		case !d.sourceLost && d.isOffsetInRange():
 			glog.Infof("dpll is locked, source is not lost, offset is in range, state is DPLL_LOCKED_HO_ACQ or DPLL_HOLDOVER(%s)", d.iface)
-			if d.hasGNSSAsSource() && d.onHoldover {
-				select {
-				case d.holdoverCloseCh <- true:
-					glog.Infof("closing holdover for %s since source is restored and locked ", d.iface)
-				default:
-				}
+			if d.hasGNSSAsSource() && d.onHoldover && d.tryCloseHoldover() {
+				glog.Infof("closing holdover for %s since source is restored and locked ", d.iface)
 			}
 			d.inSpec = true
 			d.state = event.PTP_LOCKED
@@ -682,17 +712,19 @@ func (d *DpllConfig) stateDecision() {
 				d.holdoverCloseCh = make(chan bool)
 				d.onHoldover = true
 				d.state = event.PTP_HOLDOVER
-				go d.holdover()
+				if d.replaying {
+					d.startHoldover()
+				} else {
+					go d.holdover()
+				}
 			}
 			return // do not send event holdover  will handle it
 		case !d.inSpec: // this is for GNSS only
 			glog.Infof("dpll is not in spec, state is DPLL_LOCKED_HO_ACQ or DPLL_HOLDOVER, offset is out of range, state is FREERUN(%s)", d.iface)
 			d.state = event.PTP_FREERUN
 			d.phaseOffset = FaultyPhaseOffset
-			select {
-			case d.holdoverCloseCh <- true:
+			if d.tryCloseHoldover() {
 				glog.Infof("closing holdover for %s since offset if out of spec", d.iface)
-			default:
 			}
 		}
 		d.sendDpllEvent()
@@ -712,7 +744,7 @@ func (d *DpllConfig) stateDecision() {
 
 // sendDpllEvent sends DPLL event to the event channel
 func (d *DpllConfig) sendDpllEvent() {
-	if d.processConfig.EventChannel == nil {
+	if d.processConfig.EventQueue == nil {
 		glog.Info("Skip event - dpll is not yet initialized")
 		return
 	}
@@ -740,10 +772,9 @@ func (d *DpllConfig) sendDpllEvent() {
 		WriteToLog:         true,
 		Reset:              false,
 	}
-	select {
-	case d.processConfig.EventChannel <- eventData:
+	if d.processConfig.EventQueue.Push(eventData) {
 		glog.Infof("dpll event sent for (%s)", d.iface)
-	default:
+	} else {
 		glog.Infof("failed to send dpll event, retying.(%s)", d.iface)
 	}
 }
@@ -775,23 +806,21 @@ func (d *DpllConfig) MonitorDpllSysfs() {
 		case <-d.ticker.C:
 			// Monitor DPLL
 			d.phaseStatus, d.frequencyStatus, d.phaseOffset = d.sysfs(d.iface)
-			d.stateDecision()
+			d.statusRead()
 		}
 	}
 }
 
 // sendDpllTerminationEvent sends a termination event to the event channel
 func (d *DpllConfig) sendDpllTerminationEvent() {
-	select {
-	case d.processConfig.EventChannel <- event.EventChannel{
+	if !d.processConfig.EventQueue.Push(event.EventChannel{
 		ProcessName: event.DPLL,
 		IFace:       d.iface,
 		CfgName:     d.processConfig.ConfigName,
 		ClockType:   d.processConfig.ClockType,
 		Time:        time.Now().UnixMilli(),
 		Reset:       true,
-	}:
-	default:
+	}) {
 		glog.Error("failed to send dpll terminated event")
 	}
 
@@ -827,42 +856,100 @@ func (d *DpllConfig) holdover() {
 	ticker := time.NewTicker(1 * time.Second)
 	defer func() {
 		ticker.Stop()
-		d.onHoldover = false
-		d.stateDecision()
+		d.endHoldover()
 	}()
-	d.sendDpllEvent()
-	glog.Infof("setting dpll holdover for max holdover %v", d.LocalHoldoverTimeout)
+	d.startHoldover()
 	for timeout := time.After(time.Duration(int64(d.LocalHoldoverTimeout) * int64(time.Second))); ; {
 		select {
 		case <-ticker.C:
-			d.phaseOffset = int64(math.Round((d.slope) * time.Since(start).Seconds()))
-			glog.Infof("(%s) time since holdover start %f, offset %d nanosecond holdover %s", d.iface, time.Since(start).Seconds(), d.phaseOffset, strconv.FormatBool(d.onHoldover))
-			if d.frequencyTraceable {
-				//TODO:  not implemented : add when syncE is handled here
-				// use  !d.isInSpecOffsetInRange()  to declare HOLDOVER with  clockClass 140
-				// !d.isMaxHoldoverOffsetInRange()  for clock class to move from 140 to 248 and event to FREERUN
-			} else if !d.isInSpecOffsetInRange() { // when holdover verify with local max holdover not with regular threshold
-				d.inSpec = false // will be in HO, Out of spec only if  frequency is traceable
-				d.state = event.PTP_FREERUN
-				d.sendDpllEvent()
+			elapsed := time.Since(start).Seconds()
+			d.record(Input{Holdover: elapsed})
+			if d.holdoverTick(elapsed) {
 				return
 			}
-			d.sendDpllEvent()
 		case <-timeout: // since ts2phc has same timer , ts2phc should also move out of holdover
-			d.inSpec = false // not in HO, Out of spec
-			d.state = event.PTP_FREERUN
-			d.phaseOffset = FaultyPhaseOffset
-			glog.Infof("holdover timer %d expired", d.timer)
-			d.sendDpllEvent()
+			d.record(Input{HoldoverExpired: true})
+			d.holdoverExpired()
 			return
 		case <-d.holdoverCloseCh:
-			glog.Info("holdover was closed")
-			d.inSpec = true // if someone else is closing then it should be back in spec (if it was not in spec before)
+			d.holdoverClosed()
 			return
 		}
 	}
 }
 
+// startHoldover sends the holdover event, the holdover is then driven by holdoverTick until it ends
+func (d *DpllConfig) startHoldover() {
+	d.sendDpllEvent()
+	glog.Infof("setting dpll holdover for max holdover %v", d.LocalHoldoverTimeout)
+}
+
+// holdoverTick estimates the phase offset elapsed seconds into the holdover, it returns true when the holdover is over
+func (d *DpllConfig) holdoverTick(elapsed float64) bool {
+	d.phaseOffset = int64(math.Round((d.slope) * elapsed))
+	glog.Infof("(%s) time since holdover start %f, offset %d nanosecond holdover %s", d.iface, elapsed, d.phaseOffset, strconv.FormatBool(d.onHoldover))
+	if d.frequencyTraceable {
+		//TODO:  not implemented : add when syncE is handled here
+		// use  !d.isInSpecOffsetInRange()  to declare HOLDOVER with  clockClass 140
+		// !d.isMaxHoldoverOffsetInRange()  for clock class to move from 140 to 248 and event to FREERUN
+	} else if !d.isInSpecOffsetInRange() { // when holdover verify with local max holdover not with regular threshold
+		d.inSpec = false // will be in HO, Out of spec only if  frequency is traceable
+		d.state = event.PTP_FREERUN
+		d.sendDpllEvent()
+		return true
+	}
+	d.sendDpllEvent()
+	return false
+}
+
+// holdoverExpired moves to FREERUN once the holdover lasted LocalHoldoverTimeout
+func (d *DpllConfig) holdoverExpired() {
+	d.inSpec = false // not in HO, Out of spec
+	d.state = event.PTP_FREERUN
+	d.phaseOffset = FaultyPhaseOffset
+	glog.Infof("holdover timer %d expired", d.timer)
+	d.sendDpllEvent()
+}
+
+// holdoverClosed ends a holdover closed by stateDecision
+func (d *DpllConfig) holdoverClosed() {
+	glog.Info("holdover was closed")
+	d.inSpec = true // if someone else is closing then it should be back in spec (if it was not in spec before)
+}
+
+// endHoldover decides the state once the holdover is over
+func (d *DpllConfig) endHoldover() {
+	d.onHoldover = false
+	d.stateDecision()
+}
+
+// closeHoldover closes the holdover, waiting for the holdover goroutine to receive it
+func (d *DpllConfig) closeHoldover() {
+	if d.replaying {
+		d.holdoverClosed()
+		d.endHoldover()
+		return
+	}
+	d.holdoverCloseCh <- true
+}
+
+// tryCloseHoldover closes the holdover when the holdover goroutine is ready to receive it, it returns whether it did
+func (d *DpllConfig) tryCloseHoldover() bool {
+	if d.replaying {
+		if !d.onHoldover {
+			return false
+		}
+		d.closeHoldover()
+		return true
+	}
+	select {
+	case d.holdoverCloseCh <- true:
+		return true
+	default:
+		return false
+	}
+}
+
 func (d *DpllConfig) isMaxHoldoverOffsetInRange() bool {
 	if d.phaseOffset <= int64(d.LocalMaxHoldoverOffSet) {
 		return true
@@ -914,9 +1001,9 @@ func (d *DpllConfig) sysfs(iface string) (phaseState, frequencyState, phaseOffse
 		return value, nil
 	}
 
-	frequencyStateStr := fmt.Sprintf("/sys/class/net/%s/device/dpll_0_state", iface)
-	phaseStateStr := fmt.Sprintf("/sys/class/net/%s/device/dpll_1_state", iface)
-	phaseOffsetStr := fmt.Sprintf("/sys/class/net/%s/device/dpll_1_offset", iface)
+	frequencyStateStr := filepath.Join(SysfsNetDir, iface, "device", "dpll_0_state")
+	phaseStateStr := filepath.Join(SysfsNetDir, iface, "device", "dpll_1_state")
+	phaseOffsetStr := filepath.Join(SysfsNetDir, iface, "device", "dpll_1_offset")
 
 	frequencyState, err := readInt64FromFile(frequencyStateStr)
 	if err != nil {
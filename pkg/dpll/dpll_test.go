@@ -171,14 +171,14 @@ func getTestData(source event.EventSource, pinType uint32) []DpllTestCase {
 func TestDpllConfig_MonitorProcessGNSS(t *testing.T) {
 	dpll.MockDpllReplies = make(chan *nl.DoDeviceGetReply, 1)
 	assert.True(t, dpll.MockDpllReplies != nil)
-	eChannel := make(chan event.EventChannel, 10)
+	eQueue := event.NewQueue("node", event.DefaultQueueCapacity, nil, nil, nil)
 	closeChn := make(chan bool)
 	// event has to be running before dpll is started
-	eventProcessor := event.Init("node", false, "/tmp/go.sock", eChannel, closeChn, nil, nil, nil)
+	eventProcessor := event.Init("node", false, "/tmp/go.sock", eQueue, closeChn, nil, nil, nil)
 	d := dpll.NewDpll(clockid, 10, 2, 5, "ens01",
 		[]event.EventSource{event.GNSS}, dpll.MOCK, map[string]map[string]string{})
 	d.CmdInit()
-	eventChannel := make(chan event.EventChannel, 10)
+	eventQueue := event.NewQueue("node", event.DefaultQueueCapacity, nil, nil, nil)
 	go eventProcessor.ProcessEvents()
 
 	time.Sleep(5 * time.Second)
@@ -186,7 +186,7 @@ func TestDpllConfig_MonitorProcessGNSS(t *testing.T) {
 		d.MonitorProcess(config.ProcessConfig{
 			ClockType:       "GM",
 			ConfigName:      "test",
-			EventChannel:    eventChannel,
+			EventQueue:      eventQueue,
 			GMThreshold:     config.Threshold{},
 			InitialPTPState: event.PTP_FREERUN,
 		})
@@ -213,14 +213,14 @@ func TestDpllConfig_MonitorProcessGNSS(t *testing.T) {
 func TestDpllConfig_MonitorProcessPPS(t *testing.T) {
 	dpll.MockDpllReplies = make(chan *nl.DoDeviceGetReply, 1)
 	assert.True(t, dpll.MockDpllReplies != nil)
-	eChannel := make(chan event.EventChannel, 10)
+	eQueue := event.NewQueue("node", event.DefaultQueueCapacity, nil, nil, nil)
 	closeChn := make(chan bool)
 	// event has to be running before dpll is started
-	eventProcessor := event.Init("node", false, "/tmp/go.sock", eChannel, closeChn, nil, nil, nil)
+	eventProcessor := event.Init("node", false, "/tmp/go.sock", eQueue, closeChn, nil, nil, nil)
 	d := dpll.NewDpll(clockid, 10, 2, 5, "ens01",
 		[]event.EventSource{event.GNSS}, dpll.MOCK, map[string]map[string]string{})
 	d.CmdInit()
-	eventChannel := make(chan event.EventChannel, 10)
+	eventQueue := event.NewQueue("node", event.DefaultQueueCapacity, nil, nil, nil)
 	go eventProcessor.ProcessEvents()
 
 	time.Sleep(5 * time.Second)
@@ -228,7 +228,7 @@ func TestDpllConfig_MonitorProcessPPS(t *testing.T) {
 		d.MonitorProcess(config.ProcessConfig{
 			ClockType:       "GM",
 			ConfigName:      "test",
-			EventChannel:    eventChannel,
+			EventQueue:      eventQueue,
 			GMThreshold:     config.Threshold{},
 			InitialPTPState: event.PTP_FREERUN,
 		})
@@ -290,3 +290,50 @@ func TestSlopeAndTimer(t *testing.T) {
 		assert.Equal(t, tt.expectedSlope, d.Slope(), "Slope")
 	}
 }
+
+func TestDpllConfig_Stopped(t *testing.T) {
+	d := dpll.NewDpll(100, dpll.LocalMaxHoldoverOffSet, dpll.LocalHoldoverTimeout, dpll.MaxInSpecOffset,
+		"test", []event.EventSource{event.GNSS}, dpll.MOCK, map[string]map[string]string{})
+	assert.False(t, d.Stopped())
+	d.CmdStop()
+	assert.True(t, d.Stopped())
+}
+
+func TestDpllConfig_Replay(t *testing.T) {
+	eventQueue := event.NewQueue("node", event.DefaultQueueCapacity, nil, nil, nil)
+	// 60ns of phase offset per second of holdover, out of spec after 2 seconds
+	d := dpll.NewReplayDpll("ts2phc.0.config", "ens01", dpll.Settings{
+		ClockID:                clockid,
+		LocalMaxHoldoverOffSet: 6000,
+		LocalHoldoverTimeout:   100,
+		MaxInSpecOffset:        100,
+		DependsOn:              []event.EventSource{event.GNSS},
+		ClockType:              event.GM,
+		GMThreshold:            config.Threshold{Max: 100, Min: -100},
+	}, eventQueue)
+	locked := &dpll.Status{PhaseStatus: dpll.DPLL_LOCKED_HO_ACQ, FrequencyStatus: dpll.DPLL_LOCKED_HO_ACQ, PhaseOffset: 5}
+
+	for _, tt := range []struct {
+		in            dpll.Input
+		expectedState event.PTPState
+		desc          string
+	}{
+		{dpll.Input{Status: locked}, event.PTP_LOCKED, "locked with the offset in range"},
+		{dpll.Input{Source: event.GNSS, State: event.PTP_LOCKED}, event.PTP_LOCKED, "GNSS locked"},
+		{dpll.Input{Source: event.GNSS, State: event.PTP_FREERUN}, event.PTP_HOLDOVER, "GNSS lost while in spec"},
+		{dpll.Input{Holdover: 1}, event.PTP_HOLDOVER, "60ns after a second of holdover"},
+		{dpll.Input{Holdover: 2}, event.PTP_FREERUN, "120ns after two seconds of holdover"},
+		{dpll.Input{Holdover: 3}, event.PTP_FREERUN, "ticks after the holdover ended are ignored"},
+		{dpll.Input{Source: event.GNSS, State: event.PTP_LOCKED}, event.PTP_FREERUN, "the offset is not read yet"},
+		{dpll.Input{Status: locked}, event.PTP_LOCKED, "locked again"},
+	} {
+		d.Replay(tt.in)
+		assert.Equal(t, tt.expectedState, d.State(), tt.desc)
+	}
+	var states []event.PTPState
+	for eventQueue.Len() > 0 {
+		states = append(states, (<-eventQueue.Out()).State)
+	}
+	assert.Contains(t, states, event.PTP_HOLDOVER, "the holdover events are sent")
+	assert.Equal(t, event.PTP_LOCKED, states[len(states)-1])
+}
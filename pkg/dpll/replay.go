@@ -0,0 +1,120 @@
+package dpll
+
+import (
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/config"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+)
+
+// Recorder records what a DPLL is monitored with and the inputs of its state decisions, so that they can be replayed
+type Recorder interface {
+	DpllStarted(cfgName, iface string, settings Settings)
+	DpllInput(cfgName, iface string, in Input)
+}
+
+// Settings are what a DPLL is created and monitored with, see NewDpll and MonitorProcess
+type Settings struct {
+	ClockID                uint64              `json:"clockId"`
+	LocalMaxHoldoverOffSet uint64              `json:"localMaxHoldoverOffSet"`
+	LocalHoldoverTimeout   uint64              `json:"localHoldoverTimeout"`
+	MaxInSpecOffset        uint64              `json:"maxInSpecOffset"`
+	DependsOn              []event.EventSource `json:"dependsOn,omitempty"`
+	APIType                string              `json:"apiType"`
+	ClockType              event.ClockType     `json:"clockType,omitempty"`
+	GMThreshold            config.Threshold    `json:"gmThreshold"`
+}
+
+// Input is an input of the DPLL state decision, one of its fields is set
+type Input struct {
+	// Status is the lock statuses and phase offset read from netlink or sysfs
+	Status *Status `json:"status,omitempty"`
+	// Source changed to State, it is a source the DPLL depends on
+	Source event.EventSource `json:"source,omitempty"`
+	State  event.PTPState    `json:"state,omitempty"`
+	// Holdover is the number of seconds since the holdover started, on every holdover tick
+	Holdover float64 `json:"holdover,omitempty"`
+	// HoldoverExpired is set when the holdover lasted LocalHoldoverTimeout
+	HoldoverExpired bool `json:"holdoverExpired,omitempty"`
+}
+
+// Status is the state of the DPLL read from the hardware
+type Status struct {
+	PhaseStatus     int64 `json:"phaseStatus"`
+	FrequencyStatus int64 `json:"frequencyStatus"`
+	PhaseOffset     int64 `json:"phaseOffset"`
+}
+
+// SetRecorder records the settings and the inputs of the DPLL to r, it must be called before MonitorProcess
+func (d *DpllConfig) SetRecorder(r Recorder) {
+	d.recorder = r
+}
+
+func (d *DpllConfig) record(in Input) {
+	if d.recorder != nil {
+		d.recorder.DpllInput(d.processConfig.ConfigName, d.iface, in)
+	}
+}
+
+// settings returns what the DPLL is monitored with
+func (d *DpllConfig) settings() Settings {
+	return Settings{
+		ClockID:                d.clockId,
+		LocalMaxHoldoverOffSet: d.LocalMaxHoldoverOffSet,
+		LocalHoldoverTimeout:   d.LocalHoldoverTimeout,
+		MaxInSpecOffset:        d.MaxInSpecOffset,
+		DependsOn:              d.dependsOn,
+		APIType:                string(d.apiType),
+		ClockType:              d.processConfig.ClockType,
+		GMThreshold:            d.processConfig.GMThreshold,
+	}
+}
+
+// statusRead records the lock statuses and phase offset just read and decides the state from them
+func (d *DpllConfig) statusRead() {
+	d.record(Input{Status: &Status{PhaseStatus: d.phaseStatus, FrequencyStatus: d.frequencyStatus, PhaseOffset: d.phaseOffset}})
+	d.stateDecision()
+}
+
+// NewReplayDpll creates a DPLL with recorded settings, which sends its events to eventQueue.
+// It reads nothing from the hardware nor runs timers, its inputs are given with Replay.
+func NewReplayDpll(cfgName, iface string, s Settings, eventQueue *event.Queue) *DpllConfig {
+	d := NewDpll(s.ClockID, s.LocalMaxHoldoverOffSet, s.LocalHoldoverTimeout, s.MaxInSpecOffset, iface, s.DependsOn,
+		dpllApiType(s.APIType), nil)
+	d.ticker.Stop()
+	d.replaying = true
+	d.processConfig = config.ProcessConfig{
+		ClockType:       s.ClockType,
+		ConfigName:      cfgName,
+		EventQueue:      eventQueue,
+		GMThreshold:     s.GMThreshold,
+		InitialPTPState: event.PTP_FREERUN,
+	}
+	dependingProcessStateMap.Lock()
+	defer dependingProcessStateMap.Unlock()
+	for _, dep := range d.dependsOn {
+		if dep == event.GNSS {
+			dependingProcessStateMap.states[dep] = event.PTP_UNKNOWN
+		}
+	}
+	if d.apiType == SYSFS {
+		d.inSpec = true // see MonitorDpllSysfs
+	}
+	return d
+}
+
+// Replay decides the state from a recorded input, as the DPLL that recorded it did
+func (d *DpllConfig) Replay(in Input) {
+	switch {
+	case in.Status != nil:
+		d.phaseStatus, d.frequencyStatus, d.phaseOffset = in.Status.PhaseStatus, in.Status.FrequencyStatus, in.Status.PhaseOffset
+		d.stateDecision()
+	case in.Source != "":
+		d.notify(in.Source, in.State)
+	case in.Holdover > 0 && d.onHoldover:
+		if d.holdoverTick(in.Holdover) {
+			d.endHoldover()
+		}
+	case in.HoldoverExpired && d.onHoldover:
+		d.holdoverExpired()
+		d.endHoldover()
+	}
+}
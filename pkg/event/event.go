@@ -3,19 +3,24 @@ package event
 import (
 	"fmt"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/debug"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/eventsocket"
 	"net"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/pmc"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/protocol"
 
 	fbprotocol "github.com/facebook/time/ptp/protocol"
 	"github.com/golang/glog"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/history"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/logging"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -42,6 +47,8 @@ const (
 	CLOCK_QUALITY        ValueType = "clock_quality"
 	NETWORK_OPTION       ValueType = "network_option"
 	EEC_STATE                      = "eec_state"
+	STRATUM              ValueType = "stratum"
+	LEAP_STATUS          ValueType = "leap_status"
 )
 
 var valueTypeHelpTxt = map[ValueType]string{
@@ -51,6 +58,7 @@ var valueTypeHelpTxt = map[ValueType]string{
 	FREQUENCY_STATUS: "-1=UNKNOWN, 0=INVALID, 1=FREERUN, 2=LOCKED, 3=LOCKED_HO_ACQ, 4=HOLDOVER",
 	NMEA_STATUS:      "0 = UNAVAILABLE, 1 = AVAILABLE",
 	PPS_STATUS:       "0 = UNAVAILABLE, 1 = AVAILABLE",
+	LEAP_STATUS:      "0 = NORMAL, 1 = INSERT SECOND, 2 = DELETE SECOND, 3 = NOT SYNCHRONISED",
 }
 
 // ClockType ...
@@ -93,6 +101,9 @@ const (
 	OC ClockType = "OC"
 )
 
+// HeartbeatInterval is the interval the event loop beats at, see LastHeartbeat
+const HeartbeatInterval = 5 * time.Second
+
 // PTP4lProcessName ...
 const PTP4lProcessName = "ptp4l"
 
@@ -114,6 +125,8 @@ const (
 	PPS        EventSource = "1pps"
 	SYNCE      EventSource = "synce4l"
 	MONITORING EventSource = "monitoring"
+	CHRONY     EventSource = "chronyd"
+	GMSTATE    EventSource = "gm" // the grandmaster state derived from the other sources, only published to the StateNotifier
 )
 
 // PTPState ...
@@ -156,18 +169,26 @@ type EventHandler struct {
 	nodeName           string
 	stdoutSocket       string
 	stdoutToSocket     bool
-	processChannel     <-chan EventChannel
+	queue              *Queue
 	closeCh            chan bool
 	data               map[string][]*Data
 	offsetMetric       *prometheus.GaugeVec
 	clockMetric        *prometheus.GaugeVec
 	clockClassMetric   *prometheus.GaugeVec
-	clockClass         fbprotocol.ClockClass
-	clockAccuracy      fbprotocol.ClockAccuracy
+	clockQuality       map[string]fbprotocol.ClockQuality // clock class and accuracy last set, by config, guarded by the mutex
+	gmStateMachines    map[string]*GMStateMachine         // by config when not the default one, guarded by the mutex
 	gmSyncState        map[string]*grandMasterSyncState
-	outOfSpec          bool // is offset out of spec, used for Lost Source,In Spec and OPut of Spec state transitions
-	frequencyTraceable bool // will be tru if synce is traceable
-	ReduceLog          bool // reduce logs for every announce
+	outOfSpec          map[string]bool          // is offset out of spec, by config, used for Lost Source,In Spec and OPut of Spec state transitions
+	frequencyTraceable map[string]bool          // will be tru if synce is traceable, by config
+	ReduceLog          bool                     // reduce logs for every announce
+	heartbeat          atomic.Int64             // unix nano time the event loop was last seen running, see LastHeartbeat
+	statusCh           chan chan []apiv1.Config // status requests answered by the event loop, see Status
+	drainCh            chan chan struct{}       // closed by the event loop once it gets to them, see Drain
+	clockClassUpdates  sync.WaitGroup           // clock class requests not handled yet, see Drain
+	tap                func(EventChannel)       // sees every event before it is processed, see SetTap
+	history            *history.History         // records the transitions, see SetHistory
+	// lastStates holds the state of the last event recorded, by source, see recordTransitions
+	lastStates map[historyKey]sourceState
 }
 
 // EventChannel .. event channel to subscriber to events
@@ -191,29 +212,37 @@ var (
 	StateRegisterer *StateNotifier
 )
 
+// SetTap calls tap with every event received, in the event loop. It must be set before ProcessEvents runs.
+func (e *EventHandler) SetTap(tap func(EventChannel)) {
+	e.tap = tap
+}
+
 // MockEnable ...
 func (e *EventHandler) MockEnable() {
 	mockTest = true
 }
 
 // Init ... initialize event manager
-func Init(nodeName string, stdOutToSocket bool, socketName string, processChannel chan EventChannel, closeCh chan bool,
+func Init(nodeName string, stdOutToSocket bool, socketName string, queue *Queue, closeCh chan bool,
 	offsetMetric *prometheus.GaugeVec, clockMetric *prometheus.GaugeVec, clockClassMetric *prometheus.GaugeVec) *EventHandler {
 	ptpEvent := &EventHandler{
 		nodeName:           nodeName,
 		stdoutSocket:       socketName,
 		stdoutToSocket:     stdOutToSocket,
 		closeCh:            closeCh,
-		processChannel:     processChannel,
+		queue:              queue,
 		data:               map[string][]*Data{},
 		clockMetric:        clockMetric,
 		offsetMetric:       offsetMetric,
 		clockClassMetric:   clockClassMetric,
-		clockClass:         protocol.ClockClassUninitialized,
+		clockQuality:       map[string]fbprotocol.ClockQuality{},
 		gmSyncState:        map[string]*grandMasterSyncState{},
-		outOfSpec:          false,
-		frequencyTraceable: false,
+		outOfSpec:          map[string]bool{},
+		frequencyTraceable: map[string]bool{},
 		ReduceLog:          true,
+		statusCh:           make(chan chan []apiv1.Config),
+		drainCh:            make(chan chan struct{}),
+		lastStates:         map[historyKey]sourceState{},
 	}
 	if clockClassMetric != nil {
 		clockClassMetric.With(prometheus.Labels{
@@ -283,32 +312,8 @@ DPLL| Frequency/Phase      | Offset      | GNSS STATE               | DPLL PTP S
 14. | 4 (HOLDOVER)		| in Range       | FREERUN (SL)             | LOCKED
 15. | 4 (HOLDOVER)		| Out Range      | FREERUN (SL)             | FREERUN
 ------------------------------------------------------------------------------------------
-FINAL GM STATE  *SL = Source Lost
----------------------------------------------------------------------------------------------
-| DPLL PTP State        | GNSS PTP STATE    | TS2PHC PTP STATE | GM STATE  | Clock Class
----------------------------------------------------------------------------------------------
-| FREERUN               | NA                | NA                | FREERUN  | 248
-| HOLDOVER IN SPEC      | NA                | NA                | HOLDOVER | 7
-| FREERUN OUT OF SPEC   | NA                | NA                | FREERUN  | 140
-| LOCKED                | LOCKED            | LOCKED            | LOCKED   | 6
-| LOCKED                | LOCKED            | FREERUN           | FREERUN  | 248
-| LOCKED                | *FREERUN (SL)     | LOCKED            | NA       | Wait for DPLL
-                                                                           | to move to HOLDOVER
-
-| LOCKED                | *FREERUN (SL)     | FREERUN           | NA       | Wait for DPLL
-                                                                           |to move to HOLDOVER
-
-| LOCKED                | *FREERUN(offset)  | LOCKED            | FREERUN  | 248
-| LOCKED                | *FREERUN(offset)  | FREERUN           | FREERUN  | 248
- Final GM State When DPLL not available
----------------------------------------------------------------------------------------------
-DPLL PTP State |  GNSS PTP STATE  |	TS2PHC PTP STATE| GM STATE | Clock Class
----------------------------------------------------------------------------------------------
-| NA           |  FREERUN         |	LOCKED          | FREERUN  | 248
-| NA           |  FREERUN         |	FREERUN         | FREERUN  | 248
-| NA           |  LOCKED          |	FREERUN         | FREERUN  | 248
-| NA           |  LOCKED          |	LOCKED          | LOCKED   | 6
-
+The final GM state and clock class are derived from the DPLL, GNSS and ts2phc states by the GM state machine
+of the config, see defaultGMRules for the default transitions and ParseGMStateMachine for the ones a profile can select.
 */
 func (e *EventHandler) updateGMState(cfgName string) grandMasterSyncState {
 	dpllState := PTP_NOTSET
@@ -355,98 +360,17 @@ func (e *EventHandler) updateGMState(cfgName string) grandMasterSyncState {
 		return *e.gmSyncState[cfgName]
 	}
 	e.gmSyncState[cfgName].gmIFace = gmInterface
-	switch dpllState {
-	case PTP_FREERUN: // This is OVER ALL State with HOLDOVER having the highest priority
-		// add check so that clock class won't change if GM was in HOLDOVER state
-		e.gmSyncState[cfgName].state = dpllState
-		// T-GM or T-BC in free-run mode
-		if e.outOfSpec && e.frequencyTraceable {
-			// T-GM in holdover, out of holdover specification
-			e.gmSyncState[cfgName].clockClass = protocol.ClockClassOutOfSpec
-		} else { // from holdover it goes to out of spec to free run
-			// T-GM or T-BC in free-run mode
-			e.gmSyncState[cfgName].clockClass = protocol.ClockClassFreerun
+	machine := e.gmStateMachine(cfgName)
+	in := GMInputs{DPLL: dpllState, GNSS: gnssState, TS2PHC: ts2phcState, SourceLost: gnssSrcLost,
+		OutOfSpec: e.outOfSpec[cfgName] && e.frequencyTraceable[cfgName]}
+	if i := machine.Next(in); i >= 0 && machine.Rules[i].State != "" {
+		rule := machine.Rules[i]
+		e.gmSyncState[cfgName].state = rule.State
+		if rule.ClockClass != 0 {
+			e.gmSyncState[cfgName].clockClass = rule.ClockClass
 		}
-		e.gmSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyUnknown
-	case PTP_HOLDOVER:
-		e.gmSyncState[cfgName].state = dpllState
-		// T-GM in holdover, within holdover specification
-		e.gmSyncState[cfgName].clockClass = fbprotocol.ClockClass7
-	case PTP_LOCKED, PTP_NOTSET: // consider DPLL is locked if DPLL is not available
-		switch gnssState {
-		case PTP_LOCKED:
-			switch ts2phcState {
-			case PTP_FREERUN:
-				e.gmSyncState[cfgName].state = PTP_FREERUN
-				// T-GM or T-BC in free-run mode
-				e.gmSyncState[cfgName].clockClass = protocol.ClockClassFreerun
-				e.gmSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyUnknown
-			case PTP_LOCKED:
-				e.gmSyncState[cfgName].state = PTP_LOCKED
-				// T-GM connected to a PRTC in locked mode (e.g., PRTC traceable to GNSS)
-				e.gmSyncState[cfgName].clockClass = fbprotocol.ClockClass6
-				e.gmSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyNanosecond100
-			case PTP_HOLDOVER:
-				e.gmSyncState[cfgName].state = PTP_HOLDOVER
-				e.gmSyncState[cfgName].clockClass = fbprotocol.ClockClass7
-			}
-		case PTP_FREERUN:
-			if gnssSrcLost {
-				switch ts2phcState {
-				case PTP_LOCKED, PTP_FREERUN:
-				// stay with last GM state and wait for DPLL to move to HOLDOVER
-				case PTP_HOLDOVER:
-					e.gmSyncState[cfgName].state = PTP_HOLDOVER
-					e.gmSyncState[cfgName].clockClass = fbprotocol.ClockClass7
-				}
-			} else {
-				switch ts2phcState {
-				case PTP_FREERUN, PTP_LOCKED, PTP_UNKNOWN, PTP_NOTSET:
-					e.gmSyncState[cfgName].state = PTP_FREERUN
-					// T-GM or T-BC in free-run mode
-					e.gmSyncState[cfgName].clockClass = protocol.ClockClassFreerun
-					e.gmSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyUnknown
-				}
-			}
-		}
-	default:
-		switch gnssState {
-		case PTP_LOCKED:
-			switch ts2phcState {
-			case PTP_FREERUN, PTP_UNKNOWN, PTP_NOTSET:
-				e.gmSyncState[cfgName].state = PTP_FREERUN
-				// T-GM or T-BC in free-run mode
-				e.gmSyncState[cfgName].clockClass = protocol.ClockClassFreerun
-				e.gmSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyUnknown
-			case PTP_LOCKED:
-				e.gmSyncState[cfgName].state = PTP_LOCKED
-				// T-GM connected to a PRTC in locked mode (e.g., PRTC traceable to GNSS)
-				e.gmSyncState[cfgName].clockClass = fbprotocol.ClockClass6
-				e.gmSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyNanosecond100
-			case PTP_HOLDOVER:
-				e.gmSyncState[cfgName].state = PTP_HOLDOVER
-				e.gmSyncState[cfgName].clockClass = fbprotocol.ClockClass7 //TODO: check if this is correct
-			}
-		case PTP_FREERUN:
-			switch ts2phcState {
-			case PTP_FREERUN, PTP_LOCKED, PTP_UNKNOWN, PTP_NOTSET: // when GNSS is lost ts2phc will stop printing and will wait to move to HOLDOVER
-				e.gmSyncState[cfgName].state = PTP_FREERUN
-				e.gmSyncState[cfgName].clockClass = protocol.ClockClassFreerun
-				e.gmSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyUnknown
-			case PTP_HOLDOVER: // if holdover is detected then wait for ts2phc to move to HOLDOVER
-				e.gmSyncState[cfgName].state = PTP_HOLDOVER
-				e.gmSyncState[cfgName].clockClass = fbprotocol.ClockClass7 //TODO: check if this is correct
-			}
-		default: // bad case
-			e.gmSyncState[cfgName].state = ts2phcState
-			switch ts2phcState {
-			case PTP_FREERUN:
-				e.gmSyncState[cfgName].clockClass = protocol.ClockClassFreerun
-				e.gmSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyUnknown
-			case PTP_LOCKED:
-				e.gmSyncState[cfgName].clockClass = fbprotocol.ClockClass7
-				e.gmSyncState[cfgName].clockAccuracy = fbprotocol.ClockAccuracyNanosecond100
-			}
+		if rule.ClockAccuracy != 0 {
+			e.gmSyncState[cfgName].clockAccuracy = rule.ClockAccuracy
 		}
 	}
 	gSycState := e.gmSyncState[cfgName]
@@ -464,11 +388,39 @@ func (e *EventHandler) updateGMState(cfgName string) grandMasterSyncState {
 		e.gmSyncState[cfgName].lastLoggedTime = logTime
 		e.gmSyncState[cfgName].gmLog = gmLog
 		rGrandMasterSyncState.gmLog = gmLog
-		glog.Infof("dpll State %s, gnss State %s, tsphc state %s, gm state %s,", dpllState, gnssState, ts2phcState, e.gmSyncState[cfgName].state)
+		glog.Infof("dpll State %s, gnss State %s, tsphc state %s, gm state %s, state machine %s", dpllState, gnssState, ts2phcState,
+			e.gmSyncState[cfgName].state, machine.Name)
 	}
 	return rGrandMasterSyncState
 }
 
+// SetGMStateMachine sets the GM state machine of cfgName, the default one when m is nil
+func (e *EventHandler) SetGMStateMachine(cfgName string, m *GMStateMachine) {
+	if e == nil {
+		return
+	}
+	e.Lock()
+	defer e.Unlock()
+	if m == nil {
+		delete(e.gmStateMachines, cfgName)
+		return
+	}
+	if e.gmStateMachines == nil {
+		e.gmStateMachines = map[string]*GMStateMachine{}
+	}
+	e.gmStateMachines[cfgName] = m
+}
+
+// gmStateMachine returns the GM state machine of cfgName
+func (e *EventHandler) gmStateMachine(cfgName string) *GMStateMachine {
+	e.Lock()
+	defer e.Unlock()
+	if m, ok := e.gmStateMachines[cfgName]; ok {
+		return m
+	}
+	return defaultGMStateMachine
+}
+
 func (e *EventHandler) getGMState(cfgName string) grandMasterSyncState {
 	if g, ok := e.gmSyncState[cfgName]; ok {
 		return *g
@@ -517,9 +469,31 @@ func (e *EventHandler) getGNSSInterface(cfgName string) string {
 func (e *EventHandler) updateSpecState(event EventChannel) {
 	// update if DPLL holdover is out of spec
 	if event.ProcessName == DPLL {
-		e.outOfSpec = event.OutOfSpec
-		e.frequencyTraceable = event.FrequencyTraceable
+		e.outOfSpec[event.CfgName] = event.OutOfSpec
+		e.frequencyTraceable[event.CfgName] = event.FrequencyTraceable
+	}
+}
+
+// getClockQuality returns the clock class and accuracy last set on the ptp4l of cfgName
+func (e *EventHandler) getClockQuality(cfgName string) (fbprotocol.ClockClass, fbprotocol.ClockAccuracy) {
+	e.Lock()
+	defer e.Unlock()
+	if q, ok := e.clockQuality[cfgName]; ok {
+		return q.ClockClass, q.ClockAccuracy
 	}
+	return protocol.ClockClassUninitialized, fbprotocol.ClockAccuracyUnknown
+}
+
+func (e *EventHandler) setClockQuality(cfgName string, clockClass fbprotocol.ClockClass, clockAccuracy fbprotocol.ClockAccuracy) {
+	e.Lock()
+	defer e.Unlock()
+	e.clockQuality[cfgName] = fbprotocol.ClockQuality{ClockClass: clockClass, ClockAccuracy: clockAccuracy}
+}
+
+func (e *EventHandler) resetClockQuality(cfgName string) {
+	e.Lock()
+	defer e.Unlock()
+	delete(e.clockQuality, cfgName)
 }
 func (e *EventHandler) toString() string {
 	// update if DPLL holdover is out of spec
@@ -570,20 +544,26 @@ func (e *EventHandler) ProcessEvents() {
 	redialClockClass := true
 	retryCount := 0
 	defer func() {
+		e.queue.Close()
 		if e.stdoutToSocket && c != nil {
 			if err = c.Close(); err != nil {
 				glog.Errorf("closing connection returned error %s", err)
 			}
 		}
 	}()
-	var lastgmState PTPState
+	lastgmState := map[string]PTPState{}     // by config
+	lastChronyState := map[string]PTPState{} // by config
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
 connect:
+	e.beat()
 	select {
 	case <-e.closeCh:
 		return
 	default:
 		if e.stdoutToSocket {
-			c, err = net.Dial("unix", e.stdoutSocket)
+			var conn *eventsocket.Conn
+			conn, err = eventsocket.Dial(e.stdoutSocket)
 			if err != nil {
 				// reduce log spam
 				if retryCount == 0 || retryCount%5 == 0 {
@@ -594,6 +574,8 @@ connect:
 				goto connect
 			}
 			retryCount = 0
+			c = conn
+			glog.Infof("connected to event socket in %s mode", conn.Mode())
 		}
 	}
 
@@ -607,7 +589,10 @@ connect:
 			for {
 				select {
 				case clk := <-clockClassRequestCh:
-					e.UpdateClockClass(c, clk)
+					func() {
+						defer e.clockClassUpdates.Done()
+						e.UpdateClockClass(c, clk)
+					}()
 				case <-e.closeCh:
 					return
 				}
@@ -631,7 +616,11 @@ connect:
 	glog.Info("starting state monitoring...")
 	for {
 		select {
-		case event := <-e.processChannel: // for non GM this thread will be in sleep forever
+		case event := <-e.queue.Out(): // for non GM this thread will be in sleep forever
+			if e.tap != nil {
+				e.tap(event)
+			}
+			e.recordTransitions(event)
 			// ts2phc[123455]:[ts2phc.0.config] 12345 s0 offset/gps
 			// replace ts2phc logs here
 			if event.Reset { // clean up
@@ -639,8 +628,7 @@ connect:
 				if event.ProcessName == TS2PHC {
 					e.unregisterMetrics(event.CfgName, "")
 					delete(e.data, event.CfgName) // this will delete all index
-					e.clockClass = protocol.ClockClassUninitialized
-					e.clockAccuracy = fbprotocol.ClockAccuracyUnknown
+					e.resetClockQuality(event.CfgName)
 				} else {
 					// Check if the index is within the slice bounds
 					for indexToRemove, d := range e.data[event.CfgName] {
@@ -652,20 +640,30 @@ connect:
 						}
 					}
 					delete(e.gmSyncState, event.CfgName) // delete the gmSyncState
-					e.outOfSpec = false
-					e.frequencyTraceable = false
+					delete(e.outOfSpec, event.CfgName)
+					delete(e.frequencyTraceable, event.CfgName)
 				}
 				continue
 			}
 			var logOut []string
 			logDataValues := ""
-			if event.ProcessName == SYNCE {
+			if event.ProcessName == SYNCE || event.ProcessName == CHRONY {
+				// neither contributes to the GM state
 				// Update the metrics
 				logDataValues = event.GetLogData()
 				if event.WriteToLog && logDataValues != "" {
 					logOut = append(logOut, logDataValues)
 				}
 				e.UpdateClockStateMetrics(event.State, string(event.ProcessName), event.IFace)
+				instance := Instance{CfgName: event.CfgName, IFace: event.IFace}
+				if event.ProcessName == SYNCE && event.State != PTP_UNKNOWN {
+					StateRegisterer.PublishInstance(event.ProcessName, instance, event.State)
+				}
+				// chronyd reports its state on every sample, only the changes are published
+				if event.ProcessName == CHRONY && lastChronyState[event.CfgName] != event.State {
+					lastChronyState[event.CfgName] = event.State
+					StateRegisterer.PublishInstance(event.ProcessName, instance, event.State)
+				}
 			} else {
 				// Update the in MemData
 				dataDetails := e.addEvent(event)
@@ -728,7 +726,8 @@ connect:
 
 				// Default Assignment: The clockAccuracy of gmState is initially set to the clockAccuracy of the event
 				//This serves as a default value.
-				gmState.clockAccuracy = e.clockAccuracy
+				clockClass, clockAccuracy := e.getClockQuality(event.CfgName)
+				gmState.clockAccuracy = clockAccuracy
 
 				// Conditional Update: Check if the clockClass of gmState is either fbprotocol.ClockClass7 or protocol.ClockClassOutOfSpec
 				// and if the ProcessName of the event is DPLL.
@@ -751,10 +750,11 @@ connect:
 				// If the clockClass of gmState is not protocol.ClockClassUninitialized and there is a change in clockClass or clockAccuracy,
 				// log the change and update the clock class.
 				if gmState.clockClass != protocol.ClockClassUninitialized &&
-					(uint8(gmState.clockClass) != uint8(e.clockClass) || gmState.clockAccuracy != e.clockAccuracy) {
-					glog.Infof("clock class change request from %d to %d with clock accuracy from %d to %d",
-						uint8(e.clockClass), uint8(gmState.clockClass), uint8(e.clockAccuracy), uint8(gmState.clockAccuracy))
+					(uint8(gmState.clockClass) != uint8(clockClass) || gmState.clockAccuracy != clockAccuracy) {
+					glog.Infof("%s clock class change request from %d to %d with clock accuracy from %d to %d", event.CfgName,
+						uint8(clockClass), uint8(gmState.clockClass), uint8(clockAccuracy), uint8(gmState.clockAccuracy))
 					debug.UpdateClockClass(uint8(gmState.clockClass))
+					e.clockClassUpdates.Add(1)
 					go func() {
 						select {
 						case clockClassRequestCh <- ClockClassRequest{
@@ -765,20 +765,23 @@ connect:
 							clockAccuracy: gmState.clockAccuracy,
 						}:
 						default:
+							e.clockClassUpdates.Done()
 							glog.Error("clock class request busy updating previous request, will try next event")
 						}
 					}()
 				}
-				if lastgmState != gmState.state {
-					glog.Infof("PTP State: GM State %v, Clock Class %d Time %s sourceLost %v", gmState.state, gmState.clockClass, time.Now(), gmState.sourceLost)
-					lastgmState = gmState.state
+				if lastgmState[event.CfgName] != gmState.state {
+					e.recordGMState(event.CfgName, lastgmState[event.CfgName], gmState)
+					glog.Infof("PTP State: %s GM State %v, Clock Class %d Time %s sourceLost %v", event.CfgName, gmState.state, gmState.clockClass, time.Now(), gmState.sourceLost)
+					lastgmState[event.CfgName] = gmState.state
+					StateRegisterer.PublishInstance(GMSTATE, Instance{CfgName: event.CfgName}, gmState.state)
 				}
 
 			} // end of GM condition
 			if len(logOut) > 0 {
 				if e.stdoutToSocket {
 					for _, l := range logOut {
-						fmt.Printf("%s", l)
+						logging.ProcessOutput(l)
 						_, err = c.Write([]byte(l))
 						if err != nil {
 							glog.Errorf("Write %s error %s:", l, err)
@@ -787,17 +790,36 @@ connect:
 					}
 				} else {
 					for _, l := range logOut {
-						fmt.Printf("%s", l)
+						logging.ProcessOutput(l)
 					}
 				}
 			}
 
+		case <-heartbeat.C:
+			e.beat()
+		case reply := <-e.statusCh:
+			reply <- e.status()
+		case done := <-e.drainCh:
+			close(done)
 		case <-e.closeCh:
 			return
 		}
 	}
 }
 
+func (e *EventHandler) beat() {
+	e.heartbeat.Store(time.Now().UnixNano())
+}
+
+// LastHeartbeat returns when the event loop was last seen running, it beats every HeartbeatInterval.
+// It is zero until ProcessEvents starts.
+func (e *EventHandler) LastHeartbeat() time.Time {
+	if t := e.heartbeat.Load(); t != 0 {
+		return time.Unix(0, t)
+	}
+	return time.Time{}
+}
+
 func (e *EventHandler) updateCLockClass(cfgName string, clkClass fbprotocol.ClockClass, clockType ClockType, clkAccuracy fbprotocol.ClockAccuracy,
 	gmGetterFn func(string) (protocol.GrandmasterSettings, error),
 	gmSetterFn func(string, protocol.GrandmasterSettings) error) (err error, clockClass fbprotocol.ClockClass, clockAccuracy fbprotocol.ClockAccuracy) {
@@ -917,6 +939,7 @@ func (e *EventHandler) updateMetrics(cfgName string, process EventSource, proces
 					m := d.Metrics[dataType]
 					m.GaugeMetric = e.offsetMetric
 					m.isRegistered = true
+					m.Value = dataValue
 					d.Metrics[dataType] = m
 				}
 				pLabels := map[string]string{"from": pName, "node": e.nodeName,
@@ -958,7 +981,8 @@ func (e *EventHandler) updateMetrics(cfgName string, process EventSource, proces
 			s.Labels = map[string]string{"from": pName, "node": e.nodeName,
 				"process": string(process), "iface": iface}
 			s.Value = dataValue
-			d.Metrics[dataType].GaugeMetric.With(s.Labels).Set(s.Value)
+			s.GaugeMetric.With(s.Labels).Set(s.Value)
+			d.Metrics[dataType] = s
 		}
 	}
 
@@ -1019,6 +1043,37 @@ func (e *EventHandler) addEvent(event EventChannel) *DataDetails {
 	return d.GetDataDetails(event.IFace)
 }
 
+// Drain waits until the events queued so far are processed and the clock class updates they requested are done
+func (e *EventHandler) Drain(timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for e.queue.Len() > 0 {
+		select {
+		case <-deadline:
+			return fmt.Errorf("%d events still queued after %s", e.queue.Len(), timeout)
+		case <-time.After(time.Millisecond):
+		}
+	}
+	// the event loop handles one request at a time, the last event received is processed once it gets to this one
+	done := make(chan struct{})
+	select {
+	case e.drainCh <- done:
+	case <-deadline:
+		return fmt.Errorf("event loop did not answer within %s", timeout)
+	}
+	<-done
+	updated := make(chan struct{})
+	go func() {
+		e.clockClassUpdates.Wait()
+		close(updated)
+	}()
+	select {
+	case <-updated:
+		return nil
+	case <-deadline:
+		return fmt.Errorf("clock class updates not done within %s", timeout)
+	}
+}
+
 // UpdateClockClass ... update clock class
 func (e *EventHandler) UpdateClockClass(c net.Conn, clk ClockClassRequest) {
 	classErr, clockClass, clockAccuracy := e.updateCLockClass(clk.cfgName, clk.clockClass, clk.clockType, clk.clockAccuracy,
@@ -1027,9 +1082,13 @@ func (e *EventHandler) UpdateClockClass(c net.Conn, clk ClockClassRequest) {
 	if classErr != nil {
 		glog.Errorf("error updating clock class %s", classErr)
 	} else {
-		glog.Infof("updated clock class for last clock class %d to %d with clock accuracy %d", e.clockClass, clockClass, clockAccuracy)
-		e.clockClass = clockClass
-		e.clockAccuracy = clockAccuracy
+		lastClockClass, _ := e.getClockQuality(clk.cfgName)
+		glog.Infof("updated %s clock class for last clock class %d to %d with clock accuracy %d", clk.cfgName, lastClockClass, clockClass, clockAccuracy)
+		if lastClockClass != clockClass {
+			e.recordClockClass(clk.cfgName, uint8(lastClockClass), uint8(clockClass))
+		}
+		e.setClockQuality(clk.cfgName, clockClass, clockAccuracy)
+		StateRegisterer.PublishClockClass(clk.cfgName, uint8(clockClass))
 		clockClassOut := fmt.Sprintf("%s[%d]:[%s] CLOCK_CLASS_CHANGE %d\n", PTP4l, time.Now().Unix(), clk.cfgName, clockClass)
 		if e.stdoutToSocket {
 			if c != nil {
@@ -1044,7 +1103,7 @@ func (e *EventHandler) UpdateClockClass(c net.Conn, clk ClockClassRequest) {
 			e.clockClassMetric.With(prometheus.Labels{
 				"process": PTP4lProcessName, "node": e.nodeName}).Set(float64(clockClass))
 		}
-		fmt.Printf("%s", clockClassOut)
+		logging.ProcessOutput(clockClassOut)
 	}
 }
 
@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/protocol"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -284,22 +286,21 @@ func TestEventHandler_ProcessEvents(t *testing.T) {
 	}
 
 	logOut := make(chan string, 100)
-	eChannel := make(chan event.EventChannel, 100)
+	eQueue := event.NewQueue("node", event.DefaultQueueCapacity, nil, nil, nil)
 	closeChn := make(chan bool)
 	go listenToEvents(closeChn, logOut)
-	eventManager := event.Init("node", true, "/tmp/go.sock", eChannel, closeChn, nil, nil, nil)
+	eventManager := event.Init("node", true, "/tmp/go.sock", eQueue, closeChn, nil, nil, nil)
 	eventManager.MockEnable()
 	go eventManager.ProcessEvents()
 	assert.NoError(t, leap.MockLeapFile())
 	defer close(leap.LeapMgr.Close)
 	time.Sleep(1 * time.Second)
 	for _, test := range tests {
-		select {
-		case eChannel <- sendEvents(test.cfgName, test.iface, test.processName, test.clockState, test.values, test.outOfSpec, test.sourceLost):
+		if eQueue.Push(sendEvents(test.cfgName, test.iface, test.processName, test.clockState, test.values, test.outOfSpec, test.sourceLost)) {
 			log.Println("sent data to channel")
 			log.Println(test.cfgName, test.processName, test.clockState, test.outOfSpec, test.values)
 			time.Sleep(1 * time.Second)
-		default:
+		} else {
 			log.Println("nothing to read")
 		}
 	retry:
@@ -332,6 +333,85 @@ func TestEventHandler_ProcessEvents(t *testing.T) {
 	time.Sleep(1 * time.Second)
 }
 
+// clockClassSubscriber records the last clock class published for each config
+type clockClassSubscriber struct {
+	Subscriber
+	sync.Mutex
+	clockClass map[string]uint8
+}
+
+func (s *clockClassSubscriber) NotifyClockClass(cfgName string, clockClass uint8) {
+	s.Lock()
+	defer s.Unlock()
+	s.clockClass[cfgName] = clockClass
+}
+
+func (s *clockClassSubscriber) get(cfgName string) uint8 {
+	s.Lock()
+	defer s.Unlock()
+	return s.clockClass[cfgName]
+}
+
+// newEventHandler returns an event handler of the events pushed to the queue returned, updating metrics instead of
+// writing to a socket. The caller runs ProcessEvents, which is stopped at the end of the test.
+func newEventHandler(t *testing.T) (*event.EventHandler, *event.Queue) {
+	monkeyPatch()
+	offsetMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_offset_ns"}, []string{"from", "node", "process", "iface"})
+	clockMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_clock_state"}, []string{"process", "node", "iface"})
+	clockClassMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_clock_class"}, []string{"process", "node"})
+	eQueue := event.NewQueue("node", event.DefaultQueueCapacity, nil, nil, nil)
+	closeChn := make(chan bool)
+	eventManager := event.Init("node", false, "", eQueue, closeChn, offsetMetric, clockMetric, clockClassMetric)
+	t.Cleanup(func() { close(closeChn) })
+	return eventManager, eQueue
+}
+
+func TestEventHandler_clockClassByConfig(t *testing.T) {
+	assert.NoError(t, leap.MockLeapFile())
+	defer close(leap.LeapMgr.Close)
+	eventManager, eQueue := newEventHandler(t)
+	go eventManager.ProcessEvents()
+	sub := &clockClassSubscriber{Subscriber: Subscriber{source: event.PTP4l, id: "clockclass"}, clockClass: map[string]uint8{}}
+	event.StateRegisterer.Register(sub)
+
+	push := func(cfgName, iface string, processName event.EventSource, state event.PTPState, values map[event.ValueType]interface{}) {
+		eQueue.Push(sendEvents(cfgName, iface, processName, state, values, false, false))
+		assert.NoError(t, eventManager.Drain(5*time.Second))
+	}
+	wantClockClass := func(cfgName string, clockClass uint8) {
+		assert.Eventually(t, func() bool { return sub.get(cfgName) == clockClass }, 5*time.Second, 10*time.Millisecond,
+			"%s clock class is %d, want %d", cfgName, sub.get(cfgName), clockClass)
+	}
+
+	// the first ts2phc instance locks, then its DPLL goes in holdover
+	push("ts2phc.0.config", "ens1f0", event.DPLL, event.PTP_LOCKED,
+		map[event.ValueType]interface{}{event.OFFSET: 0, event.PHASE_STATUS: 3, event.FREQUENCY_STATUS: 3, event.PPS_STATUS: 1})
+	push("ts2phc.0.config", "ens1f0", event.GNSS, event.PTP_LOCKED, map[event.ValueType]interface{}{event.OFFSET: 0, event.GPS_STATUS: 3})
+	push("ts2phc.0.config", "ens1f0", event.TS2PHCProcessName, event.PTP_LOCKED, map[event.ValueType]interface{}{event.OFFSET: 0})
+	wantClockClass("ts2phc.0.config", 6)
+	push("ts2phc.0.config", "ens1f0", event.DPLL, event.PTP_HOLDOVER,
+		map[event.ValueType]interface{}{event.OFFSET: 0, event.PHASE_STATUS: 4, event.FREQUENCY_STATUS: 4, event.PPS_STATUS: 1})
+	wantClockClass("ts2phc.0.config", 7)
+
+	// the second one starts in FREERUN and locks with its own clock class, the first one stays in holdover
+	push("ts2phc.1.config", "ens3f0", event.DPLL, event.PTP_LOCKED,
+		map[event.ValueType]interface{}{event.OFFSET: 0, event.PHASE_STATUS: 3, event.FREQUENCY_STATUS: 3, event.PPS_STATUS: 1})
+	push("ts2phc.1.config", "ens3f0", event.GNSS, event.PTP_LOCKED, map[event.ValueType]interface{}{event.OFFSET: 0, event.GPS_STATUS: 3})
+	wantClockClass("ts2phc.1.config", 248)
+	push("ts2phc.1.config", "ens3f0", event.TS2PHCProcessName, event.PTP_LOCKED, map[event.ValueType]interface{}{event.OFFSET: 0})
+	wantClockClass("ts2phc.1.config", 6)
+	wantClockClass("ts2phc.0.config", 7)
+}
+
+func TestEventHandler_heartbeat(t *testing.T) {
+	eventManager, _ := newEventHandler(t)
+	assert.True(t, eventManager.LastHeartbeat().IsZero())
+	go eventManager.ProcessEvents()
+	// the event loop beats once it starts, then every HeartbeatInterval
+	assert.Eventually(t, func() bool { return !eventManager.LastHeartbeat().IsZero() }, 5*time.Second, 10*time.Millisecond)
+	assert.WithinDuration(t, time.Now(), eventManager.LastHeartbeat(), event.HeartbeatInterval)
+}
+
 func listenToEvents(closeChn chan bool, logOut chan string) {
 	l, sErr := Listen("/tmp/go.sock")
 	if sErr != nil {
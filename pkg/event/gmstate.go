@@ -0,0 +1,313 @@
+package event
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	fbprotocol "github.com/facebook/time/ptp/protocol"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/protocol"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultGMStateMachine is the name of the built-in GM state machine used unless a profile selects another one
+	DefaultGMStateMachine = "default"
+	// StrictHoldoverGMStateMachine is the built-in GM state machine entering holdover on the DPLL holdover only,
+	// a ts2phc holdover with the DPLL still locked, or without a DPLL, is free run
+	StrictHoldoverGMStateMachine = "strict-holdover"
+)
+
+// GMInputs are the states the GM state of a config is derived from
+type GMInputs struct {
+	DPLL       PTPState // PTP_NOTSET when there is no DPLL
+	GNSS       PTPState
+	TS2PHC     PTPState
+	SourceLost bool // the GNSS source is lost
+	OutOfSpec  bool // the DPLL is out of its holdover specification with the frequency still traceable
+}
+
+// GMRule is a row of the transition table of a GMStateMachine: when the inputs match, the GM moves to State.
+// An empty list of states matches any state, a nil bool either value.
+type GMRule struct {
+	DPLL       []PTPState
+	GNSS       []PTPState
+	TS2PHC     []PTPState
+	SourceLost *bool
+	OutOfSpec  *bool
+
+	// State is the GM state, the GM keeps its state, clock class and accuracy when it is empty
+	State PTPState
+	// ClockClass is the clock class of the GM, it is kept when 0
+	ClockClass fbprotocol.ClockClass
+	// ClockAccuracy is the clock accuracy of the GM, it is kept when 0
+	ClockAccuracy fbprotocol.ClockAccuracy
+	Description   string
+}
+
+// Match returns whether the rule applies to in
+func (r GMRule) Match(in GMInputs) bool {
+	return matchState(r.DPLL, in.DPLL) && matchState(r.GNSS, in.GNSS) && matchState(r.TS2PHC, in.TS2PHC) &&
+		(r.SourceLost == nil || *r.SourceLost == in.SourceLost) &&
+		(r.OutOfSpec == nil || *r.OutOfSpec == in.OutOfSpec)
+}
+
+func matchState(states []PTPState, state PTPState) bool {
+	return len(states) == 0 || slices.Contains(states, state)
+}
+
+// GMStateMachine derives the GM state and clock class of a config from the states of its DPLL, GNSS and ts2phc.
+// The first rule matching the inputs applies, the GM keeps its state when none does.
+type GMStateMachine struct {
+	Name  string
+	Rules []GMRule
+}
+
+// Next returns the index of the rule applying to in, -1 when there is none
+func (m *GMStateMachine) Next(in GMInputs) int {
+	for i, r := range m.Rules {
+		if r.Match(in) {
+			return i
+		}
+	}
+	return -1
+}
+
+// GMStates are the states a GM state machine takes as inputs
+var GMStates = []PTPState{PTP_FREERUN, PTP_HOLDOVER, PTP_LOCKED, PTP_UNKNOWN, PTP_NOTSET}
+
+// AllGMInputs returns every combination of inputs of a GM state machine
+func AllGMInputs() []GMInputs {
+	var inputs []GMInputs
+	for _, dpll := range GMStates {
+		for _, gnss := range GMStates {
+			for _, ts2phc := range GMStates {
+				for _, sourceLost := range []bool{false, true} {
+					for _, outOfSpec := range []bool{false, true} {
+						inputs = append(inputs, GMInputs{DPLL: dpll, GNSS: gnss, TS2PHC: ts2phc, SourceLost: sourceLost, OutOfSpec: outOfSpec})
+					}
+				}
+			}
+		}
+	}
+	return inputs
+}
+
+// Unreachable returns the indexes of the rules no inputs apply, shadowed by the rules before them
+func (m *GMStateMachine) Unreachable() []int {
+	applied := make([]bool, len(m.Rules))
+	for _, in := range AllGMInputs() {
+		if i := m.Next(in); i >= 0 {
+			applied[i] = true
+		}
+	}
+	var unreachable []int
+	for i, ok := range applied {
+		if !ok {
+			unreachable = append(unreachable, i)
+		}
+	}
+	return unreachable
+}
+
+var (
+	isTrue = func() *bool { b := true; return &b }()
+	// lockedOrAbsent is a DPLL locked, or no DPLL at all
+	lockedOrAbsent = []PTPState{PTP_LOCKED, PTP_NOTSET}
+)
+
+// defaultGMRules are the transitions of the default GM state machine, by DPLL state, then GNSS state, then ts2phc state.
+// See the tables above updateGMState for how the DPLL and GNSS states are derived.
+func defaultGMRules() []GMRule {
+	freerun := func(description string) GMRule {
+		return GMRule{State: PTP_FREERUN, ClockClass: protocol.ClockClassFreerun, ClockAccuracy: fbprotocol.ClockAccuracyUnknown,
+			Description: description}
+	}
+	locked := func(description string) GMRule {
+		return GMRule{State: PTP_LOCKED, ClockClass: fbprotocol.ClockClass6, ClockAccuracy: fbprotocol.ClockAccuracyNanosecond100,
+			Description: description}
+	}
+	holdover := func(description string) GMRule {
+		return GMRule{State: PTP_HOLDOVER, ClockClass: fbprotocol.ClockClass7, Description: description}
+	}
+	with := func(r GMRule, dpll, gnss, ts2phc []PTPState) GMRule {
+		r.DPLL, r.GNSS, r.TS2PHC = dpll, gnss, ts2phc
+		return r
+	}
+	states := func(s ...PTPState) []PTPState { return s }
+
+	rules := []GMRule{
+		// the DPLL state is the overall state, with HOLDOVER having the highest priority
+		{DPLL: states(PTP_FREERUN), OutOfSpec: isTrue, State: PTP_FREERUN, ClockClass: protocol.ClockClassOutOfSpec,
+			ClockAccuracy: fbprotocol.ClockAccuracyUnknown, Description: "T-GM in holdover, out of holdover specification"},
+		with(freerun("T-GM in free-run mode, from holdover it goes to out of spec to free run"), states(PTP_FREERUN), nil, nil),
+		with(holdover("T-GM in holdover, within holdover specification"), states(PTP_HOLDOVER), nil, nil),
+
+		// a DPLL locked, or no DPLL which is then considered locked
+		with(freerun("ts2phc in free-run mode"), lockedOrAbsent, states(PTP_LOCKED), states(PTP_FREERUN)),
+		with(locked("T-GM connected to a PRTC in locked mode, i.e. PRTC traceable to GNSS"), lockedOrAbsent, states(PTP_LOCKED), states(PTP_LOCKED)),
+		with(holdover("ts2phc in holdover"), lockedOrAbsent, states(PTP_LOCKED), states(PTP_HOLDOVER)),
+		{DPLL: lockedOrAbsent, GNSS: states(PTP_LOCKED), Description: "ts2phc state not known yet"},
+		{DPLL: lockedOrAbsent, GNSS: states(PTP_FREERUN), SourceLost: isTrue, TS2PHC: states(PTP_HOLDOVER), State: PTP_HOLDOVER,
+			ClockClass: fbprotocol.ClockClass7, Description: "GNSS lost and ts2phc in holdover"},
+		{DPLL: lockedOrAbsent, GNSS: states(PTP_FREERUN), SourceLost: isTrue,
+			Description: "GNSS lost, wait for the DPLL to move to HOLDOVER"},
+		with(freerun("GNSS in free-run mode, i.e. its offset is out of range"), lockedOrAbsent, states(PTP_FREERUN),
+			states(PTP_FREERUN, PTP_LOCKED, PTP_UNKNOWN, PTP_NOTSET)),
+		{DPLL: lockedOrAbsent, Description: "GNSS state not known yet, or in free-run mode with ts2phc in holdover"},
+
+		// a DPLL in an unknown state
+		with(freerun("ts2phc in free-run mode"), nil, states(PTP_LOCKED), states(PTP_FREERUN, PTP_UNKNOWN, PTP_NOTSET)),
+		with(locked("T-GM connected to a PRTC in locked mode, i.e. PRTC traceable to GNSS"), nil, states(PTP_LOCKED), states(PTP_LOCKED)),
+		with(holdover("ts2phc in holdover"), nil, states(PTP_LOCKED), states(PTP_HOLDOVER)),
+		with(freerun("GNSS lost, ts2phc stops reporting and waits to move to HOLDOVER"), nil, states(PTP_FREERUN),
+			states(PTP_FREERUN, PTP_LOCKED, PTP_UNKNOWN, PTP_NOTSET)),
+		with(holdover("GNSS lost and ts2phc in holdover"), nil, states(PTP_FREERUN), states(PTP_HOLDOVER)),
+
+		// neither the DPLL nor the GNSS state is known, the GM follows ts2phc
+		with(freerun("ts2phc in free-run mode"), nil, nil, states(PTP_FREERUN)),
+		{TS2PHC: states(PTP_LOCKED), State: PTP_LOCKED, ClockClass: fbprotocol.ClockClass7,
+			ClockAccuracy: fbprotocol.ClockAccuracyNanosecond100, Description: "ts2phc locked without a known GNSS state"},
+	}
+	for _, state := range []PTPState{PTP_HOLDOVER, PTP_UNKNOWN, PTP_NOTSET} {
+		rules = append(rules, GMRule{TS2PHC: states(state), State: state, Description: "the GM follows ts2phc, keeping its clock class"})
+	}
+	return rules
+}
+
+// strictHoldoverGMRules are evaluated before the default rules by the strict-holdover GM state machine
+func strictHoldoverGMRules() []GMRule {
+	return []GMRule{
+		{DPLL: []PTPState{PTP_LOCKED, PTP_NOTSET, PTP_UNKNOWN}, TS2PHC: []PTPState{PTP_HOLDOVER}, State: PTP_FREERUN,
+			ClockClass: protocol.ClockClassFreerun, ClockAccuracy: fbprotocol.ClockAccuracyUnknown,
+			Description: "holdover is only entered on the DPLL holdover"},
+	}
+}
+
+// defaultGMStateMachine is the GM state machine of the configs without one set
+var defaultGMStateMachine, _ = BuiltinGMStateMachine(DefaultGMStateMachine)
+
+// BuiltinGMStateMachine returns the built-in GM state machine called name
+func BuiltinGMStateMachine(name string) (*GMStateMachine, bool) {
+	switch name {
+	case DefaultGMStateMachine:
+		return &GMStateMachine{Name: name, Rules: defaultGMRules()}, true
+	case StrictHoldoverGMStateMachine:
+		return &GMStateMachine{Name: name, Rules: append(strictHoldoverGMRules(), defaultGMRules()...)}, true
+	}
+	return nil, false
+}
+
+// gmStateMachineConfig is a GM state machine as written in a profile
+type gmStateMachineConfig struct {
+	// Base is the built-in state machine the rules are added to, the default one when empty
+	Base string `json:"base"`
+	// ClockClasses replaces the clock classes of the base rules, i.e. {"7": 135}
+	ClockClasses map[uint8]uint8 `json:"clockClasses"`
+	// Rules are evaluated before the base rules
+	Rules []gmRuleConfig `json:"rules"`
+}
+
+// gmRuleConfig is a GMRule as written in a profile, the states are FREERUN, HOLDOVER, LOCKED, UNKNOWN or NOTSET
+type gmRuleConfig struct {
+	DPLL          []string `json:"dpll"`
+	GNSS          []string `json:"gnss"`
+	TS2PHC        []string `json:"ts2phc"`
+	SourceLost    *bool    `json:"sourceLost"`
+	OutOfSpec     *bool    `json:"outOfSpec"`
+	State         string   `json:"state"`
+	ClockClass    uint8    `json:"clockClass"`
+	ClockAccuracy uint8    `json:"clockAccuracy"`
+	Description   string   `json:"description"`
+}
+
+var gmStateNames = map[string]PTPState{
+	"FREERUN":  PTP_FREERUN,
+	"HOLDOVER": PTP_HOLDOVER,
+	"LOCKED":   PTP_LOCKED,
+	"UNKNOWN":  PTP_UNKNOWN,
+	"NOTSET":   PTP_NOTSET,
+}
+
+func parseGMState(name string) (PTPState, error) {
+	if state, ok := gmStateNames[strings.ToUpper(name)]; ok {
+		return state, nil
+	}
+	return "", fmt.Errorf("unknown state %q, expected FREERUN, HOLDOVER, LOCKED, UNKNOWN or NOTSET", name)
+}
+
+func parseGMStates(names []string) ([]PTPState, error) {
+	var states []PTPState
+	for _, name := range names {
+		state, err := parseGMState(name)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func (c gmRuleConfig) rule() (GMRule, error) {
+	r := GMRule{SourceLost: c.SourceLost, OutOfSpec: c.OutOfSpec, ClockClass: fbprotocol.ClockClass(c.ClockClass),
+		ClockAccuracy: fbprotocol.ClockAccuracy(c.ClockAccuracy), Description: c.Description}
+	var err error
+	if r.DPLL, err = parseGMStates(c.DPLL); err != nil {
+		return r, fmt.Errorf("dpll: %w", err)
+	}
+	if r.GNSS, err = parseGMStates(c.GNSS); err != nil {
+		return r, fmt.Errorf("gnss: %w", err)
+	}
+	if r.TS2PHC, err = parseGMStates(c.TS2PHC); err != nil {
+		return r, fmt.Errorf("ts2phc: %w", err)
+	}
+	if c.State != "" {
+		if r.State, err = parseGMState(c.State); err != nil {
+			return r, fmt.Errorf("state: %w", err)
+		}
+	} else if c.ClockClass != 0 || c.ClockAccuracy != 0 {
+		return r, fmt.Errorf("a rule keeping the GM state cannot set its clock class or accuracy")
+	}
+	return r, nil
+}
+
+// ParseGMStateMachine parses the GM state machine of a profile: the name of a built-in one, or a YAML document with
+// the base built-in machine, the clock classes replacing its ones and the rules evaluated before its ones, i.e.
+//
+//	base: default
+//	clockClasses: {"7": 135}
+//	rules:
+//	- {dpll: [LOCKED], gnss: [FREERUN], sourceLost: true, state: FREERUN, clockClass: 248}
+func ParseGMStateMachine(s string) (*GMStateMachine, error) {
+	s = strings.TrimSpace(s)
+	if m, ok := BuiltinGMStateMachine(s); ok {
+		return m, nil
+	}
+	var c gmStateMachineConfig
+	if err := yaml.UnmarshalStrict([]byte(s), &c); err != nil {
+		return nil, fmt.Errorf("invalid GM state machine, expected %s, %s or a YAML document: %w",
+			DefaultGMStateMachine, StrictHoldoverGMStateMachine, err)
+	}
+	if c.Base == "" {
+		c.Base = DefaultGMStateMachine
+	}
+	base, ok := BuiltinGMStateMachine(c.Base)
+	if !ok {
+		return nil, fmt.Errorf("unknown base GM state machine %q", c.Base)
+	}
+	for i := range base.Rules {
+		if class, ok := c.ClockClasses[uint8(base.Rules[i].ClockClass)]; ok && base.Rules[i].ClockClass != 0 {
+			base.Rules[i].ClockClass = fbprotocol.ClockClass(class)
+		}
+	}
+	m := &GMStateMachine{Name: "custom:" + c.Base}
+	for i, rc := range c.Rules {
+		r, err := rc.rule()
+		if err != nil {
+			return nil, fmt.Errorf("GM state machine rule %d: %w", i, err)
+		}
+		m.Rules = append(m.Rules, r)
+	}
+	m.Rules = append(m.Rules, base.Rules...)
+	return m, nil
+}
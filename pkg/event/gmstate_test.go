@@ -0,0 +1,233 @@
+package event_test
+
+import (
+	"fmt"
+	"testing"
+
+	fbprotocol "github.com/facebook/time/ptp/protocol"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+// gmOutput is the GM state a state machine leads to
+type gmOutput struct {
+	state         event.PTPState
+	clockClass    fbprotocol.ClockClass
+	clockAccuracy fbprotocol.ClockAccuracy
+}
+
+func (o gmOutput) String() string {
+	return fmt.Sprintf("%s/%d/%#x", o.state, o.clockClass, o.clockAccuracy)
+}
+
+// previous is the GM state before a transition, told apart from any state a rule sets
+var previous = gmOutput{state: "previous", clockClass: 1, clockAccuracy: 1}
+
+// next applies m to the previous GM state, as updateGMState does
+func next(m *event.GMStateMachine, in event.GMInputs) gmOutput {
+	out := previous
+	if i := m.Next(in); i >= 0 && m.Rules[i].State != "" {
+		r := m.Rules[i]
+		out.state = r.State
+		if r.ClockClass != 0 {
+			out.clockClass = r.ClockClass
+		}
+		if r.ClockAccuracy != 0 {
+			out.clockAccuracy = r.ClockAccuracy
+		}
+	}
+	return out
+}
+
+// legacyGMState is the truth table updateGMState hard coded before the GM state machines, the default one must match it
+func legacyGMState(in event.GMInputs) gmOutput {
+	out := previous
+	set := func(state event.PTPState, clockClass fbprotocol.ClockClass, clockAccuracy fbprotocol.ClockAccuracy) {
+		out.state = state
+		if clockClass != 0 {
+			out.clockClass = clockClass
+		}
+		if clockAccuracy != 0 {
+			out.clockAccuracy = clockAccuracy
+		}
+	}
+	freerun := func() { set(event.PTP_FREERUN, protocol.ClockClassFreerun, fbprotocol.ClockAccuracyUnknown) }
+	switch in.DPLL {
+	case event.PTP_FREERUN:
+		if in.OutOfSpec {
+			set(event.PTP_FREERUN, protocol.ClockClassOutOfSpec, fbprotocol.ClockAccuracyUnknown)
+		} else {
+			freerun()
+		}
+	case event.PTP_HOLDOVER:
+		set(event.PTP_HOLDOVER, fbprotocol.ClockClass7, 0)
+	case event.PTP_LOCKED, event.PTP_NOTSET:
+		switch in.GNSS {
+		case event.PTP_LOCKED:
+			switch in.TS2PHC {
+			case event.PTP_FREERUN:
+				freerun()
+			case event.PTP_LOCKED:
+				set(event.PTP_LOCKED, fbprotocol.ClockClass6, fbprotocol.ClockAccuracyNanosecond100)
+			case event.PTP_HOLDOVER:
+				set(event.PTP_HOLDOVER, fbprotocol.ClockClass7, 0)
+			}
+		case event.PTP_FREERUN:
+			if in.SourceLost {
+				if in.TS2PHC == event.PTP_HOLDOVER {
+					set(event.PTP_HOLDOVER, fbprotocol.ClockClass7, 0)
+				}
+			} else {
+				switch in.TS2PHC {
+				case event.PTP_FREERUN, event.PTP_LOCKED, event.PTP_UNKNOWN, event.PTP_NOTSET:
+					freerun()
+				}
+			}
+		}
+	default:
+		switch in.GNSS {
+		case event.PTP_LOCKED:
+			switch in.TS2PHC {
+			case event.PTP_FREERUN, event.PTP_UNKNOWN, event.PTP_NOTSET:
+				freerun()
+			case event.PTP_LOCKED:
+				set(event.PTP_LOCKED, fbprotocol.ClockClass6, fbprotocol.ClockAccuracyNanosecond100)
+			case event.PTP_HOLDOVER:
+				set(event.PTP_HOLDOVER, fbprotocol.ClockClass7, 0)
+			}
+		case event.PTP_FREERUN:
+			switch in.TS2PHC {
+			case event.PTP_FREERUN, event.PTP_LOCKED, event.PTP_UNKNOWN, event.PTP_NOTSET:
+				freerun()
+			case event.PTP_HOLDOVER:
+				set(event.PTP_HOLDOVER, fbprotocol.ClockClass7, 0)
+			}
+		default:
+			out.state = in.TS2PHC
+			switch in.TS2PHC {
+			case event.PTP_FREERUN:
+				freerun()
+			case event.PTP_LOCKED:
+				set(event.PTP_LOCKED, fbprotocol.ClockClass7, fbprotocol.ClockAccuracyNanosecond100)
+			}
+		}
+	}
+	return out
+}
+
+func TestGMStateMachine_default(t *testing.T) {
+	m, ok := event.BuiltinGMStateMachine(event.DefaultGMStateMachine)
+	if !assert.True(t, ok) {
+		t.FailNow()
+	}
+	for _, in := range event.AllGMInputs() {
+		assert.Equal(t, legacyGMState(in).String(), next(m, in).String(), "%+v", in)
+	}
+	assert.Empty(t, m.Unreachable(), "every rule applies to some inputs")
+}
+
+// gmTransition is a transition of a GM state machine, from the previous state when keep is true
+type gmTransition struct {
+	in   event.GMInputs
+	want gmOutput
+	keep bool
+}
+
+func locked() gmOutput {
+	return gmOutput{state: event.PTP_LOCKED, clockClass: fbprotocol.ClockClass6, clockAccuracy: fbprotocol.ClockAccuracyNanosecond100}
+}
+
+func freerun() gmOutput {
+	return gmOutput{state: event.PTP_FREERUN, clockClass: protocol.ClockClassFreerun, clockAccuracy: fbprotocol.ClockAccuracyUnknown}
+}
+
+func holdover() gmOutput {
+	return gmOutput{state: event.PTP_HOLDOVER, clockClass: fbprotocol.ClockClass7, clockAccuracy: previous.clockAccuracy}
+}
+
+func TestGMStateMachine_transitions(t *testing.T) {
+	const (
+		s0 = event.PTP_FREERUN
+		s1 = event.PTP_HOLDOVER
+		s2 = event.PTP_LOCKED
+		na = event.PTP_NOTSET
+	)
+	outOfSpec := gmOutput{state: s0, clockClass: protocol.ClockClassOutOfSpec, clockAccuracy: fbprotocol.ClockAccuracyUnknown}
+	// the transitions documented in the GM STATE tables, by DPLL, GNSS and ts2phc state
+	defaults := []gmTransition{
+		{in: event.GMInputs{DPLL: s0, GNSS: s2, TS2PHC: s2}, want: freerun()},
+		{in: event.GMInputs{DPLL: s1, GNSS: s0, TS2PHC: s0, SourceLost: true}, want: holdover()},
+		{in: event.GMInputs{DPLL: s0, GNSS: s0, TS2PHC: s0, SourceLost: true, OutOfSpec: true}, want: outOfSpec},
+		{in: event.GMInputs{DPLL: s2, GNSS: s2, TS2PHC: s2}, want: locked()},
+		{in: event.GMInputs{DPLL: s2, GNSS: s2, TS2PHC: s0}, want: freerun()},
+		{in: event.GMInputs{DPLL: s2, GNSS: s0, TS2PHC: s2, SourceLost: true}, keep: true},
+		{in: event.GMInputs{DPLL: s2, GNSS: s0, TS2PHC: s0, SourceLost: true}, keep: true},
+		{in: event.GMInputs{DPLL: s2, GNSS: s0, TS2PHC: s1, SourceLost: true}, want: holdover()},
+		{in: event.GMInputs{DPLL: s2, GNSS: s0, TS2PHC: s2}, want: freerun()},
+		{in: event.GMInputs{DPLL: s2, GNSS: s0, TS2PHC: s0}, want: freerun()},
+		{in: event.GMInputs{DPLL: na, GNSS: s0, TS2PHC: s2}, want: freerun()},
+		{in: event.GMInputs{DPLL: na, GNSS: s0, TS2PHC: s0}, want: freerun()},
+		{in: event.GMInputs{DPLL: na, GNSS: s2, TS2PHC: s0}, want: freerun()},
+		{in: event.GMInputs{DPLL: na, GNSS: s2, TS2PHC: s2}, want: locked()},
+	}
+	strict := append([]gmTransition{
+		{in: event.GMInputs{DPLL: s2, GNSS: s0, TS2PHC: s1, SourceLost: true}, want: freerun()},
+		{in: event.GMInputs{DPLL: na, GNSS: s2, TS2PHC: s1}, want: freerun()},
+		{in: event.GMInputs{DPLL: s1, GNSS: s0, TS2PHC: s1, SourceLost: true}, want: holdover()},
+	}, defaults[:7]...)
+
+	for name, transitions := range map[string][]gmTransition{
+		event.DefaultGMStateMachine:        defaults,
+		event.StrictHoldoverGMStateMachine: strict,
+	} {
+		m, ok := event.BuiltinGMStateMachine(name)
+		if !assert.True(t, ok, name) {
+			continue
+		}
+		for _, tr := range transitions {
+			want := tr.want
+			if tr.keep {
+				want = previous
+			}
+			assert.Equal(t, want.String(), next(m, tr.in).String(), "%s %+v", name, tr.in)
+		}
+	}
+}
+
+func TestParseGMStateMachine(t *testing.T) {
+	m, err := event.ParseGMStateMachine(" strict-holdover\n")
+	assert.NoError(t, err)
+	assert.Equal(t, event.StrictHoldoverGMStateMachine, m.Name)
+
+	// a PPS only source: no GNSS, the GM follows ts2phc with class 7 in holdover replaced by 135
+	m, err = event.ParseGMStateMachine(`
+clockClasses: {7: 135}
+rules:
+- {dpll: [LOCKED, NOTSET], ts2phc: [LOCKED], state: LOCKED, clockClass: 6, clockAccuracy: 0x21, description: PPS locked}
+- dpll: [locked, notset]
+  ts2phc: [FREERUN]
+  state: FREERUN
+  clockClass: 248
+`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "custom:default", m.Name)
+		assert.Equal(t, locked().String(), next(m, event.GMInputs{DPLL: event.PTP_NOTSET, GNSS: event.PTP_FREERUN, TS2PHC: event.PTP_LOCKED}).String())
+		assert.Equal(t, gmOutput{state: event.PTP_FREERUN, clockClass: protocol.ClockClassFreerun, clockAccuracy: previous.clockAccuracy}.String(),
+			next(m, event.GMInputs{DPLL: event.PTP_LOCKED, GNSS: event.PTP_LOCKED, TS2PHC: event.PTP_FREERUN}).String())
+		assert.Equal(t, gmOutput{state: event.PTP_HOLDOVER, clockClass: 135, clockAccuracy: previous.clockAccuracy}.String(),
+			next(m, event.GMInputs{DPLL: event.PTP_HOLDOVER}).String(), "clock class of the base rules replaced")
+	}
+
+	for _, invalid := range []string{
+		"g.8275.9",
+		"base: lenient",
+		"rules: [{dpll: [LOCKING], state: FREERUN}]",
+		"rules: [{ts2phc: [FREERUN], state: s0}]",
+		"rules: [{ts2phc: [FREERUN], clockClass: 248}]",
+		"rules: [{ts2phc: [FREERUN], state: FREERUN, clockclas: 248}]",
+	} {
+		_, err = event.ParseGMStateMachine(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
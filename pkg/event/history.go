@@ -0,0 +1,99 @@
+package event
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/history"
+)
+
+// historyKey identifies an event source in the history
+type historyKey struct {
+	source EventSource
+	cfg    string
+	iface  string
+}
+
+// SetHistory records the state transitions, threshold crossings and clock class changes to h.
+// It must be set before ProcessEvents runs.
+func (e *EventHandler) SetHistory(h *history.History) {
+	e.history = h
+}
+
+// recordTransitions records the state changes and threshold crossings of the source of ev, it runs in the event loop
+func (e *EventHandler) recordTransitions(ev EventChannel) {
+	if e.history == nil {
+		return
+	}
+	if ev.Reset {
+		for key := range e.lastStates {
+			if key.cfg == ev.CfgName && (ev.ProcessName == TS2PHC || key.source == ev.ProcessName) {
+				delete(e.lastStates, key)
+			}
+		}
+		return
+	}
+	key := historyKey{source: ev.ProcessName, cfg: ev.CfgName, iface: ev.IFace}
+	last, seen := e.lastStates[key]
+	e.lastStates[key] = sourceState{state: ev.State, sourceLost: ev.SourceLost, outOfSpec: ev.OutOfSpec}
+	record := apiv1.Event{Config: ev.CfgName, Source: string(ev.ProcessName), Interface: ev.IFace, Message: offsetMessage(ev)}
+	if ev.Time > 0 {
+		record.Time = time.UnixMilli(ev.Time)
+	}
+	if !seen || last.state != ev.State {
+		record.Type, record.To = apiv1.EventStateChange, stateName(ev.State)
+		if seen {
+			record.From = stateName(last.state)
+		}
+		if ev.SourceLost {
+			record.Message = joinMessage(record.Message, "source lost")
+		}
+		e.history.Add(record)
+	}
+	if seen && last.outOfSpec != ev.OutOfSpec {
+		record.Type, record.From, record.To = apiv1.EventThresholdCrossing, specName(last.outOfSpec), specName(ev.OutOfSpec)
+		e.history.Add(record)
+	}
+}
+
+// recordGMState records a change of the grandmaster state of cfgName
+func (e *EventHandler) recordGMState(cfgName string, last PTPState, gmState grandMasterSyncState) {
+	record := apiv1.Event{Type: apiv1.EventStateChange, Config: cfgName, Source: string(GM), Interface: gmState.gmIFace,
+		To: stateName(gmState.state), Message: fmt.Sprintf("clock class %d", uint8(gmState.clockClass))}
+	if last != "" {
+		record.From = stateName(last)
+	}
+	e.history.Add(record)
+}
+
+// recordClockClass records a clock class change of the ptp4l of cfgName
+func (e *EventHandler) recordClockClass(cfgName string, last, clockClass uint8) {
+	record := apiv1.Event{Type: apiv1.EventClockClassChange, Config: cfgName, Source: PTP4lProcessName, To: strconv.Itoa(int(clockClass))}
+	if last != 0 {
+		record.From = strconv.Itoa(int(last))
+	}
+	e.history.Add(record)
+}
+
+func offsetMessage(ev EventChannel) string {
+	if offset, ok := ev.Values[OFFSET]; ok {
+		return fmt.Sprintf("offset %v", offset)
+	}
+	return ""
+}
+
+func joinMessage(message, more string) string {
+	if message == "" {
+		return more
+	}
+	return message + ", " + more
+}
+
+func specName(outOfSpec bool) string {
+	if outOfSpec {
+		return "out of spec"
+	}
+	return "in spec"
+}
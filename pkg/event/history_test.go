@@ -0,0 +1,63 @@
+package event_test
+
+import (
+	"testing"
+	"time"
+
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/history"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventHandler_history(t *testing.T) {
+	assert.NoError(t, leap.MockLeapFile())
+	defer close(leap.LeapMgr.Close)
+	eventManager, eQueue := newEventHandler(t)
+	h := history.New(history.DefaultCapacity)
+	eventManager.SetHistory(h)
+	go eventManager.ProcessEvents()
+
+	dpll := func(state event.PTPState, phaseStatus int64, outOfSpec bool) event.EventChannel {
+		return sendEvents("ts2phc.0.config", "ens1f0", event.DPLL, state,
+			map[event.ValueType]interface{}{event.OFFSET: 0, event.PHASE_STATUS: phaseStatus, event.FREQUENCY_STATUS: phaseStatus, event.PPS_STATUS: 1}, outOfSpec, false)
+	}
+	ts2phc := func(state event.PTPState, offset int64) event.EventChannel {
+		return sendEvents("ts2phc.0.config", "ens1f0", event.TS2PHCProcessName, state, map[event.ValueType]interface{}{event.OFFSET: offset}, false, false)
+	}
+	// the grandmaster locks, its ts2phc offset spikes once, then its DPLL goes in holdover and out of its specification
+	for _, ev := range []event.EventChannel{
+		dpll(event.PTP_LOCKED, 3, false),
+		sendEvents("ts2phc.0.config", "ens1f0", event.GNSS, event.PTP_LOCKED, map[event.ValueType]interface{}{event.OFFSET: 0, event.GPS_STATUS: 3}, false, false),
+		ts2phc(event.PTP_LOCKED, 0),
+		ts2phc(event.PTP_FREERUN, 5000),
+		ts2phc(event.PTP_LOCKED, 0),
+		dpll(event.PTP_HOLDOVER, 4, false),
+		dpll(event.PTP_HOLDOVER, 4, true),
+	} {
+		eQueue.Push(ev)
+		assert.NoError(t, eventManager.Drain(5*time.Second))
+	}
+
+	transitions := func(q history.Query) []string {
+		q.Config = "ts2phc.0.config"
+		var got []string
+		for _, ev := range h.Query(q) {
+			got = append(got, ev.From+"->"+ev.To)
+		}
+		return got
+	}
+	assert.Equal(t, []string{"->LOCKED", "LOCKED->FREERUN", "FREERUN->LOCKED"},
+		transitions(history.Query{Type: apiv1.EventStateChange, Source: string(event.TS2PHC)}))
+	assert.Equal(t, []string{"->FREERUN", "FREERUN->LOCKED", "LOCKED->FREERUN", "FREERUN->LOCKED", "LOCKED->HOLDOVER"},
+		transitions(history.Query{Type: apiv1.EventStateChange, Source: string(event.GM)}))
+	assert.Equal(t, []string{"->248", "248->6", "6->248", "248->6", "6->7"},
+		transitions(history.Query{Type: apiv1.EventClockClassChange}))
+	assert.Equal(t, []string{"in spec->out of spec"}, transitions(history.Query{Type: apiv1.EventThresholdCrossing}))
+
+	gmStates := h.Query(history.Query{Type: apiv1.EventStateChange, Config: "ts2phc.0.config", Source: string(event.GM)})
+	if assert.NotEmpty(t, gmStates) {
+		assert.Equal(t, "clock class 7", gmStates[len(gmStates)-1].Message)
+	}
+}
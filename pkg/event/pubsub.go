@@ -2,8 +2,22 @@ package event
 
 import (
 	"fmt"
-	"github.com/golang/glog"
+	"path"
+	"slices"
 	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultSubscriberQueueSize is the number of notifications queued for a subscriber before the oldest ones are dropped
+	DefaultSubscriberQueueSize = 64
+	// MaxSubscriberFailures is the number of consecutive failed notifications after which a subscriber is unregistered
+	MaxSubscriberFailures = 5
+	// TopicAll is the topic of the subscribers notified of every source. A topic may be any path.Match pattern, i.e. ts2phc*
+	TopicAll EventSource = "*"
 )
 
 type Subscriber interface {
@@ -16,14 +30,75 @@ type Subscriber interface {
 	Topic() EventSource
 }
 
+// ClockClassSubscriber is a Subscriber of the PTP4l topic also notified of the clock class changes
+type ClockClassSubscriber interface {
+	Subscriber
+	NotifyClockClass(cfgName string, clockClass uint8)
+}
+
+// Instance is the process, or the interface of a process, whose state a notification is of. A source has an instance
+// per profile, i.e. the ptp4l of each config, and the GNSS of each grandmaster interface.
+type Instance struct {
+	CfgName string
+	IFace   string
+}
+
+// InstanceSubscriber is a Subscriber told which instance of the source changed, NotifyInstance is called instead of Notify
+type InstanceSubscriber interface {
+	Subscriber
+	NotifyInstance(source EventSource, instance Instance, state PTPState)
+}
+
+// SourceFilter is implemented by the Subscribers of a wildcard topic only notified of some of the sources it matches
+type SourceFilter interface {
+	Sources() []EventSource
+}
+
+// ErrorSubscriber is a Subscriber whose notifications may fail. TryNotify is called instead of Notify, and the
+// subscriber is unregistered after MaxSubscriberFailures consecutive errors. A panic of any subscriber is a failure too.
+type ErrorSubscriber interface {
+	Subscriber
+	TryNotify(source EventSource, state PTPState) error
+}
+
 type Notifier interface {
 	Register(o *Subscriber)
 	Unregister(o *Subscriber)
 }
 
+// SubscriberMetrics are the metrics of the subscribers of a StateNotifier, labelled by node and subscriber. Any of them may be nil.
+type SubscriberMetrics struct {
+	Depth    *prometheus.GaugeVec   // notifications queued
+	Lag      *prometheus.GaugeVec   // seconds between the publication and the delivery of the last notification
+	Healthy  *prometheus.GaugeVec   // 1, or 0 when the last notification failed
+	Dropped  *prometheus.CounterVec // notifications dropped because the queue was full
+	Failures *prometheus.CounterVec // failed notifications
+}
+
+// StateNotifier notifies the Subscribers of the state changes of their topic. Each subscriber has its own bounded
+// queue and worker, so a slow subscriber neither delays the others nor sees its notifications reordered.
 type StateNotifier struct {
 	sync.Mutex
-	Subscribers map[string]Subscriber
+	Subscribers   map[string]Subscriber
+	subscriptions map[string]*subscription // by the same id as Subscribers
+	queueSize     int
+	nodeName      string
+	metrics       SubscriberMetrics
+}
+
+// SetMetrics sets the metrics of the subscribers registered from now on
+func (n *StateNotifier) SetMetrics(nodeName string, metrics SubscriberMetrics) {
+	n.Lock()
+	defer n.Unlock()
+	n.nodeName = nodeName
+	n.metrics = metrics
+}
+
+// SetQueueSize sets the number of notifications queued for each subscriber registered from now on
+func (n *StateNotifier) SetQueueSize(size int) {
+	n.Lock()
+	defer n.Unlock()
+	n.queueSize = size
 }
 
 func (n *StateNotifier) Register(s Subscriber) {
@@ -31,48 +106,296 @@ func (n *StateNotifier) Register(s Subscriber) {
 	glog.Infof("Registering  for monitoring with id %s -  topic %s", id, s.Topic())
 	n.Lock()
 	defer n.Unlock()
+	if old, ok := n.subscriptions[id]; ok {
+		old.close()
+	}
 	n.Subscribers[id] = s
+	n.subscriptions[id] = newSubscription(n, id, s)
 }
 
 func (n *StateNotifier) Unregister(s Subscriber) {
 	id := fmt.Sprintf("%s_%s", s.Topic(), s.ID())
 	n.Lock()
 	defer n.Unlock()
-	if _, ok := n.Subscribers[id]; ok {
-		delete(n.Subscribers, id)
+	if sub, ok := n.subscriptions[id]; ok {
+		n.remove(sub)
+	}
+}
+
+// remove unregisters sub, n must be locked
+func (n *StateNotifier) remove(sub *subscription) {
+	if n.subscriptions[sub.id] != sub {
+		return
 	}
+	delete(n.Subscribers, sub.id)
+	delete(n.subscriptions, sub.id)
+	sub.close()
 }
 
+// monitor starts the monitoring of the MONITORING subscribers, which are then unregistered
 func (n *StateNotifier) monitor() {
-	if len(n.Subscribers) == 0 {
+	if n == nil {
 		return
 	}
 	n.Lock()
 	defer n.Unlock()
-	for key, o := range n.Subscribers {
-		if o.Topic() == MONITORING {
-			o.Monitor()
-			// monitoring is once time registering
-			delete(n.Subscribers, key)
+	for id, sub := range n.subscriptions {
+		if sub.subscriber.Topic() == MONITORING {
+			// monitoring is once time registering, the worker stops once Monitor returns
+			sub.push(notification{monitor: true, last: true})
+			delete(n.Subscribers, id)
+			delete(n.subscriptions, id)
 		}
 	}
 }
 
-func (n *StateNotifier) notify(source EventSource, state PTPState) {
+func (n *StateNotifier) notify(source EventSource, instance Instance, state PTPState) {
+	if n == nil {
+		return
+	}
 	n.Lock()
 	defer n.Unlock()
-	for _, o := range n.Subscribers {
+	for _, sub := range n.subscriptions {
 		// for source dpll, topic is gnss
-		if o.Topic() == source && o.Topic() != MONITORING {
-			glog.Infof("notifying source %v with state %v", source, state)
-			o.Notify(source, state)
+		if sub.matches(source) {
+			sub.push(notification{source: source, state: state, cfgName: instance.CfgName, iface: instance.IFace})
+		}
+	}
+}
+
+func (n *StateNotifier) notifyClockClass(cfgName string, clockClass uint8) {
+	if n == nil {
+		return
+	}
+	n.Lock()
+	defer n.Unlock()
+	for _, sub := range n.subscriptions {
+		if _, ok := sub.subscriber.(ClockClassSubscriber); ok && sub.subscriber.Topic() == PTP4l {
+			sub.push(notification{cfgName: cfgName, clockClass: clockClass, clockClassChange: true})
 		}
 	}
 }
 
+// Publish notifies the subscribers of source of a state change the event loop does not see, i.e. of ptp4l or phc2sys
+func (n *StateNotifier) Publish(source EventSource, state PTPState) {
+	n.notify(source, Instance{}, state)
+}
+
+// PublishInstance is Publish for the subscribers told the instance of source that changed, see InstanceSubscriber
+func (n *StateNotifier) PublishInstance(source EventSource, instance Instance, state PTPState) {
+	n.notify(source, instance, state)
+}
+
+// PublishClockClass notifies the ClockClassSubscribers of a clock class change of the ptp4l of cfgName
+func (n *StateNotifier) PublishClockClass(cfgName string, clockClass uint8) {
+	n.notifyClockClass(cfgName, clockClass)
+}
+
 func NewStateNotifier() *StateNotifier {
 	return &StateNotifier{
-		Subscribers: make(map[string]Subscriber),
+		Subscribers:   make(map[string]Subscriber),
+		subscriptions: make(map[string]*subscription),
+		queueSize:     DefaultSubscriberQueueSize,
+	}
+
+}
+
+// notification is a call queued for a subscriber
+type notification struct {
+	source           EventSource
+	state            PTPState
+	cfgName          string
+	iface            string
+	clockClass       uint8
+	clockClassChange bool // NotifyClockClass instead of Notify
+	monitor          bool // Monitor instead of Notify
+	last             bool // the worker stops after this one
+	time             time.Time
+}
+
+// subscription is the queue and worker of a registered Subscriber
+type subscription struct {
+	id         string
+	subscriber Subscriber
+	notifier   *StateNotifier
+	metrics    SubscriberMetrics
+	labels     prometheus.Labels
+
+	mu        sync.Mutex
+	queue     []notification
+	size      int
+	ready     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	failures int // consecutive, only used by the worker
+}
+
+func newSubscription(n *StateNotifier, id string, s Subscriber) *subscription {
+	sub := &subscription{
+		id:         id,
+		subscriber: s,
+		notifier:   n,
+		metrics:    n.metrics,
+		labels:     prometheus.Labels{"node": n.nodeName, "subscriber": id},
+		size:       max(n.queueSize, 1),
+		ready:      make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	if sub.metrics.Healthy != nil {
+		sub.metrics.Healthy.With(sub.labels).Set(1)
+	}
+	go sub.run()
+	return sub
+}
+
+// matches returns whether the subscriber is notified of the state of source
+func (s *subscription) matches(source EventSource) bool {
+	topic := s.subscriber.Topic()
+	if topic == MONITORING {
+		return false
+	}
+	if topic != source {
+		if ok, _ := path.Match(string(topic), string(source)); !ok {
+			return false
+		}
+	}
+	if f, ok := s.subscriber.(SourceFilter); ok {
+		return slices.Contains(f.Sources(), source)
+	}
+	return true
+}
+
+// push queues n, dropping the oldest notification when the queue is full. It never blocks.
+func (s *subscription) push(n notification) {
+	n.time = time.Now()
+	s.mu.Lock()
+	if len(s.queue) >= s.size {
+		s.queue = s.queue[1:]
+		glog.Warningf("subscriber %s is too slow, dropping its oldest notification", s.id)
+		if m := s.metrics.Dropped; m != nil {
+			m.With(s.labels).Inc()
+		}
+	}
+	s.queue = append(s.queue, n)
+	s.setDepth(len(s.queue))
+	s.mu.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription) pop() (notification, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return notification{}, false
+	}
+	n := s.queue[0]
+	s.queue = s.queue[1:]
+	s.setDepth(len(s.queue))
+	return n, true
+}
+
+func (s *subscription) setDepth(depth int) {
+	if m := s.metrics.Depth; m != nil {
+		m.With(s.labels).Set(float64(depth))
+	}
+}
+
+// close stops the worker, the notifications still queued are dropped
+func (s *subscription) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		for _, m := range []*prometheus.GaugeVec{s.metrics.Depth, s.metrics.Lag, s.metrics.Healthy} {
+			if m != nil {
+				m.Delete(s.labels)
+			}
+		}
+	})
+}
+
+// run delivers the queued notifications in order until the subscription is closed
+func (s *subscription) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.ready:
+		}
+		for {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			n, ok := s.pop()
+			if !ok {
+				break
+			}
+			s.deliver(n)
+			if n.last {
+				s.close()
+				return
+			}
+		}
+	}
+}
+
+// deliver calls the subscriber, it is unregistered after MaxSubscriberFailures consecutive failures
+func (s *subscription) deliver(n notification) {
+	err := s.call(n)
+	metrics := s.metrics
+	if metrics.Lag != nil {
+		metrics.Lag.With(s.labels).Set(time.Since(n.time).Seconds())
+	}
+	if err == nil {
+		s.failures = 0
+		if metrics.Healthy != nil {
+			metrics.Healthy.With(s.labels).Set(1)
+		}
+		return
+	}
+	s.failures++
+	glog.Errorf("failed to notify subscriber %s (%d/%d): %v", s.id, s.failures, MaxSubscriberFailures, err)
+	if metrics.Failures != nil {
+		metrics.Failures.With(s.labels).Inc()
+	}
+	if metrics.Healthy != nil {
+		metrics.Healthy.With(s.labels).Set(0)
 	}
+	if s.failures >= MaxSubscriberFailures && !n.last {
+		glog.Errorf("unregistering subscriber %s after %d consecutive failures", s.id, s.failures)
+		s.notifier.Lock()
+		s.notifier.remove(s)
+		s.notifier.Unlock()
+	}
+}
 
+// call makes the call of n, a panic is returned as an error
+func (s *subscription) call(n notification) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	switch {
+	case n.monitor:
+		s.subscriber.Monitor()
+	case n.clockClassChange:
+		glog.Infof("notifying %s clock class %d", n.cfgName, n.clockClass)
+		s.subscriber.(ClockClassSubscriber).NotifyClockClass(n.cfgName, n.clockClass)
+	default:
+		glog.Infof("notifying source %v with state %v", n.source, n.state)
+		if i, ok := s.subscriber.(InstanceSubscriber); ok {
+			i.NotifyInstance(n.source, Instance{CfgName: n.cfgName, IFace: n.iface}, n.state)
+			return nil
+		}
+		if e, ok := s.subscriber.(ErrorSubscriber); ok {
+			return e.TryNotify(n.source, n.state)
+		}
+		s.subscriber.Notify(n.source, n.state)
+	}
+	return nil
 }
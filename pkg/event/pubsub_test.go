@@ -1,10 +1,15 @@
 package event_test
 
 import (
+	"errors"
+	"testing"
+	"time"
+
 	"github.com/golang/glog"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
-	"testing"
 )
 
 // Subscriber ... event subscriber
@@ -52,3 +57,166 @@ func TestNewStateNotifier(t *testing.T) {
 	event.StateRegisterer.Unregister(m)
 	assert.Equal(t, 0, len(event.StateRegisterer.Subscribers))
 }
+
+// recorder is a subscriber sending its notifications to notified, blocking until unblock is closed
+type recorder struct {
+	id       string
+	topic    event.EventSource
+	sources  []event.EventSource
+	notified chan string
+	unblock  chan struct{}
+	err      error
+}
+
+func newRecorder(id string, topic event.EventSource) *recorder {
+	unblock := make(chan struct{})
+	close(unblock)
+	return &recorder{id: id, topic: topic, notified: make(chan string, 100), unblock: unblock}
+}
+
+func (r *recorder) ID() string               { return r.id }
+func (r *recorder) Monitor()                 {}
+func (r *recorder) Topic() event.EventSource { return r.topic }
+
+func (r *recorder) Notify(source event.EventSource, state event.PTPState) {
+	r.notified <- string(source) + " " + string(state)
+	<-r.unblock
+}
+
+// filtered is a recorder of some of the sources of its topic only
+type filtered struct{ *recorder }
+
+func (f filtered) Sources() []event.EventSource { return f.sources }
+
+// failing is a recorder whose notifications fail
+type failing struct{ *recorder }
+
+func (f failing) TryNotify(source event.EventSource, state event.PTPState) error {
+	f.Notify(source, state)
+	return f.err
+}
+
+func received(t *testing.T, r *recorder, n int) []string {
+	var got []string
+	for i := 0; i < n; i++ {
+		select {
+		case s := <-r.notified:
+			got = append(got, s)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s received %v, expected %d notifications", r.id, got, n)
+		}
+	}
+	return got
+}
+
+func TestStateNotifier_delivery(t *testing.T) {
+	n := event.NewStateNotifier()
+	metrics := event.SubscriberMetrics{
+		Depth:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "depth"}, []string{"node", "subscriber"}),
+		Healthy: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "healthy"}, []string{"node", "subscriber"}),
+		Lag:     prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "lag"}, []string{"node", "subscriber"}),
+		Dropped: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dropped"}, []string{"node", "subscriber"}),
+	}
+	n.SetMetrics("node", metrics)
+
+	fast := newRecorder("fast", event.GNSS)
+	n.Register(fast)
+	n.SetQueueSize(2)
+	slow := newRecorder("slow", event.GNSS)
+	slow.unblock = make(chan struct{})
+	n.Register(slow)
+
+	states := []event.PTPState{event.PTP_LOCKED, event.PTP_HOLDOVER, event.PTP_FREERUN, event.PTP_LOCKED, event.PTP_FREERUN}
+	n.Publish(event.GNSS, states[0])
+	assert.Equal(t, []string{"gnss s2"}, received(t, slow, 1))
+	for _, state := range states[1:] {
+		n.Publish(event.GNSS, state)
+	}
+	// the slow subscriber does not delay the fast one, which gets every state in order
+	assert.Equal(t, []string{"gnss s2", "gnss s1", "gnss s0", "gnss s2", "gnss s0"}, received(t, fast, len(states)))
+
+	// the slow one has the oldest of the 4 states published while it was blocked dropped
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.Depth.WithLabelValues("node", "gnss_slow")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.Dropped.WithLabelValues("node", "gnss_slow")))
+	close(slow.unblock)
+	assert.Equal(t, []string{"gnss s2", "gnss s0"}, received(t, slow, 2))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.Healthy.WithLabelValues("node", "gnss_fast")))
+
+	n.Unregister(slow)
+	n.Publish(event.GNSS, event.PTP_HOLDOVER)
+	assert.Equal(t, []string{"gnss s1"}, received(t, fast, 1))
+	select {
+	case s := <-slow.notified:
+		t.Errorf("unregistered subscriber notified of %s", s)
+	case <-time.After(100 * time.Millisecond):
+	}
+	n.Unregister(fast)
+}
+
+func TestStateNotifier_topics(t *testing.T) {
+	n := event.NewStateNotifier()
+	all := newRecorder("all", event.TopicAll)
+	ts2phc := newRecorder("ts2phc", "ts2phc*")
+	some := filtered{newRecorder("some", event.TopicAll)}
+	some.sources = []event.EventSource{event.DPLL, event.GNSS}
+	n.Register(all)
+	n.Register(ts2phc)
+	n.Register(some)
+	defer func() {
+		for _, s := range []event.Subscriber{all, ts2phc, some} {
+			n.Unregister(s)
+		}
+	}()
+
+	n.Publish(event.TS2PHC, event.PTP_LOCKED)
+	n.Publish(event.GNSS, event.PTP_FREERUN)
+	n.Publish(event.PTP4l, event.PTP_HOLDOVER)
+	assert.Equal(t, []string{"ts2phc s2", "gnss s0", "ptp4l s1"}, received(t, all, 3))
+	assert.Equal(t, []string{"ts2phc s2"}, received(t, ts2phc, 1))
+	assert.Equal(t, []string{"gnss s0"}, received(t, some.recorder, 1))
+}
+
+func TestStateNotifier_failures(t *testing.T) {
+	n := event.NewStateNotifier()
+	failures := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "failures"}, []string{"node", "subscriber"})
+	n.SetMetrics("node", event.SubscriberMetrics{Failures: failures})
+	f := failing{newRecorder("failing", event.DPLL)}
+	f.err = errors.New("unreachable")
+	n.Register(f)
+
+	for i := 0; i < event.MaxSubscriberFailures+2; i++ {
+		n.Publish(event.DPLL, event.PTP_LOCKED)
+	}
+	received(t, f.recorder, event.MaxSubscriberFailures)
+	assert.Eventually(t, func() bool {
+		n.Lock()
+		defer n.Unlock()
+		return len(n.Subscribers) == 0
+	}, time.Second, 10*time.Millisecond, "unregistered after repeated failures")
+	assert.Equal(t, float64(event.MaxSubscriberFailures), testutil.ToFloat64(failures.WithLabelValues("node", "dpll_failing")))
+
+	// a panic is a failure too, the notifications go on
+	panicking := &panicker{recorder: newRecorder("panicking", event.DPLL)}
+	n.Register(panicking)
+	defer n.Unregister(panicking)
+	n.Publish(event.DPLL, event.PTP_FREERUN)
+	n.Publish(event.DPLL, event.PTP_LOCKED)
+	assert.Equal(t, []string{"dpll s2"}, received(t, panicking.recorder, 1))
+	assert.Equal(t, float64(event.MaxSubscriberFailures), testutil.ToFloat64(failures.WithLabelValues("node", "dpll_failing")),
+		"metrics by subscriber")
+	assert.Equal(t, 1.0, testutil.ToFloat64(failures.WithLabelValues("node", "dpll_panicking")))
+}
+
+// panicker panics on its first notification
+type panicker struct {
+	*recorder
+	panicked bool
+}
+
+func (p *panicker) Notify(source event.EventSource, state event.PTPState) {
+	if !p.panicked {
+		p.panicked = true
+		panic("first notification")
+	}
+	p.recorder.Notify(source, state)
+}
@@ -0,0 +1,215 @@
+package event
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultQueueCapacity is the number of events a Queue holds before dropping periodic ones
+const DefaultQueueCapacity = 100
+
+// Queue is the bounded queue between the processes and monitors sending events and ProcessEvents.
+// Push never blocks, so a slow event loop does not stall the senders. Events are delivered in the order they
+// were pushed. Events changing the state of their source are never dropped; periodic events, repeating the state
+// of their source with new values, are coalesced: a periodic event replaces the one of the same source still queued,
+// in its place. When the queue is full, new periodic events are dropped and state transitions evict the oldest
+// periodic event.
+type Queue struct {
+	mu        sync.Mutex
+	nodeName  string
+	capacity  int
+	events    *list.List               // of queuedEvent, oldest first
+	bySource  map[string]*list.Element // queued periodic event, by source
+	last      map[string]sourceState   // state of the last event pushed, by source
+	inFlight  bool                     // an event was taken out and waits for ProcessEvents
+	ready     chan struct{}
+	out       chan EventChannel
+	done      chan struct{}
+	closeOnce sync.Once
+
+	depthMetric     *prometheus.GaugeVec
+	droppedMetric   *prometheus.CounterVec
+	coalescedMetric *prometheus.CounterVec
+}
+
+// queuedEvent is an event waiting in the queue
+type queuedEvent struct {
+	ev       EventChannel
+	periodic bool
+}
+
+// sourceState is what tells a state transition from a periodic event
+type sourceState struct {
+	state      PTPState
+	sourceLost bool
+	outOfSpec  bool
+}
+
+// NewQueue creates a queue of capacity events, the metrics may be nil
+func NewQueue(nodeName string, capacity int, depthMetric *prometheus.GaugeVec, droppedMetric, coalescedMetric *prometheus.CounterVec) *Queue {
+	q := &Queue{
+		nodeName:        nodeName,
+		capacity:        capacity,
+		events:          list.New(),
+		bySource:        map[string]*list.Element{},
+		last:            map[string]sourceState{},
+		ready:           make(chan struct{}, 1),
+		out:             make(chan EventChannel),
+		done:            make(chan struct{}),
+		depthMetric:     depthMetric,
+		droppedMetric:   droppedMetric,
+		coalescedMetric: coalescedMetric,
+	}
+	go q.run()
+	return q
+}
+
+// sourceKey identifies the source of an event
+func sourceKey(ev EventChannel) string {
+	return string(ev.ProcessName) + "/" + ev.CfgName + "/" + ev.IFace
+}
+
+// Push queues ev, it returns false when ev was dropped. Pushing to a nil queue drops the event.
+func (q *Queue) Push(ev EventChannel) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := sourceKey(ev)
+	state := sourceState{state: ev.State, sourceLost: ev.SourceLost, outOfSpec: ev.OutOfSpec}
+	last, seen := q.last[key]
+	q.last[key] = state
+	if ev.Reset {
+		delete(q.last, key)
+	}
+
+	if !ev.Reset && seen && last == state {
+		if e, ok := q.bySource[key]; ok {
+			e.Value = queuedEvent{ev: ev, periodic: true}
+			q.count(q.coalescedMetric, ev)
+			return true
+		}
+		if q.lenLocked() >= q.capacity {
+			q.count(q.droppedMetric, ev)
+			return false
+		}
+		q.bySource[key] = q.events.PushBack(queuedEvent{ev: ev, periodic: true})
+	} else {
+		// the queued periodic event of the source reports the previous state, the transition supersedes it
+		if e, ok := q.bySource[key]; ok {
+			q.remove(e)
+			q.count(q.coalescedMetric, ev)
+		}
+		if q.lenLocked() >= q.capacity {
+			if oldest := q.oldestPeriodic(); oldest != nil {
+				q.remove(oldest)
+				q.count(q.droppedMetric, oldest.Value.(queuedEvent).ev)
+			} else {
+				glog.Warningf("event queue holds %d state transitions, over its capacity of %d", q.lenLocked()+1, q.capacity)
+			}
+		}
+		q.events.PushBack(queuedEvent{ev: ev})
+	}
+	q.updateDepth()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Out returns the channel ProcessEvents receives the events from
+func (q *Queue) Out() <-chan EventChannel {
+	return q.out
+}
+
+// Len returns the number of events not received from Out yet
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := q.lenLocked()
+	if q.inFlight {
+		n++
+	}
+	return n
+}
+
+// Close stops delivering the events
+func (q *Queue) Close() {
+	q.closeOnce.Do(func() { close(q.done) })
+}
+
+// run delivers the queued events to Out
+func (q *Queue) run() {
+	for {
+		ev, ok := q.pop()
+		if !ok {
+			select {
+			case <-q.ready:
+				continue
+			case <-q.done:
+				return
+			}
+		}
+		select {
+		case q.out <- ev:
+		case <-q.done:
+			return
+		}
+		q.mu.Lock()
+		q.inFlight = false
+		q.mu.Unlock()
+	}
+}
+
+// pop takes the next event out of the queue
+func (q *Queue) pop() (EventChannel, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e := q.events.Front()
+	if e == nil {
+		return EventChannel{}, false
+	}
+	q.remove(e)
+	q.inFlight = true
+	q.updateDepth()
+	return e.Value.(queuedEvent).ev, true
+}
+
+// oldestPeriodic returns the periodic event queued first, nil when only state transitions are queued
+func (q *Queue) oldestPeriodic() *list.Element {
+	for e := q.events.Front(); e != nil; e = e.Next() {
+		if e.Value.(queuedEvent).periodic {
+			return e
+		}
+	}
+	return nil
+}
+
+// remove removes a queued event
+func (q *Queue) remove(e *list.Element) {
+	q.events.Remove(e)
+	if queued := e.Value.(queuedEvent); queued.periodic {
+		delete(q.bySource, sourceKey(queued.ev))
+	}
+}
+
+func (q *Queue) lenLocked() int {
+	return q.events.Len()
+}
+
+func (q *Queue) updateDepth() {
+	if q.depthMetric != nil {
+		q.depthMetric.With(prometheus.Labels{"node": q.nodeName}).Set(float64(q.lenLocked()))
+	}
+}
+
+func (q *Queue) count(metric *prometheus.CounterVec, ev EventChannel) {
+	if metric != nil {
+		metric.With(prometheus.Labels{"node": q.nodeName, "source": string(ev.ProcessName)}).Inc()
+	}
+}
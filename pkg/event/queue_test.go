@@ -0,0 +1,114 @@
+package event_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type queueMetrics struct {
+	depth     *prometheus.GaugeVec
+	dropped   *prometheus.CounterVec
+	coalesced *prometheus.CounterVec
+}
+
+func newQueue(t *testing.T, capacity int) (*event.Queue, queueMetrics) {
+	m := queueMetrics{
+		depth:     prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "depth"}, []string{"node"}),
+		dropped:   prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dropped"}, []string{"node", "source"}),
+		coalesced: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "coalesced"}, []string{"node", "source"}),
+	}
+	q := event.NewQueue("node", capacity, m.depth, m.dropped, m.coalesced)
+	t.Cleanup(q.Close)
+	// the first event is taken out of the queue right away, where it waits for the event loop
+	q.Push(queueEvent(event.DPLL, "blocker", event.PTP_LOCKED, 0))
+	assert.Eventually(t, func() bool { return m.depthValue() == 0 }, time.Second, time.Millisecond)
+	return q, m
+}
+
+func (m queueMetrics) depthValue() float64 {
+	return testutil.ToFloat64(m.depth.WithLabelValues("node"))
+}
+
+func queueEvent(source event.EventSource, iface string, state event.PTPState, offset int64) event.EventChannel {
+	return event.EventChannel{ProcessName: source, CfgName: "ts2phc.0.config", IFace: iface, State: state,
+		Values: map[event.ValueType]interface{}{event.OFFSET: offset}}
+}
+
+// receive returns the sources, interfaces and offsets of the n next events
+func receive(t *testing.T, q *event.Queue, n int) []string {
+	var got []string
+	for i := 0; i < n; i++ {
+		select {
+		case ev := <-q.Out():
+			got = append(got, string(ev.ProcessName)+" "+ev.IFace+" "+string(ev.State)+" "+
+				time.Duration(ev.Values[event.OFFSET].(int64)).String())
+		case <-time.After(time.Second):
+			t.Fatalf("event %d not received", i)
+		}
+	}
+	return got
+}
+
+func TestQueue_order(t *testing.T) {
+	q, m := newQueue(t, 10)
+	assert.True(t, q.Push(queueEvent(event.TS2PHC, "ens1f0", event.PTP_LOCKED, 1)))
+	assert.True(t, q.Push(queueEvent(event.TS2PHC, "ens1f0", event.PTP_LOCKED, 2)), "periodic")
+	assert.True(t, q.Push(queueEvent(event.GNSS, "ens1f0", event.PTP_LOCKED, 3)))
+	assert.True(t, q.Push(queueEvent(event.TS2PHC, "ens1f0", event.PTP_LOCKED, 4)), "periodic, replacing 2")
+	assert.Equal(t, float64(3), m.depthValue())
+	assert.Equal(t, 4, q.Len(), "with the event waiting for the event loop")
+
+	assert.Equal(t, []string{
+		"dpll blocker s2 0s",
+		"ts2phc ens1f0 s2 1ns",
+		"ts2phc ens1f0 s2 4ns",
+		"gnss ens1f0 s2 3ns",
+	}, receive(t, q, 4), "in arrival order, the coalesced event in the place of the one it replaced")
+	assert.Eventually(t, func() bool { return q.Len() == 0 }, time.Second, time.Millisecond)
+}
+
+func TestQueue_coalesceAndDrop(t *testing.T) {
+	q, m := newQueue(t, 3)
+	q.Push(queueEvent(event.GNSS, "ens1f0", event.PTP_LOCKED, 1))
+	q.Push(queueEvent(event.GNSS, "ens1f0", event.PTP_LOCKED, 2))
+	q.Push(queueEvent(event.GNSS, "ens1f0", event.PTP_LOCKED, 3))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.coalesced.WithLabelValues("node", "gnss")), "the newest periodic event replaces the queued one")
+	q.Push(queueEvent(event.TS2PHC, "ens1f0", event.PTP_LOCKED, 4))
+	assert.Equal(t, float64(3), m.depthValue())
+
+	assert.False(t, q.Push(queueEvent(event.TS2PHC, "ens1f0", event.PTP_LOCKED, 5)), "periodic events are dropped when the queue is full")
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.dropped.WithLabelValues("node", "ts2phc")))
+
+	assert.True(t, q.Push(queueEvent(event.GNSS, "ens1f0", event.PTP_FREERUN, 6)), "state transitions are never dropped")
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.coalesced.WithLabelValues("node", "gnss")), "the transition supersedes the queued periodic event")
+	assert.True(t, q.Push(queueEvent(event.DPLL, "ens2f0", event.PTP_FREERUN, 7)))
+	assert.Equal(t, float64(4), m.depthValue(), "over capacity rather than dropping a transition")
+
+	assert.Equal(t, []string{
+		"dpll blocker s2 0s",
+		"gnss ens1f0 s2 1ns",
+		"ts2phc ens1f0 s2 4ns",
+		"gnss ens1f0 s0 6ns",
+		"dpll ens2f0 s0 7ns",
+	}, receive(t, q, 5))
+
+	// a transition evicts the oldest periodic event, not an older transition
+	q, m = newQueue(t, 2)
+	q.Push(queueEvent(event.TS2PHC, "ens1f0", event.PTP_LOCKED, 1))
+	q.Push(queueEvent(event.TS2PHC, "ens1f0", event.PTP_LOCKED, 2))
+	assert.True(t, q.Push(queueEvent(event.GNSS, "ens1f0", event.PTP_LOCKED, 3)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.dropped.WithLabelValues("node", "ts2phc")))
+	assert.Equal(t, []string{
+		"dpll blocker s2 0s",
+		"ts2phc ens1f0 s2 1ns",
+		"gnss ens1f0 s2 3ns",
+	}, receive(t, q, 3))
+
+	var nilQueue *event.Queue
+	assert.False(t, nilQueue.Push(queueEvent(event.GNSS, "ens1f0", event.PTP_LOCKED, 1)))
+}
@@ -76,9 +76,7 @@ func (d *Data) AddEvent(event EventChannel) {
 		if dd.IFace == event.IFace {
 			if dd.time <= event.Time {
 				if dd.State != event.State {
-					if len(StateRegisterer.Subscribers) > 0 {
-						go StateRegisterer.notify(event.ProcessName, event.State)
-					}
+					StateRegisterer.notify(event.ProcessName, Instance{CfgName: event.CfgName, IFace: event.IFace}, event.State)
 				}
 				dd.State = event.State
 				dd.sourceLost = event.SourceLost
@@ -103,10 +101,7 @@ func (d *Data) AddEvent(event EventChannel) {
 	}
 	d.logData = details.logData
 	d.Details = append(d.Details, details)
-	if len(StateRegisterer.Subscribers) > 0 {
-		glog.Infof("notify state changed for %s", event.ProcessName)
-		go StateRegisterer.notify(event.ProcessName, event.State)
-	}
+	StateRegisterer.notify(event.ProcessName, Instance{CfgName: event.CfgName, IFace: event.IFace}, event.State)
 }
 
 // ToString ... data
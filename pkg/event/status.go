@@ -0,0 +1,107 @@
+package event
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/protocol"
+)
+
+// Status returns the clock state of every config. It is answered by the event loop,
+// an error is returned when the loop does not answer within timeout.
+func (e *EventHandler) Status(timeout time.Duration) ([]apiv1.Config, error) {
+	reply := make(chan []apiv1.Config, 1)
+	select {
+	case e.statusCh <- reply:
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("event loop did not answer within %s", timeout)
+	}
+	select {
+	case configs := <-reply:
+		return configs, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("event loop did not answer within %s", timeout)
+	}
+}
+
+// status returns the clock state of every config, it runs in the event loop
+func (e *EventHandler) status() []apiv1.Config {
+	names := map[string]bool{}
+	for cfgName := range e.data {
+		names[cfgName] = true
+	}
+	for cfgName := range e.gmSyncState {
+		names[cfgName] = true
+	}
+	e.Lock()
+	quality := make(map[string][2]uint8, len(e.clockQuality))
+	for cfgName, q := range e.clockQuality {
+		names[cfgName] = true
+		quality[cfgName] = [2]uint8{uint8(q.ClockClass), uint8(q.ClockAccuracy)}
+	}
+	e.Unlock()
+
+	configs := make([]apiv1.Config, 0, len(names))
+	for cfgName := range names {
+		c := apiv1.Config{Name: cfgName, Sources: []apiv1.Source{}}
+		if gm, ok := e.gmSyncState[cfgName]; ok && gm.clockClass != protocol.ClockClassUninitialized {
+			c.GM = &apiv1.GMState{
+				State:         stateName(gm.state),
+				ClockClass:    uint8(gm.clockClass),
+				ClockAccuracy: uint8(gm.clockAccuracy),
+				SourceLost:    gm.sourceLost,
+				Interface:     gm.gmIFace,
+			}
+		}
+		if q, ok := quality[cfgName]; ok {
+			c.ClockClass, c.ClockAccuracy = &q[0], &q[1]
+		}
+		for _, d := range e.data[cfgName] {
+			c.Sources = append(c.Sources, d.status())
+		}
+		sort.Slice(c.Sources, func(i, j int) bool { return c.Sources[i].Name < c.Sources[j].Name })
+		configs = append(configs, c)
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
+	return configs
+}
+
+func (d *Data) status() apiv1.Source {
+	s := apiv1.Source{Name: string(d.ProcessName), State: stateName(d.State), Interfaces: []apiv1.Interface{}}
+	for _, dd := range d.Details {
+		i := apiv1.Interface{
+			Name:         dd.IFace,
+			State:        stateName(dd.State),
+			ClockType:    string(dd.ClockType),
+			SignalSource: string(dd.signalSource),
+			SourceLost:   dd.sourceLost,
+		}
+		if dd.time > 0 {
+			t := time.UnixMilli(dd.time)
+			i.Time = &t
+		}
+		for valueType, m := range dd.Metrics {
+			if i.Values == nil {
+				i.Values = map[string]float64{}
+			}
+			i.Values[string(valueType)] = m.Value
+		}
+		s.Interfaces = append(s.Interfaces, i)
+	}
+	return s
+}
+
+// stateName returns the API name of a clock state
+func stateName(state PTPState) string {
+	switch state {
+	case PTP_LOCKED, "s3":
+		return apiv1.StateLocked
+	case PTP_HOLDOVER:
+		return apiv1.StateHoldover
+	case PTP_FREERUN:
+		return apiv1.StateFreerun
+	}
+	return apiv1.StateUnknown
+}
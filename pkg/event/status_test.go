@@ -0,0 +1,78 @@
+package event_test
+
+import (
+	"testing"
+	"time"
+
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventHandler_status(t *testing.T) {
+	assert.NoError(t, leap.MockLeapFile())
+	defer close(leap.LeapMgr.Close)
+	eventManager, eQueue := newEventHandler(t)
+	go eventManager.ProcessEvents()
+
+	// the grandmaster locks, then its DPLL goes in holdover
+	for _, ev := range []event.EventChannel{
+		sendEvents("ts2phc.0.config", "ens1f0", event.DPLL, event.PTP_LOCKED,
+			map[event.ValueType]interface{}{event.OFFSET: 0, event.PHASE_STATUS: 3, event.FREQUENCY_STATUS: 3, event.PPS_STATUS: 1}, false, false),
+		sendEvents("ts2phc.0.config", "ens1f0", event.GNSS, event.PTP_LOCKED, map[event.ValueType]interface{}{event.OFFSET: 0, event.GPS_STATUS: 3}, false, false),
+		sendEvents("ts2phc.0.config", "ens1f0", event.TS2PHCProcessName, event.PTP_LOCKED, map[event.ValueType]interface{}{event.OFFSET: 0}, false, false),
+		sendEvents("ts2phc.0.config", "ens1f0", event.DPLL, event.PTP_HOLDOVER,
+			map[event.ValueType]interface{}{event.OFFSET: 0, event.PHASE_STATUS: 4, event.FREQUENCY_STATUS: 4, event.PPS_STATUS: 1}, false, false),
+	} {
+		eQueue.Push(ev)
+		assert.NoError(t, eventManager.Drain(5*time.Second))
+	}
+
+	configs, err := eventManager.Status(time.Second)
+	assert.NoError(t, err)
+	if assert.Len(t, configs, 1) {
+		c := configs[0]
+		assert.Equal(t, "ts2phc.0.config", c.Name)
+		if assert.NotNil(t, c.GM) {
+			assert.Equal(t, apiv1.StateHoldover, c.GM.State)
+			assert.Equal(t, uint8(7), c.GM.ClockClass)
+			assert.Equal(t, "ens1f0", c.GM.Interface)
+		}
+		if assert.NotNil(t, c.ClockClass) {
+			assert.Equal(t, uint8(7), *c.ClockClass)
+		}
+		var sources []string
+		for _, source := range c.Sources {
+			sources = append(sources, source.Name)
+		}
+		assert.Equal(t, []string{string(event.DPLL), string(event.GNSS), string(event.TS2PHC)}, sources)
+	}
+}
+
+func TestEventHandler_statusValues(t *testing.T) {
+	offsetMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_offset_ns"}, []string{"from", "node", "process", "iface"})
+	clockMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_clock_state"}, []string{"process", "node", "iface"})
+	eQueue := event.NewQueue("node", event.DefaultQueueCapacity, nil, nil, nil)
+	closeChn := make(chan bool)
+	eventManager := event.Init("node", false, "", eQueue, closeChn, offsetMetric, clockMetric, nil)
+	go eventManager.ProcessEvents()
+	defer close(closeChn)
+
+	// the status reports the last offset of a source, not the first one
+	for _, offset := range []int64{10, 20} {
+		ev := sendEvents("phc2sys.0.config", "ens1f0", event.PHC2SYS, event.PTP_LOCKED, map[event.ValueType]interface{}{event.OFFSET: offset}, false, false)
+		ev.ClockType = event.OC
+		eQueue.Push(ev)
+	}
+	assert.Eventually(t, func() bool {
+		configs, err := eventManager.Status(time.Second)
+		if err != nil || len(configs) != 1 || len(configs[0].Sources) != 1 || len(configs[0].Sources[0].Interfaces) != 1 {
+			return false
+		}
+		return configs[0].Sources[0].Interfaces[0].Values[string(event.OFFSET)] == 20
+	}, 5*time.Second, 100*time.Millisecond)
+	assert.Equal(t, float64(20), testutil.ToFloat64(offsetMetric.WithLabelValues(string(event.PHC2SYS), "node", string(event.PHC2SYS), "ens1fx")))
+}
@@ -0,0 +1,94 @@
+package eventsocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"slices"
+	"time"
+)
+
+// NegotiationTimeout is how long Dial waits for the Hello of the consumer before falling back to ModeText
+var NegotiationTimeout = 500 * time.Millisecond
+
+// Conn is the daemon end of a connection. Its Write takes the legacy text lines the daemon has always written,
+// and sends them as they are in ModeText, or as the events they stand for in ModeJSON.
+type Conn struct {
+	net.Conn
+	mode Mode
+}
+
+// Dial connects to the events socket at path and negotiates the protocol with the consumer
+func Dial(path string) (*Conn, error) {
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := negotiate(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &Conn{Conn: c, mode: mode}, nil
+}
+
+// negotiate reads the Hello of the consumer and accepts Version when it is supported,
+// a consumer that sends nothing within NegotiationTimeout, or no Hello, gets ModeText
+func negotiate(c net.Conn) (Mode, error) {
+	if err := c.SetReadDeadline(time.Now().Add(NegotiationTimeout)); err != nil {
+		return ModeText, err
+	}
+	// the consumer sends nothing else, reading past the Hello line is harmless
+	line, err := bufio.NewReader(c).ReadBytes('\n')
+	if resetErr := c.SetReadDeadline(time.Time{}); resetErr != nil {
+		return ModeText, resetErr
+	}
+	if err != nil {
+		return ModeText, nil
+	}
+	var hello Hello
+	if json.Unmarshal(line, &hello) != nil || hello.Protocol != Protocol || !slices.Contains(hello.Versions, Version) {
+		return ModeText, nil
+	}
+	b, err := json.Marshal(Accept{Protocol: Protocol, Version: Version})
+	if err != nil {
+		return ModeText, err
+	}
+	if _, err = c.Write(append(b, '\n')); err != nil {
+		return ModeText, err
+	}
+	return ModeJSON, nil
+}
+
+// Mode returns how the events are sent on c
+func (c *Conn) Mode() Mode {
+	return c.mode
+}
+
+// Write sends the text lines of b, as events in ModeJSON. All of b is sent with one write,
+// so writes from several goroutines do not interleave.
+func (c *Conn) Write(b []byte) (int, error) {
+	if c.mode != ModeJSON {
+		return c.Conn.Write(b)
+	}
+	var out bytes.Buffer
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		ev, err := json.Marshal(Parse(string(line)))
+		if err != nil {
+			return 0, err
+		}
+		out.Write(ev)
+		out.WriteByte('\n')
+	}
+	if out.Len() == 0 {
+		return len(b), nil
+	}
+	if _, err := c.Conn.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
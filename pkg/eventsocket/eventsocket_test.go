@@ -0,0 +1,190 @@
+package eventsocket
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	delay := int64(508)
+	tests := []struct {
+		line string
+		want Event
+	}{
+		{"ptp4l[1700000000]:[ptp4l.0.config] PTP_PROCESS_STATUS:1\n",
+			Event{Type: TypeProcessStatus, Process: "ptp4l", Config: "ptp4l.0.config", ProcessStatus: &ProcessStatus{Up: true, Status: 1}}},
+		{"ptp4l[1700000000]:[ptp4l.0.config] PTP_PROCESS_STATUS:0",
+			Event{Type: TypeProcessStatus, Process: "ptp4l", Config: "ptp4l.0.config", ProcessStatus: &ProcessStatus{Status: 0}}},
+		{"ptp4l[1700000000]:[ts2phc.0.config] CLOCK_CLASS_CHANGE 6",
+			Event{Type: TypeClockClass, Process: "ptp4l", Config: "ts2phc.0.config", ClockClass: &ClockClass{ClockClass: 6}}},
+		{"ptp4l[1700000000]:[ptp4l.0.config] CLOCK_CLASS_CHANGE 248.000000",
+			Event{Type: TypeClockClass, Process: "ptp4l", Config: "ptp4l.0.config", ClockClass: &ClockClass{ClockClass: 248}}},
+		{"phc2sys[1823126.732]: [ptp4l.0.config:6] CLOCK_REALTIME phc offset       -10 s2 freq   +8956 delay    508",
+			Event{Type: TypeOffset, Process: "phc2sys", Config: "ptp4l.0.config", Iface: "CLOCK_REALTIME",
+				Offset: &Offset{Source: "phc", Offset: -10, ServoState: "s2", Freq: 8956, Delay: &delay}}},
+		{"ptp4l[5196755.139]: [ptp4l.0.config:6] master offset          5 s2 freq   -5727 path delay       508",
+			Event{Type: TypeOffset, Process: "ptp4l", Config: "ptp4l.0.config",
+				Offset: &Offset{Source: "master", Offset: 5, ServoState: "s2", Freq: -5727, Delay: &delay}}},
+		{"ts2phc[441664.291]: [ts2phc.0.config:6] ens2f0 master offset          0 s2 freq      -0",
+			Event{Type: TypeOffset, Process: "ts2phc", Config: "ts2phc.0.config", Iface: "ens2f0",
+				Offset: &Offset{Source: "master", ServoState: "s2"}}},
+		{"ptp4l[8542280.698]: [ptp4l.0.config:5] port 1 (ens1f0): UNCALIBRATED to SLAVE on MASTER_CLOCK_SELECTED",
+			Event{Type: TypeState, Process: "ptp4l", Config: "ptp4l.0.config", Iface: "ens1f0",
+				State: &State{Port: 1, From: "UNCALIBRATED", State: "SLAVE", Event: "MASTER_CLOCK_SELECTED"}}},
+		{"GM[1700000000]:[ts2phc.0.config] ens1f0 T-GM-STATUS s2",
+			Event{Type: TypeState, Process: "GM", Config: "ts2phc.0.config", Iface: "ens1f0", State: &State{State: "s2"}}},
+		{"dpll[1700000000]:[ts2phc.0.config] ens1f0 frequency_status 3 offset -1 phase_status 3 pps_status 1 s2",
+			Event{Type: TypeState, Process: "dpll", Config: "ts2phc.0.config", Iface: "ens1f0",
+				State: &State{State: "s2", Values: map[string]float64{"frequency_status": 3, "offset": -1, "phase_status": 3, "pps_status": 1}}}},
+		{"synce4l[1700000000]:[synce4l.0.config]  clock_quality 0xff s1",
+			Event{Type: TypeState, Process: "synce4l", Config: "synce4l.0.config", State: &State{State: "s1", Values: map[string]float64{"clock_quality": 255}}}},
+		{"synce4l[622796.479]: [synce4l.0.config] tx_rebuild_tlv: attached new TLV, QL=0xf on ens7f0",
+			Event{Type: TypeSyncEQL, Process: "synce4l", Config: "synce4l.0.config", Iface: "ens7f0", SyncEQL: &SyncEQL{QL: 0xf}}},
+		{"synce4l[622796.479]: [synce4l.0.config] tx_rebuild_tlv: attached new extended TLV, EXT_QL=0xff on ens7f0",
+			Event{Type: TypeSyncEQL, Process: "synce4l", Config: "synce4l.0.config", Iface: "ens7f0", SyncEQL: &SyncEQL{QL: 0xff, Extended: true}}},
+		{"ptp4l[8542280.698]: [ptp4l.0.config:6] selected best master clock 507c6f.fffe.1fb16c",
+			Event{Type: TypeLog, Process: "ptp4l", Config: "ptp4l.0.config"}},
+		{"not a linuxptp line", Event{Type: TypeLog}},
+	}
+	for _, tt := range tests {
+		got := Parse(tt.line)
+		assert.WithinDuration(t, time.Now(), got.Time, time.Minute)
+		got.Time = time.Time{}
+		tt.want.Line = got.Line
+		assert.Equal(t, tt.want, got, tt.line)
+		assert.NotContains(t, got.Line, "\n")
+	}
+}
+
+// listen returns a listener on a socket of a temporary dir
+func listen(t *testing.T) (*Listener, string) {
+	path := filepath.Join(t.TempDir(), "events.sock")
+	l, err := Listen(path)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { l.Close() })
+	return l, path
+}
+
+// accept accepts the next daemon connection in the background
+func accept(l *Listener) <-chan *Stream {
+	ch := make(chan *Stream, 1)
+	go func() {
+		s, _ := l.Accept()
+		ch <- s
+	}()
+	return ch
+}
+
+func TestDial_json(t *testing.T) {
+	l, path := listen(t)
+	streams := accept(l)
+	c, err := Dial(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	assert.Equal(t, ModeJSON, c.Mode())
+	s := <-streams
+	defer s.Close()
+
+	_, err = c.Write([]byte("ptp4l[1700000000]:[ptp4l.0.config] PTP_PROCESS_STATUS:1\n\nptp4l[1700000000]:[ptp4l.0.config] CLOCK_CLASS_CHANGE 6\n"))
+	assert.NoError(t, err)
+	ev, err := s.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, ModeJSON, s.Mode())
+	assert.Equal(t, TypeProcessStatus, ev.Type)
+	assert.Equal(t, "ptp4l[1700000000]:[ptp4l.0.config] PTP_PROCESS_STATUS:1", ev.Line)
+	ev, err = s.Next()
+	assert.NoError(t, err)
+	if assert.Equal(t, TypeClockClass, ev.Type) {
+		assert.Equal(t, 6, ev.ClockClass.ClockClass)
+	}
+
+	c.Close()
+	_, err = s.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDial_textOnly(t *testing.T) {
+	defer func(timeout time.Duration) { NegotiationTimeout = timeout }(NegotiationTimeout)
+	NegotiationTimeout = 50 * time.Millisecond
+	l, path := listen(t)
+	l.TextOnly = true
+	streams := accept(l)
+	c, err := Dial(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	assert.Equal(t, ModeText, c.Mode())
+	s := <-streams
+	defer s.Close()
+
+	_, err = c.Write([]byte("ptp4l[1700000000]:[ptp4l.0.config] PTP_PROCESS_STATUS:0\n"))
+	assert.NoError(t, err)
+	ev, err := s.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, ModeText, s.Mode())
+	assert.Equal(t, TypeProcessStatus, ev.Type)
+}
+
+func TestDial_legacyConsumer(t *testing.T) {
+	defer func(timeout time.Duration) { NegotiationTimeout = timeout }(NegotiationTimeout)
+	NegotiationTimeout = 50 * time.Millisecond
+	path := filepath.Join(t.TempDir(), "events.sock")
+	l, err := net.Listen("unix", path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	conns := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		conns <- c
+	}()
+
+	c, err := Dial(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	assert.Equal(t, ModeText, c.Mode(), "a consumer sending no hello gets text")
+	line := "ptp4l[5196755.139]: [ptp4l.0.config:6] master offset          5 s2 freq   -5727 path delay       519\n"
+	_, err = c.Write([]byte(line))
+	assert.NoError(t, err)
+	consumer := <-conns
+	defer consumer.Close()
+	got, err := bufio.NewReader(consumer).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, line, got, "lines are sent unchanged")
+}
+
+func TestListen_legacyDaemon(t *testing.T) {
+	l, path := listen(t)
+	streams := accept(l)
+	c, err := net.Dial("unix", path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	s := <-streams
+	defer s.Close()
+
+	// a daemon not implementing the protocol ignores the hello and writes text lines
+	_, err = c.Write([]byte("ptp4l[8542280.698]: [ptp4l.0.config:5] port 1 (ens1f0): UNCALIBRATED to SLAVE on MASTER_CLOCK_SELECTED\n"))
+	assert.NoError(t, err)
+	ev, err := s.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, ModeText, s.Mode())
+	if assert.Equal(t, TypeState, ev.Type) {
+		assert.Equal(t, "SLAVE", ev.State.State)
+	}
+}
@@ -0,0 +1,102 @@
+package eventsocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+)
+
+// Listener is the consumer end of the events socket, the daemon connects to it once per process and once for its event loop
+type Listener struct {
+	listener net.Listener
+	// TextOnly skips the negotiation, the daemon then sends the legacy text lines
+	TextOnly bool
+}
+
+// Listen listens on the events socket at path, removing a socket left over by a previous consumer
+func Listen(path string) (*Listener, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{listener: l}, nil
+}
+
+// Close stops listening, the streams accepted stay open
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
+
+// Stream is a connection from the daemon, read event by event
+type Stream struct {
+	net.Conn
+	reader *bufio.Reader
+	mode   Mode
+}
+
+// Accept waits for the daemon to connect and sends it the Hello. The mode is known on the first Next:
+// a daemon that does not implement the protocol starts sending text lines instead of an Accept.
+func (l *Listener) Accept() (*Stream, error) {
+	c, err := l.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	s := &Stream{Conn: c, reader: bufio.NewReader(c)}
+	if l.TextOnly {
+		s.mode = ModeText
+		return s, nil
+	}
+	b, err := json.Marshal(Hello{Protocol: Protocol, Versions: []int{Version}})
+	if err == nil {
+		_, err = c.Write(append(b, '\n'))
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Mode returns how the daemon sends the events, empty until the first Next
+func (s *Stream) Mode() Mode {
+	return s.mode
+}
+
+// Next returns the next event, legacy text lines are parsed into events.
+// It returns io.EOF once the daemon closed the connection.
+func (s *Stream) Next() (Event, error) {
+	for {
+		line, err := s.reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return Event{}, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if s.mode == "" {
+			var accept Accept
+			if json.Unmarshal(line, &accept) == nil && accept.Protocol == Protocol {
+				if accept.Version != Version {
+					return Event{}, errors.New("the daemon accepted an unsupported protocol version")
+				}
+				s.mode = ModeJSON
+				continue
+			}
+			s.mode = ModeText
+		}
+		if s.mode == ModeText {
+			return Parse(string(line)), nil
+		}
+		var ev Event
+		if err = json.Unmarshal(line, &ev); err != nil {
+			return Event{}, err
+		}
+		return ev, nil
+	}
+}
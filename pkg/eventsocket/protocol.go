@@ -0,0 +1,200 @@
+// Package eventsocket implements the protocol of the events unix socket the daemon writes to when LOGS_TO_SOCKET is set,
+// for both ends: Dial is used by the daemon, Listen by the consumers.
+//
+// The consumer speaks first: on accepting a connection it sends a Hello line listing the versions it supports.
+// The daemon answers with an Accept line naming the version it picked, then sends one JSON encoded Event per line.
+// A consumer that sends no Hello gets the legacy text lines, unchanged, so existing consumers keep working;
+// Listen parses those lines into the same events, so a consumer built on it works with older daemons too.
+package eventsocket
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Protocol names the protocol in the Hello and Accept lines
+	Protocol = "linuxptp-daemon-events"
+	// Version is the version of the protocol implemented by this package.
+	// Fields are only added to a version, a change that breaks consumers goes to a new version.
+	Version = 1
+)
+
+// Hello is the first line sent by a consumer, listing the protocol versions it supports
+type Hello struct {
+	Protocol string `json:"protocol"`
+	Versions []int  `json:"versions"`
+}
+
+// Accept is the answer of the daemon to a Hello, every line after it is an Event of Version
+type Accept struct {
+	Protocol string `json:"protocol"`
+	Version  int    `json:"version"`
+}
+
+// Mode is how the events are sent on a connection
+type Mode string
+
+const (
+	ModeText Mode = "text" // legacy log lines
+	ModeJSON Mode = "json" // one JSON encoded Event per line
+)
+
+// Type is the type of an Event, it tells which of the payload fields is set
+type Type string
+
+const (
+	TypeProcessStatus Type = "processStatus" // ProcessStatus is set
+	TypeOffset        Type = "offset"        // Offset is set
+	TypeState         Type = "state"         // State is set
+	TypeClockClass    Type = "clockClass"    // ClockClass is set
+	TypeSyncEQL       Type = "synceQL"       // SyncEQL is set
+	TypeLog           Type = "log"           // any other line, only Line is set
+)
+
+// Event is a typed event sent on the socket
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	// Process is the process the event is about, i.e. ptp4l, dpll or GM
+	Process string `json:"process,omitempty"`
+	// Config is the config file of the process, i.e. ptp4l.0.config
+	Config string `json:"config,omitempty"`
+	Iface  string `json:"iface,omitempty"`
+
+	ProcessStatus *ProcessStatus `json:"processStatus,omitempty"`
+	Offset        *Offset        `json:"offset,omitempty"`
+	State         *State         `json:"state,omitempty"`
+	ClockClass    *ClockClass    `json:"clockClass,omitempty"`
+	SyncEQL       *SyncEQL       `json:"synceQL,omitempty"`
+
+	// Line is the legacy text line the event stands for, without its line feed
+	Line string `json:"line"`
+}
+
+// ProcessStatus reports a process going up or down
+type ProcessStatus struct {
+	// Up is whether the process is running, Status the raw PTP_PROCESS_STATUS value
+	Up     bool  `json:"up"`
+	Status int64 `json:"status"`
+}
+
+// Offset is a servo update of ptp4l, phc2sys or ts2phc
+type Offset struct {
+	// Source is what the offset is measured against: master, phc or sys
+	Source string `json:"source"`
+	// Offset is in nanoseconds
+	Offset int64 `json:"offset"`
+	// ServoState is the linuxptp servo state: s0, s1 or s2
+	ServoState string `json:"servoState"`
+	Freq       int64  `json:"freq"`
+	// Delay is the path delay in nanoseconds, when reported
+	Delay *int64 `json:"delay,omitempty"`
+}
+
+// State is a state transition: a ptp4l port changing state, or a DPLL, GNSS, ts2phc or GM clock state
+type State struct {
+	// State is the new state: a port state such as SLAVE, or a clock state s0 (FREERUN), s1 (HOLDOVER) or s2 (LOCKED)
+	State string `json:"state"`
+	// From and Event are the previous state of a port and the event that changed it
+	From  string `json:"from,omitempty"`
+	Event string `json:"event,omitempty"`
+	// Port is the number of the ptp4l port
+	Port int `json:"port,omitempty"`
+	// Values are the values reported with a clock state, i.e. frequency_status
+	Values map[string]float64 `json:"values,omitempty"`
+}
+
+// ClockClass reports the clock class of a ptp4l instance
+type ClockClass struct {
+	ClockClass int `json:"clockClass"`
+}
+
+// SyncEQL reports the quality level synce4l attached to an interface
+type SyncEQL struct {
+	QL uint8 `json:"ql"`
+	// Extended is whether QL is the extended quality level of the extended TLV
+	Extended bool `json:"extended"`
+}
+
+var (
+	// headerRegexp matches "ptp4l[12.345]: [ptp4l.0.config:6] rest", and the "ptp4l[1700000000]:[ptp4l.0.config] rest" of the daemon
+	headerRegexp        = regexp.MustCompile(`^(\S+?)\[[\d.]+\]:\s*\[([^\]]*)\]\s*(.*)$`)
+	processStatusRegexp = regexp.MustCompile(`PTP_PROCESS_STATUS:(-?\d+)`)
+	clockClassRegexp    = regexp.MustCompile(`CLOCK_CLASS_CHANGE (\d+)`)
+	offsetRegexp        = regexp.MustCompile(`^(?:(\S+) )?(master|phc|sys) offset\s+(-?\d+)\s+(s\d)\s+freq\s+([+-]?\d+)(?:\s+(?:path )?delay\s+(-?\d+))?`)
+	portStateRegexp     = regexp.MustCompile(`port (\d+)(?: \(([^)]+)\))?: (\S+) to (\S+) on (\S+)`)
+	gmStatusRegexp      = regexp.MustCompile(`^(\S+) T-GM-STATUS (\S+)$`)
+	qlRegexp            = regexp.MustCompile(`(EXT_)?QL=0x([0-9a-fA-F]+) on (\S+)`)
+	clockStateRegexp    = regexp.MustCompile(`^s[012]$|^-[12]$`)
+)
+
+// Parse builds the event a legacy text line stands for, lines of an unknown format are TypeLog events
+func Parse(line string) Event {
+	line = strings.TrimRight(line, "\r\n")
+	ev := Event{Type: TypeLog, Time: time.Now().UTC(), Line: line}
+	m := headerRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return ev
+	}
+	ev.Process = m[1]
+	ev.Config = strings.SplitN(m[2], ":", 2)[0]
+	rest := strings.TrimSpace(m[3])
+
+	if m = processStatusRegexp.FindStringSubmatch(rest); m != nil {
+		status, _ := strconv.ParseInt(m[1], 10, 64)
+		ev.Type, ev.ProcessStatus = TypeProcessStatus, &ProcessStatus{Up: status == 1, Status: status}
+	} else if m = clockClassRegexp.FindStringSubmatch(rest); m != nil {
+		clockClass, _ := strconv.Atoi(m[1])
+		ev.Type, ev.ClockClass = TypeClockClass, &ClockClass{ClockClass: clockClass}
+	} else if m = offsetRegexp.FindStringSubmatch(rest); m != nil {
+		o := &Offset{Source: m[2], ServoState: m[4]}
+		o.Offset, _ = strconv.ParseInt(m[3], 10, 64)
+		o.Freq, _ = strconv.ParseInt(m[5], 10, 64)
+		if m[6] != "" {
+			delay, _ := strconv.ParseInt(m[6], 10, 64)
+			o.Delay = &delay
+		}
+		ev.Type, ev.Iface, ev.Offset = TypeOffset, m[1], o
+	} else if m = portStateRegexp.FindStringSubmatch(rest); m != nil {
+		port, _ := strconv.Atoi(m[1])
+		ev.Type, ev.Iface, ev.State = TypeState, m[2], &State{Port: port, From: m[3], State: m[4], Event: m[5]}
+	} else if m = gmStatusRegexp.FindStringSubmatch(rest); m != nil {
+		ev.Type, ev.Iface, ev.State = TypeState, m[1], &State{State: m[2]}
+	} else if m = qlRegexp.FindStringSubmatch(rest); m != nil {
+		ql, _ := strconv.ParseUint(m[2], 16, 8)
+		ev.Type, ev.Iface, ev.SyncEQL = TypeSyncEQL, m[3], &SyncEQL{QL: uint8(ql), Extended: m[1] != ""}
+	} else if iface, state, ok := parseClockState(rest); ok {
+		ev.Type, ev.Iface, ev.State = TypeState, iface, state
+	}
+	return ev
+}
+
+// parseClockState parses the "ens1f0 frequency_status 3 offset 5 s2" lines of the DPLL, GNSS and ts2phc events,
+// the interface is missing from the events of a whole config
+func parseClockState(rest string) (string, *State, bool) {
+	fields := strings.Fields(rest)
+	if len(fields) < 2 || !clockStateRegexp.MatchString(fields[len(fields)-1]) {
+		return "", nil, false
+	}
+	iface, values := "", fields[:len(fields)-1]
+	if len(values)%2 != 0 {
+		iface, values = values[0], values[1:]
+	}
+	s := &State{State: fields[len(fields)-1], Values: map[string]float64{}}
+	for i := 0; i < len(values); i += 2 {
+		v, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			// byte values are printed in hexadecimal
+			n, hexErr := strconv.ParseInt(values[i+1], 0, 64)
+			if hexErr != nil {
+				return "", nil, false
+			}
+			v = float64(n)
+		}
+		s.Values[values[i]] = v
+	}
+	return iface, s, true
+}
@@ -0,0 +1,274 @@
+// Package history keeps a bounded history of the state transitions, clock class changes, threshold crossings
+// and process restarts of the daemon, queried by time range and source and optionally persisted to a JSON lines file.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
+)
+
+// DefaultCapacity is the number of events a History keeps
+const DefaultCapacity = 10000
+
+// History is a ring buffer of events in the order they were added, safe for concurrent use.
+// Adding to a nil History does nothing.
+type History struct {
+	mu       sync.Mutex
+	capacity int
+	events   []apiv1.Event
+	start    int // index of the oldest event once events is full
+	path     string
+	file     *os.File
+	lines    int // events in the file, it is compacted beyond twice the capacity
+}
+
+// New creates a history of capacity events
+func New(capacity int) *History {
+	return &History{capacity: max(capacity, 1)}
+}
+
+// Add records ev, its time is set to now when zero
+func (h *History) Add(ev apiv1.Event) {
+	if h == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	ev.Time = ev.Time.UTC()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.push(ev)
+	if h.file == nil {
+		return
+	}
+	if h.lines >= 2*h.capacity {
+		if err := h.compact(); err != nil {
+			glog.Errorf("history persistence disabled, failed to compact %s: %v", h.path, err)
+			h.closeFile()
+		}
+		return
+	}
+	if err := writeEvent(h.file, ev); err != nil {
+		glog.Errorf("history persistence disabled, failed to write to %s: %v", h.path, err)
+		h.closeFile()
+		return
+	}
+	h.lines++
+}
+
+func (h *History) push(ev apiv1.Event) {
+	if len(h.events) < h.capacity {
+		h.events = append(h.events, ev)
+		return
+	}
+	h.events[h.start] = ev
+	h.start = (h.start + 1) % len(h.events)
+}
+
+// all returns the events, oldest first
+func (h *History) all() []apiv1.Event {
+	events := make([]apiv1.Event, 0, len(h.events))
+	events = append(events, h.events[h.start:]...)
+	return append(events, h.events[:h.start]...)
+}
+
+// Query filters the events of a History, the zero value of a field matches every event
+type Query struct {
+	Since  time.Time
+	Until  time.Time
+	Type   string
+	Config string
+	Source string
+}
+
+// Match returns whether ev matches q
+func (q Query) Match(ev apiv1.Event) bool {
+	return (q.Since.IsZero() || !ev.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || !ev.Time.After(q.Until)) &&
+		(q.Type == "" || q.Type == ev.Type) &&
+		(q.Config == "" || q.Config == ev.Config) &&
+		(q.Source == "" || q.Source == ev.Source)
+}
+
+// Values returns q as the query parameters of the history API
+func (q Query) Values() url.Values {
+	values := url.Values{}
+	if !q.Since.IsZero() {
+		values.Set("since", q.Since.Format(time.RFC3339Nano))
+	}
+	if !q.Until.IsZero() {
+		values.Set("until", q.Until.Format(time.RFC3339Nano))
+	}
+	for key, value := range map[string]string{"type": q.Type, "config": q.Config, "source": q.Source} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	return values
+}
+
+// ParseQuery parses the query parameters of the history API. since and until are RFC 3339 times,
+// or durations before now, i.e. since=1h for the last hour.
+func ParseQuery(values url.Values, now time.Time) (Query, error) {
+	q := Query{Type: values.Get("type"), Config: values.Get("config"), Source: values.Get("source")}
+	var err error
+	if q.Since, err = ParseTime(values.Get("since"), now); err != nil {
+		return q, fmt.Errorf("invalid since: %w", err)
+	}
+	if q.Until, err = ParseTime(values.Get("until"), now); err != nil {
+		return q, fmt.Errorf("invalid until: %w", err)
+	}
+	return q, nil
+}
+
+// ParseTime parses an RFC 3339 time, or a duration before now, the zero time is returned for an empty s
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// Query returns the events matching q, oldest first
+func (h *History) Query(q Query) []apiv1.Event {
+	events := []apiv1.Event{}
+	if h == nil {
+		return events
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ev := range h.all() {
+		if q.Match(ev) {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// SetCapacity changes the number of events kept, dropping the oldest ones beyond it
+func (h *History) SetCapacity(capacity int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	events := h.all()
+	h.capacity = max(capacity, 1)
+	h.events, h.start = nil, 0
+	for _, ev := range events {
+		h.push(ev)
+	}
+}
+
+// Persist loads the events of the file at path, which come before the ones already added,
+// and appends the events added from now on to it. A missing file is created.
+func (h *History) Persist(path string) error {
+	loaded, err := Load(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	events := append(loaded, h.all()...)
+	h.events, h.start = nil, 0
+	for _, ev := range events {
+		h.push(ev)
+	}
+	h.closeFile()
+	h.path = path
+	if err = h.compact(); err != nil {
+		return err
+	}
+	glog.Infof("history of %d events persisted to %s", len(h.events), path)
+	return nil
+}
+
+// Close stops persisting the events
+func (h *History) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closeFile()
+}
+
+func (h *History) closeFile() {
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+}
+
+// compact rewrites the file with the events kept and reopens it for appending
+func (h *History) compact() error {
+	h.closeFile()
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), filepath.Base(h.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	events := h.all()
+	w := bufio.NewWriter(tmp)
+	for _, ev := range events {
+		if err = writeEvent(w, ev); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), h.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if h.file, err = os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return err
+	}
+	h.lines = len(events)
+	return nil
+}
+
+func writeEvent(w io.Writer, ev apiv1.Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Load reads the events of a file written by a persisted History, oldest first. Lines that cannot be parsed,
+// i.e. the last one of a daemon killed while writing it, are skipped.
+func Load(path string) ([]apiv1.Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var events []apiv1.Event
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var ev apiv1.Event
+		if err = json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			glog.Warningf("%s:%d: skipping invalid history event: %v", path, line, err)
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, scanner.Err()
+}
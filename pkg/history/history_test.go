@@ -0,0 +1,129 @@
+package history
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// event returns the i-th event of a test history, one per minute from start
+func event(i int, source string) apiv1.Event {
+	return apiv1.Event{Time: start.Add(time.Duration(i) * time.Minute), Type: apiv1.EventStateChange,
+		Config: "ts2phc.0.config", Source: source, To: strconv.Itoa(i)}
+}
+
+func tos(events []apiv1.Event) []string {
+	var to []string
+	for _, ev := range events {
+		to = append(to, ev.To)
+	}
+	return to
+}
+
+func TestHistory_ring(t *testing.T) {
+	h := New(3)
+	for i := 0; i < 5; i++ {
+		h.Add(event(i, "dpll"))
+	}
+	assert.Equal(t, []string{"2", "3", "4"}, tos(h.Query(Query{})), "oldest events dropped")
+
+	h.SetCapacity(2)
+	assert.Equal(t, []string{"3", "4"}, tos(h.Query(Query{})))
+	h.SetCapacity(4)
+	h.Add(event(5, "dpll"))
+	assert.Equal(t, []string{"3", "4", "5"}, tos(h.Query(Query{})))
+
+	h.Add(apiv1.Event{Type: apiv1.EventProcessRestart})
+	events := h.Query(Query{Type: apiv1.EventProcessRestart})
+	if assert.Len(t, events, 1) {
+		assert.WithinDuration(t, time.Now(), events[0].Time, time.Second, "zero time set to now")
+		assert.Equal(t, time.UTC, events[0].Time.Location())
+	}
+
+	var nilHistory *History
+	nilHistory.Add(event(0, "dpll"))
+	assert.Empty(t, nilHistory.Query(Query{}))
+}
+
+func TestHistory_query(t *testing.T) {
+	h := New(DefaultCapacity)
+	for i := 0; i < 10; i++ {
+		source := "dpll"
+		if i%2 == 1 {
+			source = "GM"
+		}
+		h.Add(event(i, source))
+	}
+	assert.Equal(t, []string{"1", "3", "5", "7", "9"}, tos(h.Query(Query{Source: "GM"})))
+	assert.Equal(t, []string{"4", "5", "6"}, tos(h.Query(Query{Since: start.Add(4 * time.Minute), Until: start.Add(6 * time.Minute)})))
+	assert.Empty(t, h.Query(Query{Type: apiv1.EventThresholdCrossing}))
+	assert.Empty(t, h.Query(Query{Config: "ptp4l.0.config"}))
+
+	now := start.Add(10 * time.Minute)
+	q, err := ParseQuery(url.Values{"since": {"3m"}, "until": {start.Add(8 * time.Minute).Format(time.RFC3339)}, "source": {"dpll"}}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"8"}, tos(h.Query(q)))
+	assert.Equal(t, q, mustParse(t, q.Values(), now), "Values round trips")
+
+	_, err = ParseQuery(url.Values{"since": {"yesterday"}}, now)
+	assert.Error(t, err)
+	_, err = ParseQuery(url.Values{"until": {"1 hour"}}, now)
+	assert.Error(t, err)
+}
+
+func mustParse(t *testing.T, values url.Values, now time.Time) Query {
+	q, err := ParseQuery(values, now)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return q
+}
+
+func TestHistory_persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	h := New(3)
+	h.Add(event(0, "dpll"))
+	assert.NoError(t, h.Persist(path), "missing file created")
+	h.Add(event(1, "dpll"))
+	h.Close()
+
+	// a daemon killed while writing an event leaves a partial line
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = f.WriteString(`{"time":"2024-01-01T00:0`)
+	assert.NoError(t, err)
+	f.Close()
+
+	loaded, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0", "1"}, tos(loaded))
+
+	restarted := New(3)
+	restarted.Add(event(2, "GM"))
+	assert.NoError(t, restarted.Persist(path))
+	defer restarted.Close()
+	assert.Equal(t, []string{"0", "1", "2"}, tos(restarted.Query(Query{})), "loaded events before the ones added")
+
+	// the file is compacted to the capacity beyond twice of it
+	for i := 3; i < 10; i++ {
+		restarted.Add(event(i, "GM"))
+	}
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, strings.Count(string(b), "\n"), 6)
+	loaded, err = Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"7", "8", "9"}, tos(loaded)[len(loaded)-3:])
+	assert.Equal(t, []string{"7", "8", "9"}, tos(restarted.Query(Query{})))
+}
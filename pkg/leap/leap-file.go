@@ -9,12 +9,14 @@ import (
 	"html/template"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/pmc"
 	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ublox"
 	v1 "k8s.io/api/core/v1"
@@ -46,6 +48,8 @@ type LeapManager struct {
 	// client
 	client    kubernetes.Interface
 	namespace string
+	// local leap file used instead of the configmap in standalone mode
+	localFile string
 	// Leap file structure
 	leapFile LeapFile
 	// Retry configmap update if failed
@@ -54,10 +58,13 @@ type LeapManager struct {
 	leapFilePath string
 	leapFileName string
 	// UTC offset and its validity time
-	utcOffset       int
-	utcOffsetTime   time.Time
-	ptp4lConfigPath string
-	pmcLeapSent     bool
+	utcOffset     int
+	utcOffsetTime time.Time
+	// ptp4l configs of the grandmasters the leap announcement is sent to, with whether it was sent in the current window
+	ptp4lConfigPaths map[string]bool
+	pathsLock        sync.Mutex
+	// status requests answered by Run, see Status
+	statusCh chan chan apiv1.Leap
 }
 
 type LeapEvent struct {
@@ -77,19 +84,30 @@ var lock = &sync.Mutex{}
 var LeapMgr *LeapManager
 
 func New(kubeclient kubernetes.Interface, namespace string) (*LeapManager, error) {
+	return newLeapManager(&LeapManager{
+		client:    kubeclient,
+		namespace: namespace,
+	})
+}
+
+// NewLocal ... create a Leap manager keeping the leap data in localFile instead of the leap configmap
+func NewLocal(localFile string) (*LeapManager, error) {
+	return newLeapManager(&LeapManager{
+		localFile: localFile,
+	})
+}
+
+func newLeapManager(lm *LeapManager) (*LeapManager, error) {
 	if LeapMgr == nil {
 		lock.Lock()
 		defer lock.Unlock()
 		if LeapMgr == nil {
-			lm := &LeapManager{
-				UbloxLsInd:   make(chan ublox.TimeLs, 2),
-				Close:        make(chan bool),
-				client:       kubeclient,
-				namespace:    namespace,
-				leapFile:     LeapFile{},
-				leapFilePath: defaultLeapFilePath,
-				leapFileName: defaultLeapFileName,
-			}
+			lm.UbloxLsInd = make(chan ublox.TimeLs, 2)
+			lm.Close = make(chan bool)
+			lm.statusCh = make(chan chan apiv1.Leap)
+			lm.leapFile = LeapFile{}
+			lm.leapFilePath = defaultLeapFilePath
+			lm.leapFileName = defaultLeapFileName
 			err := lm.populateLeapData()
 			if err != nil {
 				return nil, err
@@ -158,9 +176,83 @@ func parseLeapFile(b []byte) (*LeapFile, error) {
 	return &l, nil
 }
 
+// SetPtp4lConfigPath adds the ptp4l config of a grandmaster to the configs the leap announcement is sent to
 func (l *LeapManager) SetPtp4lConfigPath(path string) {
-	glog.Info("set Leap manager ptp4l config file name to ", path)
-	l.ptp4lConfigPath = path
+	glog.Info("add Leap manager ptp4l config file name ", path)
+	l.pathsLock.Lock()
+	defer l.pathsLock.Unlock()
+	if l.ptp4lConfigPaths == nil {
+		l.ptp4lConfigPaths = map[string]bool{}
+	}
+	if _, ok := l.ptp4lConfigPaths[path]; !ok {
+		l.ptp4lConfigPaths[path] = false
+	}
+}
+
+// RemovePtp4lConfigPath stops sending the leap announcement to the ptp4l config path
+func (l *LeapManager) RemovePtp4lConfigPath(path string) {
+	l.pathsLock.Lock()
+	defer l.pathsLock.Unlock()
+	delete(l.ptp4lConfigPaths, path)
+}
+
+// Ptp4lConfigPaths returns the ptp4l configs the leap announcement is sent to, sorted
+func (l *LeapManager) Ptp4lConfigPaths() []string {
+	l.pathsLock.Lock()
+	defer l.pathsLock.Unlock()
+	paths := make([]string, 0, len(l.ptp4lConfigPaths))
+	for path := range l.ptp4lConfigPaths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// setPmcLeapSent records whether the leap announcement was sent to the ptp4l config path
+func (l *LeapManager) setPmcLeapSent(path string, sent bool) {
+	l.pathsLock.Lock()
+	defer l.pathsLock.Unlock()
+	if _, ok := l.ptp4lConfigPaths[path]; ok {
+		l.ptp4lConfigPaths[path] = sent
+	}
+}
+
+func (l *LeapManager) pmcLeapSent(path string) bool {
+	l.pathsLock.Lock()
+	defer l.pathsLock.Unlock()
+	return l.ptp4lConfigPaths[path]
+}
+
+// sendPmcLeap announces the upcoming leap second on the grandmaster of the ptp4l config path
+func (l *LeapManager) sendPmcLeap(path string) {
+	if l.pmcLeapSent(path) {
+		return
+	}
+	g, err := pmc.RunPMCExpGetGMSettings(path)
+	if err != nil {
+		glog.Error("error in Leap:", err)
+		return
+	}
+	leapDiff := l.leapFile.LeapEvents[len(l.leapFile.LeapEvents)-1].LeapSec - int(g.TimePropertiesDS.CurrentUtcOffset)
+	if leapDiff > 0 {
+		g.TimePropertiesDS.Leap59 = false
+		g.TimePropertiesDS.Leap61 = true
+	} else if leapDiff < 0 {
+		g.TimePropertiesDS.Leap59 = true
+		g.TimePropertiesDS.Leap61 = false
+	} else {
+		// No actual change in leap seconds, don't send anything
+		l.setPmcLeapSent(path, true)
+		return
+	}
+	glog.Infof("Sending PMC command in Leap window to %s", path)
+	glog.Infof("Leap time properties: %++v", g.TimePropertiesDS)
+	err = pmc.RunPMCExpSetGMSettings(path, g)
+	if err != nil {
+		glog.Error("failed to send PMC for Leap: ", err)
+		return
+	}
+	l.setPmcLeapSent(path, true)
 }
 
 func (l *LeapManager) renderLeapData() (*bytes.Buffer, error) {
@@ -187,30 +279,81 @@ func (l *LeapManager) renderLeapData() (*bytes.Buffer, error) {
 	return &buf, nil
 }
 
-func (l *LeapManager) populateLeapData() error {
-	cm, err := l.client.CoreV1().ConfigMaps(l.namespace).Get(context.TODO(), leapConfigMapName, metav1.GetOptions{})
-	nodeName := os.Getenv("NODE_NAME")
+// loadDefaultLeapData loads the leap data from the system leap-seconds.list file
+func (l *LeapManager) loadDefaultLeapData() error {
+	glog.Info("Populate Leap data from file")
+	b, err := os.ReadFile(filepath.Join(l.leapFilePath, l.leapFileName))
 	if err != nil {
 		return err
 	}
-	lf, found := cm.Data[nodeName]
-	if !found {
-		glog.Info("Populate Leap data from file")
-		b, err := os.ReadFile(filepath.Join(l.leapFilePath, l.leapFileName))
-		if err != nil {
+	leapData, err := parseLeapFile(b)
+	if err != nil {
+		return err
+	}
+	l.leapFile = *leapData
+	// Set expiration time to 2036
+	exp := time.Date(2036, time.January, 1, 0, 0, 0, 0, time.UTC)
+	start := time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC)
+	expSec := int(exp.Sub(start).Seconds())
+	l.leapFile.ExpirationTime = fmt.Sprint(expSec)
+	l.rehashLeapData()
+	return nil
+}
+
+// populateLocalLeapData loads the leap data from the local leap file, creating it from the system one if missing
+func (l *LeapManager) populateLocalLeapData() error {
+	b, err := os.ReadFile(l.localFile)
+	if os.IsNotExist(err) {
+		if err = l.loadDefaultLeapData(); err != nil {
+			return err
+		}
+		if err = l.writeLocalLeapFile(); err != nil {
 			return err
 		}
+	} else if err != nil {
+		return err
+	} else {
+		glog.Info("Populate Leap data from ", l.localFile)
 		leapData, err := parseLeapFile(b)
 		if err != nil {
 			return err
 		}
 		l.leapFile = *leapData
-		// Set expiration time to 2036
-		exp := time.Date(2036, time.January, 1, 0, 0, 0, 0, time.UTC)
-		start := time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC)
-		expSec := int(exp.Sub(start).Seconds())
-		l.leapFile.ExpirationTime = fmt.Sprint(expSec)
-		l.rehashLeapData()
+	}
+	glog.Info("Leap file expiration is set to ", l.leapFile.ExpirationTime)
+	return l.setUtcOffset()
+}
+
+// writeLocalLeapFile renders the leap data to the local leap file, replacing it atomically
+func (l *LeapManager) writeLocalLeapFile() error {
+	data, err := l.renderLeapData()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(l.localFile), 0755); err != nil {
+		return err
+	}
+	tmp := l.localFile + ".tmp"
+	if err = os.WriteFile(tmp, data.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.localFile)
+}
+
+func (l *LeapManager) populateLeapData() error {
+	if l.localFile != "" {
+		return l.populateLocalLeapData()
+	}
+	cm, err := l.client.CoreV1().ConfigMaps(l.namespace).Get(context.TODO(), leapConfigMapName, metav1.GetOptions{})
+	nodeName := os.Getenv("NODE_NAME")
+	if err != nil {
+		return err
+	}
+	lf, found := cm.Data[nodeName]
+	if !found {
+		if err = l.loadDefaultLeapData(); err != nil {
+			return err
+		}
 		data, err := l.renderLeapData()
 		if err != nil {
 			return err
@@ -244,48 +387,79 @@ func (l *LeapManager) Run() {
 		select {
 		case v := <-l.UbloxLsInd:
 			l.handleLeapIndication(&v)
+		case reply := <-l.statusCh:
+			reply <- l.status()
 		case <-l.Close:
-			LeapMgr = nil
+			lock.Lock()
+			if LeapMgr == l {
+				LeapMgr = nil
+			}
+			lock.Unlock()
 			return
 		case <-ticker.C:
 			if l.retryUpdate {
 				l.updateLeapConfigmap()
 			}
-			if l.IsLeapInWindow(time.Now().UTC(), -pmcWindowStartHours*time.Hour, -pmcWindowEndSeconds*time.Second) {
-				if !l.pmcLeapSent {
-					g, err := pmc.RunPMCExpGetGMSettings(l.ptp4lConfigPath)
-					if err != nil {
-						glog.Error("error in Leap:", err)
-						continue
-					}
-					leapDiff := l.leapFile.LeapEvents[len(l.leapFile.LeapEvents)-1].LeapSec - int(g.TimePropertiesDS.CurrentUtcOffset)
-					if leapDiff > 0 {
-						g.TimePropertiesDS.Leap59 = false
-						g.TimePropertiesDS.Leap61 = true
-					} else if leapDiff < 0 {
-						g.TimePropertiesDS.Leap59 = true
-						g.TimePropertiesDS.Leap61 = false
-					} else {
-						// No actual change in leap seconds, don't send anything
-						l.pmcLeapSent = true
-						continue
-					}
-					glog.Info("Sending PMC command in Leap window")
-					glog.Infof("Leap time properties: %++v", g.TimePropertiesDS)
-					err = pmc.RunPMCExpSetGMSettings(l.ptp4lConfigPath, g)
-					if err != nil {
-						glog.Error("failed to send PMC for Leap: ", err)
-						continue
-					}
-					l.pmcLeapSent = true
+			inWindow := l.IsLeapInWindow(time.Now().UTC(), -pmcWindowStartHours*time.Hour, -pmcWindowEndSeconds*time.Second)
+			for _, path := range l.Ptp4lConfigPaths() {
+				if inWindow {
+					l.sendPmcLeap(path)
+				} else {
+					l.setPmcLeapSent(path, false)
 				}
-			} else {
-				l.pmcLeapSent = false
 			}
 		}
 	}
 }
 
+// Status returns the leap second data. It is answered by Run, an error is returned
+// when Run does not answer within timeout.
+func (l *LeapManager) Status(timeout time.Duration) (*apiv1.Leap, error) {
+	reply := make(chan apiv1.Leap, 1)
+	select {
+	case l.statusCh <- reply:
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("leap manager did not answer within %s", timeout)
+	}
+	select {
+	case leap := <-reply:
+		return &leap, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("leap manager did not answer within %s", timeout)
+	}
+}
+
+// status returns the leap second data, it runs in Run
+func (l *LeapManager) status() apiv1.Leap {
+	// leap-seconds.list times are seconds since 1900
+	startTime := time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ntpTime := func(sec string) *time.Time {
+		n, err := strconv.ParseInt(strings.TrimSpace(sec), 10, 64)
+		if err != nil {
+			return nil
+		}
+		t := startTime.Add(time.Second * time.Duration(n))
+		return &t
+	}
+	s := apiv1.Leap{
+		UTCOffset:    l.utcOffset,
+		Expiration:   ntpTime(l.leapFile.ExpirationTime),
+		Update:       ntpTime(l.leapFile.UpdateTime),
+		Events:       []apiv1.LeapEvent{},
+		Ptp4lConfigs: l.Ptp4lConfigPaths(),
+	}
+	events := l.leapFile.LeapEvents
+	if time.Now().UTC().Before(l.utcOffsetTime) && len(events) > 1 {
+		s.UTCOffset = events[len(events)-2].LeapSec
+	}
+	for _, ev := range events {
+		if t := ntpTime(ev.LeapTime); t != nil {
+			s.Events = append(s.Events, apiv1.LeapEvent{Time: *t, UTCOffset: ev.LeapSec, Comment: ev.Comment})
+		}
+	}
+	return s
+}
+
 // updateLeapFile updates a new leap event to the list of leap events, if provided
 func (l *LeapManager) updateLeapFile(leapTime time.Time,
 	leapSec int, currentTime time.Time) {
@@ -327,6 +501,15 @@ func (l *LeapManager) rehashLeapData() {
 }
 
 func (l *LeapManager) updateLeapConfigmap() {
+	if l.localFile != "" {
+		if err := l.writeLocalLeapFile(); err != nil {
+			l.retryUpdate = true
+			glog.Info("failed to update leap file (will retry): ", err)
+			return
+		}
+		l.retryUpdate = false
+		return
+	}
 	data, err := l.renderLeapData()
 	if err != nil {
 		glog.Error("Leap: ", err)
@@ -449,6 +632,10 @@ func MockLeapFile() error {
 		},
 	}
 	os.Setenv("NODE_NAME", "test-node-name")
+	// a previously mocked manager may not have exited yet, start from a fresh one
+	lock.Lock()
+	LeapMgr = nil
+	lock.Unlock()
 	client := fake.NewSimpleClientset(cm)
 	lm, err := New(client, "openshift-ptp")
 	if err != nil {
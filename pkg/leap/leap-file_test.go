@@ -260,6 +260,42 @@ func Test_populateWithFile(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func Test_populateLocalFile(t *testing.T) {
+	localFile := t.TempDir() + "/leap/leap-seconds.list"
+	lm := &LeapManager{
+		UbloxLsInd:   make(chan ublox.TimeLs),
+		Close:        make(chan bool),
+		localFile:    localFile,
+		leapFilePath: "testdata",
+		leapFileName: "leap-seconds.list",
+	}
+	// missing local file is created from the system leap file
+	err := lm.populateLeapData()
+	assert.NoError(t, err)
+	_, err = os.Stat(localFile)
+	assert.NoError(t, err)
+	events := len(lm.leapFile.LeapEvents)
+
+	lm.handleLeapIndication(&ublox.TimeLs{
+		SrcOfCurrLs:   2,
+		CurrLs:        18,
+		SrcOfLsChange: 2,
+		LsChange:      1,
+		TimeToLsEvent: 10000,
+		DateOfLsGpsWn: 2321,
+		DateOfLsGpsDn: 1,
+		Valid:         3,
+	})
+	assert.False(t, lm.retryUpdate)
+
+	// the update is read back from the local file
+	lm2 := &LeapManager{localFile: localFile}
+	err = lm2.populateLeapData()
+	assert.NoError(t, err)
+	assert.Equal(t, events+1, len(lm2.leapFile.LeapEvents))
+	assert.Equal(t, lm.leapFile.Hash, lm2.leapFile.Hash)
+}
+
 func Test_handleLeapIndication(t *testing.T) {
 	cm := &v1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-ptp", Name: "leap-configmap"},
@@ -341,7 +377,15 @@ func Test_SetPtp4lConfigPath(t *testing.T) {
 	path := "test"
 	lm := &LeapManager{}
 	lm.SetPtp4lConfigPath(path)
-	assert.Equal(t, path, lm.ptp4lConfigPath)
+	assert.Equal(t, []string{path}, lm.Ptp4lConfigPaths())
+	lm.SetPtp4lConfigPath("ptp4l.1.config")
+	lm.SetPtp4lConfigPath(path)
+	assert.Equal(t, []string{"ptp4l.1.config", path}, lm.Ptp4lConfigPaths())
+	lm.setPmcLeapSent(path, true)
+	lm.SetPtp4lConfigPath(path)
+	assert.True(t, lm.pmcLeapSent(path))
+	lm.RemovePtp4lConfigPath(path)
+	assert.Equal(t, []string{"ptp4l.1.config"}, lm.Ptp4lConfigPaths())
 }
 
 func Test_New_Good(t *testing.T) {
@@ -402,6 +446,16 @@ func Test_Run(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 	offset := GetUtcOffset()
 	assert.Equal(t, 38, offset)
+
+	lm.SetPtp4lConfigPath("ptp4l.0.config")
+	status, err := lm.Status(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 38, status.UTCOffset)
+	assert.Equal(t, []string{"ptp4l.0.config"}, status.Ptp4lConfigs)
+	assert.Equal(t, time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC), status.Events[0].Time)
+	assert.Equal(t, 37, status.Events[0].UTCOffset)
+	assert.Equal(t, 38, status.Events[len(status.Events)-1].UTCOffset)
+	assert.Equal(t, time.Date(2036, time.January, 1, 0, 0, 0, 0, time.UTC), *status.Expiration)
 	close(LeapMgr.Close)
 	time.Sleep(100 * time.Millisecond)
 	assert.Nil(t, LeapMgr)
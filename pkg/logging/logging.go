@@ -0,0 +1,257 @@
+// Package logging switches the daemon between the default glog and linuxptp text output
+// and structured JSON records, one per line, that log pipelines can index without parsing PTP output.
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// FormatText logs with glog and prints the child process output as is, the default
+	FormatText = "text"
+	// FormatJSON logs JSON records, one per line
+	FormatJSON = "json"
+)
+
+// Record is a JSON log record. Daemon records have the glog source and component, the file that logged it,
+// child process records the fields parsed from the linuxptp output next to the raw line.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Level     string    `json:"level"`
+	Component string    `json:"component"`
+	Profile   string    `json:"profile,omitempty"`
+	Config    string    `json:"config,omitempty"`
+	Process   string    `json:"process,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Msg       string    `json:"msg"`
+	Iface     string    `json:"iface,omitempty"`
+	// State is the servo state, s0 to s3
+	State     string   `json:"state,omitempty"`
+	Offset    *float64 `json:"offset,omitempty"`
+	MaxOffset *float64 `json:"maxOffset,omitempty"`
+	Frequency *float64 `json:"frequency,omitempty"`
+	Delay     *float64 `json:"delay,omitempty"`
+	Raw       string   `json:"raw,omitempty"`
+}
+
+var (
+	format = FormatText
+	// profiles are the profile names by config name, to tag the records of a config with its profile
+	profiles   = map[string]string{}
+	profilesMu sync.RWMutex
+	stdout     io.Writer = os.Stdout
+	stdoutMu   sync.Mutex
+	// glogPipe is the pipe glog writes to, glogStderr the stderr it replaced and glogDone closed when
+	// the conversion ends, nil unless glog is redirected
+	glogPipe   *os.File
+	glogStderr *os.File
+	glogDone   chan struct{}
+)
+
+var (
+	// glogHeader matches the glog header: Lmmdd hh:mm:ss.uuuuuu threadid file:line] msg
+	glogHeader = regexp.MustCompile(`^([IWEF])(\d{4} \d{2}:\d{2}:\d{2}\.\d{6})\s+\d+ ([^:\]]+:\d+)\] ?(.*)$`)
+	// processHeader matches the linuxptp and daemon event prefix: ptp4l[74737.942]: [ptp4l.0.config:6] msg
+	processHeader = regexp.MustCompile(`^\s*([\w-]+)\[[^\]]*\]:\s*\[([^\]:]+)(?::(\d+))?\]\s*(.*)$`)
+	// configName matches a config name in a daemon message, i.e. ts2phc.0.config
+	configName = regexp.MustCompile(`\b([a-z0-9]+)\.\d+\.(?:config|cfg)\b`)
+	servoState = regexp.MustCompile(`^s[0-4]$`)
+)
+
+// SetFormat sets the output format, FormatText or FormatJSON
+func SetFormat(f string) error {
+	switch f {
+	case FormatText, FormatJSON:
+		format = f
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q, expected %s or %s", f, FormatText, FormatJSON)
+}
+
+// Structured returns whether JSON records are logged
+func Structured() bool {
+	return format == FormatJSON
+}
+
+// SetConfigProfile tags the records of configName with profile
+func SetConfigProfile(configName, profile string) {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+	profiles[configName] = profile
+}
+
+func configProfile(configName string) string {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+	return profiles[configName]
+}
+
+// ProcessOutput prints a line of child process output, or a line the daemon emits in the same format,
+// as is, or as a Record when JSON records are logged
+func ProcessOutput(line string) {
+	line = strings.TrimRight(line, "\n")
+	if !Structured() {
+		write([]byte(line + "\n"))
+		return
+	}
+	r := ParseProcessLine(line)
+	r.Time = time.Now().UTC()
+	writeRecord(r)
+}
+
+// ParseProcessLine parses a line of linuxptp output, i.e.
+// "ptp4l[365195.391]: [ptp4l.0.config:6] master offset -1 s2 freq -3972 path delay 89"
+func ParseProcessLine(line string) Record {
+	r := Record{Level: "info", Component: "process", Msg: line, Raw: line}
+	m := processHeader.FindStringSubmatch(line)
+	if m == nil {
+		return r
+	}
+	r.Process, r.Config, r.Msg = m[1], m[2], m[4]
+	r.Component = r.Process
+	r.Profile = configProfile(r.Config)
+	if m[3] != "" {
+		r.Level = syslogLevel(m[3])
+	}
+
+	fields := strings.Fields(r.Msg)
+	for i, f := range fields {
+		if servoState.MatchString(f) {
+			r.State = f
+			continue
+		}
+		if i+1 >= len(fields) {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[i+1], 64)
+		if err != nil {
+			continue
+		}
+		switch f {
+		case "offset", "rms":
+			r.Offset = &v
+		case "max":
+			r.MaxOffset = &v
+		case "freq":
+			r.Frequency = &v
+		case "delay":
+			r.Delay = &v
+		}
+	}
+	if len(fields) > 0 && (r.Offset != nil || r.State != "") {
+		switch fields[0] {
+		case "master", "rms", "offset", "phc", "sys":
+		default:
+			r.Iface = fields[0]
+		}
+	}
+	return r
+}
+
+// syslogLevel returns the level of a linuxptp message tag level
+func syslogLevel(level string) string {
+	switch level {
+	case "0", "1", "2", "3":
+		return "error"
+	case "4":
+		return "warning"
+	case "7":
+		return "debug"
+	}
+	return "info"
+}
+
+// RedirectGlog converts the glog output to Records when JSON records are logged.
+// glog writes to os.Stderr, which is replaced with a pipe the records are read from;
+// file descriptor 2 is left as is so that runtime panics still reach the container log.
+// Flush must be called before exiting, or the last records may be lost.
+func RedirectGlog() error {
+	if !Structured() || glogPipe != nil {
+		return nil
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create the glog pipe: %w", err)
+	}
+	stderr := *os.Stderr
+	*os.Stderr = *w
+	glogPipe, glogStderr, glogDone = w, &stderr, make(chan struct{})
+	go func() {
+		convertGlog(r, glogStderr)
+		close(glogDone)
+	}()
+	return nil
+}
+
+// Flush writes the glog output still in the pipe and restores os.Stderr, glog writes text from then on
+func Flush() {
+	if glogPipe == nil {
+		return
+	}
+	glog.Flush()
+	*os.Stderr = *glogStderr
+	_ = glogPipe.Close()
+	select {
+	case <-glogDone:
+	case <-time.After(time.Second):
+	}
+	glogPipe = nil
+}
+
+// convertGlog writes the glog lines read from r as Records to out
+func convertGlog(r io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		b, err := json.Marshal(ParseGlogLine(scanner.Text(), time.Now()))
+		if err != nil {
+			continue
+		}
+		_, _ = out.Write(append(b, '\n'))
+	}
+}
+
+// ParseGlogLine parses a glog line, now gives the year glog leaves out
+func ParseGlogLine(line string, now time.Time) Record {
+	r := Record{Time: now.UTC(), Level: "info", Component: "daemon", Msg: line}
+	if m := glogHeader.FindStringSubmatch(line); m != nil {
+		r.Level = map[string]string{"I": "info", "W": "warning", "E": "error", "F": "fatal"}[m[1]]
+		if t, err := time.ParseInLocation("0102 15:04:05.000000", m[2], now.Location()); err == nil {
+			r.Time = t.AddDate(now.Year(), 0, 0).UTC()
+		}
+		r.Source, r.Msg = m[3], m[4]
+		r.Component = strings.TrimSuffix(filepath.Base(strings.Split(r.Source, ":")[0]), ".go")
+	}
+	if m := configName.FindStringSubmatch(r.Msg); m != nil {
+		r.Config, r.Process = m[0], m[1]
+		r.Profile = configProfile(r.Config)
+	}
+	return r
+}
+
+func writeRecord(r Record) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(r); err != nil {
+		return
+	}
+	write(b.Bytes())
+}
+
+func write(b []byte) {
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	_, _ = stdout.Write(b)
+}
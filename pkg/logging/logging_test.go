@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestParseProcessLine(t *testing.T) {
+	SetConfigProfile("ptp4l.0.config", "bc")
+	tests := []struct {
+		line string
+		want Record
+	}{
+		{
+			line: "ptp4l[365195.391]: [ptp4l.0.config:6] master offset         -1 s2 freq   -3972 path delay        89",
+			want: Record{Level: "info", Component: "ptp4l", Process: "ptp4l", Config: "ptp4l.0.config", Profile: "bc",
+				Msg: "master offset         -1 s2 freq   -3972 path delay        89", State: "s2",
+				Offset: float(-1), Frequency: float(-3972), Delay: float(89)},
+		},
+		{
+			line: "phc2sys[3560354.300]: [ptp4l.0.config:6] CLOCK_REALTIME rms    4 max    4 freq -76829 +/-   0 delay  1085 +/-   0",
+			want: Record{Level: "info", Component: "phc2sys", Process: "phc2sys", Config: "ptp4l.0.config", Profile: "bc",
+				Msg: "CLOCK_REALTIME rms    4 max    4 freq -76829 +/-   0 delay  1085 +/-   0", Iface: "CLOCK_REALTIME",
+				Offset: float(4), MaxOffset: float(4), Frequency: float(-76829), Delay: float(1085)},
+		},
+		{
+			line: "ts2phc[82674.465]: [ts2phc.0.config:6] ens2f1 master offset          0 s2 freq      -0",
+			want: Record{Level: "info", Component: "ts2phc", Process: "ts2phc", Config: "ts2phc.0.config",
+				Msg: "ens2f1 master offset          0 s2 freq      -0", Iface: "ens2f1", State: "s2",
+				Offset: float(0), Frequency: float(0)},
+		},
+		{
+			line: "ptp4l[74737.942]: [ptp4l.0.config:4] port 1 (ens1f0): SLAVE to UNCALIBRATED on SYNCHRONIZATION_FAULT",
+			want: Record{Level: "warning", Component: "ptp4l", Process: "ptp4l", Config: "ptp4l.0.config", Profile: "bc",
+				Msg: "port 1 (ens1f0): SLAVE to UNCALIBRATED on SYNCHRONIZATION_FAULT"},
+		},
+		{
+			line: "GM[1700000000]:[ts2phc.0.config] ens1f0 T-GM-STATUS s0",
+			want: Record{Level: "info", Component: "GM", Process: "GM", Config: "ts2phc.0.config",
+				Msg: "ens1f0 T-GM-STATUS s0", Iface: "ens1f0", State: "s0"},
+		},
+		{
+			line: "gpsd is not a linuxptp line",
+			want: Record{Level: "info", Component: "process", Msg: "gpsd is not a linuxptp line"},
+		},
+	}
+	for _, tt := range tests {
+		tt.want.Raw = tt.line
+		assert.Equal(t, tt.want, ParseProcessLine(tt.line), tt.line)
+	}
+}
+
+func TestParseGlogLine(t *testing.T) {
+	SetConfigProfile("ts2phc.1.config", "gm")
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	r := ParseGlogLine("E0229 12:34:56.789012  4242 daemon.go:937] CmdRun() error waiting for ts2phc.1.config: exit status 1", now)
+	assert.Equal(t, Record{
+		Time: time.Date(2024, 2, 29, 12, 34, 56, 789012000, time.UTC), Level: "error", Component: "daemon",
+		Source: "daemon.go:937", Msg: "CmdRun() error waiting for ts2phc.1.config: exit status 1",
+		Config: "ts2phc.1.config", Process: "ts2phc", Profile: "gm",
+	}, r)
+
+	r = ParseGlogLine("goroutine 1 [running]:", now)
+	assert.Equal(t, Record{Time: now, Level: "info", Component: "daemon", Msg: "goroutine 1 [running]:"}, r)
+}
+
+func TestProcessOutput(t *testing.T) {
+	var out bytes.Buffer
+	defer func(w io.Writer) {
+		stdout = w
+		_ = SetFormat(FormatText)
+	}(stdout)
+	stdout = &out
+
+	ProcessOutput("ptp4l[1.0]:[ptp4l.0.config] CLOCK_CLASS_CHANGE 248.000000\n")
+	assert.Equal(t, "ptp4l[1.0]:[ptp4l.0.config] CLOCK_CLASS_CHANGE 248.000000\n", out.String())
+
+	assert.Error(t, SetFormat("xml"))
+	assert.NoError(t, SetFormat(FormatJSON))
+	out.Reset()
+	ProcessOutput("ptp4l[1.0]: [ptp4l.0.config:6] master offset 5 s2 freq 10 path delay 20")
+	assert.Equal(t, 1, strings.Count(out.String(), "\n"))
+	var r Record
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &r))
+	assert.Equal(t, "ptp4l.0.config", r.Config)
+	assert.Equal(t, float(5), r.Offset)
+	assert.Equal(t, "ptp4l[1.0]: [ptp4l.0.config:6] master offset 5 s2 freq 10 path delay 20", r.Raw)
+	assert.False(t, r.Time.IsZero())
+}
+
+func TestConvertGlog(t *testing.T) {
+	var out bytes.Buffer
+	convertGlog(strings.NewReader("I0101 00:00:00.000000       1 main.go:80] resync period set to: 30 [s]\nW0101 00:00:01.000000       1 leap-file.go:10] leap file expired\n"), &out)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		var r Record
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &r))
+		assert.Equal(t, "warning", r.Level)
+		assert.Equal(t, "leap-file", r.Component)
+		assert.Equal(t, "leap file expired", r.Msg)
+	}
+}
@@ -0,0 +1,104 @@
+package oran
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// RegisterHandlers adds the API of p to mux:
+//   - POST, GET and DELETE SubscriptionsPath create, list and delete all the subscriptions
+//   - GET and DELETE SubscriptionsPath/{id} get and delete a subscription
+//   - GET APIPath/{ResourceAddress}/CurrentState returns the current state of a resource as a CloudEvent
+func (p *Publisher) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("POST "+SubscriptionsPath, p.createSubscription)
+	mux.HandleFunc("GET "+SubscriptionsPath, p.listSubscriptions)
+	mux.HandleFunc("DELETE "+SubscriptionsPath, p.deleteSubscriptions)
+	mux.HandleFunc("GET "+SubscriptionsPath+"/{id}", p.getSubscription)
+	mux.HandleFunc("DELETE "+SubscriptionsPath+"/{id}", p.deleteSubscription)
+	mux.HandleFunc("GET "+APIPath+"/{address...}", p.currentState)
+}
+
+func (p *Publisher) createSubscription(w http.ResponseWriter, r *http.Request) {
+	var s Subscription
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, "invalid subscription: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	s, err := p.Subscribe(s)
+	if errors.Is(err, ErrSubscriptionExists) {
+		http.Error(w, err.Error()+": "+location(r, s.ID), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.URILocation = location(r, s.ID)
+	w.Header().Set("Location", s.URILocation)
+	writeJSON(w, http.StatusCreated, s)
+}
+
+func (p *Publisher) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subscriptions := p.Subscriptions()
+	for i := range subscriptions {
+		subscriptions[i].URILocation = location(r, subscriptions[i].ID)
+	}
+	writeJSON(w, http.StatusOK, subscriptions)
+}
+
+func (p *Publisher) deleteSubscriptions(w http.ResponseWriter, _ *http.Request) {
+	p.UnsubscribeAll()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *Publisher) getSubscription(w http.ResponseWriter, r *http.Request) {
+	s, ok := p.Subscription(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "subscription not found", http.StatusNotFound)
+		return
+	}
+	s.URILocation = location(r, s.ID)
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (p *Publisher) deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if !p.Unsubscribe(r.PathValue("id")) {
+		http.Error(w, "subscription not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *Publisher) currentState(w http.ResponseWriter, r *http.Request) {
+	address, found := strings.CutSuffix("/"+r.PathValue("address"), "/"+CurrentState)
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	ev, err := p.CurrentState(address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, ev)
+}
+
+// location returns the URL of the subscription of id, as seen by the client of r
+func location(r *http.Request, id string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + SubscriptionsPath + "/" + id
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		glog.Errorf("failed to write the response: %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package oran
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/stretchr/testify/assert"
+)
+
+// receiver is a subscriber endpoint, it sends the notifications it receives to events
+func receiver(t *testing.T) (*httptest.Server, <-chan CloudEvent) {
+	events := make(chan CloudEvent, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev CloudEvent
+		assert.Equal(t, "application/cloudevents+json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&ev))
+		events <- ev
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(s.Close)
+	return s, events
+}
+
+// api serves the API of p
+func api(t *testing.T, p *Publisher) *httptest.Server {
+	mux := http.NewServeMux()
+	p.RegisterHandlers(mux)
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func request(t *testing.T, method, url string, body interface{}, out interface{}) *http.Response {
+	var b []byte
+	if body != nil {
+		b, _ = json.Marshal(body)
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(b))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp
+}
+
+// next returns the next notification
+func next(t *testing.T, events <-chan CloudEvent) CloudEvent {
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("no notification received")
+	}
+	return CloudEvent{}
+}
+
+func TestSubscriptions(t *testing.T) {
+	p := NewPublisher("worker-0")
+	defer p.Close()
+	s := api(t, p)
+	endpoint := "http://consumer:9043/event"
+
+	var created Subscription
+	resp := request(t, http.MethodPost, s.URL+SubscriptionsPath,
+		Subscription{EndpointURI: endpoint, ResourceAddress: "/cluster/node/worker-0/sync/sync-status/sync-state"}, &created)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, s.URL+SubscriptionsPath+"/"+created.ID, created.URILocation)
+	assert.Equal(t, created.URILocation, resp.Header.Get("Location"))
+
+	resp = request(t, http.MethodPost, s.URL+SubscriptionsPath, Subscription{EndpointURI: endpoint, ResourceAddress: SyncState}, nil)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "same endpoint and resource")
+	resp = request(t, http.MethodPost, s.URL+SubscriptionsPath, Subscription{EndpointURI: endpoint, ResourceAddress: "/cluster/node/worker-1" + SyncState}, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "resource of another node")
+	resp = request(t, http.MethodPost, s.URL+SubscriptionsPath, Subscription{EndpointURI: "consumer", ResourceAddress: SyncState}, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "relative endpoint")
+	resp = request(t, http.MethodPost, s.URL+SubscriptionsPath, Subscription{EndpointURI: endpoint, ResourceAddress: PtpClockClass}, nil)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var list []Subscription
+	request(t, http.MethodGet, s.URL+SubscriptionsPath, nil, &list)
+	assert.Len(t, list, 2)
+	var got Subscription
+	resp = request(t, http.MethodGet, created.URILocation, nil, &got)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, created, got)
+
+	resp = request(t, http.MethodDelete, created.URILocation, nil, nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = request(t, http.MethodGet, created.URILocation, nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = request(t, http.MethodDelete, created.URILocation, nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = request(t, http.MethodDelete, s.URL+SubscriptionsPath, nil, nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Empty(t, p.Subscriptions())
+}
+
+func TestCurrentState(t *testing.T) {
+	p := NewPublisher("worker-0")
+	defer p.Close()
+	s := api(t, p)
+
+	var ev CloudEvent
+	resp := request(t, http.MethodGet, s.URL+APIPath+"/cluster/node/worker-0"+PtpClockClass+"/"+CurrentState, nil, &ev)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1.0", ev.SpecVersion)
+	assert.Equal(t, PtpClockClassChange, ev.Type)
+	assert.Equal(t, PtpClockClass, ev.Source)
+	assert.Equal(t, []DataValue{{ResourceAddress: "/cluster/node/worker-0" + PtpClockClass, DataType: "metric", ValueType: "decimal64.3", Value: "248"}},
+		ev.Data.Values, "freerun until a clock class is set")
+
+	p.update(func() {
+		p.setState(event.GNSS, event.Instance{CfgName: "ts2phc.0.config", IFace: "ens1f0"}, event.PTP_LOCKED)
+	})
+	request(t, http.MethodGet, s.URL+APIPath+GnssSyncStatus+"/"+CurrentState, nil, &ev)
+	assert.Equal(t, GnssSynchronized, ev.Data.Values[0].Value)
+
+	resp = request(t, http.MethodGet, s.URL+APIPath+"/sync/unknown/"+CurrentState, nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = request(t, http.MethodGet, s.URL+APIPath+SyncState, nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestNotifications(t *testing.T) {
+	notifier := event.NewStateNotifier()
+	p := NewPublisher("worker-0")
+	defer p.Close()
+	p.Register(notifier)
+	consumer, events := receiver(t)
+	for _, address := range []string{SyncState, PtpClockClass, OsClockSyncState} {
+		_, err := p.Subscribe(Subscription{EndpointURI: consumer.URL, ResourceAddress: address})
+		assert.NoError(t, err)
+	}
+
+	notifier.Publish(event.PTP4l, event.PTP_LOCKED)
+	ev := next(t, events)
+	assert.Equal(t, SyncStateChange, ev.Type)
+	assert.Equal(t, "/cluster/node/worker-0"+SyncState, ev.Data.Values[0].ResourceAddress)
+	assert.Equal(t, Locked, ev.Data.Values[0].Value)
+
+	notifier.PublishClockClass("ptp4l.0.config", 6)
+	ev = next(t, events)
+	assert.Equal(t, PtpClockClassChange, ev.Type)
+	assert.Equal(t, "6", ev.Data.Values[0].Value)
+
+	// the system clock is in holdover, so is the node
+	notifier.Publish(event.PHC2SYS, event.PTP_HOLDOVER)
+	got := map[string]string{}
+	for i := 0; i < 2; i++ {
+		ev = next(t, events)
+		got[ev.Type] = ev.Data.Values[0].Value
+	}
+	assert.Equal(t, map[string]string{SyncStateChange: Holdover, OsClockSyncStateChange: Holdover}, got)
+
+	// unchanged resources are not notified
+	notifier.Publish(event.GNSS, event.PTP_LOCKED)
+	notifier.Publish(event.PHC2SYS, event.PTP_HOLDOVER)
+	select {
+	case ev = <-events:
+		t.Errorf("unexpected notification %v", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// another ptp4l in freerun does not hide behind the locked one
+	notifier.PublishInstance(event.PTP4l, event.Instance{CfgName: "ptp4l.1.config"}, event.PTP_FREERUN)
+	ev = next(t, events)
+	assert.Equal(t, SyncStateChange, ev.Type)
+	assert.Equal(t, Freerun, ev.Data.Values[0].Value)
+}
+
+func TestInstances(t *testing.T) {
+	p := NewPublisher("worker-0")
+	defer p.Close()
+	notify := subscriber{publisher: p}.NotifyInstance
+	current := func(address string) string {
+		ev, err := p.CurrentState(p.Address(address))
+		assert.NoError(t, err)
+		return ev.Data.Values[0].Value
+	}
+
+	// the worst ptp4l wins, whichever was notified last
+	notify(event.PTP4l, event.Instance{CfgName: "ptp4l.0.config"}, event.PTP_FREERUN)
+	notify(event.PTP4l, event.Instance{CfgName: "ptp4l.1.config"}, event.PTP_LOCKED)
+	assert.Equal(t, Freerun, current(SyncState))
+	notify(event.PTP4l, event.Instance{CfgName: "ptp4l.0.config"}, event.PTP_LOCKED)
+	assert.Equal(t, Locked, current(SyncState))
+
+	// the GNSS of every grandmaster interface must be locked
+	notify(event.GNSS, event.Instance{CfgName: "ts2phc.0.config", IFace: "ens1f0"}, event.PTP_LOCKED)
+	notify(event.GNSS, event.Instance{CfgName: "ts2phc.1.config", IFace: "ens2f0"}, event.PTP_FREERUN)
+	assert.Equal(t, GnssAcquiringSync, current(GnssSyncStatus))
+
+	// the system clock is disciplined by phc2sys or chronyd
+	notify(event.PHC2SYS, event.Instance{CfgName: "phc2sys.0.config"}, event.PTP_LOCKED)
+	notify(event.CHRONY, event.Instance{CfgName: "chronyd.1.config"}, event.PTP_HOLDOVER)
+	assert.Equal(t, Holdover, current(OsClockSyncState))
+	assert.Equal(t, Holdover, current(SyncState))
+	notify(event.CHRONY, event.Instance{CfgName: "chronyd.1.config"}, event.PTP_LOCKED)
+	assert.Equal(t, Locked, current(OsClockSyncState))
+}
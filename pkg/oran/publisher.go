@@ -0,0 +1,305 @@
+package oran
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/protocol"
+)
+
+// DeliveryTimeout bounds the post of a notification to a subscriber
+var DeliveryTimeout = 5 * time.Second
+
+// deliveryQueueSize is the number of notifications waiting to be posted, new ones are dropped beyond
+const deliveryQueueSize = 100
+
+// ErrSubscriptionExists is returned when subscribing an endpoint to a resource twice
+var ErrSubscriptionExists = errors.New("subscription already exists")
+
+// topics are the StateNotifier topics the resources derive from
+var topics = []event.EventSource{event.GMSTATE, event.PTP4l, event.PHC2SYS, event.CHRONY, event.GNSS, event.SYNCE}
+
+// Publisher holds the subscriptions and the state of the resources, and posts the notifications
+type Publisher struct {
+	mu            sync.Mutex
+	nodeName      string
+	subscriptions map[string]subscription
+	states        map[event.EventSource]map[event.Instance]event.PTPState // last state notified, by topic and instance
+	clockClass    uint8
+	deliveries    chan delivery
+	client        *http.Client
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+type subscription struct {
+	Subscription
+	resource resource
+}
+
+type delivery struct {
+	subscription Subscription
+	event        CloudEvent
+}
+
+// NewPublisher creates the publisher of the resources of nodeName, see Register
+func NewPublisher(nodeName string) *Publisher {
+	p := &Publisher{
+		nodeName:      nodeName,
+		subscriptions: map[string]subscription{},
+		states:        map[event.EventSource]map[event.Instance]event.PTPState{},
+		clockClass:    uint8(protocol.ClockClassFreerun),
+		deliveries:    make(chan delivery, deliveryQueueSize),
+		client:        &http.Client{Timeout: DeliveryTimeout},
+		done:          make(chan struct{}),
+	}
+	go p.deliver()
+	return p
+}
+
+// Register subscribes p to the state changes notified by n
+func (p *Publisher) Register(n *event.StateNotifier) {
+	for _, topic := range topics {
+		n.Register(subscriber{publisher: p, topic: topic})
+	}
+}
+
+// Close stops posting the notifications
+func (p *Publisher) Close() {
+	p.closeOnce.Do(func() { close(p.done) })
+}
+
+// Address returns the full address of the resource at path, i.e. /cluster/node/worker-0/sync/sync-status/sync-state
+func (p *Publisher) Address(path string) string {
+	return nodeAddress(p.nodeName) + path
+}
+
+// Subscribe adds a subscription of s.EndpointURI to the resource at s.ResourceAddress and returns it with its ID.
+// ErrSubscriptionExists is returned when the endpoint is already subscribed to the resource.
+func (p *Publisher) Subscribe(s Subscription) (Subscription, error) {
+	endpoint, err := url.Parse(s.EndpointURI)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return Subscription{}, fmt.Errorf("invalid endpoint %q, an http or https URL is expected", s.EndpointURI)
+	}
+	r, err := findResource(p.nodeName, s.ResourceAddress)
+	if err != nil {
+		return Subscription{}, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, existing := range p.subscriptions {
+		if existing.EndpointURI == s.EndpointURI && existing.resource.path == r.path {
+			return existing.Subscription, ErrSubscriptionExists
+		}
+	}
+	s.ID = uuid.NewString()
+	s.URILocation = ""
+	p.subscriptions[s.ID] = subscription{Subscription: s, resource: r}
+	glog.Infof("subscription %s of %s to %s created", s.ID, s.EndpointURI, s.ResourceAddress)
+	return s, nil
+}
+
+// Subscriptions returns the subscriptions, by ID
+func (p *Publisher) Subscriptions() []Subscription {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	subscriptions := make([]Subscription, 0, len(p.subscriptions))
+	for _, s := range p.subscriptions {
+		subscriptions = append(subscriptions, s.Subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].ID < subscriptions[j].ID })
+	return subscriptions
+}
+
+// Subscription returns the subscription of id
+func (p *Publisher) Subscription(id string) (Subscription, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.subscriptions[id]
+	return s.Subscription, ok
+}
+
+// Unsubscribe deletes the subscription of id, it returns false when there is none
+func (p *Publisher) Unsubscribe(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.subscriptions[id]; !ok {
+		return false
+	}
+	delete(p.subscriptions, id)
+	glog.Infof("subscription %s deleted", id)
+	return true
+}
+
+// UnsubscribeAll deletes every subscription
+func (p *Publisher) UnsubscribeAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subscriptions = map[string]subscription{}
+	glog.Info("all subscriptions deleted")
+}
+
+// CurrentState returns the current state of the resource at address
+func (p *Publisher) CurrentState(address string) (CloudEvent, error) {
+	r, err := findResource(p.nodeName, address)
+	if err != nil {
+		return CloudEvent{}, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return newCloudEvent(r, p.Address(r.path), p.value(r)), nil
+}
+
+// update applies change to the states and notifies the subscribers of the resources it changed
+func (p *Publisher) update(change func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	before := make([]string, len(resources))
+	for i, r := range resources {
+		before[i] = p.value(r)
+	}
+	change()
+	for i, r := range resources {
+		value := p.value(r)
+		if value == before[i] {
+			continue
+		}
+		glog.Infof("%s changed from %s to %s", r.path, before[i], value)
+		for _, s := range p.subscriptions {
+			if s.resource.path == r.path {
+				p.enqueue(delivery{subscription: s.Subscription, event: newCloudEvent(r, p.Address(r.path), value)})
+			}
+		}
+	}
+}
+
+// value returns the current value of r
+func (p *Publisher) value(r resource) string {
+	switch r.path {
+	case SyncState:
+		return stateValue(p.syncState())
+	case OsClockSyncState:
+		// the system clock is disciplined by phc2sys or chronyd
+		state, _ := p.state(event.PHC2SYS, event.CHRONY)
+		return stateValue(state)
+	case PtpClockClass:
+		return strconv.Itoa(int(p.clockClass))
+	case GnssSyncStatus:
+		if state, _ := p.state(event.GNSS); state == event.PTP_LOCKED {
+			return GnssSynchronized
+		}
+		return GnssAcquiringSync
+	case SyncEState:
+		state, _ := p.state(event.SYNCE)
+		return stateValue(state)
+	}
+	return ""
+}
+
+// syncState returns the worst known state of the grandmaster, ptp4l and the system clock, FREERUN while none is known
+func (p *Publisher) syncState() event.PTPState {
+	state, _ := p.state(event.GMSTATE, event.PTP4l, event.PHC2SYS, event.CHRONY)
+	return state
+}
+
+// state returns the worst known state of the instances of sources, FREERUN and false while none is known
+func (p *Publisher) state(sources ...event.EventSource) (event.PTPState, bool) {
+	state, known := event.PTP_FREERUN, false
+	for _, source := range sources {
+		for _, s := range p.states[source] {
+			if !known || stateRank(s) < stateRank(state) {
+				state, known = s, true
+			}
+		}
+	}
+	return state, known
+}
+
+// setState sets the state of an instance of source
+func (p *Publisher) setState(source event.EventSource, instance event.Instance, state event.PTPState) {
+	if p.states[source] == nil {
+		p.states[source] = map[event.Instance]event.PTPState{}
+	}
+	p.states[source][instance] = state
+}
+
+func (p *Publisher) enqueue(d delivery) {
+	select {
+	case p.deliveries <- d:
+	default:
+		glog.Errorf("notification queue full, dropping the %s notification of subscription %s", d.event.Type, d.subscription.ID)
+	}
+}
+
+// deliver posts the notifications in order
+func (p *Publisher) deliver() {
+	for {
+		select {
+		case d := <-p.deliveries:
+			if err := p.post(d); err != nil {
+				glog.Errorf("failed to notify subscription %s at %s: %v", d.subscription.ID, d.subscription.EndpointURI, err)
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Publisher) post(d delivery) error {
+	b, err := json.Marshal(d.event)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Post(d.subscription.EndpointURI, "application/cloudevents+json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+// subscriber is the event.Subscriber of a topic of a Publisher
+type subscriber struct {
+	publisher *Publisher
+	topic     event.EventSource
+}
+
+func (s subscriber) ID() string {
+	return "oran"
+}
+
+func (s subscriber) Monitor() {}
+
+func (s subscriber) Topic() event.EventSource {
+	return s.topic
+}
+
+func (s subscriber) Notify(source event.EventSource, state event.PTPState) {
+	s.NotifyInstance(source, event.Instance{}, state)
+}
+
+// NotifyInstance keeps the state of each instance of source, the resources report the worst one
+func (s subscriber) NotifyInstance(source event.EventSource, instance event.Instance, state event.PTPState) {
+	s.publisher.update(func() { s.publisher.setState(source, instance, state) })
+}
+
+// NotifyClockClass is called on the PTP4l topic only, the clock class is the last one set on any ptp4l
+func (s subscriber) NotifyClockClass(_ string, clockClass uint8) {
+	s.publisher.update(func() { s.publisher.clockClass = clockClass })
+}
@@ -0,0 +1,156 @@
+// Package oran serves an O-RAN O-Cloud style notification API: consumers such as vDU applications subscribe
+// to the sync resources of the node with a callback URL, are notified of their changes with CloudEvents,
+// and query their current state.
+package oran
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/event"
+)
+
+const (
+	// APIPath is the path the API is served under
+	APIPath = "/api/ocloudNotifications/v2"
+	// SubscriptionsPath serves the subscriptions
+	SubscriptionsPath = APIPath + "/subscriptions"
+	// CurrentState is the suffix of the address of a resource that serves its current state
+	CurrentState = "CurrentState"
+)
+
+// Resources, the addresses are relative to the address of the node, see Publisher.Address
+const (
+	SyncState        = "/sync/sync-status/sync-state"
+	OsClockSyncState = "/sync/sync-status/os-clock-sync-state"
+	PtpClockClass    = "/sync/ptp-status/clock-class"
+	GnssSyncStatus   = "/sync/gnss-status/gnss-sync-status"
+	SyncEState       = "/sync/synce-status/lock-state"
+)
+
+// Event types, one per resource
+const (
+	SyncStateChange        = "event.sync.sync-status.synchronization-state-change"
+	OsClockSyncStateChange = "event.sync.sync-status.os-clock-sync-state-change"
+	PtpClockClassChange    = "event.sync.ptp-status.ptp-clock-class-change"
+	GnssStateChange        = "event.sync.gnss-status.gnss-state-change"
+	SyncEStateChange       = "event.sync.synce-status.synce-state-change"
+)
+
+// Values of the state resources
+const (
+	Locked   = "LOCKED"
+	Holdover = "HOLDOVER"
+	Freerun  = "FREERUN"
+	// GnssSynchronized and GnssAcquiringSync are the values of GnssSyncStatus
+	GnssSynchronized  = "SYNCHRONIZED"
+	GnssAcquiringSync = "ACQUIRING-SYNC"
+)
+
+// resource is a sync resource of the node
+type resource struct {
+	path      string
+	eventType string
+	dataType  string
+	valueType string
+}
+
+var resources = []resource{
+	{path: SyncState, eventType: SyncStateChange, dataType: "notification", valueType: "enumeration"},
+	{path: OsClockSyncState, eventType: OsClockSyncStateChange, dataType: "notification", valueType: "enumeration"},
+	{path: PtpClockClass, eventType: PtpClockClassChange, dataType: "metric", valueType: "decimal64.3"},
+	{path: GnssSyncStatus, eventType: GnssStateChange, dataType: "notification", valueType: "enumeration"},
+	{path: SyncEState, eventType: SyncEStateChange, dataType: "notification", valueType: "enumeration"},
+}
+
+// Subscription is a subscription of a consumer to a resource
+type Subscription struct {
+	ID string `json:"SubscriptionId,omitempty"`
+	// EndpointURI is the URL the notifications are posted to
+	EndpointURI string `json:"EndpointUri"`
+	// ResourceAddress is the address of the resource, i.e. /cluster/node/worker-0/sync/sync-status/sync-state
+	ResourceAddress string `json:"ResourceAddress"`
+	// URILocation is the URL of the subscription
+	URILocation string `json:"UriLocation,omitempty"`
+}
+
+// CloudEvent is a notification, or the current state of a resource, in the CloudEvents 1.0 JSON format
+type CloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            Data      `json:"data"`
+}
+
+// Data is the data of a CloudEvent
+type Data struct {
+	Version string      `json:"version"`
+	Values  []DataValue `json:"values"`
+}
+
+// DataValue is the value of a resource
+type DataValue struct {
+	ResourceAddress string `json:"ResourceAddress"`
+	DataType        string `json:"data_type"`
+	ValueType       string `json:"value_type"`
+	Value           string `json:"value"`
+}
+
+// newCloudEvent returns the CloudEvent of value of the resource at address
+func newCloudEvent(r resource, address, value string) CloudEvent {
+	return CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              uuid.NewString(),
+		Source:          r.path,
+		Type:            r.eventType,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data: Data{
+			Version: "1.0",
+			Values:  []DataValue{{ResourceAddress: address, DataType: r.dataType, ValueType: r.valueType, Value: value}},
+		},
+	}
+}
+
+// nodeAddress returns the address the resources of nodeName are under
+func nodeAddress(nodeName string) string {
+	return "/cluster/node/" + nodeName
+}
+
+// findResource returns the resource of address, either the resource path or the full address of a resource of nodeName
+func findResource(nodeName, address string) (resource, error) {
+	path := strings.TrimPrefix(address, nodeAddress(nodeName))
+	for _, r := range resources {
+		if r.path == path {
+			return r, nil
+		}
+	}
+	return resource{}, fmt.Errorf("unknown resource %q", address)
+}
+
+// stateValue returns the value of a state resource
+func stateValue(state event.PTPState) string {
+	switch state {
+	case event.PTP_LOCKED:
+		return Locked
+	case event.PTP_HOLDOVER:
+		return Holdover
+	}
+	return Freerun
+}
+
+// stateRank orders the states from the worst to the best
+func stateRank(state event.PTPState) int {
+	switch state {
+	case event.PTP_LOCKED:
+		return 2
+	case event.PTP_HOLDOVER:
+		return 1
+	}
+	return 0
+}
@@ -16,6 +16,7 @@ var (
 	ClockClassChangeRegEx = regexp.MustCompile(`gm.ClockClass[[:space:]]+(\d+)`)
 	ClockClassUpdateRegEx = regexp.MustCompile(`clockClass[[:space:]]+(\d+)`)
 	GetGMSettingsRegEx    = regexp.MustCompile(`clockClass[[:space:]]+(\d+)[[:space:]]+clockAccuracy[[:space:]]+(0x\d+)`)
+	ParentDataSetRegEx    = regexp.MustCompile(`RESPONSE MANAGEMENT PARENT_DATA_SET`)
 	CmdGetParentDataSet   = "GET PARENT_DATA_SET"
 	CmdGetGMSettings      = "GET GRANDMASTER_SETTINGS_NP"
 	CmdSetGMSettings      = "SET GRANDMASTER_SETTINGS_NP"
@@ -24,9 +25,21 @@ var (
 	numRetry              = 6
 )
 
+var (
+	// Command is the pmc executable
+	Command = "pmc"
+	// ConfigDir is the directory of the ptp4l configs pmc is run with
+	ConfigDir = "/var/run"
+)
+
+// commandLine returns the pmc command line for the ptp4l config configFileName
+func commandLine(configFileName string) string {
+	return fmt.Sprintf("%s -u -b 0 -f %s/%s", Command, ConfigDir, configFileName)
+}
+
 // RunPMCExp ... go expect to run PMC util cmd
 func RunPMCExp(configFileName, cmdStr string, promptRE *regexp.Regexp) (result string, matches []string, err error) {
-	pmcCmd := fmt.Sprintf("pmc -u -b 0 -f /var/run/%s", configFileName)
+	pmcCmd := commandLine(configFileName)
 	glog.Infof("%s \"%s\"", pmcCmd, cmdStr)
 	e, r, err := expect.Spawn(pmcCmd, -1)
 	if err != nil {
@@ -61,7 +74,7 @@ func RunPMCExp(configFileName, cmdStr string, promptRE *regexp.Regexp) (result s
 // RunPMCExpGetGMSettings ... get current GRANDMASTER_SETTINGS_NP
 func RunPMCExpGetGMSettings(configFileName string) (g protocol.GrandmasterSettings, err error) {
 	cmdStr := CmdGetGMSettings
-	pmcCmd := fmt.Sprintf("pmc -u -b 0 -f /var/run/%s", configFileName)
+	pmcCmd := commandLine(configFileName)
 	glog.Infof("%s \"%s\"", pmcCmd, cmdStr)
 	e, r, err := expect.Spawn(pmcCmd, -1)
 	if err != nil {
@@ -106,7 +119,7 @@ func RunPMCExpGetGMSettings(configFileName string) (g protocol.GrandmasterSettin
 func RunPMCExpSetGMSettings(configFileName string, g protocol.GrandmasterSettings) (err error) {
 	cmdStr := CmdSetGMSettings
 	cmdStr += strings.Replace(g.String(), "\n", " ", -1)
-	pmcCmd := fmt.Sprintf("pmc -u -b 0 -f /var/run/%s", configFileName)
+	pmcCmd := commandLine(configFileName)
 	e, r, err := expect.Spawn(pmcCmd, -1)
 	if err != nil {
 		return err
@@ -0,0 +1,319 @@
+// Package ptpconf parses and renders linuxptp configuration files: ptp4l, phc2sys, ts2phc and synce4l.
+// A File keeps the order of its sections and options, its comments, blank lines and repeated keys,
+// so String returns the parsed text byte for byte until the File is modified.
+package ptpconf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Well known section names
+const (
+	GlobalSection             = "global"
+	NmeaSection               = "nmea"
+	UnicastMasterTableSection = "unicast_master_table"
+)
+
+// LineKind is the kind of a line of a section
+type LineKind int
+
+const (
+	Blank LineKind = iota
+	Comment
+	Option
+)
+
+// Line is a blank line, a comment or an option of a section. An option holds a key and an optional value.
+type Line struct {
+	Kind  LineKind
+	Key   string
+	Value string
+	// parsed is the line as it was parsed, nil for a new line
+	parsed *parsedLine
+}
+
+// parsedLine is the text a line was parsed from and what it was parsed to
+type parsedLine struct {
+	text  string
+	kind  LineKind
+	key   string
+	value string
+}
+
+// String renders the line, a parsed line that was not modified is rendered as it was read
+func (l *Line) String() string {
+	if p := l.parsed; p != nil && p.kind == l.Kind && p.key == l.Key && p.value == l.Value {
+		return p.text
+	}
+	switch l.Kind {
+	case Comment:
+		return "#" + l.Value
+	case Option:
+		if l.Value == "" {
+			return l.Key
+		}
+		return l.Key + " " + l.Value
+	}
+	return ""
+}
+
+// SectionType tells what a section configures, from its name
+type SectionType int
+
+const (
+	// Global holds the global options
+	Global SectionType = iota
+	// Port is an interface section, of ptp4l, phc2sys or ts2phc, or a synce4l port
+	Port
+	// Nmea holds the ts2phc NMEA source options
+	Nmea
+	// UnicastMasterTable is a ptp4l table of unicast masters, its address keys repeat
+	UnicastMasterTable
+	// SynceDevice starts a synce4l device, i.e. [<synce1>], the ports that follow belong to it
+	SynceDevice
+	// SynceExternalSource is a synce4l external source of the current device, i.e. [{SMA1}]
+	SynceExternalSource
+)
+
+// Section is a [name] section and the lines up to the next section
+type Section struct {
+	// Name is the section name without the brackets, i.e. global, ens1f0 or <synce1>
+	Name  string
+	Lines []*Line
+	// header is the text of the header line, it is used as long as the section is not renamed
+	header     string
+	headerName string
+}
+
+// Type returns the type of the section
+func (s *Section) Type() SectionType {
+	switch {
+	case s.Name == GlobalSection:
+		return Global
+	case s.Name == NmeaSection:
+		return Nmea
+	case s.Name == UnicastMasterTableSection:
+		return UnicastMasterTable
+	case strings.HasPrefix(s.Name, "<"):
+		return SynceDevice
+	case strings.HasPrefix(s.Name, "{"):
+		return SynceExternalSource
+	}
+	return Port
+}
+
+// Get returns the value of key, the last one when the key is repeated as linuxptp does
+func (s *Section) Get(key string) (string, bool) {
+	for i := len(s.Lines) - 1; i >= 0; i-- {
+		if l := s.Lines[i]; l.Kind == Option && l.Key == key {
+			return l.Value, true
+		}
+	}
+	return "", false
+}
+
+// GetAll returns every value of a repeated key in order, i.e. the UDPv4 addresses of a unicast master table
+func (s *Section) GetAll(key string) []string {
+	var values []string
+	for _, l := range s.Lines {
+		if l.Kind == Option && l.Key == key {
+			values = append(values, l.Value)
+		}
+	}
+	return values
+}
+
+// Has returns whether the section sets key
+func (s *Section) Has(key string) bool {
+	_, ok := s.Get(key)
+	return ok
+}
+
+// Options returns the option lines of the section in order
+func (s *Section) Options() []*Line {
+	var options []*Line
+	for _, l := range s.Lines {
+		if l.Kind == Option {
+			options = append(options, l)
+		}
+	}
+	return options
+}
+
+// Set sets key to value. The first occurrence of the key is updated in place and any other one removed,
+// a new key is added after the last option of the section.
+func (s *Section) Set(key, value string) {
+	found := false
+	lines := s.Lines[:0]
+	for _, l := range s.Lines {
+		if l.Kind == Option && l.Key == key {
+			if found {
+				continue
+			}
+			found = true
+			l.Value = value
+		}
+		lines = append(lines, l)
+	}
+	s.Lines = lines
+	if !found {
+		s.Add(key, value)
+	}
+}
+
+// Add adds another value of key after the last option of the section
+func (s *Section) Add(key, value string) {
+	at := 0
+	for i, l := range s.Lines {
+		if l.Kind == Option {
+			at = i + 1
+		}
+	}
+	s.Lines = append(s.Lines, nil)
+	copy(s.Lines[at+1:], s.Lines[at:])
+	s.Lines[at] = &Line{Kind: Option, Key: key, Value: value}
+}
+
+// Delete removes every occurrence of key
+func (s *Section) Delete(key string) {
+	lines := s.Lines[:0]
+	for _, l := range s.Lines {
+		if l.Kind != Option || l.Key != key {
+			lines = append(lines, l)
+		}
+	}
+	s.Lines = lines
+}
+
+func (s *Section) headerString() string {
+	if s.header != "" && s.headerName == s.Name {
+		return s.header
+	}
+	return "[" + s.Name + "]"
+}
+
+// File is a parsed configuration file
+type File struct {
+	// Preamble are the blank and comment lines before the first section
+	Preamble []*Line
+	Sections []*Section
+}
+
+// ParseError is returned by Parse for a line that is not valid
+type ParseError struct {
+	Line int // 1 based
+	Text string
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Msg, e.Text)
+}
+
+// Parse parses the text of a configuration file
+func Parse(text string) (*File, error) {
+	f := &File{}
+	if text == "" {
+		return f, nil
+	}
+	var current *Section
+	for n, raw := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(raw)
+		var line *Line
+		switch {
+		case strings.HasPrefix(trimmed, "["):
+			end := strings.Index(trimmed, "]")
+			if end < 0 {
+				return nil, &ParseError{Line: n + 1, Text: raw, Msg: "section missing closing ']'"}
+			}
+			name := strings.TrimSpace(trimmed[1:end])
+			current = &Section{Name: name, header: raw, headerName: name}
+			f.Sections = append(f.Sections, current)
+			continue
+		case trimmed == "":
+			line = &Line{Kind: Blank}
+		case strings.HasPrefix(trimmed, "#"):
+			line = &Line{Kind: Comment, Value: trimmed[1:]}
+		default:
+			if current == nil {
+				return nil, &ParseError{Line: n + 1, Text: raw, Msg: "option not in a section"}
+			}
+			key, value := trimmed, ""
+			if i := strings.IndexAny(trimmed, " \t"); i > 0 {
+				key, value = trimmed[:i], strings.TrimSpace(trimmed[i:])
+			}
+			line = &Line{Kind: Option, Key: key, Value: value}
+		}
+		line.parsed = &parsedLine{text: raw, kind: line.Kind, key: line.Key, value: line.Value}
+		if current == nil {
+			f.Preamble = append(f.Preamble, line)
+		} else {
+			current.Lines = append(current.Lines, line)
+		}
+	}
+	return f, nil
+}
+
+// String renders the file
+func (f *File) String() string {
+	var b strings.Builder
+	first := true
+	writeLine := func(s string) {
+		if !first {
+			b.WriteByte('\n')
+		}
+		first = false
+		b.WriteString(s)
+	}
+	for _, l := range f.Preamble {
+		writeLine(l.String())
+	}
+	for _, s := range f.Sections {
+		writeLine(s.headerString())
+		for _, l := range s.Lines {
+			writeLine(l.String())
+		}
+	}
+	return b.String()
+}
+
+// Section returns the first section called name, or nil
+func (f *File) Section(name string) *Section {
+	for _, s := range f.Sections {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Global returns the global section, or nil
+func (f *File) Global() *Section {
+	return f.Section(GlobalSection)
+}
+
+// SectionsOfType returns the sections of type t in order
+func (f *File) SectionsOfType(t SectionType) []*Section {
+	var sections []*Section
+	for _, s := range f.Sections {
+		if s.Type() == t {
+			sections = append(sections, s)
+		}
+	}
+	return sections
+}
+
+// AddSection adds an empty section called name at the end of the file
+func (f *File) AddSection(name string) *Section {
+	return f.InsertSection(len(f.Sections), name)
+}
+
+// InsertSection inserts an empty section called name before the section at index
+func (f *File) InsertSection(index int, name string) *Section {
+	s := &Section{Name: name}
+	f.Sections = append(f.Sections, nil)
+	copy(f.Sections[index+1:], f.Sections[index:])
+	f.Sections[index] = s
+	return s
+}
@@ -0,0 +1,137 @@
+package ptpconf_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ptpconf"
+	"github.com/stretchr/testify/assert"
+)
+
+const ptp4lConf = `# boundary clock
+[ens1f0]
+masterOnly 0
+
+[ens1f1]
+masterOnly  1
+[global]
+#
+# Default Data Set
+#
+twoStepFlag 1
+domainNumber	24
+  summary_interval 1
+[unicast_master_table]
+table_id 1
+logQueryInterval 2
+UDPv4 10.0.0.1
+UDPv4 10.0.0.2
+UDPv4 10.0.0.3
+`
+
+func TestParse_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"empty", ""},
+		{"ptp4l", ptp4lConf},
+		{"no trailing newline", "[global]\ndomainNumber 24"},
+		{"preamble", "# comment\n\n[global]\n"},
+		{"crlf", "[global]\r\ndomainNumber 24\r\n"},
+		{"synce4l", "[global]\nlogging_level 7\n[<synce1>]\nnetwork_option 1\n[{SMA1}]\n[ens1f0]\ntx_heartbeat_msec 1000\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ptpconf.Parse(tt.text)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.text, f.String())
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	f, err := ptpconf.Parse(ptp4lConf)
+	assert.NoError(t, err)
+	assert.Len(t, f.Preamble, 1)
+
+	var names []string
+	for _, s := range f.Sections {
+		names = append(names, s.Name)
+	}
+	assert.Equal(t, []string{"ens1f0", "ens1f1", "global", "unicast_master_table"}, names)
+
+	global := f.Global()
+	v, ok := global.Get("domainNumber")
+	assert.True(t, ok)
+	assert.Equal(t, "24", v)
+	v, _ = global.Get("summary_interval")
+	assert.Equal(t, "1", v)
+	v, _ = f.Section("ens1f1").Get("masterOnly")
+	assert.Equal(t, "1", v)
+	assert.False(t, global.Has("masterOnly"))
+
+	table := f.Section(ptpconf.UnicastMasterTableSection)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, table.GetAll("UDPv4"))
+	v, _ = table.Get("UDPv4")
+	assert.Equal(t, "10.0.0.3", v)
+
+	assert.Len(t, f.SectionsOfType(ptpconf.Port), 2)
+	assert.Equal(t, ptpconf.UnicastMasterTable, table.Type())
+}
+
+func TestParse_Errors(t *testing.T) {
+	var parseErr *ptpconf.ParseError
+	_, err := ptpconf.Parse("[global]\n[ens1f0\n")
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, 2, parseErr.Line)
+	_, err = ptpconf.Parse("domainNumber 24\n[global]\n")
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, 1, parseErr.Line)
+}
+
+func TestSectionTypes(t *testing.T) {
+	f, err := ptpconf.Parse("[global]\n[nmea]\n[<synce1>]\n[{SMA1}]\n[ens1f0]\n[unicast_master_table]\n")
+	assert.NoError(t, err)
+	var types []ptpconf.SectionType
+	for _, s := range f.Sections {
+		types = append(types, s.Type())
+	}
+	assert.Equal(t, []ptpconf.SectionType{ptpconf.Global, ptpconf.Nmea, ptpconf.SynceDevice,
+		ptpconf.SynceExternalSource, ptpconf.Port, ptpconf.UnicastMasterTable}, types)
+}
+
+func TestEdit(t *testing.T) {
+	f, err := ptpconf.Parse("# bc\n[global]\n# domain\ndomainNumber 24\n\n[ens1f0]\nmasterOnly 0\n")
+	assert.NoError(t, err)
+	global := f.Global()
+
+	global.Set("domainNumber", "25")
+	global.Set("message_tag", "[ptp4l.0.config]")
+	assert.Equal(t, "# bc\n[global]\n# domain\ndomainNumber 25\nmessage_tag [ptp4l.0.config]\n\n[ens1f0]\nmasterOnly 0\n", f.String())
+
+	f.InsertSection(0, "ens2f0")
+	port := f.Section("ens2f0")
+	port.Add("masterOnly", "1")
+	assert.Equal(t, "# bc\n[ens2f0]\nmasterOnly 1\n[global]\n# domain\ndomainNumber 25\nmessage_tag [ptp4l.0.config]\n\n[ens1f0]\nmasterOnly 0\n", f.String())
+
+	global.Delete("message_tag")
+	f.Sections = f.Sections[1:]
+	assert.Equal(t, "# bc\n[global]\n# domain\ndomainNumber 25\n\n[ens1f0]\nmasterOnly 0\n", f.String())
+
+	table := f.AddSection(ptpconf.UnicastMasterTableSection)
+	table.Add("UDPv4", "10.0.0.1")
+	table.Add("UDPv4", "10.0.0.2")
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, table.GetAll("UDPv4"))
+	table.Set("UDPv4", "10.0.0.3")
+	assert.Equal(t, []string{"10.0.0.3"}, table.GetAll("UDPv4"))
+}
+
+func TestEdit_KeepsUnchangedLines(t *testing.T) {
+	f, err := ptpconf.Parse("[global]\n  domainNumber\t24   \nclockClass 248\n")
+	assert.NoError(t, err)
+	global := f.Global()
+	global.Set("domainNumber", "24")
+	global.Set("clockClass", "6")
+	assert.Equal(t, "[global]\n  domainNumber\t24   \nclockClass 6\n", f.String())
+}
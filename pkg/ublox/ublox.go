@@ -36,13 +36,19 @@ const (
 	CMD_VOLTAGE_CONTROLER = " -v 1 -z CFG-HW-ANT_CFG_VOLTCTRL,%d"
 	// CMD_NAV_STATUS ...
 	CMD_NAV_STATUS  = " -t -p NAV-STATUS"
-	UBXCommand      = "/usr/local/bin/ubxtool"
 	UBXTOOL_NEW     = 0
 	UBXTOOL_ACTIVE  = 1
 	UBXTOOL_DEAD    = 2
 	UBXTOOL_STOPPED = 3
 )
 
+var (
+	// UBXCommand is the ubxtool executable
+	UBXCommand = "/usr/local/bin/ubxtool"
+	// UBXInterpreter runs UBXCommand for polling, which then runs unbuffered; UBXCommand is run directly when empty
+	UBXInterpreter = "python3"
+)
+
 // UBlox ... UBlox type
 type UBlox struct {
 	status       int
@@ -227,9 +233,13 @@ func (u *UBlox) UbloxPollInit() {
 		u.buffer = nil
 		u.buffermutex.Unlock()
 		wait := 1000000000
-		args := []string{"-u", UBXCommand, "-t", "-P", "29.20", "-w", fmt.Sprintf("%d", wait)}
+		args := []string{"-t", "-P", "29.20", "-w", fmt.Sprintf("%d", wait)}
 		//python -u /usr/local/bin/ubxtool -t -p NAV-CLOCK -p NAV-STATUS -P 29.20 -w 10
-		u.cmd = exec.Command("python3", args...)
+		if UBXInterpreter != "" {
+			u.cmd = exec.Command(UBXInterpreter, append([]string{"-u", UBXCommand}, args...)...)
+		} else {
+			u.cmd = exec.Command(UBXCommand, args...)
+		}
 		stdoutreader, _ := u.cmd.StdoutPipe()
 		u.reader = bufio.NewReader(stdoutreader)
 		u.setStatus(UBXTOOL_ACTIVE)
@@ -0,0 +1,390 @@
+//go:build e2e
+
+// Package e2e runs the daemon against the fake linuxptp toolchain of test/fakeptp:
+// profiles are applied as the config map would, and the tests check what the daemon
+// reports through its status, metrics and Kubernetes events.
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/daemon"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/dpll"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/leap"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/test/fakeptp"
+	ptpv1 "github.com/k8snetworkplumbingwg/ptp-operator/api/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	apiv1 "github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/api/v1"
+)
+
+const (
+	nodeName = "e2e-node"
+	// timeout leaves room for the 5s health refresh and rollback check of the daemon
+	timeout = 30 * time.Second
+	poll    = 100 * time.Millisecond
+	// rollbackTimeout adds the 30s the daemon waits for the crashing ptp4l to become ready before it checks the profiles again
+	rollbackTimeout = timeout + 30*time.Second
+)
+
+// toolchain is the fake toolchain the daemon runs, installed once for the whole suite
+var toolchain *fakeptp.Toolchain
+
+func TestMain(m *testing.M) {
+	if code, ok := fakeptp.Main(); ok {
+		os.Exit(code)
+	}
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "linuxptp-e2e")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	if toolchain, err = fakeptp.Install(dir, os.Args[0]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	daemon.SetBinaryDir(toolchain.BinDir)
+	daemon.SetGPSDDir(toolchain.GPSDDir)
+	dpll.SysfsNetDir = toolchain.NetDir
+	if err = leap.MockLeapFile(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer close(leap.LeapMgr.Close)
+	return m.Run()
+}
+
+// must stops the test on err
+func must(t *testing.T, err error) {
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+}
+
+// harness is a daemon running the fake toolchain, with a fake Kubernetes client
+type harness struct {
+	t         *testing.T
+	dn        *daemon.Daemon
+	update    *daemon.LinuxPTPConfUpdate
+	client    *fake.Clientset
+	configDir string
+	stopCh    chan struct{}
+	closeCh   chan bool
+}
+
+// start starts a daemon whose processes follow scenario, rollback is the rollback window, 0 disables rollbacks
+func start(t *testing.T, scenario fakeptp.Scenario, rollback time.Duration) *harness {
+	must(t, toolchain.Reset())
+	must(t, toolchain.SetScenario(scenario))
+	refresh := false
+	h := &harness{
+		t:         t,
+		update:    &daemon.LinuxPTPConfUpdate{UpdateCh: make(chan bool)},
+		client:    fake.NewSimpleClientset(),
+		configDir: t.TempDir(),
+		stopCh:    make(chan struct{}),
+		closeCh:   make(chan bool),
+	}
+	h.dn = daemon.New(nodeName, daemon.PtpNamespace, false, h.client, h.update, h.stopCh,
+		[]string{}, &[]ptpv1.HwConfig{}, &refresh, h.closeCh, 1)
+	h.dn.SetRollbackWindow(rollback)
+	go h.dn.Run()
+	t.Cleanup(h.stop)
+	return h
+}
+
+// stop stops the processes and the event loop of the daemon
+func (h *harness) stop() {
+	close(h.stopCh)
+	close(h.closeCh)
+	// the processes are stopped by the run loop, give them time to exit before the next test starts its own
+	time.Sleep(time.Second)
+}
+
+// profile returns an ordinary clock profile named name, with settings added to its PtpSettings
+func (h *harness) profile(name, iface string, settings map[string]string) ptpv1.PtpProfile {
+	ptp4lOpts, phc2sysOpts := "-2 -s", "-a -r"
+	p := ptpv1.PtpProfile{
+		Name:        &name,
+		Interface:   &iface,
+		Ptp4lOpts:   &ptp4lOpts,
+		Phc2sysOpts: &phc2sysOpts,
+		PtpSettings: map[string]string{"unitTest": h.configDir},
+	}
+	for k, v := range settings {
+		p.PtpSettings[k] = v
+	}
+	return p
+}
+
+// grandmaster returns a T-GM profile named name: ts2phc disciplines the PHC of iface from the GNSS receiver read
+// through gpsd, its DPLL is read from sysfs, and ptp4l serves the time on iface
+func (h *harness) grandmaster(name, iface string, settings map[string]string) ptpv1.PtpProfile {
+	ptp4lOpts, phc2sysOpts, ts2phcOpts := "-2", "-a -r -r -n 24", " "
+	ptp4lConf := fmt.Sprintf("[%s]\nmasterOnly 1\n[global]\ndomainNumber 24\nclockClass 248\n", iface)
+	ts2phcConf := fmt.Sprintf("[nmea]\nts2phc.master 1\n[global]\nts2phc.nmea_serialport /dev/gnss0\n[%s]\nts2phc.extts_polarity rising\n", iface)
+	p := ptpv1.PtpProfile{
+		Name:        &name,
+		Ptp4lOpts:   &ptp4lOpts,
+		Ptp4lConf:   &ptp4lConf,
+		Phc2sysOpts: &phc2sysOpts,
+		Ts2PhcOpts:  &ts2phcOpts,
+		Ts2PhcConf:  &ts2phcConf,
+		PtpSettings: map[string]string{"unitTest": h.configDir},
+	}
+	for k, v := range settings {
+		p.PtpSettings[k] = v
+	}
+	return p
+}
+
+// apply applies profiles the way a config map update does
+func (h *harness) apply(profiles ...ptpv1.PtpProfile) {
+	b, err := json.Marshal(profiles)
+	must(h.t, err)
+	must(h.t, h.update.UpdateConfig(b))
+}
+
+// process returns the status of the process name of profile, nil when it is not reported
+func (h *harness) process(profile, name string) *apiv1.Process {
+	for _, p := range h.dn.Status().Profiles {
+		if p.Name != profile {
+			continue
+		}
+		for i := range p.Processes {
+			if p.Processes[i].Name == name {
+				return &p.Processes[i]
+			}
+		}
+	}
+	return nil
+}
+
+// eventually waits until the process name of profile satisfies condition
+func (h *harness) eventually(profile, name string, condition func(p *apiv1.Process) bool, msg string) *apiv1.Process {
+	var p *apiv1.Process
+	if !assert.Eventually(h.t, func() bool {
+		p = h.process(profile, name)
+		return p != nil && condition(p)
+	}, timeout, poll, msg) {
+		h.t.Logf("last status of %s %s: %+v", profile, name, p)
+		h.t.FailNow()
+	}
+	return p
+}
+
+func locked(p *apiv1.Process) bool {
+	return p.Running && p.Locked
+}
+
+// gauge returns the value of the series of vec with labels, the node label is added
+func gauge(vec *prometheus.GaugeVec, labels prometheus.Labels) float64 {
+	labels["node"] = nodeName
+	return testutil.ToFloat64(vec.With(labels))
+}
+
+// slaveLines are the lines of a ptp4l locked to its grandmaster with offset, printed every 100ms until trigger
+func slaveLines(offset int, trigger string) []fakeptp.Step {
+	return []fakeptp.Step{
+		{Lines: []string{
+			"port 1 (ens1f0): INITIALIZING to LISTENING on INIT_COMPLETE",
+			"port 1 (ens1f0): LISTENING to UNCALIBRATED on RS_SLAVE",
+			"selected best master clock 507c6f.fffe.1fb16c",
+			"port 1 (ens1f0): UNCALIBRATED to SLAVE on MASTER_CLOCK_SELECTED",
+		}, Interval: "10ms"},
+		{Lines: []string{fmt.Sprintf("master offset %d s2 freq -10 path delay 100", offset)}, Interval: "100ms", Repeat: -1, Until: trigger},
+	}
+}
+
+// phc2sysLines are the lines of a phc2sys locked with offset, printed every 100ms
+func phc2sysLines(offset int) []fakeptp.Step {
+	return []fakeptp.Step{
+		{Lines: []string{fmt.Sprintf("CLOCK_REALTIME phc offset %d s2 freq +8956 delay 508", offset)}, Interval: "100ms", Repeat: -1},
+	}
+}
+
+func TestOrdinaryClock(t *testing.T) {
+	clockClass := 6
+	h := start(t, fakeptp.Scenario{
+		Processes: map[string]fakeptp.Process{
+			"ptp4l":   {Steps: slaveLines(11, "")},
+			"phc2sys": {Steps: phc2sysLines(-12)},
+		},
+		PMC: map[string]fakeptp.PMC{"*": {ClockClass: &clockClass}},
+	}, 0)
+	h.apply(h.profile("oc", "ens1f0", nil))
+
+	ptp4l := h.eventually("oc", "ptp4l", locked, "ptp4l locks")
+	assert.Equal(t, "ptp4l.0.config", ptp4l.ConfigName)
+	assert.NotZero(t, ptp4l.PID)
+	assert.Zero(t, ptp4l.Restarts)
+	h.eventually("oc", "phc2sys", locked, "phc2sys locks")
+
+	assert.Eventually(t, func() bool {
+		return gauge(daemon.Offset, prometheus.Labels{"from": "master", "iface": "ens1fx", "process": "ptp4l"}) == 11 &&
+			gauge(daemon.Offset, prometheus.Labels{"from": "phc", "iface": "CLOCK_REALTIME", "process": "phc2sys"}) == -12
+	}, timeout, poll, "the offsets are reported")
+	assert.Eventually(t, func() bool {
+		return gauge(daemon.ClockClassMetrics, prometheus.Labels{"process": "ptp4l"}) == 6
+	}, timeout, poll, "the clock class is read with pmc once ptp4l selects a master")
+	assert.Equal(t, 1, toolchain.Starts("ptp4l.0.config"))
+}
+
+func TestProcessRestart(t *testing.T) {
+	one := 1
+	h := start(t, fakeptp.Scenario{
+		Processes: map[string]fakeptp.Process{
+			"ptp4l": {
+				Steps:   []fakeptp.Step{{Lines: []string{"port 1 (ens1f0): INITIALIZING to LISTENING on INIT_COMPLETE"}, Interval: "500ms"}, {Exit: &one}},
+				Restart: slaveLines(21, ""),
+			},
+			"phc2sys": {Steps: phc2sysLines(-22)},
+		},
+	}, 0)
+	h.apply(h.profile("oc", "ens1f0", map[string]string{daemon.RestartBackoffInitialSetting: "100ms"}))
+
+	ptp4l := h.eventually("oc", "ptp4l", func(p *apiv1.Process) bool { return locked(p) && p.Restarts == 1 }, "ptp4l locks once restarted")
+	if assert.NotNil(t, ptp4l.LastExit) {
+		assert.Equal(t, 1, ptp4l.LastExit.Code)
+	}
+	assert.False(t, ptp4l.CrashLooping)
+	assert.Equal(t, 2, toolchain.Starts("ptp4l.0.config"))
+	assert.Eventually(t, func() bool {
+		return gauge(daemon.Offset, prometheus.Labels{"from": "master", "iface": "ens1fx", "process": "ptp4l"}) == 21
+	}, timeout, poll, "the restarted ptp4l reports its offset")
+}
+
+func TestProfileUpdate(t *testing.T) {
+	h := start(t, fakeptp.Scenario{
+		Processes: map[string]fakeptp.Process{
+			"ptp4l":   {Steps: slaveLines(31, "")},
+			"phc2sys": {Steps: phc2sysLines(-32)},
+		},
+	}, 0)
+	a, b := h.profile("a", "ens1f0", nil), h.profile("b", "ens2f0", nil)
+	h.apply(a, b)
+	pidA := h.eventually("a", "ptp4l", locked, "a locks").PID
+	pidB := h.eventually("b", "ptp4l", locked, "b locks").PID
+
+	b.PtpSettings["logReduce"] = "true"
+	h.apply(a, b)
+	h.eventually("b", "ptp4l", func(p *apiv1.Process) bool { return locked(p) && p.PID != pidB }, "b is restarted with its new settings")
+	assert.Equal(t, pidA, h.process("a", "ptp4l").PID, "a is unchanged and keeps running")
+}
+
+func TestCrashLoopRollback(t *testing.T) {
+	one := 1
+	h := start(t, fakeptp.Scenario{
+		Processes: map[string]fakeptp.Process{
+			"ptp4l":   {Steps: slaveLines(41, "")},
+			"phc2sys": {Steps: phc2sysLines(-42)},
+			// the profile applied second runs ptp4l.1.config, which exits right away
+			"ptp4l.1.config": {Steps: []fakeptp.Step{{Exit: &one}}},
+		},
+	}, time.Minute)
+	good := h.profile("good", "ens1f0", nil)
+	h.apply(good)
+	h.eventually("good", "ptp4l", locked, "the good profile locks")
+	h.eventually("good", "phc2sys", locked, "the good profile locks")
+	// the rollback check runs every 5s, wait for it to keep the good profile before applying the bad one
+	time.Sleep(6 * time.Second)
+
+	bad := h.profile("bad", "ens2f0", map[string]string{
+		daemon.RestartBackoffInitialSetting: "100ms",
+		daemon.RestartBackoffMaxSetting:     "100ms",
+		daemon.RestartMaxRestartsSetting:    "2",
+	})
+	h.apply(good, bad)
+
+	var events *corev1.EventList
+	assert.Eventually(t, func() bool {
+		var err error
+		events, err = h.client.CoreV1().Events(daemon.PtpNamespace).List(context.TODO(), metav1.ListOptions{})
+		return err == nil && len(events.Items) > 0
+	}, rollbackTimeout, poll, "the rollback is recorded as a Kubernetes event")
+	if assert.Len(t, events.Items, 1) {
+		assert.Equal(t, daemon.ProfileRollbackEventReason, events.Items[0].Reason)
+		assert.Contains(t, events.Items[0].Message, "crashloop")
+	}
+	assert.GreaterOrEqual(t, toolchain.Starts("ptp4l.1.config"), 3, "the bad ptp4l is restarted until it crash loops")
+	assert.Eventually(t, func() bool {
+		return h.process("bad", "ptp4l") == nil
+	}, timeout, poll, "the bad profile is rolled back")
+	h.eventually("good", "ptp4l", locked, "the good profile runs again")
+}
+
+// ts2phcLines are the lines of a ts2phc locked to the GNSS on iface until trigger, then in holdover
+func ts2phcLines(iface, trigger string) []fakeptp.Step {
+	return []fakeptp.Step{
+		{Lines: []string{"nmea delay: 88403525 ns", iface + " master offset 1 s2 freq -1"}, Interval: "100ms", Repeat: -1, Until: trigger},
+		{Lines: []string{iface + " master offset 1 s3 freq -1 holdover"}, Interval: "100ms", Repeat: -1},
+	}
+}
+
+func TestGrandmaster(t *testing.T) {
+	h := start(t, fakeptp.Scenario{
+		Processes: map[string]fakeptp.Process{
+			"ptp4l": {Steps: []fakeptp.Step{{Lines: []string{
+				"port 1 (ens1f0): INITIALIZING to LISTENING on INIT_COMPLETE",
+				"port 1 (ens1f0): LISTENING to MASTER on ANNOUNCE_RECEIPT_TIMEOUT_EXPIRES",
+				"selected local clock 507c6f.fffe.1fb16c as best master",
+				"port 1 (ens1f0): assuming the grand master role",
+			}, Interval: "10ms"}}},
+			"ts2phc":  {Steps: ts2phcLines("ens1f0", "gnss-lost")},
+			"phc2sys": {Steps: phc2sysLines(-52)},
+		},
+		GNSS: fakeptp.GNSS{TAcc: 4, Lost: "gnss-lost"},
+	}, 0)
+	must(t, toolchain.SetDPLL("ens1f0", fakeptp.DPLL{FrequencyState: fakeptp.DPLLLocked, PhaseState: fakeptp.DPLLLocked, PhaseOffset: 3}))
+	// the holdover stays in spec for MaxInSpecOffset / (LocalMaxHoldoverOffSet / LocalHoldoverTimeout) = 4s
+	h.apply(h.grandmaster("tgm", "ens1f0", map[string]string{
+		dpll.LocalMaxHoldoverOffSetStr: "200",
+		dpll.LocalHoldoverTimeoutStr:   "8",
+		dpll.MaxInSpecOffsetStr:        "100",
+	}))
+
+	h.eventually("tgm", "ts2phc", locked, "ts2phc locks to the GNSS")
+	assert.True(t, h.process("tgm", "ptp4l").Running)
+	clockClass := func(class float64) func() bool {
+		return func() bool {
+			return gauge(daemon.ClockClassMetrics, prometheus.Labels{"process": "ptp4l"}) == class
+		}
+	}
+	state := func(process, iface string, state float64) func() bool {
+		return func() bool {
+			return gauge(daemon.ClockState, prometheus.Labels{"process": process, "iface": iface}) == state
+		}
+	}
+	assert.Eventually(t, state("gnss", "ens1fx", 1), timeout, poll, "the GNSS locks")
+	assert.Eventually(t, state("dpll", "ens1fx", 1), timeout, poll, "the DPLL locks")
+	assert.Eventually(t, state("GM", "ens1fx", 1), timeout, poll, "the grandmaster locks")
+	assert.Eventually(t, clockClass(6), timeout, poll, "a locked grandmaster announces clock class 6")
+
+	// the receiver loses its fix, the DPLL goes in holdover and so does the grandmaster, in spec at first
+	must(t, toolchain.Trigger("gnss-lost"))
+	must(t, toolchain.SetDPLL("ens1f0", fakeptp.DPLL{FrequencyState: fakeptp.DPLLHoldover, PhaseState: fakeptp.DPLLHoldover, PhaseOffset: 3}))
+	assert.Eventually(t, state("gnss", "ens1fx", 0), timeout, poll, "the GNSS is lost")
+	assert.Eventually(t, state("dpll", "ens1fx", 2), timeout, poll, "the DPLL is in holdover")
+	assert.Eventually(t, state("GM", "ens1fx", 2), timeout, poll, "the grandmaster is in holdover")
+	assert.Eventually(t, clockClass(7), timeout, poll, "a grandmaster in holdover in spec announces clock class 7")
+
+	// the holdover times out, the grandmaster is freerunning
+	assert.Eventually(t, state("dpll", "ens1fx", 0), timeout, poll, "the DPLL holdover times out")
+	assert.Eventually(t, state("GM", "ens1fx", 0), timeout, poll, "the grandmaster is freerunning")
+	assert.Eventually(t, clockClass(248), timeout, poll, "a freerunning grandmaster announces clock class 248")
+}
@@ -0,0 +1,30 @@
+// Command fakeptp is the fake linuxptp toolchain. Run as one of the tools, through the links
+// "fakeptp install <dir>" creates in <dir>/bin, it plays that tool following the scenario named by FAKEPTP_SCENARIO.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/test/fakeptp"
+)
+
+func main() {
+	if code, ok := fakeptp.Main(); ok {
+		os.Exit(code)
+	}
+	if len(os.Args) == 3 && os.Args[1] == "install" {
+		executable, err := os.Executable()
+		if err == nil {
+			var t *fakeptp.Toolchain
+			if t, err = fakeptp.Install(os.Args[2], executable); err == nil {
+				fmt.Printf("installed %v in %s, state in %s\n", fakeptp.Names, t.BinDir, t.StateDir)
+				return
+			}
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "usage: %s install <dir>, or run as one of %v\n", os.Args[0], fakeptp.Names)
+	os.Exit(2)
+}
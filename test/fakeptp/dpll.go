@@ -0,0 +1,47 @@
+package fakeptp
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// DPLL states of the sysfs files, as the ice driver reports them
+const (
+	DPLLFreerun  = 1
+	DPLLLocked   = 2
+	DPLLHoldover = 4
+)
+
+// DPLL is the state of the DPLLs of a card the daemon reads from sysfs: the EEC DPLL for the frequency,
+// the PPS DPLL for the phase
+type DPLL struct {
+	FrequencyState int
+	PhaseState     int
+	// PhaseOffset is the offset of the PPS DPLL in ns
+	PhaseOffset int
+}
+
+// SetDPLL writes the sysfs DPLL files of iface, the daemon reads them every second
+func (t *Toolchain) SetDPLL(iface string, d DPLL) error {
+	dir := filepath.Join(t.NetDir, iface, "device")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for name, value := range map[string]int{
+		"dpll_0_state": d.FrequencyState,
+		"dpll_1_state": d.PhaseState,
+		// in tens of picoseconds
+		"dpll_1_offset": d.PhaseOffset * 100,
+	} {
+		// renamed into place, so that the daemon never reads a file being written
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path+".tmp", []byte(strconv.Itoa(value)+"\n"), 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
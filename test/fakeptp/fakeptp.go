@@ -0,0 +1,138 @@
+package fakeptp
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Main runs the tool os.Args[0] is named after, it returns the exit code and false when it is not one of Names.
+// Test binaries call it first thing in TestMain to act as the fakes:
+//
+//	if code, ok := fakeptp.Main(); ok {
+//		os.Exit(code)
+//	}
+func Main() (int, bool) {
+	name := filepath.Base(os.Args[0])
+	if !slices.Contains(Names, name) {
+		return 0, false
+	}
+	return Run(name, os.Args[1:]), true
+}
+
+// Run runs the tool name with args and returns its exit code
+func Run(name string, args []string) int {
+	scenario, stateDir, err := loadScenario()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+	switch name {
+	case "pmc":
+		return runPMC(scenario, stateDir, args)
+	case "gpsd":
+		return runGPSD(args)
+	case "gpspipe":
+		return runGPSPipe(scenario, stateDir, args)
+	case "ubxtool":
+		return runUBXTool(scenario, stateDir, args)
+	}
+	return runProcess(name, scenario, stateDir, args)
+}
+
+// terminated returns a channel closed when the process is asked to terminate, as the daemon does on stop
+func terminated() <-chan struct{} {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		<-sigCh
+		close(done)
+	}()
+	return done
+}
+
+// Toolchain is a directory of fake binaries
+type Toolchain struct {
+	// BinDir holds the binaries, see daemon.SetBinaryDir
+	BinDir string
+	// StateDir holds the scenario, the triggers and the state of the fakes
+	StateDir string
+	// NetDir holds the sysfs DPLL files of the interfaces, see dpll.SysfsNetDir and SetDPLL
+	NetDir string
+	// GPSDDir holds the FIFO gpspipe writes the NMEA sentences to, see daemon.SetGPSDDir
+	GPSDDir string
+}
+
+// Install links every tool of Names to executable in dir/bin, executable being a binary calling Main
+func Install(dir, executable string) (*Toolchain, error) {
+	t := &Toolchain{BinDir: filepath.Join(dir, "bin"), StateDir: filepath.Join(dir, "state"),
+		NetDir: filepath.Join(dir, "sys", "class", "net"), GPSDDir: filepath.Join(dir, "gpsd")}
+	for _, d := range []string{t.BinDir, t.StateDir, t.NetDir, t.GPSDDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+	}
+	executable, err := filepath.Abs(executable)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range Names {
+		path := filepath.Join(t.BinDir, name)
+		_ = os.Remove(path)
+		if err = os.Symlink(executable, path); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// SetScenario writes s and points ScenarioEnv at it, the fakes started from then on follow it
+func (t *Toolchain) SetScenario(s Scenario) error {
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(t.StateDir, "scenario.yaml")
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		return err
+	}
+	return os.Setenv(ScenarioEnv, path)
+}
+
+// Trigger fires the trigger name, releasing the steps waiting for it
+func (t *Toolchain) Trigger(name string) error {
+	return os.WriteFile(triggerPath(t.StateDir, name), nil, 0644)
+}
+
+// Starts returns how many times the process of configName was started
+func (t *Toolchain) Starts(configName string) int {
+	return readStarts(filepath.Join(t.StateDir, configName+".starts"))
+}
+
+// Reset removes the triggers, the state of the fakes and the DPLLs, the scenario is kept
+func (t *Toolchain) Reset() error {
+	if err := os.RemoveAll(t.NetDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(t.NetDir, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(t.StateDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() != "scenario.yaml" {
+			if err = os.Remove(filepath.Join(t.StateDir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package fakeptp
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	if code, ok := Main(); ok {
+		os.Exit(code)
+	}
+	os.Exit(m.Run())
+}
+
+// install installs the test binary as the toolchain and writes a ptp4l config with its UDS address
+func install(t *testing.T) (*Toolchain, string, string) {
+	dir := t.TempDir()
+	tc, err := Install(dir, os.Args[0])
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	socket := filepath.Join(dir, "ptp4l.0.socket")
+	config := filepath.Join(dir, "ptp4l.0.config")
+	assert.NoError(t, os.WriteFile(config, []byte("[global]\nmessage_tag [ptp4l.0.config:{level}]\nuds_address "+socket+"\n[ens1f0]\n"), 0644))
+	return tc, config, socket
+}
+
+func exitCode(err error) int {
+	if e, ok := err.(*exec.ExitError); ok {
+		return e.ExitCode()
+	}
+	return 0
+}
+
+func TestProcess(t *testing.T) {
+	tc, config, socket := install(t)
+	one := 1
+	assert.NoError(t, tc.SetScenario(Scenario{Processes: map[string]Process{
+		"ptp4l": {
+			Steps:   []Step{{Lines: []string{"master offset 5 s2 freq -10 path delay 100"}, Repeat: 2}, {Exit: &one}},
+			Restart: []Step{{Lines: []string{"{config} restarted"}, Exit: new(int)}},
+		},
+	}}))
+	defer os.Unsetenv(ScenarioEnv)
+
+	out, err := exec.Command(filepath.Join(tc.BinDir, "ptp4l"), "-f", config, "-2").Output()
+	assert.Equal(t, 1, exitCode(err))
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.Regexp(t, `^ptp4l\[\d+\.\d{3}\]: \[ptp4l.0.config:6\] master offset 5 s2 freq -10 path delay 100$`, lines[0])
+	}
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err), "the UDS socket is removed on exit")
+
+	out, err = exec.Command(filepath.Join(tc.BinDir, "ptp4l"), "-f", config).Output()
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "[ptp4l.0.config:6] ptp4l.0.config restarted")
+	assert.Equal(t, 2, tc.Starts("ptp4l.0.config"))
+}
+
+func TestProcess_trigger(t *testing.T) {
+	tc, config, socket := install(t)
+	assert.NoError(t, tc.SetScenario(Scenario{Processes: map[string]Process{
+		"ptp4l.0.config": {Steps: []Step{
+			{Lines: []string{"master offset 5 s2 freq -10 path delay 100"}, Interval: "10ms", Repeat: -1, Until: "lost"},
+			{Lines: []string{"master offset 99999 s0 freq -10 path delay 100"}},
+		}},
+	}}))
+	defer os.Unsetenv(ScenarioEnv)
+
+	cmd := exec.Command(filepath.Join(tc.BinDir, "ptp4l"), "-f", config)
+	var out strings.Builder
+	cmd.Stdout = &out
+	assert.NoError(t, cmd.Start())
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(socket)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// pmc answers once ptp4l listens
+	pmcOut, err := exec.Command(filepath.Join(tc.BinDir, "pmc"), "-u", "-b", "0", "-f", config, "GET PARENT_DATA_SET").Output()
+	assert.NoError(t, err)
+	assert.Contains(t, string(pmcOut), "RESPONSE MANAGEMENT PARENT_DATA_SET")
+	assert.Regexp(t, `gm.ClockClass\s+248`, string(pmcOut))
+
+	assert.NoError(t, tc.Trigger("lost"))
+	time.Sleep(200 * time.Millisecond)
+	assert.NoError(t, cmd.Process.Signal(os.Interrupt))
+	assert.NoError(t, cmd.Wait(), "terminating is a clean exit")
+	assert.Contains(t, out.String(), "s0")
+}
+
+func TestPMC(t *testing.T) {
+	tc, config, socket := install(t)
+	six := 6
+	assert.NoError(t, tc.SetScenario(Scenario{PMC: map[string]PMC{"*": {ClockClass: &six}}}))
+	defer os.Unsetenv(ScenarioEnv)
+	pmc := func(command string) string {
+		out, err := exec.Command(filepath.Join(tc.BinDir, "pmc"), "-u", "-b", "0", "-f", config, command).Output()
+		assert.NoError(t, err)
+		return string(out)
+	}
+
+	assert.NotContains(t, pmc("GET PARENT_DATA_SET"), "RESPONSE", "nothing answers without ptp4l")
+	assert.NoError(t, os.WriteFile(socket, nil, 0644))
+	assert.Regexp(t, `gm.ClockClass\s+6\n`, pmc("GET PARENT_DATA_SET"))
+	assert.Regexp(t, `clockClass\s+6\n\s+clockAccuracy\s+0xfe`, pmc("GET GRANDMASTER_SETTINGS_NP"))
+	pmc("SET GRANDMASTER_SETTINGS_NP clockClass 7 clockAccuracy 0x21")
+	assert.Regexp(t, `clockClass\s+7\n\s+clockAccuracy\s+0x21`, pmc("GET GRANDMASTER_SETTINGS_NP"), "the settings are kept")
+}
+
+func TestUBXTool(t *testing.T) {
+	tc, _, _ := install(t)
+	assert.NoError(t, tc.SetScenario(Scenario{GNSS: GNSS{TAcc: 7, Lost: "gnss-lost"}}))
+	defer os.Unsetenv(ScenarioEnv)
+	ubxtool := func(args ...string) string {
+		out, err := exec.Command(filepath.Join(tc.BinDir, "ubxtool"), args...).Output()
+		assert.NoError(t, err)
+		return string(out)
+	}
+
+	assert.Contains(t, ubxtool("-p", "MON-VER"), "PROTVER=29.20")
+	assert.Contains(t, ubxtool("-p", "NAV-STATUS"), "gpsFix 3 ")
+	assert.NoError(t, tc.Trigger("gnss-lost"))
+	assert.Contains(t, ubxtool("-p", "NAV-STATUS"), "gpsFix 0 ")
+}
+
+func TestNmeaChecksum(t *testing.T) {
+	assert.Equal(t, "$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47",
+		nmeaChecksum("GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,"))
+}
+
+func TestSetDPLL(t *testing.T) {
+	tc, _, _ := install(t)
+	assert.NoError(t, tc.SetDPLL("ens1f0", DPLL{FrequencyState: DPLLLocked, PhaseState: DPLLHoldover, PhaseOffset: -3}))
+	read := func(name string) string {
+		b, err := os.ReadFile(filepath.Join(tc.NetDir, "ens1f0", "device", name))
+		assert.NoError(t, err)
+		return string(b)
+	}
+	assert.Equal(t, "2\n", read("dpll_0_state"))
+	assert.Equal(t, "4\n", read("dpll_1_state"))
+	assert.Equal(t, "-300\n", read("dpll_1_offset"), "in tens of picoseconds")
+
+	assert.NoError(t, tc.Reset())
+	_, err := os.Stat(filepath.Join(tc.NetDir, "ens1f0"))
+	assert.True(t, os.IsNotExist(err), "Reset removes the DPLLs")
+}
@@ -0,0 +1,177 @@
+package fakeptp
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"time"
+)
+
+// gnssInterval is the interval of the NMEA sentences and of the ubxtool polls
+const gnssInterval = time.Second
+
+// fix returns the gpsFix of the receiver, 0 once it is lost
+func (g GNSS) fix(stateDir string) int {
+	if g.Lost != "" && triggered(stateDir, g.Lost) {
+		return 0
+	}
+	if g.Fix != nil {
+		return *g.Fix
+	}
+	return 3
+}
+
+// optionValue returns the value of option in args, empty when it is not given
+func optionValue(args []string, option string) string {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == option {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// runGPSD plays gpsd: it accepts clients on the port given with -S, on every address with -G, until it is terminated
+func runGPSD(args []string) int {
+	port := optionValue(args, "-S")
+	if port == "" {
+		port = "2947"
+	}
+	host := "localhost"
+	if slices.Contains(args, "-G") {
+		host = ""
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gpsd: %v\n", err)
+		return 1
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				fmt.Fprintln(conn, `{"class":"VERSION","release":"3.25","rev":"3.25","proto_major":3,"proto_minor":15}`)
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	<-terminated()
+	return 0
+}
+
+// runGPSPipe plays gpspipe: it writes NMEA sentences every second to the file given with -o, the FIFO ts2phc reads,
+// until the receiver is lost. Like gpspipe it exits when the reader goes away.
+func runGPSPipe(scenario *Scenario, stateDir string, args []string) int {
+	path := optionValue(args, "-o")
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "gpspipe: no output file given with -o")
+		return 1
+	}
+	done := terminated()
+	opened := make(chan *os.File)
+	go func() {
+		// opening a FIFO blocks until it is opened for reading
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gpspipe: %v\n", err)
+			f = nil
+		}
+		opened <- f
+	}()
+	var out *os.File
+	select {
+	case <-done:
+		return 0
+	case out = <-opened:
+		if out == nil {
+			return 1
+		}
+	}
+	defer out.Close()
+	for {
+		if scenario.GNSS.fix(stateDir) > 0 {
+			now := time.Now().UTC()
+			for _, sentence := range nmeaSentences(now) {
+				if _, err := fmt.Fprintln(out, sentence); err != nil {
+					fmt.Fprintf(os.Stderr, "gpspipe: %v\n", err)
+					return 1
+				}
+			}
+		}
+		if !sleep(gnssInterval, done) {
+			return 0
+		}
+	}
+}
+
+// nmeaSentences returns the RMC and GGA sentences of a receiver with a fix at now
+func nmeaSentences(now time.Time) []string {
+	hms := now.Format("150405.00")
+	return []string{
+		nmeaChecksum(fmt.Sprintf("GNRMC,%s,A,4533.1547,N,07334.4526,W,0.010,,%s,,,A,V", hms, now.Format("020106"))),
+		nmeaChecksum(fmt.Sprintf("GNGGA,%s,4533.1547,N,07334.4526,W,1,12,0.56,45.7,M,-32.5,M,,", hms)),
+	}
+}
+
+// nmeaChecksum frames an NMEA sentence with its checksum, i.e. $GNGGA,...*4F
+func nmeaChecksum(sentence string) string {
+	var sum byte
+	for i := 0; i < len(sentence); i++ {
+		sum ^= sentence[i]
+	}
+	return fmt.Sprintf("$%s*%02X", sentence, sum)
+}
+
+// runUBXTool plays ubxtool: polling with -w reports the clock and status of the receiver every second,
+// -p MON-VER and -p NAV-STATUS report them once and the configuration commands are acknowledged
+func runUBXTool(scenario *Scenario, stateDir string, args []string) int {
+	if optionValue(args, "-w") != "" {
+		done := terminated()
+		for {
+			if _, err := fmt.Print(ubxNavClock(scenario.GNSS) + ubxNavStatus(scenario.GNSS.fix(stateDir))); err != nil {
+				return 1
+			}
+			if !sleep(gnssInterval, done) {
+				return 0
+			}
+		}
+	}
+	switch optionValue(args, "-p") {
+	case "MON-VER":
+		fmt.Print(`UBX-MON-VER:
+  swVersion EXT CORE 1.00 (61b2dd)
+  hwVersion 00190000
+  extension ROM BASE 0x118B2060
+  extension FWVER=TIM 2.20
+  extension PROTVER=29.20
+  extension MOD=ZED-F9T
+  extension GPS;GLO;GAL;BDS
+  extension SBAS;QZSS
+  extension NAVIC
+
+`)
+	case "NAV-STATUS":
+		fmt.Print(ubxNavStatus(scenario.GNSS.fix(stateDir)))
+	default:
+		fmt.Print(`UBX-ACK-ACK:
+  ACK to Class x06 (CFG) ID x8a (VALSET)
+
+`)
+	}
+	return 0
+}
+
+func ubxNavClock(g GNSS) string {
+	return fmt.Sprintf("UBX-NAV-CLOCK:\n  iTOW 223968000 clkB 61524 clkD 2 tAcc %d fAcc 120\n\n", g.TAcc)
+}
+
+func ubxNavStatus(fix int) string {
+	return fmt.Sprintf("UBX-NAV-STATUS:\n  iTOW 223968000 gpsFix %d flags 0xdd fixStat 0x0 flags2 0x8\n  ttff 25580, msss 4294967295\n\n", fix)
+}
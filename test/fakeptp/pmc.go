@@ -0,0 +1,159 @@
+package fakeptp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	fbprotocol "github.com/facebook/time/ptp/protocol"
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/protocol"
+)
+
+// pmcClockIdentity is the identity of the fake ptp4l and of its grandmaster
+const pmcClockIdentity = "507c6f.fffe.1fb16c"
+
+// pmcValueOptions are the pmc options taking a value
+var pmcValueOptions = map[string]bool{"-b": true, "-d": true, "-f": true, "-i": true, "-s": true, "-t": true}
+
+// runPMC plays pmc: it answers the commands given as arguments, or read from stdin, for the ptp4l of the config given with -f.
+// Nothing is answered while that ptp4l does not listen on its UDS address, as pmc times out then.
+func runPMC(scenario *Scenario, stateDir string, args []string) int {
+	var commands []string
+	var cfgArgs []string
+	for i := 0; i < len(args); i++ {
+		if pmcValueOptions[args[i]] && i+1 < len(args) {
+			cfgArgs = append(cfgArgs, args[i], args[i+1])
+			i++
+		} else if !strings.HasPrefix(args[i], "-") {
+			commands = append(commands, args[i])
+		}
+	}
+	cfg, err := readConfig(cfgArgs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pmc: %v\n", err)
+		return 1
+	}
+	s := &pmcSession{pmc: scenario.pmc(cfg.name), cfg: cfg, statePath: filepath.Join(stateDir, cfg.name+".gm"), out: os.Stdout}
+	if len(commands) > 0 {
+		for _, c := range commands {
+			s.handle(c)
+		}
+		return 0
+	}
+	done := terminated()
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	for {
+		select {
+		case <-done:
+			return 0
+		case line, ok := <-lines:
+			if !ok {
+				return 0
+			}
+			if strings.TrimSpace(line) != "" {
+				s.handle(line)
+			}
+		}
+	}
+}
+
+// pmcSession answers the commands of a pmc run
+type pmcSession struct {
+	pmc       PMC
+	cfg       linuxptpConfig
+	statePath string
+	out       io.Writer
+	seq       int
+}
+
+// handle answers a command, i.e. "GET PARENT_DATA_SET"
+func (s *pmcSession) handle(command string) {
+	fmt.Fprintf(s.out, "sending: %s\n", command)
+	if s.cfg.udsAddress != "" {
+		if _, err := os.Stat(s.cfg.udsAddress); err != nil {
+			return
+		}
+	}
+	fields := strings.Fields(command)
+	if len(fields) < 2 {
+		return
+	}
+	action, id := strings.ToUpper(fields[0]), strings.ToUpper(fields[1])
+	body, ok := s.pmc.Responses[id]
+	if !ok {
+		switch {
+		case id == "PARENT_DATA_SET" && action == "GET":
+			body = s.parentDataSet()
+		case id == "GRANDMASTER_SETTINGS_NP" && action == "GET":
+			g := s.grandmasterSettings()
+			body = g.String()
+		case id == "GRANDMASTER_SETTINGS_NP" && action == "SET":
+			g := s.grandmasterSettings()
+			for i := 2; i+1 < len(fields); i += 2 {
+				g.Update(fields[i], fields[i+1])
+			}
+			if b, err := json.Marshal(g); err == nil {
+				_ = os.WriteFile(s.statePath, b, 0644)
+			}
+			body = g.String()
+		default:
+			return
+		}
+	}
+	fmt.Fprintf(s.out, "\t%s-0 seq %d RESPONSE MANAGEMENT %s \n", pmcClockIdentity, s.seq, id)
+	s.seq++
+	for _, line := range strings.Split(strings.TrimRight(body, "\n"), "\n") {
+		fmt.Fprintf(s.out, "\t\t%s\n", strings.TrimSpace(line))
+	}
+}
+
+func (s *pmcSession) clockClass() int {
+	if s.pmc.ClockClass != nil {
+		return *s.pmc.ClockClass
+	}
+	return int(protocol.ClockClassFreerun)
+}
+
+func (s *pmcSession) parentDataSet() string {
+	return fmt.Sprintf(`parentPortIdentity                    %s-1
+parentStats                           0
+observedParentOffsetScaledLogVariance 0xffff
+observedParentClockPhaseChangeRate    0x7fffffff
+grandmasterPriority1                  128
+gm.ClockClass                         %d
+gm.ClockAccuracy                      0x21
+gm.OffsetScaledLogVariance            0x4e5d
+grandmasterPriority2                  128
+grandmasterIdentity                   %s`, pmcClockIdentity, s.clockClass(), pmcClockIdentity)
+}
+
+// grandmasterSettings returns the settings last set, the defaults of a free running ptp4l otherwise
+func (s *pmcSession) grandmasterSettings() protocol.GrandmasterSettings {
+	g := protocol.GrandmasterSettings{
+		ClockQuality: fbprotocol.ClockQuality{
+			ClockClass:              fbprotocol.ClockClass(s.clockClass()),
+			ClockAccuracy:           0xfe,
+			OffsetScaledLogVariance: 0xffff,
+		},
+		TimePropertiesDS: protocol.TimePropertiesDS{
+			CurrentUtcOffset: 37,
+			PtpTimescale:     true,
+			TimeSource:       0xa0,
+		},
+	}
+	if b, err := os.ReadFile(s.statePath); err == nil {
+		_ = json.Unmarshal(b, &g)
+	}
+	return g
+}
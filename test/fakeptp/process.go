@@ -0,0 +1,143 @@
+package fakeptp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/linuxptp-daemon/pkg/ptpconf"
+)
+
+// triggerPollInterval is how often the fakes check for triggers
+const triggerPollInterval = 50 * time.Millisecond
+
+// linuxptpConfig is what the fakes read from the config a process is started with
+type linuxptpConfig struct {
+	name       string
+	messageTag string
+	udsAddress string
+}
+
+// readConfig reads the config file given with -f in args
+func readConfig(args []string) (linuxptpConfig, error) {
+	var path string
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "-f" {
+			path = args[i+1]
+		}
+	}
+	if path == "" {
+		return linuxptpConfig{}, fmt.Errorf("no config file given with -f")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return linuxptpConfig{}, err
+	}
+	file, err := ptpconf.Parse(string(b))
+	if err != nil {
+		return linuxptpConfig{}, err
+	}
+	c := linuxptpConfig{name: filepath.Base(path)}
+	if global := file.Global(); global != nil {
+		c.messageTag, _ = global.Get("message_tag")
+		c.udsAddress, _ = global.Get("uds_address")
+	}
+	return c, nil
+}
+
+// runProcess plays ptp4l, phc2sys, ts2phc or synce4l: it prints the lines of the steps of its config
+// and keeps running until it is terminated. ptp4l listens on its UDS address as pmc expects.
+func runProcess(name string, scenario *Scenario, stateDir string, args []string) int {
+	cfg, err := readConfig(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+	done := terminated()
+	if name == "ptp4l" && cfg.udsAddress != "" {
+		_ = os.Remove(cfg.udsAddress)
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: cfg.udsAddress, Net: "unixgram"})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			return 1
+		}
+		defer func() {
+			conn.Close()
+			os.Remove(cfg.udsAddress)
+		}()
+	}
+
+	steps := scenario.process(name, cfg.name).Steps
+	if restart := scenario.process(name, cfg.name).Restart; countStart(stateDir, cfg.name) > 1 && len(restart) > 0 {
+		steps = restart
+	}
+	start := time.Now()
+	tag := strings.ReplaceAll(cfg.messageTag, "{level}", "6")
+	emit := func(line string, raw bool) {
+		line = strings.ReplaceAll(line, "{config}", cfg.name)
+		if !raw {
+			line = fmt.Sprintf("%s[%.3f]: %s %s", name, time.Since(start).Seconds(), tag, line)
+		}
+		fmt.Println(line)
+	}
+
+	for _, step := range steps {
+		if step.Wait != "" && !waitFor(stateDir, step.Wait, done) {
+			return 0
+		}
+		repeat := step.Repeat
+		if repeat == 0 {
+			repeat = 1
+		}
+		for i := 0; repeat < 0 || i < repeat; i++ {
+			if step.Until != "" && triggered(stateDir, step.Until) {
+				break
+			}
+			for _, line := range step.Lines {
+				emit(line, step.Raw)
+				if !sleep(step.interval(), done) {
+					return 0
+				}
+			}
+			if len(step.Lines) == 0 && !sleep(triggerPollInterval, done) {
+				return 0
+			}
+		}
+		if step.Exit != nil {
+			return *step.Exit
+		}
+	}
+	<-done
+	return 0
+}
+
+// waitFor waits until the trigger name exists, it returns false when the process is terminated first
+func waitFor(stateDir, name string, done <-chan struct{}) bool {
+	for !triggered(stateDir, name) {
+		if !sleep(triggerPollInterval, done) {
+			return false
+		}
+	}
+	return true
+}
+
+// sleep sleeps for d, it returns false when the process is terminated first
+func sleep(d time.Duration, done <-chan struct{}) bool {
+	if d <= 0 {
+		select {
+		case <-done:
+			return false
+		default:
+			return true
+		}
+	}
+	select {
+	case <-done:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,147 @@
+// Package fakeptp provides fake ptp4l, phc2sys, ts2phc, synce4l, pmc, gpsd, gpspipe and ubxtool binaries
+// to run the daemon without PTP hardware. A single executable plays every tool, picked by the name it is
+// run as, and follows a Scenario: the log lines each process prints, when it exits, what pmc answers
+// and the state of the GNSS receiver.
+package fakeptp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ScenarioEnv is the environment variable holding the path of the scenario file, the fakes inherit it from the daemon.
+// The directory of the scenario file holds the triggers and the state of the fakes.
+const ScenarioEnv = "FAKEPTP_SCENARIO"
+
+// Names are the tools the executable plays
+var Names = []string{"ptp4l", "phc2sys", "ts2phc", "synce4l", "pmc", "gpsd", "gpspipe", "ubxtool"}
+
+// Scenario drives the fake binaries
+type Scenario struct {
+	// Processes are the outputs of ptp4l, phc2sys, ts2phc and synce4l by config file name, i.e. "phc2sys.0.config",
+	// or by process name, which applies to every config of the process
+	Processes map[string]Process `json:"processes,omitempty"`
+	// PMC are the pmc answers by ptp4l config file name, "*" applies to every config
+	PMC map[string]PMC `json:"pmc,omitempty"`
+	// GNSS is the state of the GNSS receiver gpspipe and ubxtool report
+	GNSS GNSS `json:"gnss,omitempty"`
+}
+
+// Process is what a fake linuxptp process does. Once its steps are done it keeps running until it is terminated.
+type Process struct {
+	// Steps are run on the first start
+	Steps []Step `json:"steps,omitempty"`
+	// Restart are the steps run on the next starts, Steps are run again when empty
+	Restart []Step `json:"restart,omitempty"`
+}
+
+// Step waits for Wait, prints Lines Repeat times and exits with Exit, each part being optional
+type Step struct {
+	// Wait is the trigger the step waits for, see Toolchain.Trigger
+	Wait string `json:"wait,omitempty"`
+	// Lines are printed with the linuxptp prefix, i.e. "ptp4l[12.345]: [ptp4l.0.config:6] ", unless Raw is set.
+	// {config} is replaced by the config file name.
+	Lines []string `json:"lines,omitempty"`
+	Raw   bool     `json:"raw,omitempty"`
+	// Interval is the delay after each line, i.e. "100ms"
+	Interval string `json:"interval,omitempty"`
+	// Repeat is how many times Lines are printed, 1 when 0, -1 repeats them until Until is triggered or forever
+	Repeat int `json:"repeat,omitempty"`
+	// Until stops repeating Lines once it is triggered
+	Until string `json:"until,omitempty"`
+	// Exit exits the process with this code once the lines are printed
+	Exit *int `json:"exit,omitempty"`
+}
+
+// PMC is what the fake pmc answers for a ptp4l config
+type PMC struct {
+	// ClockClass is the gm.ClockClass of PARENT_DATA_SET and the initial clockClass of GRANDMASTER_SETTINGS_NP, 248 when nil
+	ClockClass *int `json:"clockClass,omitempty"`
+	// Responses replace the built-in answers by management ID, i.e. "TIME_STATUS_NP"
+	Responses map[string]string `json:"responses,omitempty"`
+}
+
+// GNSS is the state of the fake GNSS receiver
+type GNSS struct {
+	// Fix is the gpsFix ubxtool reports, 3 (3D fix) when nil
+	Fix *int `json:"fix,omitempty"`
+	// TAcc is the time accuracy estimate in ns ubxtool reports
+	TAcc int `json:"tAcc,omitempty"`
+	// Lost is the trigger losing the receiver: gpsFix drops to 0 and gpspipe stops the NMEA sentences
+	Lost string `json:"lost,omitempty"`
+}
+
+// interval parses the interval of s, invalid intervals are 0
+func (s Step) interval() time.Duration {
+	d, err := time.ParseDuration(s.Interval)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// process returns the process of a config file, falling back on the one of the process name
+func (s *Scenario) process(name, configName string) Process {
+	if p, ok := s.Processes[configName]; ok {
+		return p
+	}
+	return s.Processes[name]
+}
+
+// pmc returns the pmc answers of a ptp4l config
+func (s *Scenario) pmc(configName string) PMC {
+	if p, ok := s.PMC[configName]; ok {
+		return p
+	}
+	return s.PMC["*"]
+}
+
+// loadScenario reads the scenario named by ScenarioEnv, an empty scenario when it is not set
+func loadScenario() (*Scenario, string, error) {
+	path := os.Getenv(ScenarioEnv)
+	if path == "" {
+		return &Scenario{}, os.TempDir(), nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	s := &Scenario{}
+	if err = yaml.Unmarshal(b, s); err != nil {
+		return nil, "", fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	return s, filepath.Dir(path), nil
+}
+
+// triggered returns whether the trigger name exists in stateDir
+func triggered(stateDir, name string) bool {
+	_, err := os.Stat(triggerPath(stateDir, name))
+	return err == nil
+}
+
+func triggerPath(stateDir, name string) string {
+	return filepath.Join(stateDir, "trigger."+name)
+}
+
+// countStart increments the start count of a config and returns it
+func countStart(stateDir, configName string) int {
+	path := filepath.Join(stateDir, configName+".starts")
+	n := readStarts(path) + 1
+	_ = os.WriteFile(path, []byte(strconv.Itoa(n)), 0644)
+	return n
+}
+
+func readStarts(path string) int {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+	return n
+}